@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/router"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type attributeHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.AttributeStore
+}
+
+func InitAttributeRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := attributeHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewAttributeStore(opts.DatabaseConnection),
+	}
+	router := parent.Subrouter()
+
+	router.AddRoute("/attributes", handler.handleGetAll).
+		Methods("GET").
+		Name("Get attributes").
+		Description("Get all variant attributes with the options of option attributes, e.g. sizes and colors. Names and values are translated to the language of the 'lang' query parameter or the 'Accept-Language' header")
+
+	router.AddRoute("/attributes", RequireEmployee(handler.handleCreate)).
+		Methods("POST").
+		Name("Create attribute").
+		Description("Create a variant attribute (employees only). Type is text, number, boolean or option, option attributes require options").
+		Schema(db.AttributeCreate{Code: "material", Name: "Material", Type: "option", Options: []string{"cotton", "linen"}})
+
+	router.AddRoute("/attributes/{id:[0-9]+}", RequireEmployee(handler.handleDelete)).
+		Methods("DELETE").
+		Name("Delete attribute").
+		Description("Delete an attribute that no variant uses (employees only). The attribute is removed from category attribute sets")
+
+	router.AddRoute("/attributes/{id:[0-9]+}/options", RequireEmployee(handler.handleAddOption)).
+		Methods("POST").
+		Name("Add attribute option").
+		Description("Add a value to an option attribute (employees only). Options can have a hex code or a swatch image, e.g. colors").
+		Schema(db.AttributeOptionCreateUpdate{Value: "Navy", HexCode: "#1f2a44"})
+
+	router.AddRoute("/attributes/{id:[0-9]+}/options/{optionId:[0-9]+}", RequireEmployee(handler.handleUpdateOption)).
+		Methods("PUT").
+		Name("Update attribute option").
+		Description("Replace the value, hex code and swatch image of an option (employees only). Empty sort_order keeps the position").
+		Schema(db.AttributeOptionCreateUpdate{Value: "Navy", SortOrder: new(int32), HexCode: "#1f2a44", SwatchFileId: new(int64)})
+
+	router.AddRoute("/attributes/{id:[0-9]+}/options/{optionId:[0-9]+}", RequireEmployee(handler.handleDeleteOption)).
+		Methods("DELETE").
+		Name("Delete attribute option").
+		Description("Delete an option that no variant uses (employees only)")
+
+	router.AddRoute("/categories/{id:[0-9]+}/attributes", handler.handleGetCategoryAttributes).
+		Methods("GET").
+		Name("Get category attributes").
+		Description("Get the attribute set of the category including the attributes inherited from parent categories")
+
+	router.AddRoute("/categories/{id:[0-9]+}/attributes", RequireEmployee(handler.handleSetCategoryAttributes)).
+		Methods("PUT").
+		Name("Set category attributes").
+		Description("Replace the own attribute set of the category (employees only). Variants of the category products must have the required attributes").
+		Schema([]db.CategoryAttributeSet{{AttributeId: 1, IsRequired: true, SortOrder: 1}})
+}
+
+func (h *attributeHandler) handleGetAll(w http.ResponseWriter, req *http.Request) {
+	attributes, err := h.EntityStore.GetAll(req.Context(), getRequestLanguages(req))
+	if err != nil {
+		respondWithAttributeError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, attributes)
+}
+
+func (h *attributeHandler) handleCreate(w http.ResponseWriter, req *http.Request) {
+	body := &db.AttributeCreate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	attribute, err := h.EntityStore.Create(req.Context(), body)
+	if err != nil {
+		respondWithAttributeError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, attribute)
+}
+
+func (h *attributeHandler) handleDelete(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.EntityStore.Delete(req.Context(), id); err != nil {
+		respondWithAttributeError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (h *attributeHandler) handleAddOption(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	body := &db.AttributeOptionCreateUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	option, err := h.EntityStore.AddOption(req.Context(), id, body)
+	if err != nil {
+		respondWithAttributeError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, option)
+}
+
+func (h *attributeHandler) handleUpdateOption(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	optionId, err := strconv.ParseInt(vars["optionId"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid option ID", http.StatusBadRequest)
+		return
+	}
+
+	body := &db.AttributeOptionCreateUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	option, err := h.EntityStore.UpdateOption(req.Context(), id, optionId, body)
+	if err != nil {
+		respondWithAttributeError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, option)
+}
+
+func (h *attributeHandler) handleDeleteOption(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	optionId, err := strconv.ParseInt(vars["optionId"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid option ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.EntityStore.DeleteOption(req.Context(), id, optionId); err != nil {
+		respondWithAttributeError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (h *attributeHandler) handleGetCategoryAttributes(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	attributes, err := h.EntityStore.GetCategoryAttributes(req.Context(), id)
+	if err != nil {
+		respondWithAttributeError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, attributes)
+}
+
+func (h *attributeHandler) handleSetCategoryAttributes(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	body := make([]db.CategoryAttributeSet, 0)
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.EntityStore.SetCategoryAttributes(req.Context(), id, body); err != nil {
+		respondWithAttributeError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func respondWithAttributeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrAttributeNotFound), errors.Is(err, db.ErrCategoryNotFound):
+		tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, db.ErrAttributeExists), errors.Is(err, db.ErrAttributeInUse):
+		tools.RespondWithError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrInvalidAttribute):
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Unexpected attribute error: %s", err.Error())
+		tools.RespondWithError(w, "Unexpected attribute error", http.StatusInternalServerError)
+	}
+}
@@ -238,3 +238,20 @@ func (handler *authHandler) handleEmployeeSignup(w http.ResponseWriter, req *htt
 func (handler *authHandler) handleVerify(w http.ResponseWriter, req *http.Request) {
 	tools.RespondWithSuccess(w, "Authorized")
 }
+
+// Responds with the public verification keys in the JWKS format (RFC 7517).
+// The response is not wrapped into the common response structure, so that standard JWT libraries can consume it
+func handleJWKS(w http.ResponseWriter, req *http.Request) {
+	keySet, err := tools.GetJSONWebKeySet()
+	if err != nil {
+		log.Printf("Error loading JWT key set: %v", err)
+		tools.RespondWithError(w, errInternalError.Message, errInternalError.Code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json;encoding=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(keySet); err != nil {
+		log.Printf("Error encoding JWT key set: %v", err)
+	}
+}
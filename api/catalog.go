@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/router"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/schema"
+)
+
+const MaxCatalogImportSize = 32 << 20 // 32mb
+
+var ErrForbiddenImageHost = errors.New("image URL points to a local or private network address")
+
+// Shared address space of carrier-grade NAT, not covered by netip.Addr.IsPrivate
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+type catalogHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.CatalogStore
+}
+
+type catalogQueryParams struct {
+	// csv, jsonl or json. Imports use the Content-Type header when the format is not set
+	Format string `schema:"format" json:"format"`
+	// Validate the file and report the changes without saving them
+	DryRun bool `schema:"dry_run,default:false" json:"dry_run"`
+}
+
+func InitCatalogRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := catalogHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewCatalogStore(opts.DatabaseConnection, NewCatalogImageLoader(NewImageDownloadClient(30*time.Second))),
+	}
+
+	router := parent.Subrouter()
+
+	router.AddRoute("/catalog/export", RequireEmployee(handler.handleExport)).
+		Methods("GET").
+		Name("Export catalog").
+		Description("Download every product variant as one row with the category name, attribute values (e.g. size and color), prices in the default currency, stock and image URLs (employees only). " +
+			"The file can be imported back without changes").
+		Schema(catalogQueryParams{Format: "<csv | jsonl | json>"})
+
+	router.AddRoute("/catalog/import", RequireEmployee(handler.handleImport)).
+		Methods("POST").
+		Name("Import catalog").
+		Description("Create or update products from a CSV ('text/csv'), JSON lines ('application/x-ndjson') or JSON array body (employees only). " +
+			"Rows with product_id update the product, other rows are matched by product name. Variants are matched by attributes. " +
+			"Images are http(s) URLs. Nothing is saved if any row is invalid, the error details list the invalid rows").
+		Schema(catalogQueryParams{Format: "<optional csv | jsonl | json>", DryRun: true})
+}
+
+func (handler *catalogHandler) handleExport(w http.ResponseWriter, req *http.Request) {
+	queryParams, ok := parseCatalogQueryParams(w, req)
+	if !ok {
+		return
+	}
+	format := queryParams.Format
+	if format == "" {
+		format = "csv"
+	}
+	contentType, exists := catalogContentTypes[format]
+	if !exists {
+		tools.RespondWithError(w, fmt.Sprintf("Unsupported format '%s'", format), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := handler.EntityStore.Export(req.Context())
+	if err != nil {
+		log.Printf("Error while exporting catalog: %s", err.Error())
+		tools.RespondWithError(w, "Cannot export catalog", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "catalog."+format))
+	if err := db.WriteCatalog(w, format, rows); err != nil {
+		log.Printf("Error while writing catalog export: %s", err.Error())
+	}
+}
+
+func (handler *catalogHandler) handleImport(w http.ResponseWriter, req *http.Request) {
+	queryParams, ok := parseCatalogQueryParams(w, req)
+	if !ok {
+		return
+	}
+	format := queryParams.Format
+	if format == "" {
+		format = "json"
+		mediaType := req.Header.Get("Content-Type")
+		for name, contentType := range catalogContentTypes {
+			if strings.HasPrefix(mediaType, strings.Split(contentType, ";")[0]) {
+				format = name
+			}
+		}
+	}
+
+	rows, err := db.ParseCatalog(http.MaxBytesReader(w, req.Body, MaxCatalogImportSize), format)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := handler.EntityStore.Import(req.Context(), rows, &db.CatalogImportOptions{
+		EmployeeId: req.Context().Value("user").(*tools.UserTokenClaims).Id,
+		DryRun:     queryParams.DryRun,
+	})
+	if err != nil {
+		log.Printf("Error while importing catalog: %s", err.Error())
+		tools.RespondWithErrorDetails(w, "Catalog import failed", report, http.StatusInternalServerError)
+		return
+	}
+	if len(report.Errors) > 0 {
+		tools.RespondWithErrorDetails(w, db.ErrInvalidCatalog.Error(), report, http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, report)
+}
+
+func parseCatalogQueryParams(w http.ResponseWriter, req *http.Request) (*catalogQueryParams, bool) {
+	queryParams := &catalogQueryParams{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(queryParams, req.URL.Query()); err != nil {
+		tools.RespondWithError(w, "Invalid query params", http.StatusBadRequest)
+		return nil, false
+	}
+	return queryParams, true
+}
+
+var catalogContentTypes = map[string]string{
+	"csv":   "text/csv; charset=utf-8",
+	"jsonl": "application/x-ndjson",
+	"json":  "application/json",
+}
+
+// Returns an image loader for catalog imports. Images are downloaded from http(s) URLs or read
+// from the local disk and converted like uploaded files
+func NewCatalogImageLoader(client *http.Client) db.CatalogImageLoader {
+	return func(ctx context.Context, ref string) (*db.FileEntity, error) {
+		var content []byte
+		var err error
+		if parsed, parseErr := url.Parse(ref); parseErr == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") {
+			content, err = downloadImage(ctx, client, ref)
+		} else {
+			content, err = os.ReadFile(ref)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return saveImage(content)
+	}
+}
+
+// Returns a client for downloading images of untrusted URLs. Every connection, including the ones
+// of redirects, is checked after the host is resolved, so URLs cannot reach the internal network
+func NewImageDownloadClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenImageHost, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		// Proxies are not used, they would connect to the checked hosts instead of the dialer
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("stopped after 5 redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("unsupported redirect scheme '%s'", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+func downloadImage(ctx context.Context, client *http.Client, imageURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "image/") {
+		return nil, fmt.Errorf("%w: response content type is '%s'", ErrInvalidImageFormat, resp.Header.Get("Content-Type"))
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > MaxFileSize {
+		return nil, errors.New("image is larger than 2MB")
+	}
+	return content, nil
+}
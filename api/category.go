@@ -1,6 +1,9 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"netshop/main/db"
 	"netshop/main/tools"
@@ -13,12 +16,14 @@ import (
 type categoryHandler struct {
 	DatabaseConnection *db.DatabaseConnection
 	EntityStore        *db.CategoryEntityStore
+	StockAlertStore    *db.StockAlertStore
 }
 
 func InitCategoryRouter(parent *router.Router, opts *InitEndpointsOptions) {
 	handler := categoryHandler{
 		DatabaseConnection: opts.DatabaseConnection,
 		EntityStore:        db.NewCategoryEntityStore(opts.DatabaseConnection),
+		StockAlertStore:    db.NewStockAlertStore(opts.DatabaseConnection),
 	}
 	router := parent.Subrouter()
 
@@ -27,14 +32,52 @@ func InitCategoryRouter(parent *router.Router, opts *InitEndpointsOptions) {
 		Name("Get all categories").
 		Description("Get all categories")
 
+	router.AddRoute("/categories/tree", handler.handleGetTree).
+		Methods("GET").
+		Name("Get category tree").
+		Description("Get root categories with nested subcategories ordered by sort order. Product counts include the products of subcategories")
+
 	router.AddRoute("/categories/{id:[0-9]+}", handler.handleGetById).
 		Methods("GET").
 		Name("Get category entity").
 		Description("Get category by given id")
+
+	router.AddRoute("/categories/slug/{slug}", handler.handleGetBySlug).
+		Methods("GET").
+		Name("Get category by slug").
+		Description("Get category by its URL slug")
+
+	router.AddRoute("/categories/{id:[0-9]+}/breadcrumbs", handler.handleGetBreadcrumbs).
+		Methods("GET").
+		Name("Get category breadcrumbs").
+		Description("Get the categories from the root category to the given category")
+
+	router.AddRoute("/categories", RequireEmployee(handler.handleCreate)).
+		Methods("POST").
+		Name("Create category").
+		Description("Create a category, optionally under a parent category (employees only). The slug is generated from the name when empty").
+		Schema(db.CategoryCreateUpdate{Name: "Shirts", Slug: "shirts", Description: "Shirts and blouses", ParentId: new(int64), SortOrder: 1})
+
+	router.AddRoute("/categories/{id:[0-9]+}", RequireEmployee(handler.handleUpdate)).
+		Methods("PUT").
+		Name("Update category").
+		Description("Update or move a category (employees only). A category cannot be moved under its own subcategories").
+		Schema(db.CategoryCreateUpdate{Name: "Shirts", Slug: "shirts", Description: "Shirts and blouses", ParentId: new(int64), SortOrder: 1})
+
+	router.AddRoute("/categories/{id:[0-9]+}", RequireEmployee(handler.handleDelete)).
+		Methods("DELETE").
+		Name("Delete category").
+		Description("Delete a category without subcategories and products (employees only)")
+
+	router.AddRoute("/categories/{id:[0-9]+}/reorder-threshold", RequireEmployee(handler.handleSetReorderThreshold)).
+		Methods("PUT").
+		Name("Set category reorder threshold").
+		Description("Set the default stock level at which low stock alerts are raised for variants of the category (employees only)").
+		Schema(reorderThresholdUpdate{})
 }
 
 func (c *categoryHandler) handleGet(w http.ResponseWriter, req *http.Request) {
-	items, err := c.EntityStore.GetCategories()
+	items, err := c.EntityStore.GetCategories(getRequestLanguages(req))
 	if err != nil {
 		tools.RespondWithError(w, "Unexpected error while received categories", http.StatusInternalServerError)
 		return
@@ -43,6 +86,110 @@ func (c *categoryHandler) handleGet(w http.ResponseWriter, req *http.Request) {
 	tools.RespondWithSuccess(w, items)
 }
 
+func (c *categoryHandler) handleGetTree(w http.ResponseWriter, req *http.Request) {
+	tree, err := c.EntityStore.GetTree(req.Context(), getRequestLanguages(req))
+	if err != nil {
+		log.Printf("Error while getting category tree: %s", err.Error())
+		tools.RespondWithError(w, "Cannot get category tree", http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, tree)
+}
+
+func (c *categoryHandler) handleGetBySlug(w http.ResponseWriter, req *http.Request) {
+	category, err := c.EntityStore.GetCategoryBySlug(mux.Vars(req)["slug"], getRequestLanguages(req))
+	if err != nil {
+		tools.RespondWithError(w, "Category not found", http.StatusNotFound)
+		return
+	}
+
+	tools.RespondWithSuccess(w, category)
+}
+
+func (c *categoryHandler) handleGetBreadcrumbs(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	breadcrumbs, err := c.EntityStore.GetBreadcrumbs(req.Context(), id, getRequestLanguages(req))
+	if err != nil {
+		respondWithCategoryError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, breadcrumbs)
+}
+
+func (c *categoryHandler) handleCreate(w http.ResponseWriter, req *http.Request) {
+	body := &db.CategoryCreateUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	category, err := c.EntityStore.Create(req.Context(), body)
+	if err != nil {
+		respondWithCategoryError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, category)
+}
+
+func (c *categoryHandler) handleUpdate(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	body := &db.CategoryCreateUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	category, err := c.EntityStore.Update(req.Context(), id, body)
+	if err != nil {
+		respondWithCategoryError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, category)
+}
+
+func (c *categoryHandler) handleDelete(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := c.EntityStore.Delete(req.Context(), id); err != nil {
+		respondWithCategoryError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func respondWithCategoryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrCategoryNotFound):
+		tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, db.ErrCategoryExists), errors.Is(err, db.ErrCategoryInUse):
+		tools.RespondWithError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrInvalidCategory):
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Unexpected category error: %s", err.Error())
+		tools.RespondWithError(w, "Unexpected category error", http.StatusInternalServerError)
+	}
+}
+
 func (c *categoryHandler) handleGetById(w http.ResponseWriter, req *http.Request) {
 	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
 	if err != nil {
@@ -50,7 +197,7 @@ func (c *categoryHandler) handleGetById(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	product, err := c.EntityStore.GetCategoryById(id)
+	product, err := c.EntityStore.GetCategoryById(id, getRequestLanguages(req))
 	if err != nil {
 		tools.RespondWithError(w, "Category not found", http.StatusNotFound)
 		return
@@ -58,3 +205,28 @@ func (c *categoryHandler) handleGetById(w http.ResponseWriter, req *http.Request
 
 	tools.RespondWithSuccess(w, product)
 }
+
+func (c *categoryHandler) handleSetReorderThreshold(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	body := reorderThresholdUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.Threshold != nil && *body.Threshold < 0 {
+		tools.RespondWithError(w, "Threshold must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if err := c.StockAlertStore.SetCategoryThreshold(req.Context(), id, body.Threshold); err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
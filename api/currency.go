@@ -0,0 +1,76 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/router"
+	"strings"
+)
+
+type currencyHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.ExchangeRateStore
+}
+
+func InitCurrencyRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := currencyHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewExchangeRateStore(opts.DatabaseConnection),
+	}
+
+	router := parent.Subrouter()
+
+	router.AddRoute("/exchange-rates", handler.handleGet).
+		Methods("GET").
+		Name("Get exchange rates").
+		Description("Get the amount of every supported currency for one unit of the default currency")
+
+	router.AddRoute("/exchange-rates", RequireEmployee(handler.handleSave)).
+		Methods("PUT").
+		Name("Update exchange rates").
+		Description("Insert or update exchange rates (employees only). Accepts a JSON array or a 'text/csv' body with 'currency,rate' rows").
+		Schema([]db.ExchangeRateEntity{{Currency: "USD", Rate: "0.0242"}})
+}
+
+func (handler *currencyHandler) handleGet(w http.ResponseWriter, req *http.Request) {
+	rates, err := handler.EntityStore.GetAll()
+	if err != nil {
+		log.Printf("Error while getting exchange rates: %s", err.Error())
+		tools.RespondWithError(w, "Cannot get exchange rates", http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, rates)
+}
+
+func (handler *currencyHandler) handleSave(w http.ResponseWriter, req *http.Request) {
+	format := "json"
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "text/csv") {
+		format = "csv"
+	}
+
+	rates, err := db.ParseExchangeRates(req.Body, format)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := handler.EntityStore.Save(req.Context(), rates); err != nil {
+		log.Printf("Error while saving exchange rates: %s", err.Error())
+		tools.RespondWithError(w, "Cannot save exchange rates", http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+// Returns the currency requested by the client: the "currency" query parameter has priority
+// over the "Accept-Currency" header. Empty result means the default currency
+func getRequestCurrency(req *http.Request) string {
+	if currency := req.URL.Query().Get("currency"); currency != "" {
+		return strings.ToUpper(currency)
+	}
+	return strings.ToUpper(strings.TrimSpace(req.Header.Get("Accept-Currency")))
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/pdf"
+	"netshop/main/tools/router"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Issued documents are not served by the static file server
+const DocumentsDirectory = "./static/documents/"
+
+type documentHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.DocumentStore
+	OrderStore         *db.OrderEntityStore
+}
+
+func InitDocumentRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := documentHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewDocumentStore(opts.DatabaseConnection, DocumentsDirectory, renderOrderDocument),
+		OrderStore:         db.NewOrderEntity(opts.DatabaseConnection),
+	}
+
+	router := parent.Subrouter()
+
+	router.AddRoute("/orders/{id:[0-9]+}/invoice.pdf", RequireAuth(handler.handleGetInvoice)).
+		Methods("GET").
+		Name("Get order invoice").
+		Description("Download the invoice of a processed order. The invoice gets the next sequential number on the first download and never changes after that. Customers can get only invoices of their own orders")
+
+	router.AddRoute("/orders/{id:[0-9]+}/packing-slip.pdf", RequireEmployee(handler.handleGetPackingSlip)).
+		Methods("GET").
+		Name("Get order packing slip").
+		Description("Download the packing slip of a processed order (employees only)")
+}
+
+func (handler *documentHandler) handleGetInvoice(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	user := req.Context().Value("user").(*tools.UserTokenClaims)
+	if user.Type != authEmployeeTypeStr {
+		orders, err := handler.OrderStore.GetAll(&db.OrderGetAllOptions{Id: &id, CustomerId: &user.Id})
+		if err != nil {
+			tools.RespondWithError(w, "Cannot get order", http.StatusInternalServerError)
+			return
+		}
+		if len(orders) == 0 {
+			tools.RespondWithError(w, "Order not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	handler.serveDocument(w, req, id, db.DocumentTypeInvoice)
+}
+
+func (handler *documentHandler) handleGetPackingSlip(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	handler.serveDocument(w, req, id, db.DocumentTypePackingSlip)
+}
+
+func (handler *documentHandler) serveDocument(w http.ResponseWriter, req *http.Request, orderId int64, docType string) {
+	document, err := handler.EntityStore.GetOrIssue(req.Context(), orderId, docType)
+	if err != nil {
+		if errors.Is(err, db.ErrDocumentNotAvailable) {
+			tools.RespondWithError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("Error while issuing %s of order %d: %s", docType, orderId, err.Error())
+		tools.RespondWithError(w, "Cannot get document", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%d.pdf", docType, orderId)
+	if document.Number != "" {
+		filename = fmt.Sprintf("%s-%s.pdf", docType, document.Number)
+	}
+	w.Header().Set("Content-Type", document.File.Filetype)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename))
+	http.ServeFile(w, req, document.File.Path)
+}
+
+// Renders an invoice or a packing slip. Packing slips list only the items and quantities
+func renderOrderDocument(data *db.OrderDocumentData) ([]byte, error) {
+	order := data.Order
+	document := pdf.NewDocument()
+	page := document.AddPage()
+
+	const left, right = 50.0, pdf.PageWidth - 50
+	title := "PACKING SLIP"
+	if data.Type == db.DocumentTypeInvoice {
+		title = "INVOICE " + data.Number
+	}
+	page.Text(left, 70, 20, true, title)
+	page.TextRight(right, 70, 14, true, "netshop")
+	page.Text(left, 95, 10, false, fmt.Sprintf("Order #%d of %s", order.Id, order.OrderDate.Format("2006-01-02")))
+	page.Text(left, 110, 10, false, "Issued "+data.IssuedAt.Format("2006-01-02"))
+
+	y := 145.0
+	if data.Customer != nil && data.Customer.Person != nil {
+		person := data.Customer.Person
+		page.Text(left, y, 10, true, "Customer")
+		page.Text(left, y+15, 10, false, person.FirstName+" "+person.LastName)
+		page.Text(left, y+30, 10, false, person.Email)
+		page.Text(left, y+45, 10, false, person.Phone)
+	}
+	page.Text(300, y, 10, true, "Delivery address")
+	page.Text(300, y+15, 10, false, order.DeliveryAddress)
+	page.Text(300, y+30, 10, false, order.DeliveryZipcode+" "+order.DeliveryCity)
+	page.Text(300, y+45, 10, false, order.DeliveryCountry)
+
+	isInvoice := data.Type == db.DocumentTypeInvoice
+	tableHeader := func(y float64) {
+		page.Text(left, y, 10, true, "Item")
+		page.TextRight(330, y, 10, true, "Qty")
+		if isInvoice {
+			page.TextRight(410, y, 10, true, "Price")
+			page.TextRight(470, y, 10, true, "Tax %")
+			page.TextRight(right, y, 10, true, "Amount")
+		}
+		page.Line(left, y+6, right, y+6)
+	}
+
+	y = 225
+	tableHeader(y)
+	for _, item := range order.Items {
+		y += 18
+		if y > pdf.PageHeight-60 {
+			page = document.AddPage()
+			y = 60
+			tableHeader(y)
+			y += 18
+		}
+
+		// The name ends before the widest quantities of the next column
+		page.Text(left, y, 10, false, truncateText(data.ItemNames[item.Id], 330-left-40, 10, false))
+		page.TextRight(330, y, 10, false, strconv.FormatUint(uint64(item.Quantity), 10))
+		if isInvoice {
+			page.TextRight(410, y, 10, false, item.Price.Decimal())
+			page.TextRight(470, y, 10, false, item.TaxRate.String())
+			page.TextRight(right, y, 10, false, item.TaxBase.Decimal())
+		}
+	}
+	if !isInvoice {
+		return document.Bytes(), nil
+	}
+
+	totals := [][2]string{{"Subtotal", order.Subtotal.String()}}
+	if !order.DiscountTotal.IsZero() {
+		totals = append(totals, [2]string{"Discount", order.DiscountTotal.Neg().String()})
+	}
+	for _, tax := range order.TaxSummary {
+		label := fmt.Sprintf("Tax %s%% on %s", tax.Rate.String(), tax.Base.Decimal())
+		if tax.Inclusive {
+			label += " (included)"
+		}
+		totals = append(totals, [2]string{label, tax.Amount.String()})
+	}
+	if order.ShippingMethodId != nil || !order.ShippingTotal.IsZero() {
+		totals = append(totals, [2]string{"Shipping", order.ShippingTotal.String()})
+	}
+
+	y += 10
+	if y+float64(len(totals)+2)*16 > pdf.PageHeight-40 {
+		page = document.AddPage()
+		y = 60
+	}
+	page.Line(left, y, right, y)
+	for _, total := range totals {
+		y += 16
+		page.TextRight(440, y, 10, false, total[0])
+		page.TextRight(right, y, 10, false, total[1])
+	}
+	y += 20
+	page.TextRight(440, y, 12, true, "Total")
+	page.TextRight(right, y, 12, true, order.Total.String())
+
+	return document.Bytes(), nil
+}
+
+// Shortens the text by whole characters with an ellipsis, so it fits the width
+func truncateText(text string, width float64, size float64, bold bool) string {
+	if pdf.TextWidth(text, size, bold) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && pdf.TextWidth(string(runes)+"...", size, bold) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
@@ -125,3 +125,32 @@ func (f *fileHandler) handleUpload(w http.ResponseWriter, req *http.Request) {
 	log.Printf("file/upload: File entity created successfully: %+v", fileEntity)
 	tools.RespondWithSuccess(w, fileEntity)
 }
+
+// Converts the image like uploaded files and stores it in the files directory.
+// The returned file entity is not saved to the database
+func saveImage(content []byte) (*db.FileEntity, error) {
+	if len(content) > MaxFileSize {
+		return nil, ErrInvalidFileSize
+	}
+	if !allowedMIMETypes[http.DetectContentType(content)] {
+		return nil, ErrInvalidImageFormat
+	}
+
+	uploadedImage, err := image.UploadImage(content, image.UploadImageOptions{
+		Dirname:   FilesDirectory,
+		Quality:   ImageQuality,
+		Watermark: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &db.FileEntity{
+		Filename:  uploadedImage.Filename,
+		Filetype:  uploadedImage.MimeType,
+		Path:      uploadedImage.Path,
+		Width:     uploadedImage.Width,
+		Height:    uploadedImage.Height,
+		SizeBytes: uploadedImage.Size,
+	}, nil
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"netshop/main/db"
 	"netshop/main/tools"
+	"netshop/main/tools/payment"
 	"netshop/main/tools/router"
 	"path"
 	"strings"
@@ -15,6 +16,7 @@ import (
 
 type InitEndpointsOptions struct {
 	DatabaseConnection *db.DatabaseConnection
+	PaymentProvider    payment.PaymentProvider
 }
 
 func moveRouterToMux(router *router.Router, muxRouter *mux.Router) {
@@ -76,10 +78,13 @@ func InitAndCreateRouter(opts *InitEndpointsOptions) http.Handler {
 		http.StripPrefix("/static/files/", fileServer).ServeHTTP(w, r)
 	})
 
+	// Public keys used by other services to verify tokens issued by netshop
+	muxRouter.HandleFunc("/.well-known/jwks.json", handleJWKS).Methods("GET")
+
 	corsConfig := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "Accept-Currency", "Accept-Language", "Idempotency-Key"},
 		AllowCredentials: true,
 	})
 
@@ -88,9 +93,24 @@ func InitAndCreateRouter(opts *InitEndpointsOptions) http.Handler {
 
 	InitAuthRouter(router, opts)
 	InitCategoryRouter(router, opts)
+	InitAttributeRouter(router, opts)
+	InitTranslationRouter(router, opts)
 	InitProductsRouter(router, opts)
+	InitProductRelationRouter(router, opts)
 	InitFileRouter(router, opts)
 	InitOrderRouter(router, opts)
+	InitStockRouter(router, opts)
+	InitPromotionRouter(router, opts)
+	InitCurrencyRouter(router, opts)
+	InitTaxRouter(router, opts)
+	InitShippingRouter(router, opts)
+	InitPaymentRouter(router, opts)
+	InitReturnRouter(router, opts)
+	InitReviewRouter(router, opts)
+	InitWishlistRouter(router, opts)
+	InitDocumentRouter(router, opts)
+	InitReportRouter(router, opts)
+	InitCatalogRouter(router, opts)
 
 	// move all registered routes to the mux router to be able to use it
 	moveRouterToMux(router, muxRouter)
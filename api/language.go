@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/http"
+	"netshop/main/tools/i18n"
+)
+
+// Returns the fallback chain of the translation languages requested by the client: the "lang"
+// query parameter has priority over the "Accept-Language" header. Empty result means the base
+// texts in the default language
+func getRequestLanguages(req *http.Request) []string {
+	preferred := make([]string, 0)
+	if language := req.URL.Query().Get("lang"); language != "" {
+		preferred = append(preferred, language)
+	}
+	preferred = append(preferred, i18n.ParseAcceptLanguage(req.Header.Get("Accept-Language"))...)
+	return i18n.FallbackChain(preferred)
+}
@@ -45,6 +45,39 @@ func RequireAuth(handler http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
+// Same as RequireAuth, but allows only employees to access the handler
+func RequireEmployee(handler http.HandlerFunc) http.HandlerFunc {
+	return requireUserType(authEmployeeTypeStr, handler)
+}
+
+// Same as RequireAuth, but allows only customers to access the handler
+func RequireCustomer(handler http.HandlerFunc) http.HandlerFunc {
+	return requireUserType(authCustomerTypeStr, handler)
+}
+
+func requireUserType(userType string, handler http.HandlerFunc) http.HandlerFunc {
+	return RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(*tools.UserTokenClaims)
+		if user.Type != userType {
+			tools.RespondWithError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		handler(w, r)
+	})
+}
+
+// Returns true for requests with a valid token of an employee. Public endpoints use it
+// to show employees the products that are not published yet
+func isEmployeeRequest(r *http.Request) bool {
+	var token string
+	if _, err := fmt.Sscanf(r.Header.Get("Authorization"), "Bearer %s", &token); err != nil {
+		return false
+	}
+	claims, err := tools.ParseJWTToken(strings.TrimSpace(token))
+	return err == nil && claims.Type == authEmployeeTypeStr
+}
+
 func RequireGuest(handler http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
@@ -1,38 +1,310 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"netshop/main/db"
 	"netshop/main/tools"
+	"netshop/main/tools/money"
 	"netshop/main/tools/router"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
 )
 
 type orderHandler struct {
 	DatabaseConnection *db.DatabaseConnection
 	EntityStore        *db.OrderEntityStore
+	PaymentStore       *db.PaymentStore
+}
+
+type orderStatusUpdate struct {
+	Status string `json:"status"`
+}
+
+type orderGetAllQueryParams struct {
+	Status         *string      `schema:"status" json:"status"`
+	CustomerId     *int64       `schema:"customer_id" json:"customer_id"`
+	OrderDateFrom  *time.Time   `schema:"order_date_from" json:"order_date_from"`
+	OrderDateTo    *time.Time   `schema:"order_date_to" json:"order_date_to"`
+	StatusDateFrom *time.Time   `schema:"status_date_from" json:"status_date_from"`
+	StatusDateTo   *time.Time   `schema:"status_date_to" json:"status_date_to"`
+	Country        *string      `schema:"country" json:"country"`
+	MinTotal       *money.Money `schema:"min_total" json:"min_total"`
+	MaxTotal       *money.Money `schema:"max_total" json:"max_total"`
+	Search         *string      `schema:"q" json:"q"`
+	Limit          int64        `schema:"limit,default:0" json:"limit"`
+	Offset         int64        `schema:"offset,default:0" json:"offset"`
+	OrderColumn    string       `schema:"order_column,default:id" json:"order_column"`
+	OrderAsc       bool         `schema:"order_asc,default:false" json:"order_asc"`
+}
+
+type orderCreate struct {
+	Delivery struct {
+		Address string `json:"address"`
+		Zipcode string `json:"zipcode"`
+		City    string `json:"city"`
+		Country string `json:"country"`
+	} `json:"delivery"`
+	Items            []*db.OrderItemCreateUpdate `json:"items"`
+	PromotionCode    string                      `json:"promotion_code"`
+	Currency         string                      `json:"currency"`
+	ShippingMethodId *int64                      `json:"shipping_method_id"`
 }
 
 func InitOrderRouter(parent *router.Router, opts *InitEndpointsOptions) {
 	handler := orderHandler{
 		DatabaseConnection: opts.DatabaseConnection,
 		EntityStore:        db.NewOrderEntity(opts.DatabaseConnection),
+		PaymentStore:       db.NewPaymentStore(opts.DatabaseConnection, opts.PaymentProvider),
 	}
 
 	router := parent.Subrouter()
 
 	router.AddRoute("/orders", RequireAuth(handler.handleGet)).
 		Methods("GET").
-		Name("Get user's orders").
-		Description("Get all orders of the current user")
+		Name("Get orders").
+		Description("Get orders of the current customer, employees get orders of all customers. " +
+			"Supports filtering by status, dates, customer, country and total in the default currency, search by order id, customer name or email ('q'), sorting and pagination. " +
+			"Dates are RFC 3339 or YYYY-MM-DD").
+		Schema(orderGetAllQueryParams{
+			Limit:       20,
+			OrderColumn: "<id | order_date | status_date | total>",
+		})
+
+	router.AddRoute("/orders/{id:[0-9]+}", RequireAuth(handler.handleGetById)).
+		Methods("GET").
+		Name("Get order").
+		Description("Get the order with its customer and item details. Customers can get only their own orders")
+
+	router.AddRoute("/orders/{id:[0-9]+}/delivery", RequireEmployee(handler.handleUpdateDelivery)).
+		Methods("PUT").
+		Name("Update order delivery").
+		Description("Change the delivery address of a pending or processing order (employees only). The country cannot be changed and a new zipcode must not change the order taxes").
+		Schema(db.OrderDeliveryUpdate{
+			Address: "Lesi Ukrainky Blvd, 26",
+			Zipcode: "01133",
+			City:    "Kyiv",
+			Country: "Ukraine",
+		})
+
+	router.AddRoute("/orders", RequireCustomer(handler.handleCreate)).
+		Methods("POST").
+		Name("Create order").
+		Description("Create a new pending order of the current customer. The stock of the items is reserved until the order is processed").
+		Schema(map[string]interface{}{
+			"delivery": map[string]string{
+				"address": "Lesi Ukrainky Blvd, 26",
+				"zipcode": "01133",
+				"city":    "Kyiv",
+				"country": "Ukraine",
+			},
+			"items":              []db.OrderItemCreateUpdate{{ProductVariantId: 1, Quantity: 2}},
+			"promotion_code":     "<optional string>",
+			"currency":           "<optional currency, defaults to the Accept-Currency header>",
+			"shipping_method_id": "<optional id of a method returned by POST /shipping/quote>",
+		})
+
+	router.AddRoute("/orders/{id:[0-9]+}/status", RequireEmployee(handler.handleUpdateStatus)).
+		Methods("PUT").
+		Name("Update order status").
+		Description("Update the order status (employees only). Moving a pending order to processing confirms its stock reservations, cancellation returns the stock and refunds captured payments").
+		Schema(orderStatusUpdate{Status: "<pending | processing | shipped | delivered | cancelled>"})
 }
 
 func (handler *orderHandler) handleGet(w http.ResponseWriter, r *http.Request) {
-	customerId := r.Context().Value("user").(*tools.UserTokenClaims).Id
-	items, err := handler.EntityStore.GetAll(&db.OrderGetAllOptions{CustomerId: &customerId})
+	queryParams := orderGetAllQueryParams{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	decoder.RegisterConverter(time.Time{}, convertQueryTime)
+	if err := decoder.Decode(&queryParams, r.URL.Query()); err != nil {
+		tools.RespondWithError(w, "Invalid query params", http.StatusBadRequest)
+		return
+	}
+
+	opts := &db.OrderGetAllOptions{
+		Status:         queryParams.Status,
+		CustomerId:     queryParams.CustomerId,
+		OrderDateFrom:  queryParams.OrderDateFrom,
+		OrderDateTo:    queryParams.OrderDateTo,
+		StatusDateFrom: queryParams.StatusDateFrom,
+		StatusDateTo:   queryParams.StatusDateTo,
+		Country:        queryParams.Country,
+		MinTotal:       queryParams.MinTotal,
+		MaxTotal:       queryParams.MaxTotal,
+		Search:         queryParams.Search,
+		Limit:          queryParams.Limit,
+		Offset:         queryParams.Offset,
+		OrderColumn:    queryParams.OrderColumn,
+		OrderAsc:       queryParams.OrderAsc,
+	}
+	user := r.Context().Value("user").(*tools.UserTokenClaims)
+	if user.Type != authEmployeeTypeStr {
+		opts.CustomerId = &user.Id
+	}
+
+	items, err := handler.EntityStore.GetAll(opts)
 	if err != nil {
 		tools.RespondWithError(w, fmt.Sprintf("Cannot get orders information: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
 	tools.RespondWithSuccess(w, items)
 }
+
+func (handler *orderHandler) handleGetById(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	order, err := handler.EntityStore.GetDetails(id)
+	user := r.Context().Value("user").(*tools.UserTokenClaims)
+	if err != nil || (user.Type != authEmployeeTypeStr && order.CustomerId != user.Id) {
+		tools.RespondWithError(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	tools.RespondWithSuccess(w, order)
+}
+
+func (handler *orderHandler) handleUpdateDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	body := &db.OrderDeliveryUpdate{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.Address == "" || body.City == "" || body.Country == "" {
+		tools.RespondWithError(w, "Delivery address, city and country are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := handler.EntityStore.UpdateDelivery(r.Context(), id, body); err != nil {
+		if errors.Is(err, db.ErrInvalidDeliveryUpdate) {
+			tools.RespondWithError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		tools.RespondWithError(w, fmt.Sprintf("Cannot update order delivery: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+// Parses RFC 3339 timestamps and YYYY-MM-DD dates of query params
+func convertQueryTime(value string) reflect.Value {
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return reflect.ValueOf(parsed)
+		}
+	}
+	return reflect.Value{}
+}
+
+func (handler *orderHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	body := orderCreate{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Items) == 0 {
+		tools.RespondWithError(w, "Property 'items' is required", http.StatusBadRequest)
+		return
+	}
+	for _, item := range body.Items {
+		if item.Quantity <= 0 {
+			tools.RespondWithError(w, "Item quantity must be positive", http.StatusBadRequest)
+			return
+		}
+	}
+	if body.Delivery.Address == "" || body.Delivery.City == "" || body.Delivery.Country == "" {
+		tools.RespondWithError(w, "Delivery address, city and country are required", http.StatusBadRequest)
+		return
+	}
+
+	createOpts := &db.OrderCreateUpdateOptions{
+		CustomerId:       r.Context().Value("user").(*tools.UserTokenClaims).Id,
+		Status:           db.OrderStatusPending,
+		Items:            body.Items,
+		PromotionCode:    body.PromotionCode,
+		Currency:         body.Currency,
+		ShippingMethodId: body.ShippingMethodId,
+	}
+	if createOpts.Currency == "" {
+		createOpts.Currency = getRequestCurrency(r)
+	}
+	createOpts.Delivery.Address = body.Delivery.Address
+	createOpts.Delivery.Zipcode = body.Delivery.Zipcode
+	createOpts.Delivery.City = body.Delivery.City
+	createOpts.Delivery.Country = body.Delivery.Country
+
+	order, err := handler.EntityStore.Create(r.Context(), createOpts)
+	if err != nil {
+		if errors.Is(err, db.ErrUnsupportedCurrency) || errors.Is(err, db.ErrShippingMethodUnavailable) {
+			tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, db.ErrInsufficientStock) {
+			tools.RespondWithError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		tools.RespondWithError(w, fmt.Sprintf("Cannot create order: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, order)
+}
+
+func (handler *orderHandler) handleUpdateStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	body := orderStatusUpdate{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	switch body.Status {
+	case db.OrderStatusPending, db.OrderStatusProcessing, db.OrderStatusShipped, db.OrderStatusDelivered, db.OrderStatusCancelled:
+	default:
+		tools.RespondWithError(w, "Invalid order status", http.StatusBadRequest)
+		return
+	}
+
+	employeeId := r.Context().Value("user").(*tools.UserTokenClaims).Id
+	if err := handler.EntityStore.UpdateStatus(r.Context(), id, body.Status, &employeeId); err != nil {
+		if errors.Is(err, db.ErrReservationExpired) || errors.Is(err, db.ErrInvalidStatusTransition) {
+			tools.RespondWithError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		tools.RespondWithError(w, fmt.Sprintf("Cannot update order status: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if body.Status == db.OrderStatusCancelled {
+		if err := handler.PaymentStore.RefundOrder(r.Context(), id); err != nil {
+			log.Printf("Error while refunding cancelled order %d: %s", id, err.Error())
+			tools.RespondWithError(w, fmt.Sprintf("Order is cancelled, but the refund failed: %s", err.Error()), http.StatusBadGateway)
+			return
+		}
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
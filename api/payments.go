@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/payment"
+	"netshop/main/tools/router"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type paymentHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.PaymentStore
+}
+
+type paymentCreate struct {
+	Token          string `json:"token"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+func InitPaymentRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := paymentHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewPaymentStore(opts.DatabaseConnection, opts.PaymentProvider),
+	}
+
+	router := parent.Subrouter()
+
+	router.AddRoute("/orders/{id:[0-9]+}/payments", RequireCustomer(handler.handleCreate)).
+		Methods("POST").
+		Name("Pay order").
+		Description("Pay the pending order of the current customer. The 'Idempotency-Key' header (or 'idempotency_key' property) is required, " +
+			"retries with the same key return the same payment. Declined payments respond with 402, async payments stay pending until the gateway webhook").
+		Schema(paymentCreate{Token: "<payment method token, mock gateway: tok_visa | tok_decline | tok_async | tok_async_decline>", IdempotencyKey: "<optional if the header is set>"})
+
+	router.AddRoute("/orders/{id:[0-9]+}/payments", RequireAuth(handler.handleGet)).
+		Methods("GET").
+		Name("Get order payments").
+		Description("Get payments of the order. Customers can get only payments of their own orders")
+
+	router.AddRoute("/payments/webhook/{provider}", handler.handleWebhook).
+		Methods("POST").
+		Name("Payment webhook").
+		Description("Receive asynchronous payment status updates of the payment gateway, the request must be signed by the gateway")
+}
+
+func (handler *paymentHandler) handleCreate(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	body := paymentCreate{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	createOpts := &db.PaymentCreate{
+		OrderId:        id,
+		CustomerId:     req.Context().Value("user").(*tools.UserTokenClaims).Id,
+		IdempotencyKey: req.Header.Get("Idempotency-Key"),
+		Token:          body.Token,
+	}
+	if createOpts.IdempotencyKey == "" {
+		createOpts.IdempotencyKey = body.IdempotencyKey
+	}
+	if createOpts.IdempotencyKey == "" || createOpts.Token == "" {
+		tools.RespondWithError(w, "Idempotency key and property 'token' are required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := handler.EntityStore.Pay(req.Context(), createOpts)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrIdempotencyKeyReused), errors.Is(err, db.ErrOrderNotPayable):
+			tools.RespondWithError(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, db.ErrProviderUnavailable):
+			log.Printf("Payment provider failed while paying order %d: %s", id, err.Error())
+			tools.RespondWithError(w, err.Error(), http.StatusBadGateway)
+		default:
+			log.Printf("Error while paying order %d: %s", id, err.Error())
+			tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	if result.Status == payment.StatusDeclined {
+		tools.RespondWithError(w, "Payment declined: "+result.Message, http.StatusPaymentRequired)
+		return
+	}
+
+	tools.RespondWithSuccess(w, result)
+}
+
+func (handler *paymentHandler) handleGet(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	// Customers can see only payments of their own orders, employees see all
+	var customerId *int64
+	user := req.Context().Value("user").(*tools.UserTokenClaims)
+	if user.Type != authEmployeeTypeStr {
+		customerId = &user.Id
+	}
+
+	payments, err := handler.EntityStore.GetByOrderId(id, customerId)
+	if err != nil {
+		log.Printf("Error while getting payments: %s", err.Error())
+		tools.RespondWithError(w, "Cannot get payments", http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, payments)
+}
+
+func (handler *paymentHandler) handleWebhook(w http.ResponseWriter, req *http.Request) {
+	if mux.Vars(req)["provider"] != handler.EntityStore.Provider.Name() {
+		tools.RespondWithError(w, "Unknown payment provider", http.StatusNotFound)
+		return
+	}
+
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	event, err := handler.EntityStore.Provider.VerifyWebhook(payload, req.Header)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := handler.EntityStore.HandleWebhook(req.Context(), event); err != nil {
+		if errors.Is(err, db.ErrPaymentNotFound) {
+			tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error while handling payment webhook: %s", err.Error())
+		tools.RespondWithError(w, "Cannot handle payment webhook", http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/router"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+)
+
+const maxRelatedProductsLimit = 50
+
+type productRelationHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.ProductRelationStore
+}
+
+type relatedQueryParams struct {
+	// Only manual relations of the type, frequently bought together products are returned without it
+	Type  string `schema:"type" json:"type"`
+	Limit int64  `schema:"limit,default:10" json:"limit"`
+}
+
+func InitProductRelationRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := productRelationHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewProductRelationStore(opts.DatabaseConnection),
+	}
+	router := parent.Subrouter()
+
+	router.AddRoute("/products/{id:[0-9]+}/related", handler.handleGetRelated).
+		Methods("GET").
+		Name("Get related products").
+		Description("Get products curated by employees followed by the products most often bought together with the product. Frequently bought together products without stock are skipped, 'limit' (at most 50) limits them").
+		Schema(relatedQueryParams{Type: "<related | accessory | upsell | cross_sell>", Limit: 10})
+
+	router.AddRoute("/products/{id:[0-9]+}/relations", RequireEmployee(handler.handleCreate)).
+		Methods("POST").
+		Name("Create product relation").
+		Description("Relate another product to the product (employees only)").
+		Schema(db.ProductRelationCreate{RelatedProductId: 2, Type: "<related | accessory | upsell | cross_sell>", SortOrder: 0})
+
+	router.AddRoute("/products/{id:[0-9]+}/relations/{type}/{relatedId:[0-9]+}", RequireEmployee(handler.handleDelete)).
+		Methods("DELETE").
+		Name("Delete product relation").
+		Description("Remove the relation of the type between the products (employees only)")
+}
+
+func (h *productRelationHandler) handleGetRelated(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	queryParams := &relatedQueryParams{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(queryParams, req.URL.Query()); err != nil {
+		tools.RespondWithError(w, "Invalid query params", http.StatusBadRequest)
+		return
+	}
+	if queryParams.Limit <= 0 || queryParams.Limit > maxRelatedProductsLimit {
+		queryParams.Limit = maxRelatedProductsLimit
+	}
+
+	products, err := h.EntityStore.GetRelated(req.Context(), id, &db.RelatedProductsOptions{
+		Type:      queryParams.Type,
+		Limit:     queryParams.Limit,
+		Currency:  getRequestCurrency(req),
+		Languages: getRequestLanguages(req),
+	})
+	if err != nil {
+		respondWithProductRelationError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, products)
+}
+
+func (h *productRelationHandler) handleCreate(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	body := &db.ProductRelationCreate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.EmployeeId = req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	if err := h.EntityStore.Create(req.Context(), id, body); err != nil {
+		respondWithProductRelationError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (h *productRelationHandler) handleDelete(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+	relatedId, err := strconv.ParseInt(vars["relatedId"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid related product id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.EntityStore.Delete(req.Context(), id, relatedId, vars["type"]); err != nil {
+		respondWithProductRelationError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func respondWithProductRelationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrProductNotFound), errors.Is(err, db.ErrRelationNotFound):
+		tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, db.ErrRelationExists):
+		tools.RespondWithError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrInvalidRelation), errors.Is(err, db.ErrUnsupportedCurrency):
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Unexpected product relation error: %s", err.Error())
+		tools.RespondWithError(w, "Unexpected product relation error", http.StatusInternalServerError)
+	}
+}
@@ -3,13 +3,19 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"netshop/main/db"
 	"netshop/main/tools"
+	"netshop/main/tools/money"
 	"netshop/main/tools/router"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
@@ -18,36 +24,62 @@ import (
 type productHandler struct {
 	DatabaseConnection *db.DatabaseConnection
 	EntityStore        *db.ProductEntityStore
+	StockMovementStore *db.StockMovementStore
+	StockAlertStore    *db.StockAlertStore
+}
+
+type reorderThresholdUpdate struct {
+	// Nil threshold removes the own threshold, so the category or default threshold is used
+	Threshold *int32 `json:"threshold"`
+}
+
+type productSlugUpdate struct {
+	Slug string `json:"slug"`
+}
+
+type variantPriceUpdate struct {
+	// Decimal price in the currency of the route, e.g. "25.00"
+	Price string `json:"price"`
 }
 
 type getAllQueryParams struct {
-	CategoryIds []int64  `schema:"q_category_ids" json:"q_category_ids"`
-	SizeIds     []int64  `schema:"q_size_ids" json:"q_size_ids"`
-	ColorIds    []int64  `schema:"q_color_ids" json:"q_color_ids"`
-	MinPrice    *float64 `schema:"q_min_price" json:"q_min_price"`
-	MaxPrice    *float64 `schema:"q_max_price" json:"q_max_price"`
-	Limit       int64    `schema:"limit,default:0" json:"limit"`
-	Offset      int64    `schema:"offset,default:0" json:"offset"`
-	OrderColumn string   `schema:"order_column,default:id" json:"order_column"`
-	OrderAsc    bool     `schema:"order_asc,default:false" json:"order_asc"`
+	CategoryIds []int64      `schema:"q_category_ids" json:"q_category_ids"`
+	MinPrice    *money.Money `schema:"q_min_price" json:"q_min_price"`
+	MaxPrice    *money.Money `schema:"q_max_price" json:"q_max_price"`
+	// Attribute values as "code:value", repeated codes match any of the values
+	Attributes []string `schema:"q_attr" json:"q_attr"`
+	// Minimal average rating of approved reviews, e.g. 4
+	MinRating *float64 `schema:"q_min_rating" json:"q_min_rating"`
+	// Product statuses visible to employees, only published products are returned to others
+	Statuses []string `schema:"q_status" json:"q_status"`
+	// Full-text search in the product names and descriptions, e.g. "linen shirt"
+	Search      string `schema:"q_search" json:"q_search"`
+	Limit       int64  `schema:"limit,default:0" json:"limit"`
+	Offset      int64  `schema:"offset,default:0" json:"offset"`
+	OrderColumn string `schema:"order_column,default:id" json:"order_column"`
+	OrderAsc    bool   `schema:"order_asc,default:false" json:"order_asc"`
 }
 
 func InitProductsRouter(router *router.Router, opts *InitEndpointsOptions) {
 	handler := productHandler{
 		DatabaseConnection: opts.DatabaseConnection,
 		EntityStore:        db.NewProductEntityStore(opts.DatabaseConnection),
+		StockMovementStore: db.NewStockMovementStore(opts.DatabaseConnection),
+		StockAlertStore:    db.NewStockAlertStore(opts.DatabaseConnection),
 	}
 	productRouter := router.Subrouter()
 	productRouter.AddRoute("/products", handler.handleGet).
 		Methods("GET").
 		Name("Get products").
-		Description("Get all products. This endpoint supports filtering by category, size, color, price, and ordering.").
+		Description("Get all published products. This endpoint supports filtering by category, price, attribute values (e.g. size and color), minimal rating, full-text search, and ordering. Products can be ordered by rating_average and rating_count as well. Employees can preview products of other statuses with 'q_status'. Texts are translated to the language of the 'lang' query parameter or the 'Accept-Language' header").
 		Schema(getAllQueryParams{
 			CategoryIds: []int64{1, 2},
-			SizeIds:     []int64{3, 4},
-			ColorIds:    []int64{5, 6},
 			MinPrice:    nil,
 			MaxPrice:    nil,
+			Attributes:  []string{"size:M", "size:L", "color:Black", "material:cotton"},
+			MinRating:   nil,
+			Statuses:    []string{"draft", "scheduled"},
+			Search:      "linen shirt",
 			Limit:       0,
 			Offset:      0,
 			OrderColumn: "id",
@@ -57,28 +89,64 @@ func InitProductsRouter(router *router.Router, opts *InitEndpointsOptions) {
 	productRouter.AddRoute("/products", RequireAuth(handler.handleCreate)).
 		Methods("POST").
 		Name("Create product").
-		Description("Create a new product").
+		Description("Create a new product. New products are drafts unless the status is 'scheduled' with a publish time or 'published'").
 		Schema(&db.ProductCreateUpdate{
 			Name:        "Product name",
+			Slug:        "product-name",
 			Description: "Product description",
-			BasePrice:   10.0,
+			BasePrice:   money.New(1000, money.DefaultCurrency()),
 			CategoryId:  1,
 			EmployeeId:  1,
 			Variants: []db.ProductVariantCreateUpdate{
 				{
-					SizeId:  1,
-					ColorId: 1,
-					Price:   10.0,
-					Stock:   10,
-					FileIds: []int64{1, 2},
+					Sku:        "SHIRT-LIN-M",
+					Barcode:    "4006381333931",
+					Price:      money.New(1000, money.DefaultCurrency()),
+					Stock:      10,
+					Weight:     450,
+					FileIds:    []int64{1, 2},
+					Attributes: map[string]any{"size": "M", "color": "Black", "material": "cotton", "organic": true},
 				},
 			},
+			Status: "<draft | scheduled | published>",
 		})
 
 	productRouter.AddRoute("/products/{id:[0-9]+}", handler.handleGetById).
 		Methods("GET").
 		Name("Get product by id").
-		Description("Gets product by id. The response includes product details and variants")
+		Description("Gets published product by id. The response includes product details and variants. Employees can preview products that are not published")
+
+	productRouter.AddRoute("/products/slug/{slug}", handler.handleGetBySlug).
+		Methods("GET").
+		Name("Get product by slug").
+		Description("Gets product by its URL slug. Previous slugs of a product are redirected to the current slug with 301 Moved Permanently")
+
+	productRouter.AddRoute("/products/sku/{sku}", handler.handleGetBySku).
+		Methods("GET").
+		Name("Get variant by SKU").
+		Description("Gets the product variant with the SKU and its product id, name and slug")
+
+	productRouter.AddRoute("/products/barcode/{barcode:[0-9]+}", handler.handleGetByBarcode).
+		Methods("GET").
+		Name("Get variant by barcode").
+		Description("Gets the product variant with the GTIN barcode and its product id, name and slug")
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/slug", RequireEmployee(handler.handleSetSlug)).
+		Methods("PUT").
+		Name("Set product slug").
+		Description("Change the URL slug of the product (employees only). The previous slug keeps redirecting to the product").
+		Schema(productSlugUpdate{Slug: "linen-shirt"})
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/status", RequireEmployee(handler.handleSetStatus)).
+		Methods("PUT").
+		Name("Set product status").
+		Description("Change the product status to draft, scheduled, published or archived (employees only). Scheduled products are published at 'publish_at', published products are archived at the optional 'unpublish_at'").
+		Schema(db.ProductStatusUpdate{Status: "<draft | scheduled | published | archived>", PublishAt: &time.Time{}, UnpublishAt: &time.Time{}, Comment: "Summer collection"})
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/status-history", RequireEmployee(handler.handleGetStatusHistory)).
+		Methods("GET").
+		Name("Get product status history").
+		Description("Get the audit log of the product status changes, the latest first (employees only)")
 
 	productRouter.AddRoute("/products/{id:[0-9]+}", handler.handleEdit).
 		Methods("PUT").
@@ -94,6 +162,76 @@ func InitProductsRouter(router *router.Router, opts *InitEndpointsOptions) {
 		Methods("GET").
 		Name("Get product variants").
 		Description("Get product variants by product id")
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}/identifiers", RequireEmployee(handler.handleSetVariantIdentifiers)).
+		Methods("PUT").
+		Name("Set variant identifiers").
+		Description("Change the SKU or the GTIN-8/12/13/14 barcode of the variant (employees only). Omitted fields are kept, an empty barcode removes it").
+		Schema(db.VariantIdentifiersUpdate{Sku: new(string), Barcode: new(string)})
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}/stock", RequireEmployee(handler.handleGetStockMovements)).
+		Methods("GET").
+		Name("Get variant stock movements").
+		Description("Get the stock ledger of the product variant (employees only)")
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}/stock", RequireEmployee(handler.handleAdjustStock)).
+		Methods("POST").
+		Name("Adjust variant stock").
+		Description("Change the variant stock and record the change in the stock ledger (employees only). Quantity is a signed delta").
+		Schema(&db.StockAdjustmentCreate{
+			Type:     "<adjustment | restock | return>",
+			Quantity: -2,
+			Reason:   "Damaged during storage",
+		})
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}/prices/{currency:[A-Za-z]{3}}", RequireEmployee(handler.handleSetVariantPrice)).
+		Methods("PUT").
+		Name("Set variant price").
+		Description("Set the explicit variant price in another currency instead of the converted default price (employees only)").
+		Schema(variantPriceUpdate{Price: "25.00"})
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}/prices/{currency:[A-Za-z]{3}}", RequireEmployee(handler.handleDeleteVariantPrice)).
+		Methods("DELETE").
+		Name("Delete variant price").
+		Description("Remove the explicit variant price, so the converted default price is used (employees only)")
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}/price-history", RequireEmployee(handler.handleGetPriceHistory)).
+		Methods("GET").
+		Name("Get variant price history").
+		Description("Get the regular price history and the sales of the variant, the latest first (employees only)")
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}/sale-prices", RequireEmployee(handler.handleScheduleSalePrice)).
+		Methods("POST").
+		Name("Schedule sale price").
+		Description("Schedule a sale price in the default currency that overrides the variant price while it is effective (employees only). Sales of a variant cannot overlap, the compare-at price is shown struck through and defaults to the regular price").
+		Schema(db.SalePriceCreate{
+			Price:          money.New(1999, money.DefaultCurrency()),
+			CompareAtPrice: &money.Money{Amount: 2499, Currency: money.DefaultCurrency()},
+			EffectiveFrom:  &time.Time{},
+			EffectiveTo:    &time.Time{},
+			Comment:        "Black Friday",
+		})
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}/sale-prices/{priceId:[0-9]+}", RequireEmployee(handler.handleCancelSalePrice)).
+		Methods("DELETE").
+		Name("Cancel sale price").
+		Description("Cancel a sale of the variant that has not ended yet, it stays in the price history (employees only)")
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}/reorder-threshold", RequireEmployee(handler.handleSetReorderThreshold)).
+		Methods("PUT").
+		Name("Set variant reorder threshold").
+		Description("Set the stock level at which a low stock alert is raised for the variant (employees only). Null falls back to the category threshold").
+		Schema(reorderThresholdUpdate{})
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}/restock-subscription", RequireCustomer(handler.handleRestockSubscribe)).
+		Methods("POST").
+		Name("Subscribe to restock").
+		Description("Notify the current customer when the out of stock variant is available again")
+
+	productRouter.AddRoute("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}/restock-subscription", RequireCustomer(handler.handleRestockUnsubscribe)).
+		Methods("DELETE").
+		Name("Unsubscribe from restock").
+		Description("Cancel the back in stock notification of the variant")
 }
 
 func (ph *productHandler) handleGet(w http.ResponseWriter, req *http.Request) {
@@ -106,20 +244,40 @@ func (ph *productHandler) handleGet(w http.ResponseWriter, req *http.Request) {
 		tools.RespondWithError(w, "Invalid query params", http.StatusBadRequest)
 		return
 	}
+	if !isEmployeeRequest(req) {
+		queryParams.Statuses = nil
+	}
+	attributes := make(map[string][]string)
+	for _, filter := range queryParams.Attributes {
+		code, value, found := strings.Cut(filter, ":")
+		if !found || code == "" {
+			tools.RespondWithError(w, fmt.Sprintf("Invalid attribute filter '%s', expected code:value", filter), http.StatusBadRequest)
+			return
+		}
+		attributes[code] = append(attributes[code], value)
+	}
 
 	products, err := ph.EntityStore.GetEntities(&db.ProductGetEntitiesOptions{
 		Query: &db.ProductGetEntitiesQueryOpts{
 			CategoryIds: queryParams.CategoryIds,
-			SizeIds:     queryParams.SizeIds,
-			ColorIds:    queryParams.ColorIds,
 			MinPrice:    queryParams.MinPrice,
 			MaxPrice:    queryParams.MaxPrice,
+			Attributes:  attributes,
+			MinRating:   queryParams.MinRating,
+			Statuses:    queryParams.Statuses,
+			Search:      queryParams.Search,
 		},
 		Limit:       queryParams.Limit,
 		Offset:      queryParams.Offset,
 		OrderColumn: queryParams.OrderColumn,
 		OrderAsc:    queryParams.OrderAsc,
+		Currency:    getRequestCurrency(req),
+		Languages:   getRequestLanguages(req),
 	})
+	if errors.Is(err, db.ErrUnsupportedCurrency) {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		tools.RespondWithError(w, fmt.Sprintf("Unexpected error while received products: %s", err.Error()), http.StatusInternalServerError)
 		return
@@ -135,7 +293,11 @@ func (ph *productHandler) handleGetById(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	product, err := ph.EntityStore.GetById(id)
+	product, err := ph.EntityStore.GetById(id, getRequestCurrency(req), getRequestLanguages(req), isEmployeeRequest(req))
+	if errors.Is(err, db.ErrUnsupportedCurrency) {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		tools.RespondWithError(w, "Product not found", http.StatusNotFound)
 		return
@@ -144,6 +306,149 @@ func (ph *productHandler) handleGetById(w http.ResponseWriter, req *http.Request
 	tools.RespondWithSuccess(w, product)
 }
 
+func (ph *productHandler) handleGetBySlug(w http.ResponseWriter, req *http.Request) {
+	slug := mux.Vars(req)["slug"]
+	id, currentSlug, err := ph.EntityStore.ResolveSlug(req.Context(), slug)
+	if err != nil {
+		respondWithProductError(w, err)
+		return
+	}
+	if currentSlug != slug {
+		location := path.Join(path.Dir(req.URL.Path), url.PathEscape(currentSlug))
+		if req.URL.RawQuery != "" {
+			location += "?" + req.URL.RawQuery
+		}
+		http.Redirect(w, req, location, http.StatusMovedPermanently)
+		return
+	}
+
+	product, err := ph.EntityStore.GetById(id, getRequestCurrency(req), getRequestLanguages(req), isEmployeeRequest(req))
+	if err != nil {
+		respondWithProductError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, product)
+}
+
+func (ph *productHandler) handleGetBySku(w http.ResponseWriter, req *http.Request) {
+	variant, err := ph.EntityStore.GetVariantBySku(req.Context(), mux.Vars(req)["sku"], getRequestCurrency(req), getRequestLanguages(req), isEmployeeRequest(req))
+	if err != nil {
+		respondWithProductError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, variant)
+}
+
+func (ph *productHandler) handleGetByBarcode(w http.ResponseWriter, req *http.Request) {
+	variant, err := ph.EntityStore.GetVariantByBarcode(req.Context(), mux.Vars(req)["barcode"], getRequestCurrency(req), getRequestLanguages(req), isEmployeeRequest(req))
+	if err != nil {
+		respondWithProductError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, variant)
+}
+
+func (ph *productHandler) handleSetSlug(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	body := productSlugUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	slug, err := ph.EntityStore.SetSlug(req.Context(), id, body.Slug)
+	if err != nil {
+		respondWithProductError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, productSlugUpdate{Slug: slug})
+}
+
+func (ph *productHandler) handleSetVariantIdentifiers(w http.ResponseWriter, req *http.Request) {
+	productId, variantId, err := parseProductVariantVars(req)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body := &db.VariantIdentifiersUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := ph.EntityStore.SetVariantIdentifiers(req.Context(), productId, variantId, body); err != nil {
+		respondWithProductError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (ph *productHandler) handleSetStatus(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	body := &db.ProductStatusUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	employeeId := req.Context().Value("user").(*tools.UserTokenClaims).Id
+	body.EmployeeId = &employeeId
+
+	change, err := ph.EntityStore.SetStatus(req.Context(), id, body)
+	if err != nil {
+		respondWithProductError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, change)
+}
+
+func (ph *productHandler) handleGetStatusHistory(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	changes, err := ph.EntityStore.GetStatusHistory(req.Context(), id)
+	if err != nil {
+		respondWithProductError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, changes)
+}
+
+func respondWithProductError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrProductNotFound), errors.Is(err, db.ErrVariantNotFound), errors.Is(err, db.ErrVariantPriceNotFound):
+		tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, db.ErrIdentifierExists), errors.Is(err, db.ErrDuplicateVariant), errors.Is(err, db.ErrSalePriceOverlap):
+		tools.RespondWithError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrInvalidIdentifier), errors.Is(err, db.ErrInvalidProductStatus), errors.Is(err, db.ErrUnsupportedCurrency),
+		errors.Is(err, db.ErrInvalidSalePrice):
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Unexpected product error: %s", err.Error())
+		tools.RespondWithError(w, "Unexpected product error", http.StatusInternalServerError)
+	}
+}
+
 func (ph *productHandler) handleCreate(w http.ResponseWriter, req *http.Request) {
 	user := req.Context().Value("user").(*tools.UserTokenClaims)
 
@@ -156,6 +461,10 @@ func (ph *productHandler) handleCreate(w http.ResponseWriter, req *http.Request)
 	createOpts.EmployeeId = user.Id
 
 	if err := ph.EntityStore.Create(context.Background(), createOpts); err != nil {
+		if errors.Is(err, db.ErrDuplicateVariant) || errors.Is(err, db.ErrIdentifierExists) {
+			tools.RespondWithError(w, err.Error(), http.StatusConflict)
+			return
+		}
 		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -180,7 +489,11 @@ func (ph *productHandler) handleGetVariants(w http.ResponseWriter, req *http.Req
 		return
 	}
 
-	variants, err := ph.EntityStore.GetVariants(id)
+	variants, err := ph.EntityStore.GetVariants(id, getRequestCurrency(req), getRequestLanguages(req), isEmployeeRequest(req))
+	if errors.Is(err, db.ErrUnsupportedCurrency) {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Printf("Error while getting product variants: %s", err.Error())
 		tools.RespondWithError(w, "Product not found", http.StatusNotFound)
@@ -189,3 +502,220 @@ func (ph *productHandler) handleGetVariants(w http.ResponseWriter, req *http.Req
 
 	tools.RespondWithSuccess(w, variants)
 }
+
+func (ph *productHandler) handleGetStockMovements(w http.ResponseWriter, req *http.Request) {
+	_, variantId, err := parseProductVariantVars(req)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	movements, err := ph.StockMovementStore.GetByVariantId(variantId)
+	if err != nil {
+		log.Printf("Error while getting stock movements: %s", err.Error())
+		tools.RespondWithError(w, "Cannot get stock movements", http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, movements)
+}
+
+func (ph *productHandler) handleAdjustStock(w http.ResponseWriter, req *http.Request) {
+	productId, variantId, err := parseProductVariantVars(req)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	adjustment := &db.StockAdjustmentCreate{}
+	if err := json.NewDecoder(req.Body).Decode(adjustment); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	adjustment.ProductId = productId
+	adjustment.ProductVariantId = variantId
+	adjustment.EmployeeId = req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	movement, err := ph.StockMovementStore.Adjust(req.Context(), adjustment)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, movement)
+}
+
+func parseProductVariantVars(req *http.Request) (productId int64, variantId int64, err error) {
+	vars := mux.Vars(req)
+	productId, err = strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("Invalid product id")
+	}
+	variantId, err = strconv.ParseInt(vars["variantId"], 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("Invalid product variant id")
+	}
+	return productId, variantId, nil
+}
+
+func (ph *productHandler) handleSetReorderThreshold(w http.ResponseWriter, req *http.Request) {
+	productId, variantId, err := parseProductVariantVars(req)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body := reorderThresholdUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.Threshold != nil && *body.Threshold < 0 {
+		tools.RespondWithError(w, "Threshold must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if err := ph.StockAlertStore.SetVariantThreshold(req.Context(), productId, variantId, body.Threshold); err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (ph *productHandler) handleRestockSubscribe(w http.ResponseWriter, req *http.Request) {
+	productId, variantId, err := parseProductVariantVars(req)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	customerId := req.Context().Value("user").(*tools.UserTokenClaims).Id
+	if err := ph.StockAlertStore.Subscribe(req.Context(), customerId, productId, variantId); err != nil {
+		if errors.Is(err, db.ErrAlreadySubscribed) || errors.Is(err, db.ErrVariantInStock) {
+			tools.RespondWithError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (ph *productHandler) handleRestockUnsubscribe(w http.ResponseWriter, req *http.Request) {
+	_, variantId, err := parseProductVariantVars(req)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	customerId := req.Context().Value("user").(*tools.UserTokenClaims).Id
+	if err := ph.StockAlertStore.Unsubscribe(req.Context(), customerId, variantId); err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (ph *productHandler) handleSetVariantPrice(w http.ResponseWriter, req *http.Request) {
+	productId, variantId, err := parseProductVariantVars(req)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body := variantPriceUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	price, err := money.Parse(body.Price, mux.Vars(req)["currency"])
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := ph.EntityStore.SetVariantPrice(req.Context(), productId, variantId, price); err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, price)
+}
+
+func (ph *productHandler) handleGetPriceHistory(w http.ResponseWriter, req *http.Request) {
+	productId, variantId, err := parseProductVariantVars(req)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	prices, err := ph.EntityStore.GetPriceHistory(req.Context(), productId, variantId)
+	if err != nil {
+		respondWithProductError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, prices)
+}
+
+func (ph *productHandler) handleScheduleSalePrice(w http.ResponseWriter, req *http.Request) {
+	productId, variantId, err := parseProductVariantVars(req)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body := &db.SalePriceCreate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.EmployeeId = req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	price, err := ph.EntityStore.ScheduleSalePrice(req.Context(), productId, variantId, body)
+	if err != nil {
+		respondWithProductError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, price)
+}
+
+func (ph *productHandler) handleCancelSalePrice(w http.ResponseWriter, req *http.Request) {
+	productId, variantId, err := parseProductVariantVars(req)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	priceId, err := strconv.ParseInt(mux.Vars(req)["priceId"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid price id", http.StatusBadRequest)
+		return
+	}
+
+	if err := ph.EntityStore.CancelSalePrice(req.Context(), productId, variantId, priceId); err != nil {
+		respondWithProductError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (ph *productHandler) handleDeleteVariantPrice(w http.ResponseWriter, req *http.Request) {
+	productId, variantId, err := parseProductVariantVars(req)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := ph.EntityStore.DeleteVariantPrice(req.Context(), productId, variantId, mux.Vars(req)["currency"]); err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
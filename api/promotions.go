@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/money"
+	"netshop/main/tools/router"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type promotionHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.PromotionEntityStore
+}
+
+func InitPromotionRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := promotionHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewPromotionEntityStore(opts.DatabaseConnection),
+	}
+
+	router := parent.Subrouter()
+
+	router.AddRoute("/promotions", RequireEmployee(handler.handleGet)).
+		Methods("GET").
+		Name("Get promotions").
+		Description("Get all promotions with their usage count (employees only)")
+
+	minOrderValue := money.New(5000, money.DefaultCurrency())
+	discountPercent := int32(10)
+	usageLimitPerCustomer := int32(1)
+	router.AddRoute("/promotions", RequireEmployee(handler.handleCreate)).
+		Methods("POST").
+		Name("Create promotion").
+		Description("Create a promotion code (employees only). Percentage promotions set discount_percent, fixed ones set discount_amount in the default currency. Promotions without categories and products discount the whole order").
+		Schema(&db.PromotionCreateUpdate{
+			Code:                  "SUMMER10",
+			Name:                  "Summer sale",
+			DiscountType:          "<percentage | fixed>",
+			DiscountPercent:       &discountPercent,
+			DiscountAmount:        nil,
+			MinOrderValue:         &minOrderValue,
+			UsageLimit:            nil,
+			UsageLimitPerCustomer: &usageLimitPerCustomer,
+			CategoryIds:           []int64{1},
+			ProductIds:            []int64{},
+		})
+
+	router.AddRoute("/promotions/{id:[0-9]+}", RequireEmployee(handler.handleDeactivate)).
+		Methods("DELETE").
+		Name("Deactivate promotion").
+		Description("Deactivate the promotion, so its code cannot be used anymore (employees only)")
+}
+
+func (handler *promotionHandler) handleGet(w http.ResponseWriter, req *http.Request) {
+	promotions, err := handler.EntityStore.GetEntities()
+	if err != nil {
+		log.Printf("Error while getting promotions: %s", err.Error())
+		tools.RespondWithError(w, "Cannot get promotions", http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, promotions)
+}
+
+func (handler *promotionHandler) handleCreate(w http.ResponseWriter, req *http.Request) {
+	createOpts := &db.PromotionCreateUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(createOpts); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	createOpts.EmployeeId = req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	promotion, err := handler.EntityStore.Create(req.Context(), createOpts)
+	if err != nil {
+		if errors.Is(err, db.ErrPromotionCodeExists) {
+			tools.RespondWithError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, promotion)
+}
+
+func (handler *promotionHandler) handleDeactivate(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid promotion id", http.StatusBadRequest)
+		return
+	}
+
+	if err := handler.EntityStore.Deactivate(req.Context(), id); err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
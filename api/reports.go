@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/router"
+	"strings"
+	"time"
+
+	"github.com/gorilla/schema"
+)
+
+type reportHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.ReportStore
+}
+
+type reportQueryParams struct {
+	From   *time.Time `schema:"from" json:"from"`
+	To     *time.Time `schema:"to" json:"to"`
+	Period string     `schema:"period,default:day" json:"period"`
+	By     string     `schema:"by" json:"by"`
+	Limit  int64      `schema:"limit,default:0" json:"limit"`
+	// "csv" exports the report as a CSV file, the 'Accept: text/csv' header does the same
+	Format string `schema:"format" json:"format"`
+}
+
+func InitReportRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := reportHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewReportStore(opts.DatabaseConnection),
+	}
+
+	router := parent.Subrouter()
+
+	router.AddRoute("/reports/revenue", RequireEmployee(handler.handleRevenue)).
+		Methods("GET").
+		Name("Revenue report").
+		Description("Revenue, order count and average order value per day, week or month in the default currency (employees only). " +
+			"Built from the daily sales view, which is refreshed by a background job").
+		Schema(reportQueryParams{Period: "<day | week | month>", Format: "<optional csv>"})
+
+	router.AddRoute("/reports/top-products", RequireEmployee(handler.handleTopProducts)).
+		Methods("GET").
+		Name("Top products report").
+		Description("Best selling products by units or revenue after discounts (employees only)").
+		Schema(reportQueryParams{By: "<units | revenue>", Limit: 10, Format: "<optional csv>"})
+
+	router.AddRoute("/reports/top-variants", RequireEmployee(handler.handleTopVariants)).
+		Methods("GET").
+		Name("Top variants report").
+		Description("Best selling product variants by units or revenue after discounts (employees only)").
+		Schema(reportQueryParams{By: "<units | revenue>", Limit: 10, Format: "<optional csv>"})
+
+	router.AddRoute("/reports/sales", RequireEmployee(handler.handleSales)).
+		Methods("GET").
+		Name("Sales report").
+		Description("Orders, units and revenue grouped by category or by the options of an option attribute, e.g. size or color (employees only)").
+		Schema(reportQueryParams{By: "<category | attribute code>", Format: "<optional csv>"})
+
+	router.AddRoute("/reports/customers", RequireEmployee(handler.handleCustomers)).
+		Methods("GET").
+		Name("Customers report").
+		Description("New and returning customers per day, week or month (employees only)").
+		Schema(reportQueryParams{Period: "<day | week | month>", Format: "<optional csv>"})
+
+	router.AddRoute("/reports/refresh", RequireEmployee(handler.handleRefresh)).
+		Methods("POST").
+		Name("Refresh reports").
+		Description("Refresh the daily sales view without waiting for the background job (employees only)")
+}
+
+func (handler *reportHandler) handleRevenue(w http.ResponseWriter, req *http.Request) {
+	opts, ok := parseReportOptions(w, req)
+	if !ok {
+		return
+	}
+	report, err := handler.EntityStore.GetRevenue(req.Context(), opts)
+	respondWithReport(w, req, "revenue", report, err)
+}
+
+func (handler *reportHandler) handleTopProducts(w http.ResponseWriter, req *http.Request) {
+	opts, ok := parseReportOptions(w, req)
+	if !ok {
+		return
+	}
+	report, err := handler.EntityStore.GetTopProducts(req.Context(), opts, false)
+	respondWithReport(w, req, "top-products", report, err)
+}
+
+func (handler *reportHandler) handleTopVariants(w http.ResponseWriter, req *http.Request) {
+	opts, ok := parseReportOptions(w, req)
+	if !ok {
+		return
+	}
+	report, err := handler.EntityStore.GetTopProducts(req.Context(), opts, true)
+	respondWithReport(w, req, "top-variants", report, err)
+}
+
+func (handler *reportHandler) handleSales(w http.ResponseWriter, req *http.Request) {
+	opts, ok := parseReportOptions(w, req)
+	if !ok {
+		return
+	}
+	report, err := handler.EntityStore.GetSalesBy(req.Context(), opts)
+	respondWithReport(w, req, "sales-by-"+opts.By, report, err)
+}
+
+func (handler *reportHandler) handleCustomers(w http.ResponseWriter, req *http.Request) {
+	opts, ok := parseReportOptions(w, req)
+	if !ok {
+		return
+	}
+	report, err := handler.EntityStore.GetCustomers(req.Context(), opts)
+	respondWithReport(w, req, "customers", report, err)
+}
+
+func (handler *reportHandler) handleRefresh(w http.ResponseWriter, req *http.Request) {
+	if err := handler.EntityStore.RefreshViews(req.Context()); err != nil {
+		log.Printf("Error while refreshing reports: %s", err.Error())
+		tools.RespondWithError(w, "Cannot refresh reports", http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func parseReportOptions(w http.ResponseWriter, req *http.Request) (*db.ReportOptions, bool) {
+	queryParams := reportQueryParams{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	decoder.RegisterConverter(time.Time{}, convertQueryTime)
+	if err := decoder.Decode(&queryParams, req.URL.Query()); err != nil {
+		tools.RespondWithError(w, "Invalid query params", http.StatusBadRequest)
+		return nil, false
+	}
+
+	return &db.ReportOptions{
+		From:   queryParams.From,
+		To:     queryParams.To,
+		Period: queryParams.Period,
+		By:     queryParams.By,
+		Limit:  queryParams.Limit,
+	}, true
+}
+
+// Responds with the report as JSON or as a CSV file if it is requested by the format param or the Accept header
+func respondWithReport(w http.ResponseWriter, req *http.Request, name string, report db.ReportTable, err error) {
+	if errors.Is(err, db.ErrInvalidReport) {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error while building %s report: %s", name, err.Error())
+		tools.RespondWithError(w, "Cannot build report", http.StatusInternalServerError)
+		return
+	}
+
+	if req.URL.Query().Get("format") != "csv" && !strings.Contains(req.Header.Get("Accept"), "text/csv") {
+		tools.RespondWithSuccess(w, report)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+	writer := csv.NewWriter(w)
+	writer.Write(report.Header())
+	writer.WriteAll(report.Records())
+	if err := writer.Error(); err != nil {
+		log.Printf("Error while writing %s report: %s", name, err.Error())
+	}
+}
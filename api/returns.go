@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/router"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type returnHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.ReturnStore
+}
+
+type returnStatusUpdate struct {
+	Status string `json:"status"`
+}
+
+func InitReturnRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := returnHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore: db.NewReturnStore(
+			opts.DatabaseConnection,
+			db.NewPaymentStore(opts.DatabaseConnection, opts.PaymentProvider),
+		),
+	}
+
+	router := parent.Subrouter()
+
+	router.AddRoute("/orders/{id:[0-9]+}/returns", RequireCustomer(handler.handleCreate)).
+		Methods("POST").
+		Name("Request return").
+		Description("Request a return of items of a delivered order of the current customer. The refund is calculated from the paid line totals").
+		Schema(db.ReturnCreate{
+			Comment: "<optional comment>",
+			Items:   []*db.ReturnItemCreate{{OrderItemId: 1, Quantity: 1, Reason: "Wrong size"}},
+		})
+
+	router.AddRoute("/returns", RequireAuth(handler.handleGet)).
+		Methods("GET").
+		Name("Get returns").
+		Description("Get returns of the current customer, employees get all returns. Optional 'status' query parameter filters them by status")
+
+	router.AddRoute("/returns/{id:[0-9]+}/status", RequireEmployee(handler.handleUpdateStatus)).
+		Methods("PUT").
+		Name("Update return status").
+		Description("Approve or reject a requested return, or mark an approved return as received (employees only)").
+		Schema(returnStatusUpdate{Status: "<approved | rejected | received>"})
+
+	router.AddRoute("/returns/{id:[0-9]+}/inspection", RequireEmployee(handler.handleInspect)).
+		Methods("POST").
+		Name("Inspect return").
+		Description("Record the inspection of a received return and restock the sellable quantities (employees only). Items missing in the list are not restocked").
+		Schema(db.ReturnInspection{Items: []*db.ReturnItemInspection{{ReturnItemId: 1, RestockedQuantity: 1}}})
+
+	router.AddRoute("/returns/{id:[0-9]+}/refund", RequireEmployee(handler.handleRefund)).
+		Methods("POST").
+		Name("Refund return").
+		Description("Refund the inspected return to the order payments (employees only)")
+}
+
+func (handler *returnHandler) handleCreate(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	createOpts := &db.ReturnCreate{}
+	if err := json.NewDecoder(req.Body).Decode(createOpts); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	createOpts.OrderId = id
+	createOpts.CustomerId = req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	entity, err := handler.EntityStore.Create(req.Context(), createOpts)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, entity)
+}
+
+func (handler *returnHandler) handleGet(w http.ResponseWriter, req *http.Request) {
+	opts := &db.ReturnGetAllOptions{}
+	user := req.Context().Value("user").(*tools.UserTokenClaims)
+	if user.Type != authEmployeeTypeStr {
+		opts.CustomerId = &user.Id
+	}
+	if status := req.URL.Query().Get("status"); status != "" {
+		opts.Status = &status
+	}
+
+	returns, err := handler.EntityStore.GetAll(opts)
+	if err != nil {
+		log.Printf("Error while getting returns: %s", err.Error())
+		tools.RespondWithError(w, "Cannot get returns", http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, returns)
+}
+
+func (handler *returnHandler) handleUpdateStatus(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid return id", http.StatusBadRequest)
+		return
+	}
+
+	body := returnStatusUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	employeeId := req.Context().Value("user").(*tools.UserTokenClaims).Id
+	if err := handler.EntityStore.UpdateStatus(req.Context(), id, body.Status, employeeId); err != nil {
+		respondWithReturnError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (handler *returnHandler) handleInspect(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid return id", http.StatusBadRequest)
+		return
+	}
+
+	inspection := &db.ReturnInspection{}
+	if err := json.NewDecoder(req.Body).Decode(inspection); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	inspection.ReturnId = id
+	inspection.EmployeeId = req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	entity, err := handler.EntityStore.Inspect(req.Context(), inspection)
+	if err != nil {
+		respondWithReturnError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, entity)
+}
+
+func (handler *returnHandler) handleRefund(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid return id", http.StatusBadRequest)
+		return
+	}
+
+	employeeId := req.Context().Value("user").(*tools.UserTokenClaims).Id
+	if err := handler.EntityStore.Refund(req.Context(), id, employeeId); err != nil {
+		if errors.Is(err, db.ErrRefundExceedsPayment) {
+			tools.RespondWithError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		respondWithReturnError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func respondWithReturnError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrReturnNotFound):
+		tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, db.ErrInvalidReturnTransition):
+		tools.RespondWithError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrInvalidReturn):
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error while updating return: %s", err.Error())
+		tools.RespondWithError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/router"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+)
+
+type reviewHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.ReviewStore
+}
+
+type reviewQueryParams struct {
+	// newest, helpful, rating_desc or rating_asc
+	Sort      string  `schema:"sort,default:newest" json:"sort"`
+	Limit     int64   `schema:"limit,default:20" json:"limit"`
+	Offset    int64   `schema:"offset,default:0" json:"offset"`
+	Status    *string `schema:"status" json:"status,omitempty"`
+	ProductId *int64  `schema:"product_id" json:"product_id,omitempty"`
+}
+
+func InitReviewRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := reviewHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewReviewStore(opts.DatabaseConnection),
+	}
+	router := parent.Subrouter()
+
+	router.AddRoute("/products/{id:[0-9]+}/reviews", handler.handleGetProductReviews).
+		Methods("GET").
+		Name("Get product reviews").
+		Description("Get approved reviews of the product").
+		Schema(reviewQueryParams{Sort: "<newest | helpful | rating_desc | rating_asc>", Limit: 20})
+
+	router.AddRoute("/products/{id:[0-9]+}/reviews", RequireCustomer(handler.handleCreate)).
+		Methods("POST").
+		Name("Create review").
+		Description("Review a product of a delivered order of the current customer. Reviews are published after moderation").
+		Schema(db.ReviewCreateUpdate{Rating: 5, Title: "Great fit", Body: "True to size", FileIds: []int64{1}})
+
+	router.AddRoute("/reviews", RequireAuth(handler.handleGet)).
+		Methods("GET").
+		Name("Get reviews").
+		Description("Get reviews of the current customer in every status, employees get all reviews. Optional 'status' and 'product_id' query parameters filter them").
+		Schema(reviewQueryParams{Sort: "<newest | helpful | rating_desc | rating_asc>", Limit: 20, Status: new(string), ProductId: new(int64)})
+
+	router.AddRoute("/reviews/{id:[0-9]+}", RequireCustomer(handler.handleUpdate)).
+		Methods("PUT").
+		Name("Update review").
+		Description("Edit a review of the current customer, the edited review is moderated again").
+		Schema(db.ReviewCreateUpdate{Rating: 4, Title: "Great fit", Body: "True to size, but the color fades", FileIds: []int64{1}})
+
+	router.AddRoute("/reviews/{id:[0-9]+}", RequireAuth(handler.handleDelete)).
+		Methods("DELETE").
+		Name("Delete review").
+		Description("Delete a review of the current customer, employees can delete any review")
+
+	router.AddRoute("/reviews/{id:[0-9]+}/status", RequireEmployee(handler.handleModerate)).
+		Methods("PUT").
+		Name("Moderate review").
+		Description("Approve or reject a review (employees only). The note is shown to the author of rejected reviews").
+		Schema(db.ReviewModeration{Status: "<approved | rejected | pending>", Note: "Contains personal data"})
+
+	router.AddRoute("/reviews/{id:[0-9]+}/helpful", RequireCustomer(handler.handleVote)).
+		Methods("POST").
+		Name("Vote for review").
+		Description("Mark an approved review of another customer as helpful")
+
+	router.AddRoute("/reviews/{id:[0-9]+}/helpful", RequireCustomer(handler.handleUnvote)).
+		Methods("DELETE").
+		Name("Remove review vote").
+		Description("Remove the helpful vote of the current customer")
+}
+
+func (h *reviewHandler) handleGetProductReviews(w http.ResponseWriter, req *http.Request) {
+	productId, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+	queryParams, ok := parseReviewQueryParams(w, req)
+	if !ok {
+		return
+	}
+
+	status := db.ReviewStatusApproved
+	reviews, err := h.EntityStore.GetAll(req.Context(), &db.ReviewGetAllOptions{
+		ProductId: &productId,
+		Status:    &status,
+		Sort:      queryParams.Sort,
+		Limit:     queryParams.Limit,
+		Offset:    queryParams.Offset,
+	})
+	if err != nil {
+		respondWithReviewError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, reviews)
+}
+
+func (h *reviewHandler) handleGet(w http.ResponseWriter, req *http.Request) {
+	queryParams, ok := parseReviewQueryParams(w, req)
+	if !ok {
+		return
+	}
+
+	opts := &db.ReviewGetAllOptions{
+		ProductId: queryParams.ProductId,
+		Status:    queryParams.Status,
+		Sort:      queryParams.Sort,
+		Limit:     queryParams.Limit,
+		Offset:    queryParams.Offset,
+	}
+	user := req.Context().Value("user").(*tools.UserTokenClaims)
+	if user.Type != authEmployeeTypeStr {
+		opts.CustomerId = &user.Id
+	}
+
+	reviews, err := h.EntityStore.GetAll(req.Context(), opts)
+	if err != nil {
+		respondWithReviewError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, reviews)
+}
+
+func (h *reviewHandler) handleCreate(w http.ResponseWriter, req *http.Request) {
+	productId, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	body := &db.ReviewCreateUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.ProductId = productId
+	body.CustomerId = req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	review, err := h.EntityStore.Create(req.Context(), body)
+	if err != nil {
+		respondWithReviewError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, review)
+}
+
+func (h *reviewHandler) handleUpdate(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid review id", http.StatusBadRequest)
+		return
+	}
+
+	body := &db.ReviewCreateUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.CustomerId = req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	review, err := h.EntityStore.Update(req.Context(), id, body)
+	if err != nil {
+		respondWithReviewError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, review)
+}
+
+func (h *reviewHandler) handleDelete(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid review id", http.StatusBadRequest)
+		return
+	}
+
+	var customerId *int64
+	user := req.Context().Value("user").(*tools.UserTokenClaims)
+	if user.Type != authEmployeeTypeStr {
+		customerId = &user.Id
+	}
+
+	if err := h.EntityStore.Delete(req.Context(), id, customerId); err != nil {
+		respondWithReviewError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (h *reviewHandler) handleModerate(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid review id", http.StatusBadRequest)
+		return
+	}
+
+	body := &db.ReviewModeration{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.EmployeeId = req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	review, err := h.EntityStore.Moderate(req.Context(), id, body)
+	if err != nil {
+		respondWithReviewError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, review)
+}
+
+func (h *reviewHandler) handleVote(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid review id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.EntityStore.Vote(req.Context(), id, req.Context().Value("user").(*tools.UserTokenClaims).Id); err != nil {
+		respondWithReviewError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (h *reviewHandler) handleUnvote(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid review id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.EntityStore.Unvote(req.Context(), id, req.Context().Value("user").(*tools.UserTokenClaims).Id); err != nil {
+		respondWithReviewError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func parseReviewQueryParams(w http.ResponseWriter, req *http.Request) (*reviewQueryParams, bool) {
+	queryParams := &reviewQueryParams{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(queryParams, req.URL.Query()); err != nil {
+		tools.RespondWithError(w, "Invalid query params", http.StatusBadRequest)
+		return nil, false
+	}
+	return queryParams, true
+}
+
+func respondWithReviewError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrReviewNotFound):
+		tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, db.ErrReviewExists):
+		tools.RespondWithError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrReviewNotAllowed), errors.Is(err, db.ErrOwnReviewVote):
+		tools.RespondWithError(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, db.ErrInvalidReview):
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Unexpected review error: %s", err.Error())
+		tools.RespondWithError(w, "Unexpected review error", http.StatusInternalServerError)
+	}
+}
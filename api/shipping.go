@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/money"
+	"netshop/main/tools/router"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type shippingHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.ShippingStore
+}
+
+func InitShippingRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := shippingHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewShippingStore(opts.DatabaseConnection),
+	}
+
+	router := parent.Subrouter()
+
+	router.AddRoute("/shipping/methods", handler.handleGetMethods).
+		Methods("GET").
+		Name("Get shipping methods").
+		Description("Get active shipping methods with their rates")
+
+	freeShippingThreshold := money.New(200000, money.DefaultCurrency())
+	router.AddRoute("/shipping/methods", RequireEmployee(handler.handleCreateMethod)).
+		Methods("POST").
+		Name("Create shipping method").
+		Description("Create a shipping method of a carrier (employees only). Orders with a value of at least the free shipping threshold are shipped for free").
+		Schema(&db.ShippingMethodCreate{
+			Carrier:               "Nova Poshta",
+			Name:                  "Courier",
+			FreeShippingThreshold: &freeShippingThreshold,
+		})
+
+	router.AddRoute("/shipping/methods/{id:[0-9]+}", RequireEmployee(handler.handleDeactivateMethod)).
+		Methods("DELETE").
+		Name("Deactivate shipping method").
+		Description("Deactivate the shipping method, so it cannot be chosen for new orders (employees only)")
+
+	maxWeight := int32(5000)
+	router.AddRoute("/shipping/methods/{id:[0-9]+}/rates", RequireEmployee(handler.handleAddRate)).
+		Methods("POST").
+		Name("Add shipping rate").
+		Description("Add a rate of the shipping method for a country (empty for any), a weight limit in grams and a minimum order value (employees only)").
+		Schema(&db.ShippingRateCreate{
+			Country:       "Ukraine",
+			MaxWeight:     &maxWeight,
+			MinOrderValue: money.New(0, money.DefaultCurrency()),
+			Price:         money.New(7000, money.DefaultCurrency()),
+		})
+
+	router.AddRoute("/shipping/rates/{id:[0-9]+}", RequireEmployee(handler.handleDeleteRate)).
+		Methods("DELETE").
+		Name("Delete shipping rate").
+		Description("Delete the shipping rate (employees only)")
+
+	router.AddRoute("/shipping/quote", handler.handleQuote).
+		Methods("POST").
+		Name("Quote shipping").
+		Description("Get the cost of every shipping method available for the items and the delivery country").
+		Schema(&db.ShippingQuoteRequest{
+			Country:  "Ukraine",
+			Items:    []*db.OrderItemCreateUpdate{{ProductVariantId: 1, Quantity: 2}},
+			Currency: "<optional currency, defaults to the Accept-Currency header>",
+		})
+}
+
+func (handler *shippingHandler) handleGetMethods(w http.ResponseWriter, req *http.Request) {
+	methods, err := handler.EntityStore.GetMethods(true)
+	if err != nil {
+		log.Printf("Error while getting shipping methods: %s", err.Error())
+		tools.RespondWithError(w, "Cannot get shipping methods", http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, methods)
+}
+
+func (handler *shippingHandler) handleCreateMethod(w http.ResponseWriter, req *http.Request) {
+	createOpts := &db.ShippingMethodCreate{}
+	if err := json.NewDecoder(req.Body).Decode(createOpts); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	method, err := handler.EntityStore.CreateMethod(req.Context(), createOpts)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, method)
+}
+
+func (handler *shippingHandler) handleDeactivateMethod(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid shipping method id", http.StatusBadRequest)
+		return
+	}
+
+	if err := handler.EntityStore.Deactivate(req.Context(), id); err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (handler *shippingHandler) handleAddRate(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid shipping method id", http.StatusBadRequest)
+		return
+	}
+
+	createOpts := &db.ShippingRateCreate{}
+	if err := json.NewDecoder(req.Body).Decode(createOpts); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	createOpts.ShippingMethodId = id
+
+	rate, err := handler.EntityStore.AddRate(req.Context(), createOpts)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, rate)
+}
+
+func (handler *shippingHandler) handleDeleteRate(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid shipping rate id", http.StatusBadRequest)
+		return
+	}
+
+	if err := handler.EntityStore.DeleteRate(req.Context(), id); err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (handler *shippingHandler) handleQuote(w http.ResponseWriter, req *http.Request) {
+	body := &db.ShippingQuoteRequest{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if body.Country == "" || len(body.Items) == 0 {
+		tools.RespondWithError(w, "Properties 'country' and 'items' are required", http.StatusBadRequest)
+		return
+	}
+	for _, item := range body.Items {
+		if item.Quantity <= 0 {
+			tools.RespondWithError(w, "Item quantity must be positive", http.StatusBadRequest)
+			return
+		}
+	}
+	if body.Currency == "" {
+		body.Currency = getRequestCurrency(req)
+	}
+
+	quotes, err := handler.EntityStore.Quote(req.Context(), body)
+	if err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, quotes)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/router"
+)
+
+type stockHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	AlertStore         *db.StockAlertStore
+}
+
+func InitStockRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := stockHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		AlertStore:         db.NewStockAlertStore(opts.DatabaseConnection),
+	}
+
+	router := parent.Subrouter()
+
+	router.AddRoute("/stock/alerts", RequireEmployee(handler.handleGetAlerts)).
+		Methods("GET").
+		Name("Get low stock alerts").
+		Description("Get all unresolved low stock alerts (employees only)")
+}
+
+func (handler *stockHandler) handleGetAlerts(w http.ResponseWriter, req *http.Request) {
+	alerts, err := handler.AlertStore.GetOpenAlerts()
+	if err != nil {
+		log.Printf("Error while getting low stock alerts: %s", err.Error())
+		tools.RespondWithError(w, "Cannot get low stock alerts", http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, alerts)
+}
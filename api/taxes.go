@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/router"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type taxHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.TaxRuleStore
+}
+
+func InitTaxRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := taxHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewTaxRuleStore(opts.DatabaseConnection),
+	}
+
+	router := parent.Subrouter()
+
+	router.AddRoute("/tax-rules", RequireEmployee(handler.handleGet)).
+		Methods("GET").
+		Name("Get tax rules").
+		Description("Get all tax rules (employees only)")
+
+	categoryId := int64(1)
+	router.AddRoute("/tax-rules", RequireEmployee(handler.handleCreate)).
+		Methods("POST").
+		Name("Create tax rule").
+		Description("Create a tax rule of a country or its region (zipcode prefix), optionally limited to a category (employees only). The most specific rule is applied to each order line").
+		Schema(&db.TaxRuleCreate{
+			Name:          "Reduced VAT for books",
+			Country:       "Ukraine",
+			ZipcodePrefix: "",
+			CategoryId:    &categoryId,
+			Rate:          "7",
+			IsInclusive:   true,
+		})
+
+	router.AddRoute("/tax-rules/{id:[0-9]+}", RequireEmployee(handler.handleDelete)).
+		Methods("DELETE").
+		Name("Delete tax rule").
+		Description("Delete the tax rule, existing orders keep their taxes (employees only)")
+}
+
+func (handler *taxHandler) handleGet(w http.ResponseWriter, req *http.Request) {
+	rules, err := handler.EntityStore.GetAll()
+	if err != nil {
+		log.Printf("Error while getting tax rules: %s", err.Error())
+		tools.RespondWithError(w, "Cannot get tax rules", http.StatusInternalServerError)
+		return
+	}
+
+	tools.RespondWithSuccess(w, rules)
+}
+
+func (handler *taxHandler) handleCreate(w http.ResponseWriter, req *http.Request) {
+	createOpts := &db.TaxRuleCreate{}
+	if err := json.NewDecoder(req.Body).Decode(createOpts); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := handler.EntityStore.Create(req.Context(), createOpts)
+	if err != nil {
+		if errors.Is(err, db.ErrTaxRuleExists) {
+			tools.RespondWithError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tools.RespondWithSuccess(w, rule)
+}
+
+func (handler *taxHandler) handleDelete(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid tax rule id", http.StatusBadRequest)
+		return
+	}
+
+	if err := handler.EntityStore.Delete(req.Context(), id); err != nil {
+		tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
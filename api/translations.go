@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/i18n"
+	"netshop/main/tools/router"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+)
+
+type translationHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.TranslationStore
+}
+
+type languagesResponse struct {
+	DefaultLanguage    string   `json:"default_language"`
+	SupportedLanguages []string `json:"supported_languages"`
+}
+
+type missingTranslationsQueryParams struct {
+	// product, category, attribute or attribute_option
+	Type string `schema:"type,default:product" json:"type"`
+	// Only entities missing the language, all translation languages when empty
+	Language string `schema:"language" json:"language"`
+	Limit    int64  `schema:"limit,default:50" json:"limit"`
+	Offset   int64  `schema:"offset,default:0" json:"offset"`
+}
+
+func InitTranslationRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := translationHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewTranslationStore(opts.DatabaseConnection),
+	}
+	router := parent.Subrouter()
+
+	router.AddRoute("/languages", handler.handleGetLanguages).
+		Methods("GET").
+		Name("Get languages").
+		Description("Get the default language of the catalog and the supported languages")
+
+	router.AddRoute("/translations/missing", RequireEmployee(handler.handleGetMissing)).
+		Methods("GET").
+		Name("Get missing translations").
+		Description("Get products, categories, attributes or attribute options (e.g. sizes and colors) without a translation to some of the supported languages, ordered by id (employees only). Translations with an empty description are missing when the base description is set").
+		Schema(missingTranslationsQueryParams{Type: "<product | category | attribute | attribute_option>", Language: "en", Limit: 50})
+
+	router.AddRoute("/translations/{type}/{id:[0-9]+}", RequireEmployee(handler.handleGet)).
+		Methods("GET").
+		Name("Get translations").
+		Description("Get the translations of a product, category, attribute or attribute option (employees only)")
+
+	router.AddRoute("/translations/{type}/{id:[0-9]+}/{language}", RequireEmployee(handler.handleSet)).
+		Methods("PUT").
+		Name("Set translation").
+		Description("Insert or replace the translation of a product, category, attribute or attribute option to a supported language other than the default one (employees only). Only products and categories have descriptions").
+		Schema(db.TranslationUpdate{Name: "Linen shirt", Description: "Breathable shirt made of pure linen"})
+
+	router.AddRoute("/translations/{type}/{id:[0-9]+}/{language}", RequireEmployee(handler.handleDelete)).
+		Methods("DELETE").
+		Name("Delete translation").
+		Description("Remove the translation, so the next language of the fallback chain is used (employees only)")
+}
+
+func (h *translationHandler) handleGetLanguages(w http.ResponseWriter, req *http.Request) {
+	tools.RespondWithSuccess(w, languagesResponse{
+		DefaultLanguage:    i18n.DefaultLanguage(),
+		SupportedLanguages: i18n.SupportedLanguages(),
+	})
+}
+
+func (h *translationHandler) handleGetMissing(w http.ResponseWriter, req *http.Request) {
+	queryParams := &missingTranslationsQueryParams{}
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(queryParams, req.URL.Query()); err != nil {
+		tools.RespondWithError(w, "Invalid query params", http.StatusBadRequest)
+		return
+	}
+
+	missing, err := h.EntityStore.GetMissing(req.Context(), &db.MissingTranslationsOptions{
+		EntityType: queryParams.Type,
+		Language:   queryParams.Language,
+		Limit:      queryParams.Limit,
+		Offset:     queryParams.Offset,
+	})
+	if err != nil {
+		respondWithTranslationError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, missing)
+}
+
+func (h *translationHandler) handleGet(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	translations, err := h.EntityStore.GetAll(req.Context(), vars["type"], id)
+	if err != nil {
+		respondWithTranslationError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, translations)
+}
+
+func (h *translationHandler) handleSet(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	body := &db.TranslationUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	translation, err := h.EntityStore.Set(req.Context(), vars["type"], id, vars["language"], body)
+	if err != nil {
+		respondWithTranslationError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, translation)
+}
+
+func (h *translationHandler) handleDelete(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.EntityStore.Delete(req.Context(), vars["type"], id, vars["language"]); err != nil {
+		respondWithTranslationError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func respondWithTranslationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrTranslationNotFound), errors.Is(err, db.ErrProductNotFound), errors.Is(err, db.ErrCategoryNotFound),
+		errors.Is(err, db.ErrAttributeNotFound):
+		tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, db.ErrInvalidTranslation):
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Unexpected translation error: %s", err.Error())
+		tools.RespondWithError(w, "Unexpected translation error", http.StatusInternalServerError)
+	}
+}
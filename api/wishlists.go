@@ -0,0 +1,284 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"netshop/main/db"
+	"netshop/main/tools"
+	"netshop/main/tools/router"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type wishlistHandler struct {
+	DatabaseConnection *db.DatabaseConnection
+	EntityStore        *db.WishlistStore
+}
+
+type wishlistShareResponse struct {
+	ShareToken string `json:"share_token"`
+}
+
+func InitWishlistRouter(parent *router.Router, opts *InitEndpointsOptions) {
+	handler := wishlistHandler{
+		DatabaseConnection: opts.DatabaseConnection,
+		EntityStore:        db.NewWishlistStore(opts.DatabaseConnection),
+	}
+	router := parent.Subrouter()
+
+	router.AddRoute("/wishlists", RequireCustomer(handler.handleGet)).
+		Methods("GET").
+		Name("Get wishlists").
+		Description("Get wishlists of the current customer with live prices and stock of the items. Prices are in the 'currency' query parameter or the Accept-Currency header")
+
+	router.AddRoute("/wishlists", RequireCustomer(handler.handleCreate)).
+		Methods("POST").
+		Name("Create wishlist").
+		Description("Create a named wishlist of the current customer").
+		Schema(db.WishlistCreateUpdate{Name: "Summer"})
+
+	router.AddRoute("/wishlists/shared/{token:[0-9a-f]+}", handler.handleGetShared).
+		Methods("GET").
+		Name("Get shared wishlist").
+		Description("Get a wishlist by its share token")
+
+	router.AddRoute("/wishlists/{id:[0-9]+}", RequireCustomer(handler.handleGetById)).
+		Methods("GET").
+		Name("Get wishlist").
+		Description("Get a wishlist of the current customer with live prices and stock of the items")
+
+	router.AddRoute("/wishlists/{id:[0-9]+}", RequireCustomer(handler.handleUpdate)).
+		Methods("PUT").
+		Name("Rename wishlist").
+		Description("Rename a wishlist of the current customer").
+		Schema(db.WishlistCreateUpdate{Name: "Gifts"})
+
+	router.AddRoute("/wishlists/{id:[0-9]+}", RequireCustomer(handler.handleDelete)).
+		Methods("DELETE").
+		Name("Delete wishlist").
+		Description("Delete a wishlist of the current customer with its items")
+
+	router.AddRoute("/wishlists/{id:[0-9]+}/share", RequireCustomer(handler.handleShare)).
+		Methods("POST").
+		Name("Share wishlist").
+		Description("Create a public share token of the wishlist. A shared wishlist keeps its token")
+
+	router.AddRoute("/wishlists/{id:[0-9]+}/share", RequireCustomer(handler.handleUnshare)).
+		Methods("DELETE").
+		Name("Unshare wishlist").
+		Description("Remove the share token, so the shared link stops working")
+
+	router.AddRoute("/wishlists/{id:[0-9]+}/items", RequireCustomer(handler.handleAddItem)).
+		Methods("POST").
+		Name("Add wishlist item").
+		Description("Add a product or a specific variant to the wishlist. Price drops of wishlisted variants are notified by email unless 'notify_price_drop' is false").
+		Schema(db.WishlistItemCreate{ProductId: 1, ProductVariantId: new(int64), NotifyPriceDrop: new(bool)})
+
+	router.AddRoute("/wishlists/{id:[0-9]+}/items/{itemId:[0-9]+}", RequireCustomer(handler.handleRemoveItem)).
+		Methods("DELETE").
+		Name("Remove wishlist item").
+		Description("Remove an item from the wishlist")
+}
+
+func (h *wishlistHandler) handleGet(w http.ResponseWriter, req *http.Request) {
+	customerId := req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	wishlists, err := h.EntityStore.GetAll(req.Context(), customerId, getRequestCurrency(req))
+	if err != nil {
+		respondWithWishlistError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, wishlists)
+}
+
+func (h *wishlistHandler) handleGetById(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid wishlist id", http.StatusBadRequest)
+		return
+	}
+	customerId := req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	wishlist, err := h.EntityStore.GetById(req.Context(), id, customerId, getRequestCurrency(req))
+	if err != nil {
+		respondWithWishlistError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, wishlist)
+}
+
+func (h *wishlistHandler) handleGetShared(w http.ResponseWriter, req *http.Request) {
+	wishlist, err := h.EntityStore.GetShared(req.Context(), mux.Vars(req)["token"], getRequestCurrency(req))
+	if err != nil {
+		respondWithWishlistError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, wishlist)
+}
+
+func (h *wishlistHandler) handleCreate(w http.ResponseWriter, req *http.Request) {
+	body := &db.WishlistCreateUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.CustomerId = req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	wishlist, err := h.EntityStore.Create(req.Context(), body)
+	if err != nil {
+		respondWithWishlistError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, wishlist)
+}
+
+func (h *wishlistHandler) handleUpdate(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid wishlist id", http.StatusBadRequest)
+		return
+	}
+
+	body := &db.WishlistCreateUpdate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.CustomerId = req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	if err := h.EntityStore.Update(req.Context(), id, body); err != nil {
+		respondWithWishlistError(w, err)
+		return
+	}
+
+	wishlist, err := h.EntityStore.GetById(req.Context(), id, body.CustomerId, getRequestCurrency(req))
+	if err != nil {
+		respondWithWishlistError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, wishlist)
+}
+
+func (h *wishlistHandler) handleDelete(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid wishlist id", http.StatusBadRequest)
+		return
+	}
+	customerId := req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	if err := h.EntityStore.Delete(req.Context(), id, customerId); err != nil {
+		respondWithWishlistError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (h *wishlistHandler) handleShare(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid wishlist id", http.StatusBadRequest)
+		return
+	}
+	customerId := req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	token, err := h.EntityStore.Share(req.Context(), id, customerId)
+	if err != nil {
+		respondWithWishlistError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, wishlistShareResponse{ShareToken: token})
+}
+
+func (h *wishlistHandler) handleUnshare(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid wishlist id", http.StatusBadRequest)
+		return
+	}
+	customerId := req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	if err := h.EntityStore.Unshare(req.Context(), id, customerId); err != nil {
+		respondWithWishlistError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func (h *wishlistHandler) handleAddItem(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid wishlist id", http.StatusBadRequest)
+		return
+	}
+
+	body := &db.WishlistItemCreate{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		tools.RespondWithError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	customerId := req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	if _, err := h.EntityStore.AddItem(req.Context(), id, customerId, body); err != nil {
+		respondWithWishlistError(w, err)
+		return
+	}
+
+	wishlist, err := h.EntityStore.GetById(req.Context(), id, customerId, getRequestCurrency(req))
+	if err != nil {
+		respondWithWishlistError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, wishlist)
+}
+
+func (h *wishlistHandler) handleRemoveItem(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid wishlist id", http.StatusBadRequest)
+		return
+	}
+	itemId, err := strconv.ParseInt(vars["itemId"], 10, 64)
+	if err != nil {
+		tools.RespondWithError(w, "Invalid wishlist item id", http.StatusBadRequest)
+		return
+	}
+	customerId := req.Context().Value("user").(*tools.UserTokenClaims).Id
+
+	if err := h.EntityStore.RemoveItem(req.Context(), id, itemId, customerId); err != nil {
+		respondWithWishlistError(w, err)
+		return
+	}
+
+	tools.RespondWithSuccess(w, true)
+}
+
+func respondWithWishlistError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrWishlistNotFound),
+		errors.Is(err, db.ErrWishlistItemNotFound),
+		errors.Is(err, db.ErrProductNotFound),
+		errors.Is(err, db.ErrVariantNotFound):
+		tools.RespondWithError(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, db.ErrWishlistExists), errors.Is(err, db.ErrWishlistItemExists):
+		tools.RespondWithError(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrInvalidWishlist), errors.Is(err, db.ErrUnsupportedCurrency):
+		tools.RespondWithError(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Unexpected wishlist error: %s", err.Error())
+		tools.RespondWithError(w, "Unexpected wishlist error", http.StatusInternalServerError)
+	}
+}
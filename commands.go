@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"netshop/main/api"
+	"netshop/main/db"
+)
+
+// Maintenance commands that can be run instead of the HTTP server, e.g. `go run . reconcile-stock`
+var commands = map[string]func(database *db.DatabaseConnection, args []string) error{
+	"reconcile-stock":     reconcileStockCommand,
+	"load-exchange-rates": loadExchangeRatesCommand,
+	"import-catalog":      importCatalogCommand,
+	"export-catalog":      exportCatalogCommand,
+}
+
+// Runs the command by its name and exits the process with non-zero code on failure
+func runCommand(database *db.DatabaseConnection, name string, args []string) {
+	command, exists := commands[name]
+	if !exists {
+		log.Fatalf("Unknown command '%s'", name)
+	}
+
+	if err := command(database, args); err != nil {
+		log.Fatalf("Command '%s' failed: %s", name, err.Error())
+	}
+}
+
+// Verifies that the sum of the stock ledger matches the stock of every product variant
+func reconcileStockCommand(database *db.DatabaseConnection, args []string) error {
+	stockMovementStore := db.NewStockMovementStore(database)
+	discrepancies, err := stockMovementStore.Reconcile(database.Context)
+	if err != nil {
+		return err
+	}
+
+	for _, discrepancy := range discrepancies {
+		log.Printf("Variant %d: stock is %d, but the ledger sum is %d",
+			discrepancy.ProductVariantId, discrepancy.Stock, discrepancy.LedgerStock)
+	}
+	if len(discrepancies) > 0 {
+		return fmt.Errorf("found %d variants with stock that does not match the ledger", len(discrepancies))
+	}
+
+	log.Println("Stock ledger is consistent with product variants")
+	return nil
+}
+
+// Loads exchange rates from a .csv or .json file, e.g. `go run . load-exchange-rates rates.csv`
+func loadExchangeRatesCommand(database *db.DatabaseConnection, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: load-exchange-rates <file.csv | file.json>")
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(args[0])), ".")
+	rates, err := db.ParseExchangeRates(file, format)
+	if err != nil {
+		return err
+	}
+
+	if err := db.NewExchangeRateStore(database).Save(database.Context, rates); err != nil {
+		return err
+	}
+
+	log.Printf("Loaded %d exchange rates", len(rates))
+	return nil
+}
+
+// Imports products from a .csv, .jsonl or .json file, e.g. `go run . import-catalog catalog.csv --dry-run`.
+// Unlike the HTTP endpoint, images can be paths on the local disk
+func importCatalogCommand(database *db.DatabaseConnection, args []string) error {
+	dryRun := len(args) == 2 && args[1] == "--dry-run"
+	if len(args) != 1 && !dryRun {
+		return fmt.Errorf("usage: import-catalog <file.csv | file.jsonl | file.json> [--dry-run]")
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rows, err := db.ParseCatalog(file, catalogFileFormat(args[0]))
+	if err != nil {
+		return err
+	}
+
+	catalogStore := db.NewCatalogStore(database, api.NewCatalogImageLoader(api.NewImageDownloadClient(30*time.Second)))
+	report, err := catalogStore.Import(database.Context, rows, &db.CatalogImportOptions{
+		DryRun:          dryRun,
+		AllowLocalFiles: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rowError := range report.Errors {
+		log.Printf("Line %d: %s", rowError.Line, rowError.Message)
+	}
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("found %d invalid rows, nothing was imported", len(report.Errors))
+	}
+
+	action := "Imported"
+	if dryRun {
+		action = "Dry run, would import"
+	}
+	log.Printf("%s %d rows: %d products created, %d updated, %d variants created, %d updated",
+		action, report.Rows, report.ProductsCreated, report.ProductsUpdated, report.VariantsCreated, report.VariantsUpdated)
+	return nil
+}
+
+// Exports all products to a .csv, .jsonl or .json file, e.g. `go run . export-catalog catalog.csv`
+func exportCatalogCommand(database *db.DatabaseConnection, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: export-catalog <file.csv | file.jsonl | file.json>")
+	}
+
+	rows, err := db.NewCatalogStore(database, nil).Export(database.Context)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := db.WriteCatalog(file, catalogFileFormat(args[0]), rows); err != nil {
+		return err
+	}
+
+	log.Printf("Exported %d product variants", len(rows))
+	return file.Close()
+}
+
+func catalogFileFormat(filename string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+}
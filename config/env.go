@@ -15,6 +15,58 @@ type config struct {
 	ServerURL   string
 	JwtSecret   string
 	JwtExpire   string
+
+	// ISO 4217 code of the currency used for prices without explicit currency
+	DefaultCurrency string
+	// ISO 639-1 code of the language of the product, category, size and color texts
+	DefaultLanguage string
+	// Comma-separated ISO 639-1 codes of the languages the catalog can be translated to
+	SupportedLanguages string
+
+	// Signing method of the issued tokens: HS256, RS256 or EdDSA
+	JwtSigningMethod string
+	// Path to the PEM encoded private key used for RS256/EdDSA signing
+	JwtPrivateKeyFile string
+	// Key id (kid header) of the active signing key
+	JwtKeyId string
+	// Additional verification keys for key rotation, comma-separated "kid=path/to/public.pem" pairs
+	JwtVerificationKeys string
+	JwtIssuer           string
+	JwtAudience         string
+
+	// How long stock stays reserved for a pending order, value for `time.ParseDuration`
+	ReservationTTL string
+	// How often expired reservations are released, value for `time.ParseDuration`
+	ReservationReleaseInterval string
+
+	// Default reorder threshold for variants without variant or category threshold
+	LowStockThreshold string
+	// How often low stock and back in stock notifications are checked, value for `time.ParseDuration`
+	StockNotificationsInterval string
+	// How often price drops of wishlisted variants are checked, value for `time.ParseDuration`
+	PriceDropNotificationsInterval string
+	// Comma-separated list of notification channels: log, email, webhook
+	Notifiers        string
+	NotifyWebhookURL string
+	SmtpHost         string
+	SmtpPort         string
+	SmtpUsername     string
+	SmtpPassword     string
+	SmtpFrom         string
+
+	// Payment gateway used for order payments: mock
+	PaymentProvider string
+	// Secret used to verify signatures of the payment gateway webhooks
+	PaymentWebhookSecret string
+	// Delay before the mock gateway completes async payments, value for `time.ParseDuration`
+	MockPaymentWebhookDelay string
+
+	// How often the sales report views are refreshed, value for `time.ParseDuration`
+	ReportsRefreshInterval string
+	// How often the frequently bought together statistics are refreshed, value for `time.ParseDuration`
+	RecommendationsRefreshInterval string
+	// How often scheduled products are published and expired products are archived, value for `time.ParseDuration`
+	PublishingInterval string
 }
 
 var AppConfig = config{}
@@ -27,6 +79,33 @@ func init() {
 
 	AppConfig.JwtSecret = tryGetEnv("JWT_SECRET", "30bd725869cb74ba")
 	AppConfig.JwtExpire = tryGetEnv("JWT_EXPIRE", "24h")
+	AppConfig.JwtSigningMethod = tryGetEnv("JWT_SIGNING_METHOD", "HS256")
+	AppConfig.JwtPrivateKeyFile = tryGetEnv("JWT_PRIVATE_KEY_FILE", "")
+	AppConfig.JwtKeyId = tryGetEnv("JWT_KEY_ID", "")
+	AppConfig.JwtVerificationKeys = tryGetEnv("JWT_VERIFICATION_KEYS", "")
+	AppConfig.JwtIssuer = tryGetEnv("JWT_ISSUER", "netshop")
+	AppConfig.JwtAudience = tryGetEnv("JWT_AUDIENCE", "netshop")
+	AppConfig.ReservationTTL = tryGetEnv("RESERVATION_TTL", "30m")
+	AppConfig.ReservationReleaseInterval = tryGetEnv("RESERVATION_RELEASE_INTERVAL", "1m")
+	AppConfig.LowStockThreshold = tryGetEnv("LOW_STOCK_THRESHOLD", "5")
+	AppConfig.StockNotificationsInterval = tryGetEnv("STOCK_NOTIFICATIONS_INTERVAL", "5m")
+	AppConfig.PriceDropNotificationsInterval = tryGetEnv("PRICE_DROP_NOTIFICATIONS_INTERVAL", "1h")
+	AppConfig.Notifiers = tryGetEnv("NOTIFIERS", "log")
+	AppConfig.NotifyWebhookURL = tryGetEnv("NOTIFY_WEBHOOK_URL", "")
+	AppConfig.SmtpHost = tryGetEnv("SMTP_HOST", "localhost")
+	AppConfig.SmtpPort = tryGetEnv("SMTP_PORT", "25")
+	AppConfig.SmtpUsername = tryGetEnv("SMTP_USERNAME", "")
+	AppConfig.SmtpPassword = tryGetEnv("SMTP_PASSWORD", "")
+	AppConfig.SmtpFrom = tryGetEnv("SMTP_FROM", "netshop@localhost")
+	AppConfig.PaymentProvider = tryGetEnv("PAYMENT_PROVIDER", "mock")
+	AppConfig.PaymentWebhookSecret = tryGetEnv("PAYMENT_WEBHOOK_SECRET", "")
+	AppConfig.MockPaymentWebhookDelay = tryGetEnv("MOCK_PAYMENT_WEBHOOK_DELAY", "2s")
+	AppConfig.ReportsRefreshInterval = tryGetEnv("REPORTS_REFRESH_INTERVAL", "15m")
+	AppConfig.RecommendationsRefreshInterval = tryGetEnv("RECOMMENDATIONS_REFRESH_INTERVAL", "1h")
+	AppConfig.PublishingInterval = tryGetEnv("PUBLISHING_INTERVAL", "1m")
+	AppConfig.DefaultCurrency = tryGetEnv("DEFAULT_CURRENCY", "UAH")
+	AppConfig.DefaultLanguage = tryGetEnv("DEFAULT_LANGUAGE", "uk")
+	AppConfig.SupportedLanguages = tryGetEnv("SUPPORTED_LANGUAGES", "uk,en")
 	AppConfig.DatabaseURL = tryGetEnv("DATABASE_URL", "localhost")
 	AppConfig.ServerHost = tryGetEnv("SERVER_HOST", "localhost")
 	AppConfig.ServerPort = tryGetEnv("SERVER_PORT", "6900")
@@ -0,0 +1,763 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	AttributeTypeText    = "text"
+	AttributeTypeNumber  = "number"
+	AttributeTypeBoolean = "boolean"
+	AttributeTypeOption  = "option"
+)
+
+var (
+	ErrAttributeNotFound = errors.New("attribute not found")
+	ErrAttributeExists   = errors.New("attribute with the same code already exists")
+	ErrAttributeInUse    = errors.New("attribute is used by product variants")
+	ErrInvalidAttribute  = errors.New("invalid attribute")
+)
+
+var (
+	attributeCodeRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	hexCodeRegexp       = regexp.MustCompile(`^#?([0-9a-f]{3}|[0-9a-f]{6})$`)
+)
+
+// AttributeOptionEntity is an allowed value of an option attribute, e.g. a size or a color
+type AttributeOptionEntity struct {
+	Id        int64  `json:"id"`
+	Value     string `json:"value"`
+	SortOrder int32  `json:"sort_order"`
+	// Lowercase "#rrggbb" code of the option sample, e.g. of a color
+	HexCode string `json:"hex_code,omitempty"`
+	// Uploaded swatch image shown instead of the hex code, e.g. for patterned fabrics
+	SwatchFileId *int64 `json:"swatch_file_id,omitempty"`
+	SwatchUrl    string `json:"swatch_url,omitempty"`
+}
+
+type AttributeOptionCreateUpdate struct {
+	Value string `json:"value"`
+	// Position in the option list, lower values first. Nil adds new options to the end
+	// and keeps the position of updated ones
+	SortOrder *int32 `json:"sort_order"`
+	// "#rrggbb" or "#rgb", empty for none
+	HexCode string `json:"hex_code"`
+	// File uploaded with /file/upload, nil for none
+	SwatchFileId *int64 `json:"swatch_file_id"`
+}
+
+// AttributeEntity is a definition of a variant attribute, e.g. material, fit or capacity
+type AttributeEntity struct {
+	Id   int64  `json:"id"`
+	Code string `json:"code"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Unit of number values, e.g. "ml"
+	Unit string `json:"unit,omitempty"`
+	// Allowed values of option attributes
+	Options []AttributeOptionEntity `json:"options,omitempty"`
+}
+
+type AttributeCreate struct {
+	Code    string   `json:"code"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Unit    string   `json:"unit"`
+	Options []string `json:"options"`
+}
+
+// CategoryAttributeEntity is an attribute of a category attribute set
+type CategoryAttributeEntity struct {
+	AttributeEntity
+	IsRequired bool  `json:"is_required"`
+	SortOrder  int32 `json:"sort_order"`
+	// Category that defines the attribute, a parent category for inherited attributes
+	CategoryId int64 `json:"category_id"`
+}
+
+type CategoryAttributeSet struct {
+	AttributeId int64 `json:"attribute_id"`
+	IsRequired  bool  `json:"is_required"`
+	SortOrder   int32 `json:"sort_order"`
+}
+
+// VariantAttributeValue is an attribute value of a product variant
+type VariantAttributeValue struct {
+	AttributeId int64  `json:"attribute_id"`
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Unit        string `json:"unit,omitempty"`
+	OptionId    *int64 `json:"option_id,omitempty"`
+	// String, number or boolean by the attribute type
+	Value any `json:"value"`
+}
+
+// attributeValue is a validated attribute value of a variant, only one of the values is set
+type attributeValue struct {
+	attributeId int64
+	optionId    *int64
+	text        *string
+	number      *float64
+	boolean     *bool
+}
+
+type AttributeStore struct {
+	db *DatabaseConnection
+}
+
+const attributeOptionSelectSQL = `select "attribute_options".id, "attribute_options".attribute_id, "attribute_options".value,
+	"attribute_options".sort_order, coalesce("attribute_options".hex_code, ''), "attribute_options".swatch_file_id,
+	coalesce("files".path, '')
+	from "attribute_options"
+	left join "files" on "files".id = "attribute_options".swatch_file_id`
+
+// Comma-separated attribute values of "product_variants", e.g. "Color: Black, Material: cotton, Size: M"
+const variantAttributesTextSQL = `(
+	select string_agg("attributes".name || ': ' || coalesce(
+		"attribute_options".value,
+		"product_variant_attributes".value_text,
+		"product_variant_attributes".value_number::text || coalesce(' ' || "attributes".unit, ''),
+		case when "product_variant_attributes".value_boolean then 'yes' else 'no' end
+	), ', ' order by "attributes".name)
+	from "product_variant_attributes"
+	join "attributes" on "attributes".id = "product_variant_attributes".attribute_id
+	left join "attribute_options" on "attribute_options".id = "product_variant_attributes".option_id
+	where "product_variant_attributes".product_variant_id = "product_variants".id
+)`
+
+func NewAttributeStore(database *DatabaseConnection) *AttributeStore {
+	return &AttributeStore{
+		db: database,
+	}
+}
+
+// Returns all attributes with the options of option attributes. Names and options are returned
+// in the languages of the fallback chain, the base texts are returned for an empty chain
+func (s *AttributeStore) GetAll(ctx context.Context, languages []string) ([]AttributeEntity, error) {
+	attributes, err := getAttributes(ctx, s.db.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	attributeIds := make([]int64, 0, len(attributes))
+	optionIds := make([]int64, 0)
+	for _, attribute := range attributes {
+		attributeIds = append(attributeIds, attribute.Id)
+		for _, option := range attribute.Options {
+			optionIds = append(optionIds, option.Id)
+		}
+	}
+	attributeTexts, err := newTranslationList(ctx, s.db.Connection, TranslationAttribute, attributeIds, languages)
+	if err != nil {
+		return nil, err
+	}
+	optionTexts, err := newTranslationList(ctx, s.db.Connection, TranslationAttributeOption, optionIds, languages)
+	if err != nil {
+		return nil, err
+	}
+	for i := range attributes {
+		attributes[i].Name = attributeTexts.name(attributes[i].Id, attributes[i].Name)
+		for j := range attributes[i].Options {
+			option := &attributes[i].Options[j]
+			option.Value = optionTexts.name(option.Id, option.Value)
+		}
+	}
+	return attributes, nil
+}
+
+func getAttributes(ctx context.Context, q querier) ([]AttributeEntity, error) {
+	rows, err := q.Query(ctx, `select id, code, name, type, coalesce(unit, '') from "attributes" order by name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attributes: %w", err)
+	}
+	defer rows.Close()
+
+	attributes := make([]AttributeEntity, 0)
+	indexes := make(map[int64]int)
+	for rows.Next() {
+		var attribute AttributeEntity
+		if err := rows.Scan(&attribute.Id, &attribute.Code, &attribute.Name, &attribute.Type, &attribute.Unit); err != nil {
+			return nil, err
+		}
+		indexes[attribute.Id] = len(attributes)
+		attributes = append(attributes, attribute)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(ctx, attributeOptionSelectSQL+` order by "attribute_options".sort_order, "attribute_options".value`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attribute options: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		option, attributeId, err := scanAttributeOption(rows)
+		if err != nil {
+			return nil, err
+		}
+		attribute := &attributes[indexes[attributeId]]
+		attribute.Options = append(attribute.Options, option)
+	}
+	return attributes, rows.Err()
+}
+
+// Returns the attributes by code
+func getAttributeDefinitions(ctx context.Context, q querier) (map[string]*AttributeEntity, error) {
+	attributes, err := getAttributes(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	definitions := make(map[string]*AttributeEntity, len(attributes))
+	for i := range attributes {
+		definitions[attributes[i].Code] = &attributes[i]
+	}
+	return definitions, nil
+}
+
+func (s *AttributeStore) Create(ctx context.Context, opts *AttributeCreate) (*AttributeEntity, error) {
+	opts.Code = strings.ToLower(strings.TrimSpace(opts.Code))
+	opts.Name = strings.TrimSpace(opts.Name)
+	if !attributeCodeRegexp.MatchString(opts.Code) {
+		return nil, fmt.Errorf("%w: code must contain lowercase latin letters, digits and underscores", ErrInvalidAttribute)
+	}
+	if opts.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidAttribute)
+	}
+	switch opts.Type {
+	case AttributeTypeText, AttributeTypeNumber, AttributeTypeBoolean:
+		if len(opts.Options) > 0 {
+			return nil, fmt.Errorf("%w: only option attributes have options", ErrInvalidAttribute)
+		}
+	case AttributeTypeOption:
+		if len(opts.Options) == 0 {
+			return nil, fmt.Errorf("%w: option attributes require options", ErrInvalidAttribute)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported type '%s'", ErrInvalidAttribute, opts.Type)
+	}
+	if opts.Unit != "" && opts.Type != AttributeTypeNumber {
+		return nil, fmt.Errorf("%w: only number attributes have units", ErrInvalidAttribute)
+	}
+
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	attribute := &AttributeEntity{Code: opts.Code, Name: opts.Name, Type: opts.Type, Unit: opts.Unit}
+	err = tx.QueryRow(ctx, `
+		insert into "attributes" (code, name, type, unit)
+		values ($1, $2, $3, nullif($4, ''))
+		returning id`, opts.Code, opts.Name, opts.Type, opts.Unit,
+	).Scan(&attribute.Id)
+	if err != nil {
+		return nil, attributeWriteError(err)
+	}
+
+	for i, value := range opts.Options {
+		option, err := s.txAddOption(ctx, tx, attribute.Id, &AttributeOptionCreateUpdate{Value: value}, int32(i))
+		if err != nil {
+			return nil, err
+		}
+		attribute.Options = append(attribute.Options, *option)
+	}
+
+	return attribute, tx.Commit(ctx)
+}
+
+// Adds an option to the option attribute values, to the end when the sort order is not set
+func (s *AttributeStore) AddOption(ctx context.Context, attributeId int64, opts *AttributeOptionCreateUpdate) (*AttributeOptionEntity, error) {
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var attributeType string
+	var sortOrder int32
+	err = tx.QueryRow(ctx, `
+		select type, coalesce((select max(sort_order) + 1 from "attribute_options" where attribute_id = $1), 0)
+		from "attributes"
+		where id = $1
+		for update`, attributeId).Scan(&attributeType, &sortOrder)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAttributeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attribute: %w", err)
+	}
+	if attributeType != AttributeTypeOption {
+		return nil, fmt.Errorf("%w: only option attributes have options", ErrInvalidAttribute)
+	}
+
+	if opts.SortOrder != nil {
+		sortOrder = *opts.SortOrder
+	}
+	option, err := s.txAddOption(ctx, tx, attributeId, opts, sortOrder)
+	if err != nil {
+		return nil, err
+	}
+	return option, tx.Commit(ctx)
+}
+
+func (s *AttributeStore) txAddOption(ctx context.Context, tx pgx.Tx, attributeId int64, opts *AttributeOptionCreateUpdate, sortOrder int32) (*AttributeOptionEntity, error) {
+	if err := normalizeAttributeOption(opts); err != nil {
+		return nil, err
+	}
+
+	var id int64
+	err := tx.QueryRow(ctx, `
+		insert into "attribute_options" (attribute_id, value, sort_order, hex_code, swatch_file_id)
+		values ($1, $2, $3, nullif($4, ''), $5)
+		returning id`, attributeId, opts.Value, sortOrder, opts.HexCode, opts.SwatchFileId,
+	).Scan(&id)
+	if err != nil {
+		return nil, attributeOptionWriteError(err, opts.Value)
+	}
+	return getAttributeOption(ctx, tx, attributeId, id)
+}
+
+// Renames, reorders or changes the sample of an option. Variants with the option get the new value
+func (s *AttributeStore) UpdateOption(ctx context.Context, attributeId int64, optionId int64, opts *AttributeOptionCreateUpdate) (*AttributeOptionEntity, error) {
+	if err := normalizeAttributeOption(opts); err != nil {
+		return nil, err
+	}
+
+	tag, err := s.db.Connection.Exec(ctx, `
+		update "attribute_options"
+		set value = $3, sort_order = coalesce($4, sort_order), hex_code = nullif($5, ''), swatch_file_id = $6
+		where id = $2 and attribute_id = $1`,
+		attributeId, optionId, opts.Value, opts.SortOrder, opts.HexCode, opts.SwatchFileId)
+	if err != nil {
+		return nil, attributeOptionWriteError(err, opts.Value)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrAttributeNotFound
+	}
+	return getAttributeOption(ctx, s.db.Connection, attributeId, optionId)
+}
+
+// Deletes an attribute that no variant uses, the attribute is removed from category attribute sets
+func (s *AttributeStore) Delete(ctx context.Context, id int64) error {
+	tag, err := s.db.Connection.Exec(ctx, `delete from "attributes" where id = $1`, id)
+	if err != nil {
+		return attributeWriteError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAttributeNotFound
+	}
+	return nil
+}
+
+// Deletes an option that no variant uses
+func (s *AttributeStore) DeleteOption(ctx context.Context, attributeId int64, optionId int64) error {
+	tag, err := s.db.Connection.Exec(ctx, `delete from "attribute_options" where id = $2 and attribute_id = $1`, attributeId, optionId)
+	if err != nil {
+		return attributeWriteError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAttributeNotFound
+	}
+	return nil
+}
+
+// Returns the attribute set of the category including the attributes inherited from parent categories
+func (s *AttributeStore) GetCategoryAttributes(ctx context.Context, categoryId int64) ([]CategoryAttributeEntity, error) {
+	exists, err := NewCategoryEntityStore(s.db).CheckCategoryExists(categoryId)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrCategoryNotFound
+	}
+
+	set, err := getCategoryAttributeSet(ctx, s.db.Connection, categoryId)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := getAttributes(ctx, s.db.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]CategoryAttributeEntity, 0, len(set))
+	for _, attribute := range attributes {
+		if item, exists := set[attribute.Id]; exists {
+			item.AttributeEntity = attribute
+			result = append(result, *item)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].SortOrder < result[j].SortOrder
+	})
+	return result, nil
+}
+
+// Replaces the own attribute set of the category, inherited attributes are not changed
+func (s *AttributeStore) SetCategoryAttributes(ctx context.Context, categoryId int64, set []CategoryAttributeSet) error {
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	if err := tx.QueryRow(ctx, `select exists(select 1 from "categories" where id = $1)`, categoryId).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check category: %w", err)
+	}
+	if !exists {
+		return ErrCategoryNotFound
+	}
+
+	if _, err := tx.Exec(ctx, `delete from "category_attributes" where category_id = $1`, categoryId); err != nil {
+		return fmt.Errorf("failed to clear category attributes: %w", err)
+	}
+	for _, item := range set {
+		_, err := tx.Exec(ctx, `
+			insert into "category_attributes" (category_id, attribute_id, is_required, sort_order)
+			values ($1, $2, $3, $4)`, categoryId, item.AttributeId, item.IsRequired, item.SortOrder)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && (pgErr.Code == "23503" || pgErr.Code == "23505") {
+				return fmt.Errorf("%w: attribute '%d' is not found or repeated", ErrInvalidAttribute, item.AttributeId)
+			}
+			return fmt.Errorf("failed to add category attribute: %w", err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Returns the attribute set of the category by attribute id. For attributes inherited from
+// several categories the nearest category wins
+func getCategoryAttributeSet(ctx context.Context, q querier, categoryId int64) (map[int64]*CategoryAttributeEntity, error) {
+	rows, err := q.Query(ctx, `
+		with recursive ancestors as (
+			select id, parent_id, 0 as depth from "categories" where id = $1
+			union all
+			select "categories".id, "categories".parent_id, ancestors.depth + 1 from "categories"
+			join ancestors on ancestors.parent_id = "categories".id
+		)
+		select distinct on ("category_attributes".attribute_id)
+			"category_attributes".attribute_id, "category_attributes".category_id,
+			"category_attributes".is_required, "category_attributes".sort_order
+		from "category_attributes"
+		join ancestors on ancestors.id = "category_attributes".category_id
+		order by "category_attributes".attribute_id, ancestors.depth`, categoryId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get category attributes: %w", err)
+	}
+	defer rows.Close()
+
+	set := make(map[int64]*CategoryAttributeEntity)
+	for rows.Next() {
+		item := &CategoryAttributeEntity{}
+		if err := rows.Scan(&item.AttributeEntity.Id, &item.CategoryId, &item.IsRequired, &item.SortOrder); err != nil {
+			return nil, err
+		}
+		set[item.AttributeEntity.Id] = item
+	}
+	return set, rows.Err()
+}
+
+// Validates attribute values by attribute code. Values are strings, numbers or booleans by the attribute type,
+// option attributes accept the option value and strings are accepted for every type
+func resolveAttributeValues(definitions map[string]*AttributeEntity, values map[string]any) ([]attributeValue, error) {
+	resolved := make([]attributeValue, 0, len(values))
+	for code, raw := range values {
+		attribute, exists := definitions[strings.ToLower(code)]
+		if !exists {
+			return nil, fmt.Errorf("%w: unknown attribute '%s'", ErrInvalidAttribute, code)
+		}
+
+		value := attributeValue{attributeId: attribute.Id}
+		text, isString := raw.(string)
+		text = strings.TrimSpace(text)
+		switch attribute.Type {
+		case AttributeTypeText:
+			if !isString || text == "" {
+				return nil, fmt.Errorf("%w: '%s' must be a non-empty string", ErrInvalidAttribute, code)
+			}
+			value.text = &text
+		case AttributeTypeNumber:
+			number, isNumber := raw.(float64)
+			if isString {
+				parsed, err := strconv.ParseFloat(text, 64)
+				number, isNumber = parsed, err == nil
+			}
+			if !isNumber {
+				return nil, fmt.Errorf("%w: '%s' must be a number", ErrInvalidAttribute, code)
+			}
+			value.number = &number
+		case AttributeTypeBoolean:
+			boolean, isBoolean := raw.(bool)
+			if isString {
+				parsed, err := strconv.ParseBool(text)
+				boolean, isBoolean = parsed, err == nil
+			}
+			if !isBoolean {
+				return nil, fmt.Errorf("%w: '%s' must be a boolean", ErrInvalidAttribute, code)
+			}
+			value.boolean = &boolean
+		case AttributeTypeOption:
+			for _, option := range attribute.Options {
+				if isString && strings.EqualFold(option.Value, text) {
+					value.optionId = &option.Id
+					break
+				}
+			}
+			if value.optionId == nil {
+				return nil, fmt.Errorf("%w: '%v' is not an option of '%s'", ErrInvalidAttribute, raw, code)
+			}
+		}
+		resolved = append(resolved, value)
+	}
+
+	sort.Slice(resolved, func(i, j int) bool {
+		return resolved[i].attributeId < resolved[j].attributeId
+	})
+	return resolved, nil
+}
+
+// Checks that the values contain every required attribute of the category attribute set
+func checkRequiredAttributes(set map[int64]*CategoryAttributeEntity, definitions map[string]*AttributeEntity, values []attributeValue) error {
+	for _, attribute := range definitions {
+		item, exists := set[attribute.Id]
+		if !exists || !item.IsRequired {
+			continue
+		}
+		found := false
+		for _, value := range values {
+			found = found || value.attributeId == attribute.Id
+		}
+		if !found {
+			return fmt.Errorf("%w: attribute '%s' is required in the category", ErrInvalidAttribute, attribute.Code)
+		}
+	}
+	return nil
+}
+
+// Returns the canonical form of the sorted attribute values, variants of a product must have different keys
+func attributeDimensionKey(values []attributeValue) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		var part string
+		switch {
+		case value.optionId != nil:
+			part = "o" + strconv.FormatInt(*value.optionId, 10)
+		case value.text != nil:
+			part = "t" + strconv.Quote(strings.ToLower(*value.text))
+		case value.number != nil:
+			part = "n" + strconv.FormatFloat(*value.number, 'f', -1, 64)
+		case value.boolean != nil:
+			part = "b" + strconv.FormatBool(*value.boolean)
+		}
+		parts = append(parts, strconv.FormatInt(value.attributeId, 10)+"="+part)
+	}
+	return strings.Join(parts, ";")
+}
+
+// Validates the variant attributes against the attribute definitions and the attribute set of the product category
+func resolveVariantAttributes(ctx context.Context, q querier, productId int64, values map[string]any) ([]attributeValue, error) {
+	definitions, err := getAttributeDefinitions(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := resolveAttributeValues(definitions, values)
+	if err != nil {
+		return nil, err
+	}
+
+	var categoryId *int64
+	if err := q.QueryRow(ctx, `select category_id from "products" where id = $1`, productId).Scan(&categoryId); err != nil {
+		return nil, fmt.Errorf("failed to get product category: %w", err)
+	}
+	if categoryId == nil {
+		return resolved, nil
+	}
+	set, err := getCategoryAttributeSet(ctx, q, *categoryId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRequiredAttributes(set, definitions, resolved); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+func txInsertVariantAttributes(ctx context.Context, tx pgx.Tx, variantId int64, values []attributeValue) error {
+	for _, value := range values {
+		_, err := tx.Exec(ctx, `
+			insert into "product_variant_attributes" (product_variant_id, attribute_id, option_id, value_text, value_number, value_boolean)
+			values ($1, $2, $3, $4, $5, $6)`,
+			variantId, value.attributeId, value.optionId, value.text, value.number, value.boolean)
+		if err != nil {
+			return fmt.Errorf("failed to add variant attribute: %w", err)
+		}
+	}
+	return nil
+}
+
+// Returns the attribute values of the variants by variant id. Attribute names and option values
+// are returned in the languages of the fallback chain, the base texts are returned for an empty chain
+func getVariantAttributes(ctx context.Context, q querier, variantIds []int64, languages []string) (map[int64][]VariantAttributeValue, error) {
+	result := make(map[int64][]VariantAttributeValue)
+	if len(variantIds) == 0 {
+		return result, nil
+	}
+
+	rows, err := q.Query(ctx, `
+		select "product_variant_attributes".product_variant_id, "attributes".id, "attributes".code, "attributes".name,
+			"attributes".type, coalesce("attributes".unit, ''), "product_variant_attributes".option_id,
+			coalesce("attribute_options".value, "product_variant_attributes".value_text),
+			"product_variant_attributes".value_number::float8, "product_variant_attributes".value_boolean
+		from "product_variant_attributes"
+		join "attributes" on "attributes".id = "product_variant_attributes".attribute_id
+		left join "attribute_options" on "attribute_options".id = "product_variant_attributes".option_id
+		where "product_variant_attributes".product_variant_id = any($1)
+		order by "attributes".name`, variantIds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant attributes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variantId int64
+		var value VariantAttributeValue
+		var text *string
+		var number *float64
+		var boolean *bool
+		err := rows.Scan(&variantId, &value.AttributeId, &value.Code, &value.Name, &value.Type, &value.Unit, &value.OptionId, &text, &number, &boolean)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case text != nil:
+			value.Value = *text
+		case number != nil:
+			value.Value = *number
+		case boolean != nil:
+			value.Value = *boolean
+		}
+		result[variantId] = append(result[variantId], value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	attributeIds := make([]int64, 0)
+	optionIds := make([]int64, 0)
+	for _, values := range result {
+		for _, value := range values {
+			attributeIds = append(attributeIds, value.AttributeId)
+			if value.OptionId != nil {
+				optionIds = append(optionIds, *value.OptionId)
+			}
+		}
+	}
+	attributeTexts, err := newTranslationList(ctx, q, TranslationAttribute, attributeIds, languages)
+	if err != nil {
+		return nil, err
+	}
+	optionTexts, err := newTranslationList(ctx, q, TranslationAttributeOption, optionIds, languages)
+	if err != nil {
+		return nil, err
+	}
+	for _, values := range result {
+		for i := range values {
+			values[i].Name = attributeTexts.name(values[i].AttributeId, values[i].Name)
+			if values[i].OptionId != nil {
+				values[i].Value = optionTexts.name(*values[i].OptionId, values[i].Value.(string))
+			}
+		}
+	}
+	return result, nil
+}
+
+func getAttributeOption(ctx context.Context, q querier, attributeId int64, optionId int64) (*AttributeOptionEntity, error) {
+	option, _, err := scanAttributeOption(q.QueryRow(ctx, attributeOptionSelectSQL+`
+		where "attribute_options".id = $2 and "attribute_options".attribute_id = $1`, attributeId, optionId))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAttributeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attribute option: %w", err)
+	}
+	return &option, nil
+}
+
+// Scans a row of attributeOptionSelectSQL, returns the option and its attribute id
+func scanAttributeOption(row pgx.Row) (AttributeOptionEntity, int64, error) {
+	var option AttributeOptionEntity
+	var attributeId int64
+	var swatchPath string
+	err := row.Scan(&option.Id, &attributeId, &option.Value, &option.SortOrder, &option.HexCode, &option.SwatchFileId, &swatchPath)
+	if swatchPath != "" {
+		option.SwatchUrl = getImageURLFromPath(swatchPath)
+	}
+	return option, attributeId, err
+}
+
+func normalizeAttributeOption(opts *AttributeOptionCreateUpdate) error {
+	opts.Value = strings.TrimSpace(opts.Value)
+	if opts.Value == "" {
+		return fmt.Errorf("%w: option value is required", ErrInvalidAttribute)
+	}
+	if len(opts.Value) > 255 {
+		return fmt.Errorf("%w: option value must be at most 255 characters", ErrInvalidAttribute)
+	}
+
+	hexCode := strings.ToLower(strings.TrimSpace(opts.HexCode))
+	if hexCode == "" {
+		opts.HexCode = ""
+		return nil
+	}
+	if !hexCodeRegexp.MatchString(hexCode) {
+		return fmt.Errorf("%w: hex code must look like '#1a2b3c'", ErrInvalidAttribute)
+	}
+	hexCode = strings.TrimPrefix(hexCode, "#")
+	if len(hexCode) == 3 {
+		hexCode = string([]byte{hexCode[0], hexCode[0], hexCode[1], hexCode[1], hexCode[2], hexCode[2]})
+	}
+	opts.HexCode = "#" + hexCode
+	return nil
+}
+
+func attributeOptionWriteError(err error, value string) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return fmt.Errorf("%w: duplicate option '%s'", ErrInvalidAttribute, value)
+		case "23503":
+			return fmt.Errorf("%w: swatch file not found", ErrInvalidAttribute)
+		}
+	}
+	return fmt.Errorf("failed to save attribute option: %w", err)
+}
+
+func attributeWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return ErrAttributeExists
+		case "23503":
+			return ErrAttributeInUse
+		}
+	}
+	return fmt.Errorf("failed to save attribute: %w", err)
+}
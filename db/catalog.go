@@ -0,0 +1,959 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"netshop/main/config"
+	"netshop/main/tools/money"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const catalogImportBatchSize = 100
+
+var ErrInvalidCatalog = errors.New("invalid catalog")
+
+// Columns of catalog CSV files, the order is used for exports
+var catalogColumns = []string{
+	"product_id", "name", "description", "category", "base_price",
+	"attributes", "sku", "barcode", "price", "stock", "weight", "images",
+}
+
+// CatalogRow is a single product variant of the catalog import and export files
+type CatalogRow struct {
+	// Line of the row in the imported file
+	Line int `json:"-"`
+	// Existing product to update. Rows without id are matched to products by name or by the SKU of an existing variant
+	ProductId   *int64 `json:"product_id,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Category is referenced by name
+	Category string `json:"category"`
+	// Decimal prices in the default currency
+	BasePrice string `json:"base_price"`
+	// Attribute values by attribute code, e.g. size and color, "code=value" pairs separated by "|" in CSV files
+	Attributes map[string]any `json:"attributes,omitempty"`
+	// SKU of an existing variant selects the variant, new variants without SKU get a generated one
+	Sku     string `json:"sku,omitempty"`
+	Barcode string `json:"barcode,omitempty"`
+	Price   string `json:"price"`
+	// Stock on hand, the difference to the current stock is recorded in the stock ledger
+	Stock  *int32 `json:"stock,omitempty"`
+	Weight *int32 `json:"weight,omitempty"`
+	// Image URLs or paths. Images already uploaded to the shop are matched by their URL.
+	// Empty list keeps the images of existing variants
+	Images []string `json:"images,omitempty"`
+}
+
+type CatalogImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// CatalogImportReport describes the changes of an import. For dry runs these are the changes
+// that would be made
+type CatalogImportReport struct {
+	DryRun          bool                 `json:"dry_run"`
+	Rows            int                  `json:"rows"`
+	ProductsCreated int                  `json:"products_created"`
+	ProductsUpdated int                  `json:"products_updated"`
+	VariantsCreated int                  `json:"variants_created"`
+	VariantsUpdated int                  `json:"variants_updated"`
+	Errors          []CatalogImportError `json:"errors"`
+}
+
+type CatalogImportOptions struct {
+	// Employee recorded for created products and stock changes, 0 for none
+	EmployeeId int64
+	// Only validates the rows and reports the changes
+	DryRun bool
+	// Allows images from the local disk, only trusted callers like CLI commands should allow it
+	AllowLocalFiles bool
+}
+
+// CatalogImageLoader downloads or reads an image referenced by an import row and stores it in
+// the files directory. The returned file is not saved to the database yet
+type CatalogImageLoader func(ctx context.Context, ref string) (*FileEntity, error)
+
+type CatalogStore struct {
+	db        *DatabaseConnection
+	Products  *ProductEntityStore
+	LoadImage CatalogImageLoader
+	// Number of products saved in one transaction
+	BatchSize int
+}
+
+// catalogProduct is a validated product of an import, nil fields keep the values of existing products
+type catalogProduct struct {
+	line     int
+	id       *int64
+	patch    productPatch
+	variants []*catalogVariant
+}
+
+type catalogVariant struct {
+	line int
+	id   *int64
+	// Attribute values of the variant, see catalogVariantKey
+	key        string
+	attributes map[string]any
+	resolved   []attributeValue
+	patch      variantPatch
+	images     []string
+}
+
+// Names of categories, the attributes and the existing products used to validate an import
+type catalogLookup struct {
+	categories map[string]int64
+	attributes map[string]*AttributeEntity
+	// Category of every existing product, nil for products without category
+	productIds     map[int64]*int64
+	productsByName map[string][]int64
+	// Variant id by catalogVariantKey, a variant is defined by its attribute values
+	variants map[string]int64
+	// Product and catalogVariantKey without product of every existing variant
+	variantProducts map[int64]int64
+	variantKeys     map[int64]string
+	// Variant ids by SKU and barcode
+	skus     map[string]int64
+	barcodes map[string]int64
+	// Lines of the SKUs and barcodes of the imported rows
+	importedIdentifiers map[string]int
+	// Attribute sets by category id
+	categoryAttributes map[int64]map[int64]*CategoryAttributeEntity
+	// Ids of already uploaded images by their reference
+	files map[string]int64
+}
+
+func NewCatalogStore(database *DatabaseConnection, loadImage CatalogImageLoader) *CatalogStore {
+	return &CatalogStore{
+		db:        database,
+		Products:  NewProductEntityStore(database),
+		LoadImage: loadImage,
+		BatchSize: catalogImportBatchSize,
+	}
+}
+
+// Parses catalog rows from a CSV file with a header row, a JSON array or JSON lines
+func ParseCatalog(reader io.Reader, format string) ([]CatalogRow, error) {
+	switch format {
+	case "csv":
+		return parseCatalogCSV(reader)
+	case "jsonl":
+		rows := make([]CatalogRow, 0)
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			row := CatalogRow{}
+			decoder := json.NewDecoder(strings.NewReader(text))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&row); err != nil {
+				return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidCatalog, line, err)
+			}
+			row.Line = line
+			rows = append(rows, row)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidCatalog, err)
+		}
+		return rows, nil
+	case "json":
+		rows := make([]CatalogRow, 0)
+		decoder := json.NewDecoder(reader)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&rows); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidCatalog, err)
+		}
+		for i := range rows {
+			rows[i].Line = i + 1
+		}
+		return rows, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported format '%s'", ErrInvalidCatalog, format)
+	}
+}
+
+func parseCatalogCSV(reader io.Reader) ([]CatalogRow, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+
+	rows := make([]CatalogRow, 0)
+	header, err := csvReader.Read()
+	if errors.Is(err, io.EOF) {
+		return rows, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCatalog, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(catalogColumns, name) {
+			return nil, fmt.Errorf("%w: unknown column '%s'", ErrInvalidCatalog, name)
+		}
+		columns[name] = i
+	}
+
+	for {
+		record, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidCatalog, err)
+		}
+		line, _ := csvReader.FieldPos(0)
+		value := func(column string) string {
+			if i, exists := columns[column]; exists {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := CatalogRow{
+			Line:        line,
+			Name:        value("name"),
+			Description: value("description"),
+			Category:    value("category"),
+			BasePrice:   value("base_price"),
+			Sku:         value("sku"),
+			Barcode:     value("barcode"),
+			Price:       value("price"),
+		}
+		if value := value("product_id"); value != "" {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: invalid product_id '%s'", ErrInvalidCatalog, line, value)
+			}
+			row.ProductId = &id
+		}
+		if row.Stock, err = parseCatalogInt(value("stock")); err != nil {
+			return nil, fmt.Errorf("%w: line %d: invalid stock '%s'", ErrInvalidCatalog, line, value("stock"))
+		}
+		if row.Weight, err = parseCatalogInt(value("weight")); err != nil {
+			return nil, fmt.Errorf("%w: line %d: invalid weight '%s'", ErrInvalidCatalog, line, value("weight"))
+		}
+		for _, image := range strings.Split(value("images"), "|") {
+			if image = strings.TrimSpace(image); image != "" {
+				row.Images = append(row.Images, image)
+			}
+		}
+		for _, pair := range strings.Split(value("attributes"), "|") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			code, attributeValue, found := strings.Cut(pair, "=")
+			if !found {
+				return nil, fmt.Errorf("%w: line %d: invalid attribute '%s', expected code=value", ErrInvalidCatalog, line, pair)
+			}
+			if row.Attributes == nil {
+				row.Attributes = make(map[string]any)
+			}
+			row.Attributes[strings.TrimSpace(code)] = strings.TrimSpace(attributeValue)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseCatalogInt(value string) (*int32, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	result := int32(parsed)
+	return &result, nil
+}
+
+// Writes catalog rows in a format accepted by ParseCatalog
+func WriteCatalog(writer io.Writer, format string, rows []CatalogRow) error {
+	switch format {
+	case "csv":
+		csvWriter := csv.NewWriter(writer)
+		csvWriter.Write(catalogColumns)
+		for _, row := range rows {
+			productId, stock, weight := "", "", ""
+			if row.ProductId != nil {
+				productId = strconv.FormatInt(*row.ProductId, 10)
+			}
+			if row.Stock != nil {
+				stock = strconv.FormatInt(int64(*row.Stock), 10)
+			}
+			if row.Weight != nil {
+				weight = strconv.FormatInt(int64(*row.Weight), 10)
+			}
+			csvWriter.Write([]string{
+				productId, row.Name, row.Description, row.Category, row.BasePrice,
+				formatCatalogAttributes(row.Attributes), row.Sku, row.Barcode, row.Price, stock, weight,
+				strings.Join(row.Images, "|"),
+			})
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	case "jsonl":
+		encoder := json.NewEncoder(writer)
+		for _, row := range rows {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		return json.NewEncoder(writer).Encode(rows)
+	default:
+		return fmt.Errorf("%w: unsupported format '%s'", ErrInvalidCatalog, format)
+	}
+}
+
+// Formats attribute values as "code=value" pairs sorted by code
+func formatCatalogAttributes(attributes map[string]any) string {
+	pairs := make([]string, 0, len(attributes))
+	for code, value := range attributes {
+		text := fmt.Sprint(value)
+		if number, isNumber := value.(float64); isNumber {
+			text = strconv.FormatFloat(number, 'f', -1, 64)
+		}
+		pairs = append(pairs, code+"="+text)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "|")
+}
+
+// Returns every product variant as a catalog row with the physical stock and prices in the default currency.
+// Importing the rows back does not change the catalog
+func (s *CatalogStore) Export(ctx context.Context) ([]CatalogRow, error) {
+	rows, err := s.db.Connection.Query(ctx, `
+		select "product_variants".id, "products".id, "products".name, coalesce("products".description, ''),
+			coalesce("categories".name, ''), "products".base_price,
+			"product_variants".sku, coalesce("product_variants".barcode, ''), "product_variants".price, "product_variants".stock, "product_variants".weight,
+			coalesce(array_agg("files".path order by "product_variant_images".id) filter (where "files".id is not null), '{}')
+		from "products"
+		join "product_variants" on "product_variants".product_id = "products".id
+		left join "categories" on "categories".id = "products".category_id
+		left join "product_variant_images" on "product_variant_images".product_variant_id = "product_variants".id
+		left join "files" on "files".id = "product_variant_images".file_id
+		group by "products".id, "categories".name, "product_variants".id
+		order by "products".id, "product_variants".id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export catalog: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]CatalogRow, 0)
+	variantIds := make([]int64, 0)
+	for rows.Next() {
+		var row CatalogRow
+		var variantId, productId int64
+		var stock, weight int32
+		var basePrice, price money.Money
+		var paths []string
+		err := rows.Scan(
+			&variantId, &productId, &row.Name, &row.Description, &row.Category, &basePrice,
+			&row.Sku, &row.Barcode, &price, &stock, &weight, &paths,
+		)
+		if err != nil {
+			return nil, err
+		}
+		row.ProductId = &productId
+		row.BasePrice = basePrice.Decimal()
+		row.Price = price.Decimal()
+		row.Stock = &stock
+		row.Weight = &weight
+		for _, imagePath := range paths {
+			row.Images = append(row.Images, getImageURLFromPath(imagePath))
+		}
+		result = append(result, row)
+		variantIds = append(variantIds, variantId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	attributes, err := getVariantAttributes(ctx, s.db.Connection, variantIds, nil)
+	if err != nil {
+		return nil, err
+	}
+	for i, variantId := range variantIds {
+		for _, attribute := range attributes[variantId] {
+			if result[i].Attributes == nil {
+				result[i].Attributes = make(map[string]any)
+			}
+			result[i].Attributes[attribute.Code] = attribute.Value
+		}
+	}
+	return result, nil
+}
+
+// Validates all rows and creates or updates the products and their variants in batches.
+// Nothing is saved if any row is invalid, the report lists the errors of all rows.
+// Variants are matched by product and attribute values, variants missing in the rows are kept
+func (s *CatalogStore) Import(ctx context.Context, rows []CatalogRow, opts *CatalogImportOptions) (*CatalogImportReport, error) {
+	report := &CatalogImportReport{
+		DryRun: opts.DryRun,
+		Rows:   len(rows),
+		Errors: make([]CatalogImportError, 0),
+	}
+
+	lookup, err := s.getLookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	products, err := s.plan(ctx, rows, opts, lookup, report)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Errors) > 0 || opts.DryRun {
+		return report, nil
+	}
+
+	// Images are loaded before any product is saved, loaded files that are not saved are removed
+	loaded := make(map[string]*FileEntity)
+	defer func() {
+		for _, file := range loaded {
+			os.Remove(file.Path)
+		}
+	}()
+	for _, product := range products {
+		for _, variant := range product.variants {
+			for _, ref := range variant.images {
+				if _, exists := lookup.files[ref]; exists || loaded[ref] != nil {
+					continue
+				}
+				file, err := s.LoadImage(ctx, ref)
+				if err != nil {
+					report.Errors = append(report.Errors, CatalogImportError{Line: variant.line, Message: fmt.Sprintf("cannot load image '%s': %s", ref, err.Error())})
+					continue
+				}
+				loaded[ref] = file
+			}
+		}
+	}
+	if len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	imported := &CatalogImportReport{Rows: len(rows), Errors: report.Errors}
+	for start := 0; start < len(products); start += s.BatchSize {
+		batch := products[start:min(start+s.BatchSize, len(products))]
+		if err := s.importBatch(ctx, batch, opts.EmployeeId, lookup, loaded); err != nil {
+			return imported, fmt.Errorf("import stopped after %d of %d products: %w", start, len(products), err)
+		}
+		countCatalogChanges(imported, batch)
+	}
+	return imported, nil
+}
+
+func (s *CatalogStore) getLookup(ctx context.Context) (*catalogLookup, error) {
+	lookup := &catalogLookup{
+		productIds:          make(map[int64]*int64),
+		productsByName:      make(map[string][]int64),
+		variants:            make(map[string]int64),
+		variantProducts:     make(map[int64]int64),
+		variantKeys:         make(map[int64]string),
+		skus:                make(map[string]int64),
+		barcodes:            make(map[string]int64),
+		importedIdentifiers: make(map[string]int),
+		categoryAttributes:  make(map[int64]map[int64]*CategoryAttributeEntity),
+		files:               make(map[string]int64),
+	}
+
+	var err error
+	if lookup.categories, err = s.getNames(ctx, "categories"); err != nil {
+		return nil, err
+	}
+	if lookup.attributes, err = getAttributeDefinitions(ctx, s.db.Connection); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Connection.Query(ctx, `select id, name, category_id from "products"`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get products: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		var categoryId *int64
+		if err := rows.Scan(&id, &name, &categoryId); err != nil {
+			return nil, err
+		}
+		lookup.productIds[id] = categoryId
+		lookup.productsByName[strings.ToLower(name)] = append(lookup.productsByName[strings.ToLower(name)], id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Connection.Query(ctx, `
+		select id, product_id, dimension_key, sku, barcode
+		from "product_variants"`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product variants: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, productId int64
+		var dimensionKey, sku string
+		var barcode *string
+		if err := rows.Scan(&id, &productId, &dimensionKey, &sku, &barcode); err != nil {
+			return nil, err
+		}
+		lookup.variants[catalogVariantKey(productId, dimensionKey)] = id
+		lookup.variantProducts[id] = productId
+		lookup.variantKeys[id] = catalogVariantKey(0, dimensionKey)
+		lookup.skus[sku] = id
+		if barcode != nil {
+			lookup.barcodes[*barcode] = id
+		}
+	}
+	return lookup, rows.Err()
+}
+
+// Returns ids of the table rows by lowercase name
+func (s *CatalogStore) getNames(ctx context.Context, table string) (map[string]int64, error) {
+	rows, err := s.db.Connection.Query(ctx, fmt.Sprintf(`select id, name from %q`, table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]int64)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[strings.ToLower(name)] = id
+	}
+	return names, rows.Err()
+}
+
+// Groups the rows by product and resolves names to ids. Invalid rows are added to the report errors
+func (s *CatalogStore) plan(ctx context.Context, rows []CatalogRow, opts *CatalogImportOptions, lookup *catalogLookup, report *CatalogImportReport) ([]*catalogProduct, error) {
+	products := make([]*catalogProduct, 0)
+	productsByKey := make(map[string]*catalogProduct)
+	for _, row := range rows {
+		var productId *int64
+		switch {
+		case row.ProductId != nil:
+			if _, exists := lookup.productIds[*row.ProductId]; !exists {
+				report.Errors = append(report.Errors, CatalogImportError{Line: row.Line, Message: fmt.Sprintf("product with id '%d' not found", *row.ProductId)})
+				continue
+			}
+			productId = row.ProductId
+		case row.Name == "":
+			variantId, exists := lookup.skus[strings.ToUpper(strings.TrimSpace(row.Sku))]
+			if !exists {
+				report.Errors = append(report.Errors, CatalogImportError{Line: row.Line, Message: "name, product_id or the sku of an existing variant is required"})
+				continue
+			}
+			productId = new(int64)
+			*productId = lookup.variantProducts[variantId]
+		default:
+			matches := lookup.productsByName[strings.ToLower(row.Name)]
+			if len(matches) > 1 {
+				report.Errors = append(report.Errors, CatalogImportError{Line: row.Line, Message: fmt.Sprintf("there are %d products named '%s', product_id is required", len(matches), row.Name)})
+				continue
+			}
+			if len(matches) == 1 {
+				productId = &matches[0]
+			}
+		}
+
+		key := "name:" + strings.ToLower(row.Name)
+		if productId != nil {
+			key = "id:" + strconv.FormatInt(*productId, 10)
+		}
+		product, exists := productsByKey[key]
+		if !exists {
+			product = &catalogProduct{line: row.Line, id: productId}
+			productsByKey[key] = product
+			products = append(products, product)
+		}
+
+		if err := s.planRow(ctx, product, row, opts, lookup); err != nil {
+			if !errors.Is(err, ErrInvalidCatalog) {
+				return nil, err
+			}
+			report.Errors = append(report.Errors, CatalogImportError{Line: row.Line, Message: strings.TrimPrefix(err.Error(), ErrInvalidCatalog.Error()+": ")})
+		}
+	}
+
+	for _, product := range products {
+		if err := s.checkRequiredAttributes(ctx, product, lookup, report); err != nil {
+			return nil, err
+		}
+		if product.id != nil {
+			continue
+		}
+		if product.patch.CategoryId == nil {
+			report.Errors = append(report.Errors, CatalogImportError{Line: product.line, Message: "category is required for new products"})
+		}
+		if product.patch.BasePrice == nil {
+			report.Errors = append(report.Errors, CatalogImportError{Line: product.line, Message: "base_price is required for new products"})
+		}
+	}
+
+	countCatalogChanges(report, products)
+	return products, nil
+}
+
+// Merges the row into the product. Product fields may be repeated on every row of the product,
+// but must have the same values
+func (s *CatalogStore) planRow(ctx context.Context, product *catalogProduct, row CatalogRow, opts *CatalogImportOptions, lookup *catalogLookup) error {
+	merge := func(field **string, column string, value string) error {
+		if value == "" {
+			return nil
+		}
+		if *field != nil && **field != value {
+			return fmt.Errorf("%w: %s differs from the value on line %d", ErrInvalidCatalog, column, product.line)
+		}
+		*field = &value
+		return nil
+	}
+	if err := merge(&product.patch.Name, "name", row.Name); err != nil {
+		return err
+	}
+	if err := merge(&product.patch.Description, "description", row.Description); err != nil {
+		return err
+	}
+	if row.Category != "" {
+		categoryId, exists := lookup.categories[strings.ToLower(row.Category)]
+		if !exists {
+			return fmt.Errorf("%w: category '%s' not found", ErrInvalidCatalog, row.Category)
+		}
+		if product.patch.CategoryId != nil && *product.patch.CategoryId != categoryId {
+			return fmt.Errorf("%w: category differs from the value on line %d", ErrInvalidCatalog, product.line)
+		}
+		product.patch.CategoryId = &categoryId
+	}
+	if row.BasePrice != "" {
+		basePrice, err := parseCatalogPrice(row.BasePrice)
+		if err != nil {
+			return fmt.Errorf("%w: invalid base_price: %w", ErrInvalidCatalog, err)
+		}
+		if product.patch.BasePrice != nil && *product.patch.BasePrice != basePrice {
+			return fmt.Errorf("%w: base_price differs from the value on line %d", ErrInvalidCatalog, product.line)
+		}
+		product.patch.BasePrice = &basePrice
+	}
+
+	variant := &catalogVariant{line: row.Line, attributes: row.Attributes, images: row.Images}
+	resolved, err := resolveAttributeValues(lookup.attributes, row.Attributes)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCatalog, err)
+	}
+	variant.resolved = resolved
+
+	variant.key = catalogVariantKey(0, attributeDimensionKey(resolved))
+	if product.id != nil {
+		key := catalogVariantKey(*product.id, attributeDimensionKey(resolved))
+		if variantId, exists := lookup.variants[key]; exists {
+			variant.id = &variantId
+		}
+	}
+	if err := planIdentifiers(product, variant, row, lookup); err != nil {
+		return err
+	}
+	for _, other := range product.variants {
+		if other.key == variant.key {
+			return fmt.Errorf("%w: variant with the same attributes is already on line %d", ErrInvalidCatalog, other.line)
+		}
+	}
+	if row.Price != "" {
+		price, err := parseCatalogPrice(row.Price)
+		if err != nil {
+			return fmt.Errorf("%w: invalid price: %w", ErrInvalidCatalog, err)
+		}
+		variant.patch.Price = &price
+	} else if variant.id == nil {
+		return fmt.Errorf("%w: price is required for new variants", ErrInvalidCatalog)
+	}
+	if row.Stock != nil && *row.Stock < 0 {
+		return fmt.Errorf("%w: stock must not be negative", ErrInvalidCatalog)
+	}
+	if row.Weight != nil && *row.Weight < 0 {
+		return fmt.Errorf("%w: weight must not be negative", ErrInvalidCatalog)
+	}
+	variant.patch.Stock = row.Stock
+	variant.patch.Weight = row.Weight
+	variant.patch.Reason = "Catalog import"
+
+	for _, ref := range row.Images {
+		if err := s.checkImage(ctx, ref, opts, lookup); err != nil {
+			return err
+		}
+	}
+
+	product.variants = append(product.variants, variant)
+	return nil
+}
+
+// Validates the SKU and the barcode of the row. A row with only the SKU of an existing variant
+// updates that variant, otherwise the SKU must not be used by other variants
+func planIdentifiers(product *catalogProduct, variant *catalogVariant, row CatalogRow, lookup *catalogLookup) error {
+	if row.Sku != "" {
+		sku, err := normalizeSku(row.Sku)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidCatalog, err)
+		}
+		if line, exists := lookup.importedIdentifiers["sku:"+sku]; exists {
+			return fmt.Errorf("%w: sku '%s' is already used on line %d", ErrInvalidCatalog, sku, line)
+		}
+		lookup.importedIdentifiers["sku:"+sku] = row.Line
+
+		if variantId, exists := lookup.skus[sku]; exists {
+			if product.id == nil || lookup.variantProducts[variantId] != *product.id {
+				return fmt.Errorf("%w: sku '%s' is used by another product", ErrInvalidCatalog, sku)
+			}
+			withoutDimensions := len(row.Attributes) == 0
+			if withoutDimensions {
+				variant.id = &variantId
+				variant.key = lookup.variantKeys[variantId]
+			}
+			if variant.id == nil || *variant.id != variantId {
+				return fmt.Errorf("%w: sku '%s' is used by a variant with other attributes", ErrInvalidCatalog, sku)
+			}
+		}
+		variant.patch.Sku = &sku
+	}
+
+	if row.Barcode != "" {
+		if err := checkGTIN(row.Barcode); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidCatalog, err)
+		}
+		if line, exists := lookup.importedIdentifiers["barcode:"+row.Barcode]; exists {
+			return fmt.Errorf("%w: barcode '%s' is already used on line %d", ErrInvalidCatalog, row.Barcode, line)
+		}
+		lookup.importedIdentifiers["barcode:"+row.Barcode] = row.Line
+
+		if variantId, exists := lookup.barcodes[row.Barcode]; exists && (variant.id == nil || *variant.id != variantId) {
+			return fmt.Errorf("%w: barcode '%s' is used by another variant", ErrInvalidCatalog, row.Barcode)
+		}
+		variant.patch.Barcode = &row.Barcode
+	}
+	return nil
+}
+
+// Checks that the new variants have the required attributes of the product category
+func (s *CatalogStore) checkRequiredAttributes(ctx context.Context, product *catalogProduct, lookup *catalogLookup, report *CatalogImportReport) error {
+	categoryId := product.patch.CategoryId
+	if categoryId == nil && product.id != nil {
+		categoryId = lookup.productIds[*product.id]
+	}
+	if categoryId == nil {
+		return nil
+	}
+
+	set, exists := lookup.categoryAttributes[*categoryId]
+	if !exists {
+		var err error
+		if set, err = getCategoryAttributeSet(ctx, s.db.Connection, *categoryId); err != nil {
+			return err
+		}
+		lookup.categoryAttributes[*categoryId] = set
+	}
+	for _, variant := range product.variants {
+		if variant.id != nil {
+			continue
+		}
+		if err := checkRequiredAttributes(set, lookup.attributes, variant.resolved); err != nil {
+			report.Errors = append(report.Errors, CatalogImportError{Line: variant.line, Message: err.Error()})
+		}
+	}
+	return nil
+}
+
+// Returns the identity of a variant, product id 0 compares variants of the same product
+func catalogVariantKey(productId int64, dimensionKey string) string {
+	return fmt.Sprintf("%d/%s", productId, dimensionKey)
+}
+
+func parseCatalogPrice(value string) (money.Money, error) {
+	price, err := money.Parse(value, money.DefaultCurrency())
+	if err != nil {
+		return money.Money{}, err
+	}
+	if price.Amount < 0 {
+		return money.Money{}, errors.New("price must not be negative")
+	}
+	return price, nil
+}
+
+// Checks that the image is an uploaded file, a remote URL or an allowed local file
+func (s *CatalogStore) checkImage(ctx context.Context, ref string, opts *CatalogImportOptions, lookup *catalogLookup) error {
+	if _, exists := lookup.files[ref]; exists {
+		return nil
+	}
+
+	filePath, isShopURL := catalogImagePath(ref)
+	var fileId int64
+	err := s.db.Connection.QueryRow(ctx, `select id from "files" where path = $1 order by id limit 1`, filePath).Scan(&fileId)
+	if err == nil {
+		lookup.files[ref] = fileId
+		return nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to get file: %w", err)
+	}
+	if isShopURL {
+		return fmt.Errorf("%w: image '%s' not found", ErrInvalidCatalog, ref)
+	}
+
+	if parsed, err := url.Parse(ref); err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "" {
+		return nil
+	}
+	if !opts.AllowLocalFiles {
+		return fmt.Errorf("%w: image '%s' must be an http or https URL", ErrInvalidCatalog, ref)
+	}
+	if info, err := os.Stat(ref); err != nil || info.IsDir() {
+		return fmt.Errorf("%w: image file '%s' not found", ErrInvalidCatalog, ref)
+	}
+	return nil
+}
+
+// Returns the file path of an image URL of the shop, other references are returned as is
+func catalogImagePath(ref string) (string, bool) {
+	for _, prefix := range []string{getImageURLFromPath("") + "/", config.AppConfig.ServerURL + "/"} {
+		if strings.HasPrefix(ref, prefix) {
+			return strings.TrimPrefix(ref, prefix), true
+		}
+	}
+	return ref, false
+}
+
+func (s *CatalogStore) importBatch(ctx context.Context, batch []*catalogProduct, employeeId int64, lookup *catalogLookup, loaded map[string]*FileEntity) error {
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Files saved in this batch become usable for the next batches only after the commit
+	saved := make(map[string]int64)
+	fileIds := func(refs []string) ([]int64, error) {
+		ids := make([]int64, 0, len(refs))
+		for _, ref := range refs {
+			if id, exists := lookup.files[ref]; exists {
+				ids = append(ids, id)
+				continue
+			}
+			if id, exists := saved[ref]; exists {
+				ids = append(ids, id)
+				continue
+			}
+			file, err := NewFileEntityStore(s.db).txCreate(ctx, tx, *loaded[ref])
+			if err != nil {
+				return nil, err
+			}
+			saved[ref] = file.Id
+			ids = append(ids, file.Id)
+		}
+		return ids, nil
+	}
+
+	for _, product := range batch {
+		productId := int64(0)
+		if product.id == nil {
+			description := ""
+			if product.patch.Description != nil {
+				description = *product.patch.Description
+			}
+			productId, err = s.Products.createBaseProduct(ctx, tx, &ProductCreateUpdate{
+				Name:        *product.patch.Name,
+				Description: description,
+				CategoryId:  *product.patch.CategoryId,
+				EmployeeId:  employeeId,
+				BasePrice:   *product.patch.BasePrice,
+				// Imported products are visible right away
+				Status: ProductStatusPublished,
+			})
+		} else {
+			productId = *product.id
+			err = s.Products.txPatchProduct(ctx, tx, productId, &product.patch)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", product.line, err)
+		}
+
+		for _, variant := range product.variants {
+			ids, err := fileIds(variant.images)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", variant.line, err)
+			}
+
+			if variant.id == nil {
+				create := &ProductVariantCreateUpdate{
+					FileIds:    ids,
+					Price:      *variant.patch.Price,
+					Attributes: variant.attributes,
+				}
+				if variant.patch.Stock != nil {
+					create.Stock = *variant.patch.Stock
+				}
+				if variant.patch.Weight != nil {
+					create.Weight = *variant.patch.Weight
+				}
+				if variant.patch.Sku != nil {
+					create.Sku = *variant.patch.Sku
+				}
+				if variant.patch.Barcode != nil {
+					create.Barcode = *variant.patch.Barcode
+				}
+				_, err = s.Products.addProductVariant(ctx, tx, productId, employeeId, create)
+			} else {
+				if len(variant.images) > 0 {
+					variant.patch.FileIds = ids
+				}
+				err = s.Products.txPatchVariant(ctx, tx, *variant.id, employeeId, &variant.patch)
+			}
+			if err != nil {
+				return fmt.Errorf("line %d: %w", variant.line, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	for ref, id := range saved {
+		lookup.files[ref] = id
+		delete(loaded, ref)
+	}
+	return nil
+}
+
+func countCatalogChanges(report *CatalogImportReport, products []*catalogProduct) {
+	for _, product := range products {
+		if product.id == nil {
+			report.ProductsCreated++
+		} else {
+			report.ProductsUpdated++
+		}
+		for _, variant := range product.variants {
+			if variant.id == nil {
+				report.VariantsCreated++
+			} else {
+				report.VariantsUpdated++
+			}
+		}
+	}
+}
@@ -1,15 +1,57 @@
 package db
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrCategoryExists   = errors.New("category with the same name or slug already exists")
+	ErrCategoryInUse    = errors.New("category has subcategories or products")
+	ErrInvalidCategory  = errors.New("invalid category")
+)
+
 // CategoryEntity represents a category of products in the database
 type CategoryEntity struct {
-	Id   int64  `json:"id"`
+	Id          int64  `json:"id"`
+	Name        string `json:"name"`
+	Slug        string `json:"slug,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Nil for root categories
+	ParentId *int64 `json:"parent_id,omitempty"`
+	// Position among the sibling categories, lower values first
+	SortOrder int32 `json:"sort_order,omitempty"`
+}
+
+// CategoryTreeNode is a category with its subcategories
+type CategoryTreeNode struct {
+	CategoryEntity
+	// Number of products in the category and all its subcategories
+	ProductCount int64               `json:"product_count"`
+	Children     []*CategoryTreeNode `json:"children"`
+}
+
+type CategoryCreateUpdate struct {
 	Name string `json:"name"`
+	// Generated from the name when empty
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	ParentId    *int64 `json:"parent_id"`
+	SortOrder   int32  `json:"sort_order"`
 }
 
 type CategoryEntityStore struct {
 	db *DatabaseConnection
 }
 
+const categorySelectSQL = `select "id", "name", "slug", coalesce("description", ''), "parent_id", "sort_order" from "categories"`
+
 func NewCategoryEntityStore(database *DatabaseConnection) *CategoryEntityStore {
 	return &CategoryEntityStore{
 		db: database,
@@ -26,19 +68,35 @@ func (c *CategoryEntityStore) CheckCategoryExists(id int64) (bool, error) {
 	return exists, nil
 }
 
-func (c *CategoryEntityStore) GetCategoryById(id int64) (CategoryEntity, error) {
-	row := c.db.Connection.QueryRow(c.db.Context, `select "id", "name" from "categories" where id = $1`, id)
-	var category CategoryEntity
-	err := row.Scan(&category.Id, &category.Name)
+// Texts of the categories are returned in the languages of the fallback chain, the base texts
+// are returned for an empty chain
+func (c *CategoryEntityStore) GetCategoryById(id int64, languages []string) (CategoryEntity, error) {
+	return c.getCategory(c.db.Context, languages, `where id = $1`, id)
+}
+
+func (c *CategoryEntityStore) GetCategoryBySlug(slug string, languages []string) (CategoryEntity, error) {
+	return c.getCategory(c.db.Context, languages, `where slug = $1`, strings.ToLower(slug))
+}
+
+func (c *CategoryEntityStore) getCategory(ctx context.Context, languages []string, where string, args ...any) (CategoryEntity, error) {
+	row := c.db.Connection.QueryRow(ctx, categorySelectSQL+" "+where, args...)
+	category, err := scanCategory(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return CategoryEntity{}, ErrCategoryNotFound
+	}
 	if err != nil {
 		return CategoryEntity{}, err
 	}
-	return category, nil
+	categories := []CategoryEntity{category}
+	if err := localizeCategories(ctx, c.db.Connection, categories, languages); err != nil {
+		return CategoryEntity{}, err
+	}
+	return categories[0], nil
 }
 
-func (c *CategoryEntityStore) GetCategories() ([]CategoryEntity, error) {
-	query := `select "id", "name" from "categories"`
-	rows, err := c.db.Connection.Query(c.db.Context, query)
+// Returns all categories as a flat list ordered by the sort order
+func (c *CategoryEntityStore) GetCategories(languages []string) ([]CategoryEntity, error) {
+	rows, err := c.db.Connection.Query(c.db.Context, categorySelectSQL+` order by "sort_order", "name"`)
 	if err != nil {
 		return nil, err
 	}
@@ -46,13 +104,332 @@ func (c *CategoryEntityStore) GetCategories() ([]CategoryEntity, error) {
 
 	categories := make([]CategoryEntity, 0)
 	for rows.Next() {
-		var category CategoryEntity
-		err := rows.Scan(&category.Id, &category.Name)
+		category, err := scanCategory(rows)
 		if err != nil {
 			return nil, err
 		}
 		categories = append(categories, category)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categories, localizeCategories(c.db.Context, c.db.Connection, categories, languages)
+}
+
+// Returns the root categories with their subcategories and product counts
+func (c *CategoryEntityStore) GetTree(ctx context.Context, languages []string) ([]*CategoryTreeNode, error) {
+	categories, err := c.GetCategories(languages)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int64]int64)
+	rows, err := c.db.Connection.Query(ctx, `
+		select category_id, count(*) from "products"
+		where category_id is not null and `+publishedProductSQL+`
+		group by category_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count products: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var categoryId, count int64
+		if err := rows.Scan(&categoryId, &count); err != nil {
+			return nil, err
+		}
+		counts[categoryId] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[int64]*CategoryTreeNode, len(categories))
+	for _, category := range categories {
+		nodes[category.Id] = &CategoryTreeNode{
+			CategoryEntity: category,
+			ProductCount:   counts[category.Id],
+			Children:       make([]*CategoryTreeNode, 0),
+		}
+	}
+
+	// Categories are sorted, so the children keep the sort order
+	roots := make([]*CategoryTreeNode, 0)
+	for _, category := range categories {
+		node := nodes[category.Id]
+		if parent, exists := nodes[derefCategoryId(category.ParentId)]; exists {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	for _, root := range roots {
+		sumCategoryProducts(root)
+	}
+	return roots, nil
+}
+
+func sumCategoryProducts(node *CategoryTreeNode) int64 {
+	for _, child := range node.Children {
+		node.ProductCount += sumCategoryProducts(child)
+	}
+	return node.ProductCount
+}
+
+// Returns the path from the root category to the given category
+func (c *CategoryEntityStore) GetBreadcrumbs(ctx context.Context, id int64, languages []string) ([]CategoryEntity, error) {
+	rows, err := c.db.Connection.Query(ctx, `
+		with recursive ancestors as (
+			select "categories".*, 0 as depth from "categories" where id = $1
+			union all
+			select "categories".*, ancestors.depth + 1 from "categories"
+			join ancestors on ancestors.parent_id = "categories".id
+		)
+		select id, name, slug, coalesce(description, ''), parent_id, sort_order
+		from ancestors
+		order by depth desc`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get category path: %w", err)
+	}
+	defer rows.Close()
+
+	categories := make([]CategoryEntity, 0)
+	for rows.Next() {
+		category, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(categories) == 0 {
+		return nil, ErrCategoryNotFound
+	}
+	return categories, localizeCategories(ctx, c.db.Connection, categories, languages)
+}
+
+func (c *CategoryEntityStore) Create(ctx context.Context, opts *CategoryCreateUpdate) (*CategoryEntity, error) {
+	if err := normalizeCategory(opts); err != nil {
+		return nil, err
+	}
+
+	tx, err := c.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if opts.ParentId != nil {
+		if err := c.checkParent(ctx, tx, 0, *opts.ParentId); err != nil {
+			return nil, err
+		}
+	}
+
+	category := &CategoryEntity{
+		Name:        opts.Name,
+		Slug:        opts.Slug,
+		Description: opts.Description,
+		ParentId:    opts.ParentId,
+		SortOrder:   opts.SortOrder,
+	}
+	err = tx.QueryRow(ctx, `
+		insert into "categories" (name, slug, description, parent_id, sort_order)
+		values ($1, $2, $3, $4, $5)
+		returning id`, opts.Name, opts.Slug, opts.Description, opts.ParentId, opts.SortOrder,
+	).Scan(&category.Id)
+	if err != nil {
+		return nil, categoryWriteError(err)
+	}
+
+	return category, tx.Commit(ctx)
+}
+
+// Updates the category. Moving a category under itself or its descendants is rejected
+func (c *CategoryEntityStore) Update(ctx context.Context, id int64, opts *CategoryCreateUpdate) (*CategoryEntity, error) {
+	if err := normalizeCategory(opts); err != nil {
+		return nil, err
+	}
+
+	tx, err := c.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
 
-	return categories, nil
+	// Concurrent moves could create a cycle that neither of them sees, plain reads are not blocked
+	if _, err := tx.Exec(ctx, `lock table "categories" in exclusive mode`); err != nil {
+		return nil, fmt.Errorf("failed to lock categories: %w", err)
+	}
+	if opts.ParentId != nil {
+		if err := c.checkParent(ctx, tx, id, *opts.ParentId); err != nil {
+			return nil, err
+		}
+	}
+
+	tag, err := tx.Exec(ctx, `
+		update "categories" set name = $2, slug = $3, description = $4, parent_id = $5, sort_order = $6
+		where id = $1`, id, opts.Name, opts.Slug, opts.Description, opts.ParentId, opts.SortOrder)
+	if err != nil {
+		return nil, categoryWriteError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrCategoryNotFound
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &CategoryEntity{
+		Id:          id,
+		Name:        opts.Name,
+		Slug:        opts.Slug,
+		Description: opts.Description,
+		ParentId:    opts.ParentId,
+		SortOrder:   opts.SortOrder,
+	}, nil
+}
+
+// Deletes a category without subcategories and products
+func (c *CategoryEntityStore) Delete(ctx context.Context, id int64) error {
+	var inUse bool
+	err := c.db.Connection.QueryRow(ctx, `
+		select exists(select 1 from "categories" where parent_id = $1)
+			or exists(select 1 from "products" where category_id = $1)`, id).Scan(&inUse)
+	if err != nil {
+		return fmt.Errorf("failed to check category usage: %w", err)
+	}
+	if inUse {
+		return ErrCategoryInUse
+	}
+
+	tag, err := c.db.Connection.Exec(ctx, `delete from "categories" where id = $1`, id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrCategoryInUse
+		}
+		return fmt.Errorf("failed to delete category: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrCategoryNotFound
+	}
+	return nil
+}
+
+// Checks that the parent exists and is not the category itself or one of its descendants
+func (c *CategoryEntityStore) checkParent(ctx context.Context, tx pgx.Tx, id int64, parentId int64) error {
+	var exists, isDescendant bool
+	err := tx.QueryRow(ctx, `
+		with recursive subtree as (
+			select id from "categories" where id = $1
+			union
+			select "categories".id from "categories" join subtree on "categories".parent_id = subtree.id
+		)
+		select exists(select 1 from "categories" where id = $2), exists(select 1 from subtree where id = $2)`,
+		id, parentId).Scan(&exists, &isDescendant)
+	if err != nil {
+		return fmt.Errorf("failed to check parent category: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: parent category with id '%d' not found", ErrInvalidCategory, parentId)
+	}
+	if isDescendant {
+		return fmt.Errorf("%w: category cannot be moved under itself or its subcategories", ErrInvalidCategory)
+	}
+	return nil
+}
+
+func normalizeCategory(opts *CategoryCreateUpdate) error {
+	opts.Name = strings.TrimSpace(opts.Name)
+	if opts.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidCategory)
+	}
+	if opts.Slug == "" {
+		opts.Slug = opts.Name
+	}
+	opts.Slug = slugify(opts.Slug)
+	if opts.Slug == "" {
+		return fmt.Errorf("%w: slug must contain latin letters or digits", ErrInvalidCategory)
+	}
+	return nil
+}
+
+func categoryWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrCategoryExists
+	}
+	return fmt.Errorf("failed to save category: %w", err)
+}
+
+// Replaces the names and descriptions of the categories with their translations
+func localizeCategories(ctx context.Context, q querier, categories []CategoryEntity, languages []string) error {
+	ids := make([]int64, 0, len(categories))
+	for _, category := range categories {
+		ids = append(ids, category.Id)
+	}
+	texts, err := newTranslationList(ctx, q, TranslationCategory, ids, languages)
+	if err != nil {
+		return err
+	}
+	for i := range categories {
+		categories[i].Name = texts.name(categories[i].Id, categories[i].Name)
+		categories[i].Description = texts.description(categories[i].Id, categories[i].Description)
+	}
+	return nil
+}
+
+func scanCategory(row pgx.Row) (CategoryEntity, error) {
+	var category CategoryEntity
+	err := row.Scan(&category.Id, &category.Name, &category.Slug, &category.Description, &category.ParentId, &category.SortOrder)
+	return category, err
+}
+
+func derefCategoryId(id *int64) int64 {
+	if id == nil {
+		return 0
+	}
+	return *id
+}
+
+// Returns a query of the ids of the categories and all their subcategories
+func categorySubtreeSQL(ids string) string {
+	return `with recursive subtree as (
+			select id from "categories" where id in (` + ids + `)
+			union
+			select "categories".id from "categories" join subtree on "categories".parent_id = subtree.id
+		) select id from subtree`
+}
+
+// Returns an expression of the array of the category id followed by the ids of its ancestors
+// up to the root category. The column holds the category id, null gives an empty array
+func categoryAncestorsSQL(column string) string {
+	return `array(
+		with recursive ancestors as (
+			select id, parent_id, 0 as depth from "categories" where id = ` + column + `
+			union all
+			select "categories".id, "categories".parent_id, ancestors.depth + 1 from "categories"
+			join ancestors on ancestors.parent_id = "categories".id
+		)
+		select id from ancestors order by depth)`
+}
+
+// Converts the text to a lowercase URL slug of latin letters, digits and dashes
+func slugify(text string) string {
+	slug := strings.Builder{}
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return slug.String()
 }
@@ -51,7 +51,7 @@ func (c *CustomerEntityStore) GetById(id int64) (result *CustomerEntity, err err
 	}
 	err = c.db.Connection.QueryRow(c.db.Context, `
 		select 
-			id, 
+			"customers".id, 
 			person_id,
 			person.first_name,
 			person.last_name,
@@ -66,7 +66,7 @@ func (c *CustomerEntityStore) GetById(id int64) (result *CustomerEntity, err err
 			is_verified
 		from "customers"
 		left join "person" on "person".id = "customers".person_id
-		where id = $1
+		where "customers".id = $1
 		`, id).Scan(
 		&result.Id,
 		&result.PersonId,
@@ -0,0 +1,256 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	DocumentTypeInvoice     = "invoice"
+	DocumentTypePackingSlip = "packing_slip"
+)
+
+var ErrDocumentNotAvailable = errors.New("document is not available for the order")
+
+type OrderDocumentEntity struct {
+	Id      int64  `json:"id"`
+	OrderId int64  `json:"order_id"`
+	Type    string `json:"type"`
+	// Formatted sequential number, empty for documents without numbering
+	Number   string      `json:"number"`
+	File     *FileEntity `json:"file"`
+	IssuedAt time.Time   `json:"issued_at"`
+}
+
+// OrderDocumentData is everything printed on an order document
+type OrderDocumentData struct {
+	Type     string
+	Number   string
+	IssuedAt time.Time
+	Order    *OrderEntity
+	Customer *CustomerEntity
+	// Product name with the attribute values, e.g. size and color, of every order item by the item id
+	ItemNames map[int64]string
+}
+
+// DocumentRenderer renders the document to PDF
+type DocumentRenderer func(data *OrderDocumentData) ([]byte, error)
+
+type DocumentStore struct {
+	db        *DatabaseConnection
+	Directory string
+	Render    DocumentRenderer
+}
+
+func NewDocumentStore(database *DatabaseConnection, directory string, render DocumentRenderer) *DocumentStore {
+	return &DocumentStore{
+		db:        database,
+		Directory: directory,
+		Render:    render,
+	}
+}
+
+// Returns the issued document of the order, the document is issued on the first request.
+// Invoice numbers are allocated in the same transaction as the document, so they have no gaps
+func (s *DocumentStore) GetOrIssue(ctx context.Context, orderId int64, docType string) (*OrderDocumentEntity, error) {
+	if document, err := s.get(ctx, s.db.Connection, orderId, docType); err == nil || !errors.Is(err, pgx.ErrNoRows) {
+		return document, err
+	}
+
+	orderStore := NewOrderEntity(s.db)
+	order, err := orderStore.GetById(orderId)
+	if err != nil {
+		return nil, err
+	}
+	switch order.Status {
+	case OrderStatusProcessing, OrderStatusShipped, OrderStatusDelivered:
+	default:
+		return nil, fmt.Errorf("%w: order is %s", ErrDocumentNotAvailable, order.Status)
+	}
+
+	customer, err := NewCustomerEntityStore(s.db).GetById(order.CustomerId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get customer: %w", err)
+	}
+	itemNames, err := s.getItemNames(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the order makes concurrent requests wait for the first issued document
+	if _, err := tx.Exec(ctx, `select id from "orders" where id = $1 for update`, orderId); err != nil {
+		return nil, fmt.Errorf("failed to lock order: %w", err)
+	}
+	if document, err := s.get(ctx, tx, orderId, docType); err == nil || !errors.Is(err, pgx.ErrNoRows) {
+		return document, err
+	}
+
+	var number *int32
+	if docType == DocumentTypeInvoice {
+		err = tx.QueryRow(ctx, `
+			update "document_sequences" set last_number = last_number + 1
+			where type = $1
+			returning last_number`, docType).Scan(&number)
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate document number: %w", err)
+		}
+	}
+
+	document := &OrderDocumentEntity{
+		OrderId:  orderId,
+		Type:     docType,
+		Number:   formatDocumentNumber(docType, number),
+		IssuedAt: time.Now().UTC(),
+	}
+	content, err := s.Render(&OrderDocumentData{
+		Type:      docType,
+		Number:    document.Number,
+		IssuedAt:  document.IssuedAt,
+		Order:     order,
+		Customer:  customer,
+		ItemNames: itemNames,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render document: %w", err)
+	}
+
+	path, err := s.writeFile(orderId, docType, content)
+	if err != nil {
+		return nil, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(path)
+		}
+	}()
+
+	document.File, err = NewFileEntityStore(s.db).txCreate(ctx, tx, FileEntity{
+		Filename:  filepath.Base(path),
+		Filetype:  "application/pdf",
+		Path:      path,
+		SizeBytes: len(content),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(ctx, `
+		insert into "order_documents" (order_id, type, number, file_id, issued_at)
+		values ($1, $2, $3, $4, $5)
+		returning id`, orderId, docType, number, document.File.Id, document.IssuedAt,
+	).Scan(&document.Id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create document: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	committed = true
+	return document, nil
+}
+
+func (s *DocumentStore) get(ctx context.Context, q querier, orderId int64, docType string) (*OrderDocumentEntity, error) {
+	document := &OrderDocumentEntity{File: &FileEntity{}}
+	var number *int32
+	err := q.QueryRow(ctx, `
+		select "order_documents".id, "order_documents".order_id, "order_documents".type, "order_documents".number,
+			"order_documents".issued_at, "files".id, "files".filename, "files".filetype, "files".path,
+			"files".size_bytes, "files".created_at
+		from "order_documents"
+		join "files" on "files".id = "order_documents".file_id
+		where "order_documents".order_id = $1 and "order_documents".type = $2`, orderId, docType,
+	).Scan(
+		&document.Id,
+		&document.OrderId,
+		&document.Type,
+		&number,
+		&document.IssuedAt,
+		&document.File.Id,
+		&document.File.Filename,
+		&document.File.Filetype,
+		&document.File.Path,
+		&document.File.SizeBytes,
+		&document.File.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	document.Number = formatDocumentNumber(docType, number)
+	return document, nil
+}
+
+func (s *DocumentStore) getItemNames(ctx context.Context, orderId int64) (map[int64]string, error) {
+	rows, err := s.db.Connection.Query(ctx, `
+		select "order_items".id,
+			concat_ws(', ', "products".name, `+variantAttributesTextSQL+`)
+		from "order_items"
+		left join "product_variants" on "product_variants".id = "order_items".product_variant_id
+		left join "products" on "products".id = "product_variants".product_id
+		where "order_items".order_id = $1`, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order item names: %w", err)
+	}
+	defer rows.Close()
+
+	names := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// Writes the document to a new file, the random suffix keeps file names unguessable
+func (s *DocumentStore) writeFile(orderId int64, docType string, content []byte) (string, error) {
+	if err := os.MkdirAll(s.Directory, 0755); err != nil {
+		return "", fmt.Errorf("failed to create documents directory: %w", err)
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.Directory, fmt.Sprintf("%s-%d-%s.pdf", docType, orderId, hex.EncodeToString(suffix)))
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to create document file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(content); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to write document file: %w", err)
+	}
+	return path, nil
+}
+
+func formatDocumentNumber(docType string, number *int32) string {
+	if number == nil {
+		return ""
+	}
+	if docType == DocumentTypeInvoice {
+		return fmt.Sprintf("INV-%06d", *number)
+	}
+	return fmt.Sprint(*number)
+}
@@ -35,3 +35,25 @@ func (e *EmployeeEntityStore) GetByUsername(username string) (EmployeeEntity, er
 	}
 	return employee, nil
 }
+
+// Returns email addresses of all employees that have a linked person
+func (e *EmployeeEntityStore) GetEmails() ([]string, error) {
+	rows, err := e.db.Connection.Query(e.db.Context, `
+		select "person"."email" from "employees"
+		join "person" on "person"."id" = "employees"."person_id"
+		where "person"."email" <> ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := make([]string, 0)
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, nil
+}
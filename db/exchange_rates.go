@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"netshop/main/tools/money"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrUnsupportedCurrency  = errors.New("unsupported currency")
+	ErrInvalidExchangeRates = errors.New("invalid exchange rates")
+)
+
+// ExchangeRateEntity is the amount of Currency for one unit of the default currency
+type ExchangeRateEntity struct {
+	Currency  string      `json:"currency"`
+	Rate      json.Number `json:"rate"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+type ExchangeRateStore struct {
+	db *DatabaseConnection
+}
+
+func NewExchangeRateStore(database *DatabaseConnection) *ExchangeRateStore {
+	return &ExchangeRateStore{
+		db: database,
+	}
+}
+
+func (s *ExchangeRateStore) GetAll() ([]ExchangeRateEntity, error) {
+	rows, err := s.db.Connection.Query(s.db.Context, `
+		select currency, rate::text, updated_at
+		from "exchange_rates"
+		order by currency`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make([]ExchangeRateEntity, 0)
+	for rows.Next() {
+		var rate ExchangeRateEntity
+		var value string
+		if err := rows.Scan(&rate.Currency, &value, &rate.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rate.Rate = json.Number(value)
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
+
+// Inserts or updates the given rates in a single transaction. Rates missing from the list are kept
+func (s *ExchangeRateStore) Save(ctx context.Context, rates []ExchangeRateEntity) error {
+	if err := validateExchangeRates(rates); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, rate := range rates {
+		_, err := tx.Exec(ctx, `
+			insert into "exchange_rates" (currency, rate)
+			values ($1, $2::text::decimal)
+			on conflict (currency) do update set rate = excluded.rate, updated_at = now()`,
+			rate.Currency, rate.Rate.String())
+		if err != nil {
+			return fmt.Errorf("failed to save exchange rate of '%s': %w", rate.Currency, err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Parses exchange rates from a CSV file with "currency,rate" rows (the header row is optional)
+// or from a JSON array of {"currency", "rate"} objects
+func ParseExchangeRates(reader io.Reader, format string) ([]ExchangeRateEntity, error) {
+	rates := make([]ExchangeRateEntity, 0)
+	switch format {
+	case "json":
+		if err := json.NewDecoder(reader).Decode(&rates); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidExchangeRates, err)
+		}
+	case "csv":
+		csvReader := csv.NewReader(reader)
+		csvReader.FieldsPerRecord = 2
+		csvReader.TrimLeadingSpace = true
+		records, err := csvReader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidExchangeRates, err)
+		}
+		for i, record := range records {
+			if i == 0 && strings.EqualFold(record[0], "currency") {
+				continue
+			}
+			rates = append(rates, ExchangeRateEntity{Currency: record[0], Rate: json.Number(record[1])})
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported format '%s'", ErrInvalidExchangeRates, format)
+	}
+
+	if err := validateExchangeRates(rates); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// Normalizes currency codes and checks that every rate is a positive decimal
+func validateExchangeRates(rates []ExchangeRateEntity) error {
+	for i := range rates {
+		rates[i].Currency = strings.ToUpper(strings.TrimSpace(rates[i].Currency))
+		if len(rates[i].Currency) != 3 {
+			return fmt.Errorf("%w: invalid currency code '%s'", ErrInvalidExchangeRates, rates[i].Currency)
+		}
+		if rates[i].Currency == money.DefaultCurrency() {
+			return fmt.Errorf("%w: rate of the default currency is always 1", ErrInvalidExchangeRates)
+		}
+		if _, err := money.ParseRate(rates[i].Rate.String()); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidExchangeRates, err)
+		}
+	}
+	return nil
+}
+
+// Returns the rate of the currency relative to the default currency
+func getExchangeRate(ctx context.Context, q querier, currency string) (*big.Rat, error) {
+	if currency == money.DefaultCurrency() {
+		return big.NewRat(1, 1), nil
+	}
+
+	var value string
+	err := q.QueryRow(ctx, `select rate::text from "exchange_rates" where currency = $1`, currency).Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedCurrency, currency)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exchange rate: %w", err)
+	}
+	return money.ParseRate(value)
+}
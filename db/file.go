@@ -46,7 +46,16 @@ func (c *FileEntityStore) Create(ctx context.Context, file FileEntity) (result *
 	}
 	defer tx.Rollback(ctx)
 
-	result = &FileEntity{
+	result, err = c.txCreate(ctx, tx, file)
+	if err != nil {
+		return result, err
+	}
+
+	return result, tx.Commit(ctx)
+}
+
+func (c *FileEntityStore) txCreate(ctx context.Context, tx pgx.Tx, file FileEntity) (*FileEntity, error) {
+	result := &FileEntity{
 		Filename:  file.Filename,
 		Filetype:  file.Filetype,
 		Path:      file.Path,
@@ -55,13 +64,13 @@ func (c *FileEntityStore) Create(ctx context.Context, file FileEntity) (result *
 		Height:    file.Height,
 	}
 
-	err = tx.QueryRow(c.db.Context, `
+	err := tx.QueryRow(ctx, `
 		insert into "files" (filename, filetype, path, width, height, size_bytes) values ($1, $2, $3, $4, $5, $6) 
 		returning id, created_at`, file.Filename, file.Filetype, file.Path, file.Width, file.Height, file.SizeBytes).Scan(&result.Id, &result.CreatedAt)
 
 	if err != nil {
-		return result, fmt.Errorf("failed to insert file: %w", err)
+		return nil, fmt.Errorf("failed to insert file: %w", err)
 	}
 
-	return result, tx.Commit(ctx)
+	return result, nil
 }
@@ -0,0 +1,21 @@
+-- migrate:up
+
+create type reservation_status as enum('active', 'confirmed', 'released');
+create table inventory_reservations (
+    id serial primary key,
+    order_id integer not null references orders(id) on delete cascade,
+    product_variant_id integer not null references product_variants(id) on delete cascade,
+    quantity integer not null,
+    status reservation_status not null default 'active',
+    expires_at timestamp not null,
+    created_at timestamp not null default now(),
+    updated_at timestamp not null default now()
+);
+create index inventory_reservations_order_id_idx on inventory_reservations(order_id);
+create index inventory_reservations_product_variant_id_idx on inventory_reservations(product_variant_id);
+create index inventory_reservations_status_expires_at_idx on inventory_reservations(status, expires_at);
+alter table inventory_reservations add constraint check_quantity_positive check (quantity > 0);
+
+-- migrate:down
+drop table if exists inventory_reservations;
+drop type if exists reservation_status;
@@ -0,0 +1,39 @@
+-- migrate:up
+
+alter type order_status add value if not exists 'cancelled';
+
+create type stock_movement_type as enum('sale', 'cancellation', 'adjustment', 'restock', 'return');
+create table stock_movements (
+    id serial primary key,
+    -- referenced rows cannot be deleted, the ledger keeps the whole history
+    product_variant_id integer not null references product_variants(id) on delete restrict,
+    type stock_movement_type not null,
+    -- signed stock delta: negative values decrease the stock
+    quantity integer not null,
+    employee_id integer references employees(id) on delete restrict,
+    order_id integer references orders(id) on delete restrict,
+    reason text not null default '',
+    created_at timestamp not null default now()
+);
+create index stock_movements_product_variant_id_idx on stock_movements(product_variant_id);
+create index stock_movements_order_id_idx on stock_movements(order_id);
+create index stock_movements_created_at_idx on stock_movements(created_at);
+alter table stock_movements add constraint check_quantity_nonzero check (quantity <> 0);
+
+-- the ledger is append-only
+create function stock_movements_forbid_change() returns trigger as $$
+begin
+    raise exception 'stock_movements is append-only';
+end;
+$$ language plpgsql;
+create trigger stock_movements_forbid_change before update or delete on stock_movements
+    for each row execute function stock_movements_forbid_change();
+
+-- opening balance for the existing stock
+insert into stock_movements (product_variant_id, type, quantity, reason)
+    select id, 'restock'::stock_movement_type, stock, 'Opening balance' from product_variants where stock <> 0;
+
+-- migrate:down
+drop table if exists stock_movements;
+drop function if exists stock_movements_forbid_change;
+drop type if exists stock_movement_type;
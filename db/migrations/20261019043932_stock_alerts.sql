@@ -0,0 +1,40 @@
+-- migrate:up
+
+-- reorder thresholds: the variant threshold overrides the category one
+alter table product_variants add column reorder_threshold integer;
+alter table product_variants add constraint check_reorder_threshold_nonnegative check (reorder_threshold >= 0);
+alter table categories add column reorder_threshold integer;
+alter table categories add constraint check_reorder_threshold_nonnegative check (reorder_threshold >= 0);
+
+create table low_stock_alerts (
+    id serial primary key,
+    product_variant_id integer not null references product_variants(id) on delete cascade,
+    stock integer not null,
+    threshold integer not null,
+    created_at timestamp not null default now(),
+    resolved_at timestamp,
+    -- channels that delivered the notification, the failed ones are retried until all of them deliver it
+    notified_channels varchar(32)[] not null default '{}',
+    notified_at timestamp
+);
+create index low_stock_alerts_product_variant_id_idx on low_stock_alerts(product_variant_id);
+-- only one open alert per variant
+create unique index low_stock_alerts_open_idx on low_stock_alerts(product_variant_id) where resolved_at is null;
+
+create table restock_subscriptions (
+    id serial primary key,
+    customer_id integer not null references customers(id) on delete cascade,
+    product_variant_id integer not null references product_variants(id) on delete cascade,
+    created_at timestamp not null default now(),
+    -- channels that delivered the notification, the failed ones are retried until all of them deliver it
+    notified_channels varchar(32)[] not null default '{}',
+    notified_at timestamp
+);
+create index restock_subscriptions_product_variant_id_idx on restock_subscriptions(product_variant_id);
+create unique index restock_subscriptions_pending_idx on restock_subscriptions(customer_id, product_variant_id) where notified_at is null;
+
+-- migrate:down
+drop table if exists restock_subscriptions;
+drop table if exists low_stock_alerts;
+alter table categories drop column if exists reorder_threshold;
+alter table product_variants drop column if exists reorder_threshold;
@@ -0,0 +1,85 @@
+-- migrate:up
+
+create type promotion_discount_type as enum('percentage', 'fixed');
+create table promotions (
+    id serial primary key,
+    code varchar(64) not null,
+    name varchar(255) not null default '',
+    discount_type promotion_discount_type not null,
+    -- percentage promotions have a whole percent, fixed ones an amount in the default currency
+    discount_percent integer,
+    discount_amount decimal(10, 2),
+    min_order_value decimal(10, 2),
+    -- null limits mean unlimited usage
+    usage_limit integer,
+    usage_limit_per_customer integer,
+    starts_at timestamp,
+    ends_at timestamp,
+    is_active boolean not null default true,
+    employee_id integer references employees(id) on delete set null,
+    created_at timestamp not null default now(),
+    updated_at timestamp not null default now(),
+    unique(code)
+);
+alter table promotions add constraint check_discount_value check (
+    (discount_type = 'percentage' and discount_percent between 1 and 100 and discount_amount is null)
+    or (discount_type = 'fixed' and discount_amount > 0 and discount_percent is null)
+);
+
+-- a promotion without categories and products applies to the whole order
+create table promotion_categories (
+    promotion_id integer not null references promotions(id) on delete cascade,
+    category_id integer not null references categories(id) on delete cascade,
+    primary key (promotion_id, category_id)
+);
+
+create table promotion_products (
+    promotion_id integer not null references promotions(id) on delete cascade,
+    product_id integer not null references products(id) on delete cascade,
+    primary key (promotion_id, product_id)
+);
+
+create table promotion_redemptions (
+    id serial primary key,
+    promotion_id integer not null references promotions(id) on delete cascade,
+    order_id integer not null references orders(id) on delete cascade,
+    customer_id integer not null references customers(id) on delete cascade,
+    created_at timestamp not null default now()
+);
+create index promotion_redemptions_promotion_id_idx on promotion_redemptions(promotion_id);
+create index promotion_redemptions_customer_id_idx on promotion_redemptions(customer_id);
+
+-- order level adjustments have no order_item_id
+create table order_adjustments (
+    id serial primary key,
+    order_id integer not null references orders(id) on delete cascade,
+    order_item_id integer references order_items(id) on delete cascade,
+    promotion_id integer references promotions(id) on delete set null,
+    type varchar(32) not null,
+    description varchar(255) not null default '',
+    amount decimal(10, 2) not null,
+    created_at timestamp not null default now()
+);
+create index order_adjustments_order_id_idx on order_adjustments(order_id);
+
+alter table orders add column subtotal decimal(10, 2) not null default 0;
+alter table orders add column discount_total decimal(10, 2) not null default 0;
+alter table orders add column total decimal(10, 2) not null default 0;
+
+-- Existing orders had no discounts, so their totals are the sum of their items
+update orders set subtotal = items.total, total = items.total
+from (
+    select order_id, sum(price * quantity) as total from order_items group by order_id
+) items
+where items.order_id = orders.id;
+
+-- migrate:down
+alter table orders drop column if exists total;
+alter table orders drop column if exists discount_total;
+alter table orders drop column if exists subtotal;
+drop table if exists order_adjustments;
+drop table if exists promotion_redemptions;
+drop table if exists promotion_products;
+drop table if exists promotion_categories;
+drop table if exists promotions;
+drop type if exists promotion_discount_type;
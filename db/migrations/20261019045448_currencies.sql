@@ -0,0 +1,38 @@
+-- migrate:up
+
+-- amount of the currency for one unit of the default currency (DEFAULT_CURRENCY)
+create table exchange_rates (
+    currency varchar(3) primary key,
+    rate decimal(18, 8) not null,
+    updated_at timestamp not null default now()
+);
+alter table exchange_rates add constraint check_rate_positive check (rate > 0);
+
+-- explicit variant prices in other currencies, they take priority over the converted default price
+create table product_variant_prices (
+    product_variant_id integer not null references product_variants(id) on delete cascade,
+    currency varchar(3) not null,
+    price decimal(10, 2) not null,
+    primary key (product_variant_id, currency)
+);
+alter table product_variant_prices add constraint check_price_positive check (price >= 0);
+
+-- existing orders were placed in the default currency. It is passed by `make migrate` as the
+-- app.default_currency setting, new orders always store their currency explicitly
+alter table orders add column currency varchar(3);
+alter table orders add column exchange_rate decimal(18, 8) not null default 1;
+do $$
+begin
+    if exists(select 1 from orders) and coalesce(current_setting('app.default_currency', true), '') = '' then
+        raise exception 'app.default_currency must be set to DEFAULT_CURRENCY to backfill the currency of existing orders';
+    end if;
+end $$;
+update orders set currency = upper(current_setting('app.default_currency', true));
+alter table orders alter column currency set not null;
+
+-- migrate:down
+
+alter table orders drop column if exists exchange_rate;
+alter table orders drop column if exists currency;
+drop table if exists product_variant_prices;
+drop table if exists exchange_rates;
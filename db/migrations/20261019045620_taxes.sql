@@ -0,0 +1,37 @@
+-- migrate:up
+
+-- the most specific matching rule is used: category rules win over rules without category,
+-- then the longest zipcode prefix wins. Rules without zipcode prefix match the whole country
+create table tax_rules (
+    id serial primary key,
+    name varchar(255) not null default '',
+    country varchar(64) not null,
+    zipcode_prefix varchar(16) not null default '',
+    category_id integer references categories(id) on delete cascade,
+    -- percent, e.g. 20.000 for 20% VAT
+    rate decimal(6, 3) not null,
+    -- inclusive taxes are already part of the price, exclusive taxes are added on top of it
+    is_inclusive boolean not null default true,
+    created_at timestamp not null default now()
+);
+alter table tax_rules add constraint check_tax_rate check (rate >= 0 and rate <= 100);
+create unique index tax_rules_scope_idx on tax_rules(lower(country), zipcode_prefix, coalesce(category_id, 0));
+
+-- tax_base is the line total after discounts
+alter table order_items add column tax_base decimal(10, 2) not null default 0;
+alter table order_items add column tax_rate decimal(6, 3) not null default 0;
+alter table order_items add column tax_amount decimal(10, 2) not null default 0;
+alter table order_items add column tax_inclusive boolean not null default true;
+alter table orders add column tax_total decimal(10, 2) not null default 0;
+
+-- Existing orders had no discounts per item and no taxes
+update order_items set tax_base = price * quantity;
+
+-- migrate:down
+
+alter table orders drop column if exists tax_total;
+alter table order_items drop column if exists tax_inclusive;
+alter table order_items drop column if exists tax_amount;
+alter table order_items drop column if exists tax_rate;
+alter table order_items drop column if exists tax_base;
+drop table if exists tax_rules;
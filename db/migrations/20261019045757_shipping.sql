@@ -0,0 +1,41 @@
+-- migrate:up
+
+-- weight in grams, used for shipping rates
+alter table product_variants add column weight integer not null default 0;
+alter table product_variants add constraint check_weight_positive check (weight >= 0);
+
+create table shipping_methods (
+    id serial primary key,
+    carrier varchar(64) not null,
+    name varchar(255) not null,
+    -- orders with a value (after discounts) of at least this amount are shipped for free
+    free_shipping_threshold decimal(10, 2),
+    is_active boolean not null default true,
+    created_at timestamp not null default now()
+);
+
+-- the most specific rate is used: country rates win over rates without country,
+-- then the lowest weight limit, then the highest order value limit
+create table shipping_rates (
+    id serial primary key,
+    shipping_method_id integer not null references shipping_methods(id) on delete cascade,
+    -- empty country matches every country
+    country varchar(64) not null default '',
+    -- null means no weight limit
+    max_weight integer,
+    min_order_value decimal(10, 2) not null default 0,
+    price decimal(10, 2) not null
+);
+alter table shipping_rates add constraint check_price_positive check (price >= 0);
+create index shipping_rates_method_idx on shipping_rates(shipping_method_id);
+
+alter table orders add column shipping_method_id integer references shipping_methods(id) on delete set null;
+alter table orders add column shipping_total decimal(10, 2) not null default 0;
+
+-- migrate:down
+
+alter table orders drop column if exists shipping_total;
+alter table orders drop column if exists shipping_method_id;
+drop table if exists shipping_rates;
+drop table if exists shipping_methods;
+alter table product_variants drop column if exists weight;
@@ -0,0 +1,29 @@
+-- migrate:up
+
+create type payment_status as enum('pending', 'authorized', 'captured', 'declined', 'refunded');
+create table payments (
+    id serial primary key,
+    order_id integer not null references orders(id) on delete cascade,
+    provider varchar(64) not null,
+    -- id of the payment in the provider, null until the provider responds
+    provider_payment_id varchar(255),
+    -- client generated key that makes retries of the same payment request safe
+    idempotency_key varchar(255) not null,
+    status payment_status not null default 'pending',
+    amount decimal(10, 2) not null,
+    currency varchar(3) not null,
+    refunded_amount decimal(10, 2) not null default 0,
+    message text not null default '',
+    created_at timestamp not null default now(),
+    updated_at timestamp not null default now(),
+    unique(idempotency_key),
+    unique(provider, provider_payment_id)
+);
+create index payments_order_idx on payments(order_id);
+-- an order has at most one payment that is in progress or paid
+create unique index payments_order_active_idx on payments(order_id) where status in ('pending', 'authorized', 'captured');
+
+-- migrate:down
+
+drop table if exists payments;
+drop type if exists payment_status;
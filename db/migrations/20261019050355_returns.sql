@@ -0,0 +1,43 @@
+-- migrate:up
+
+-- requested -> approved | rejected, approved -> received -> inspected -> refunding -> refunded.
+-- refunding returns are being refunded by the payment provider
+create type return_status as enum('requested', 'approved', 'rejected', 'received', 'inspected', 'refunding', 'refunded');
+create table returns (
+    id serial primary key,
+    order_id integer not null references orders(id) on delete cascade,
+    customer_id integer references customers(id) on delete cascade,
+    status return_status not null default 'requested',
+    comment text not null default '',
+    -- refund for the returned items in the order currency, calculated when the return is requested
+    refund_amount decimal(10, 2) not null default 0,
+    currency varchar(3) not null,
+    -- employee of the last status change
+    employee_id integer references employees(id) on delete set null,
+    created_at timestamp not null default now(),
+    updated_at timestamp not null default now()
+);
+create index returns_order_id_idx on returns(order_id);
+create index returns_customer_id_idx on returns(customer_id);
+create index returns_status_idx on returns(status);
+
+create table return_items (
+    id serial primary key,
+    return_id integer not null references returns(id) on delete cascade,
+    order_item_id integer not null references order_items(id) on delete cascade,
+    quantity integer not null,
+    reason text not null default '',
+    refund_amount decimal(10, 2) not null default 0,
+    -- quantity put back to the stock after the inspection, null until the return is inspected
+    restocked_quantity integer,
+    unique(return_id, order_item_id)
+);
+alter table return_items add constraint check_quantity_positive check (quantity > 0);
+alter table return_items add constraint check_restocked_quantity check (restocked_quantity >= 0 and restocked_quantity <= quantity);
+create index return_items_order_item_id_idx on return_items(order_item_id);
+
+-- migrate:down
+
+drop table if exists return_items;
+drop table if exists returns;
+drop type if exists return_status;
@@ -0,0 +1,30 @@
+-- migrate:up
+
+create type order_document_type as enum('invoice', 'packing_slip');
+
+-- last allocated number of every numbered document type. The row is locked by the allocation,
+-- so numbers of rolled back documents are reused and the sequence has no gaps
+create table document_sequences (
+    type order_document_type primary key,
+    last_number integer not null default 0
+);
+insert into document_sequences (type) values ('invoice');
+
+-- issued documents are immutable, the same file is returned on every download
+create table order_documents (
+    id serial primary key,
+    order_id integer not null references orders(id) on delete restrict,
+    type order_document_type not null,
+    -- sequential number, null for documents without numbering
+    number integer,
+    file_id integer not null references files(id) on delete restrict,
+    issued_at timestamp not null default now(),
+    unique(order_id, type),
+    unique(type, number)
+);
+
+-- migrate:down
+
+drop table if exists order_documents;
+drop table if exists document_sequences;
+drop type if exists order_document_type;
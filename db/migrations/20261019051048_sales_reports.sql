@@ -0,0 +1,21 @@
+-- migrate:up
+
+-- daily totals of sold orders in the default currency, refreshed by a background job.
+-- Orders of other currencies are converted by the exchange rate of the order time
+create materialized view daily_sales as
+    select
+        order_date::date as day,
+        count(*) as orders,
+        sum(total / exchange_rate) as revenue,
+        sum(discount_total / exchange_rate) as discounts,
+        sum(tax_total / exchange_rate) as taxes,
+        sum(shipping_total / exchange_rate) as shipping
+    from orders
+    where status in ('processing', 'shipped', 'delivered')
+    group by order_date::date;
+-- the unique index allows refreshing the view concurrently
+create unique index daily_sales_day_idx on daily_sales(day);
+
+-- migrate:down
+
+drop materialized view if exists daily_sales;
@@ -0,0 +1,25 @@
+-- migrate:up
+
+-- categories form a tree, root categories have no parent.
+-- A category with subcategories cannot be deleted
+alter table categories add column parent_id integer references categories(id) on delete restrict;
+alter table categories add constraint check_category_parent check (parent_id <> id);
+create index categories_parent_id_idx on categories(parent_id);
+
+-- position among the siblings, lower values first
+alter table categories add column sort_order integer not null default 0;
+
+alter table categories add column slug varchar(255);
+update categories set slug = trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g'));
+update categories set slug = 'category-' || id where slug = '';
+update categories set slug = categories.slug || '-' || categories.id
+    from (select id, row_number() over (partition by slug order by id) as n from categories) duplicates
+    where duplicates.id = categories.id and duplicates.n > 1;
+alter table categories alter column slug set not null;
+alter table categories add constraint categories_slug_key unique(slug);
+
+-- migrate:down
+
+alter table categories drop column if exists slug;
+alter table categories drop column if exists sort_order;
+alter table categories drop column if exists parent_id;
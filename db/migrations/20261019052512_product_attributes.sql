@@ -0,0 +1,135 @@
+-- migrate:up
+
+create type attribute_type as enum('text', 'number', 'boolean', 'option');
+
+-- attribute definitions, e.g. material, fit or capacity
+create table attributes (
+    id serial primary key,
+    code varchar(64) not null,
+    name varchar(255) not null,
+    type attribute_type not null,
+    -- unit of number values, e.g. 'ml'
+    unit varchar(32),
+    created_at timestamp not null default now(),
+    unique(code)
+);
+
+-- allowed values of option attributes
+create table attribute_options (
+    id serial primary key,
+    attribute_id integer not null references attributes(id) on delete cascade,
+    value varchar(255) not null,
+    sort_order integer not null default 0,
+    unique(attribute_id, value)
+);
+
+-- attribute sets of categories, subcategories inherit the attributes of their parents
+create table category_attributes (
+    category_id integer not null references categories(id) on delete cascade,
+    attribute_id integer not null references attributes(id) on delete cascade,
+    -- every variant of the category products must have a value of a required attribute
+    is_required boolean not null default false,
+    sort_order integer not null default 0,
+    primary key (category_id, attribute_id)
+);
+
+-- typed attribute values of variants, exactly one value column is set
+create table product_variant_attributes (
+    product_variant_id integer not null references product_variants(id) on delete cascade,
+    attribute_id integer not null references attributes(id) on delete restrict,
+    option_id integer references attribute_options(id) on delete restrict,
+    value_text varchar(255),
+    value_number numeric,
+    value_boolean boolean,
+    primary key (product_variant_id, attribute_id),
+    constraint check_single_value check (num_nonnulls(option_id, value_text, value_number, value_boolean) = 1)
+);
+create index product_variant_attributes_attribute_id_idx on product_variant_attributes(attribute_id);
+
+-- sizes and colors become option attributes, so variants are defined only by attribute values
+insert into attributes (code, name, type) values ('size', 'Size', 'option'), ('color', 'Color', 'option');
+insert into attribute_options (attribute_id, value, sort_order)
+    select attributes.id, sizes.name, row_number() over (order by sizes.id)
+    from sizes, attributes
+    where attributes.code = 'size';
+insert into attribute_options (attribute_id, value, sort_order)
+    select attributes.id, colors.name, row_number() over (order by colors.id)
+    from colors, attributes
+    where attributes.code = 'color';
+insert into product_variant_attributes (product_variant_id, attribute_id, option_id)
+    select product_variants.id, attribute_options.attribute_id, attribute_options.id
+    from product_variants
+    join sizes on sizes.id = product_variants.size_id
+    join attribute_options on attribute_options.value = sizes.name
+        and attribute_options.attribute_id = (select id from attributes where code = 'size');
+insert into product_variant_attributes (product_variant_id, attribute_id, option_id)
+    select product_variants.id, attribute_options.attribute_id, attribute_options.id
+    from product_variants
+    join colors on colors.id = product_variants.color_id
+    join attribute_options on attribute_options.value = colors.name
+        and attribute_options.attribute_id = (select id from attributes where code = 'color');
+
+-- variants were unique by size, which blocked two colors of the same size. dimension_key is
+-- the canonical form of the attribute values, maintained by the application
+alter table product_variants add column dimension_key text not null default '';
+update product_variants set dimension_key = dimensions.key
+    from (
+        select product_variant_id, string_agg(attribute_id || '=o' || option_id, ';' order by attribute_id) as key
+        from product_variant_attributes
+        group by product_variant_id
+    ) dimensions
+    where dimensions.product_variant_id = product_variants.id;
+alter table product_variants drop column size_id;
+alter table product_variants drop column color_id;
+drop table colors;
+drop table sizes;
+create unique index product_variants_dimensions_idx on product_variants(product_id, dimension_key);
+
+-- migrate:down
+
+create table sizes (
+    id serial primary key,
+    name varchar(32) not null,
+    unique(name)
+);
+create index sizes_name_idx on sizes(name);
+create table colors (
+    id serial primary key,
+    name varchar(32) not null,
+    unique(name)
+);
+create index colors_name_idx on colors(name);
+insert into sizes (name)
+    select value from attribute_options
+    where attribute_id = (select id from attributes where code = 'size')
+    order by sort_order;
+insert into colors (name)
+    select value from attribute_options
+    where attribute_id = (select id from attributes where code = 'color')
+    order by sort_order;
+
+drop index if exists product_variants_dimensions_idx;
+alter table product_variants add column size_id integer references sizes(id) on delete set null;
+alter table product_variants add column color_id integer references colors(id) on delete set null;
+update product_variants set size_id = sizes.id
+    from product_variant_attributes
+    join attributes on attributes.id = product_variant_attributes.attribute_id and attributes.code = 'size'
+    join attribute_options on attribute_options.id = product_variant_attributes.option_id
+    join sizes on sizes.name = attribute_options.value
+    where product_variant_attributes.product_variant_id = product_variants.id;
+update product_variants set color_id = colors.id
+    from product_variant_attributes
+    join attributes on attributes.id = product_variant_attributes.attribute_id and attributes.code = 'color'
+    join attribute_options on attribute_options.id = product_variant_attributes.option_id
+    join colors on colors.name = attribute_options.value
+    where product_variant_attributes.product_variant_id = product_variants.id;
+create index product_variants_size_id_idx on product_variants(size_id);
+create index product_variants_color_id_idx on product_variants(color_id);
+-- fails when a product has variants of the same size, they must be removed first
+alter table product_variants add constraint product_variants_product_id_size_id_key unique(product_id, size_id);
+alter table product_variants drop column if exists dimension_key;
+drop table if exists product_variant_attributes;
+drop table if exists category_attributes;
+drop table if exists attribute_options;
+drop table if exists attributes;
+drop type if exists attribute_type;
@@ -0,0 +1,37 @@
+-- migrate:up
+
+-- URL slugs of products, generated from the names like category slugs
+alter table products add column slug varchar(255);
+update products set slug = trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g'));
+update products set slug = 'product-' || id where slug = '';
+update products set slug = products.slug || '-' || products.id
+    from (select id, row_number() over (partition by slug order by id) as n from products) duplicates
+    where duplicates.id = products.id and duplicates.n > 1;
+alter table products alter column slug set not null;
+alter table products add constraint products_slug_key unique(slug);
+
+-- previous slugs of products, requests with an old slug are redirected to the current one
+create table product_slug_history (
+    slug varchar(255) primary key,
+    product_id integer not null references products(id) on delete cascade,
+    created_at timestamp not null default now()
+);
+create index product_slug_history_product_id_idx on product_slug_history(product_id);
+
+-- stock keeping units are unique and not derived from ids, existing variants get random ones
+alter table product_variants add column sku varchar(64);
+update product_variants set sku = 'NS-' || upper(substr(md5(random()::text || id::text), 1, 10));
+alter table product_variants alter column sku set not null;
+alter table product_variants add constraint product_variants_sku_key unique(sku);
+
+-- GTIN-8, GTIN-12 (UPC), GTIN-13 (EAN) or GTIN-14, check digits are validated by the application
+alter table product_variants add column barcode varchar(14);
+alter table product_variants add constraint product_variants_barcode_key unique(barcode);
+alter table product_variants add constraint check_barcode_format check (barcode ~ '^([0-9]{8}|[0-9]{12,14})$');
+
+-- migrate:down
+
+alter table product_variants drop column if exists barcode;
+alter table product_variants drop column if exists sku;
+drop table if exists product_slug_history;
+alter table products drop column if exists slug;
@@ -0,0 +1,22 @@
+-- migrate:up
+
+-- sizes and colors are options of the 'size' and 'color' attributes, every option can have a sample.
+-- lowercase '#rrggbb' code shown as the option sample, e.g. of a color
+alter table attribute_options add column hex_code varchar(7);
+alter table attribute_options add constraint check_attribute_option_hex_code check (hex_code ~ '^#[0-9a-f]{6}$');
+-- uploaded image shown instead of the hex code, e.g. for patterned fabrics
+alter table attribute_options add column swatch_file_id integer references files(id) on delete set null;
+
+-- deleting a category used by products fails instead of silently nulling the references.
+-- Options used by variants are already protected by product_variant_attributes
+alter table products drop constraint products_category_id_fkey;
+alter table products add constraint products_category_id_fkey
+    foreign key (category_id) references categories(id) on delete restrict;
+
+-- migrate:down
+
+alter table products drop constraint products_category_id_fkey;
+alter table products add constraint products_category_id_fkey
+    foreign key (category_id) references categories(id) on delete set null;
+alter table attribute_options drop column if exists swatch_file_id;
+alter table attribute_options drop column if exists hex_code;
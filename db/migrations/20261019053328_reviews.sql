@@ -0,0 +1,56 @@
+-- migrate:up
+
+-- pending -> approved | rejected, edited reviews are moderated again
+create type review_status as enum('pending', 'approved', 'rejected');
+
+-- reviews of customers with a delivered order of the product, one review per product and customer
+create table reviews (
+    id serial primary key,
+    product_id integer not null references products(id) on delete cascade,
+    customer_id integer not null references customers(id) on delete cascade,
+    -- latest delivered order of the product when the review was written
+    order_id integer references orders(id) on delete set null,
+    rating smallint not null,
+    title varchar(255) not null default '',
+    body text not null default '',
+    status review_status not null default 'pending',
+    -- reason of the rejection shown to the author
+    moderation_note text not null default '',
+    -- employee of the last moderation
+    employee_id integer references employees(id) on delete set null,
+    created_at timestamp not null default now(),
+    updated_at timestamp not null default now(),
+    unique(product_id, customer_id)
+);
+alter table reviews add constraint check_rating_range check (rating between 1 and 5);
+create index reviews_product_id_status_idx on reviews(product_id, status);
+create index reviews_status_idx on reviews(status);
+
+create table review_images (
+    review_id integer not null references reviews(id) on delete cascade,
+    file_id integer not null references files(id) on delete cascade,
+    sort_order integer not null default 0,
+    primary key (review_id, file_id)
+);
+
+-- customers marking reviews as helpful
+create table review_votes (
+    review_id integer not null references reviews(id) on delete cascade,
+    customer_id integer not null references customers(id) on delete cascade,
+    created_at timestamp not null default now(),
+    primary key (review_id, customer_id)
+);
+
+-- aggregates of approved reviews, maintained by the application when reviews change
+alter table products add column rating_average decimal(3, 2);
+alter table products add column rating_count integer not null default 0;
+create index products_rating_average_idx on products(rating_average);
+
+-- migrate:down
+
+alter table products drop column if exists rating_count;
+alter table products drop column if exists rating_average;
+drop table if exists review_votes;
+drop table if exists review_images;
+drop table if exists reviews;
+drop type if exists review_status;
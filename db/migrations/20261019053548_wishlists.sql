@@ -0,0 +1,32 @@
+-- migrate:up
+
+-- named product lists of customers, a list with a share token is readable by anyone with the token
+create table wishlists (
+    id serial primary key,
+    customer_id integer not null references customers(id) on delete cascade,
+    name varchar(64) not null,
+    share_token varchar(64) unique,
+    created_at timestamp not null default now(),
+    updated_at timestamp not null default now(),
+    unique(customer_id, name)
+);
+
+-- saved products, optionally a specific variant of the product
+create table wishlist_items (
+    id serial primary key,
+    wishlist_id integer not null references wishlists(id) on delete cascade,
+    product_id integer not null references products(id) on delete cascade,
+    product_variant_id integer references product_variants(id) on delete cascade,
+    -- variant price in the default currency when the customer was last notified, price drops below it are notified
+    notified_price decimal(10, 2),
+    notify_price_drop boolean not null default true,
+    created_at timestamp not null default now()
+);
+create unique index wishlist_items_product_idx on wishlist_items(wishlist_id, product_id) where product_variant_id is null;
+create unique index wishlist_items_variant_idx on wishlist_items(wishlist_id, product_variant_id) where product_variant_id is not null;
+create index wishlist_items_product_variant_id_idx on wishlist_items(product_variant_id);
+
+-- migrate:down
+
+drop table if exists wishlist_items;
+drop table if exists wishlists;
@@ -0,0 +1,43 @@
+-- migrate:up
+
+create type product_relation_type as enum('related', 'accessory', 'upsell', 'cross_sell');
+
+-- relations curated by employees, e.g. a belt as an accessory of trousers
+create table product_relations (
+    product_id integer not null references products(id) on delete cascade,
+    related_product_id integer not null references products(id) on delete cascade,
+    type product_relation_type not null,
+    -- position in the relations of the same type, lower values first
+    sort_order integer not null default 0,
+    employee_id integer references employees(id) on delete set null,
+    created_at timestamp not null default now(),
+    primary key (product_id, related_product_id, type)
+);
+alter table product_relations add constraint check_not_self_related check (product_id <> related_product_id);
+create index product_relations_related_product_id_idx on product_relations(related_product_id);
+
+-- number of sold orders that contain both products, refreshed by a background job
+create materialized view product_co_purchases as
+    with sold_products as (
+        select distinct order_items.order_id, product_variants.product_id
+        from order_items
+        join orders on orders.id = order_items.order_id
+        join product_variants on product_variants.id = order_items.product_variant_id
+        where orders.status in ('processing', 'shipped', 'delivered')
+    )
+    select
+        sold_products.product_id,
+        together.product_id as related_product_id,
+        count(*) as orders
+    from sold_products
+    join sold_products together on together.order_id = sold_products.order_id
+        and together.product_id <> sold_products.product_id
+    group by sold_products.product_id, together.product_id;
+-- the unique index allows refreshing the view concurrently
+create unique index product_co_purchases_idx on product_co_purchases(product_id, related_product_id);
+
+-- migrate:down
+
+drop materialized view if exists product_co_purchases;
+drop table if exists product_relations;
+drop type if exists product_relation_type;
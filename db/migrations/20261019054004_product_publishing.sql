@@ -0,0 +1,38 @@
+-- migrate:up
+
+-- draft -> scheduled -> published -> archived, only published products are visible to customers
+create type product_status as enum('draft', 'scheduled', 'published', 'archived');
+
+alter table products add column status product_status not null default 'draft';
+-- scheduled products are published at publish_at, published products are archived at unpublish_at
+alter table products add column publish_at timestamp;
+alter table products add column unpublish_at timestamp;
+alter table products add constraint check_publish_period check (unpublish_at is null or publish_at is null or unpublish_at > publish_at);
+-- products created before the workflow stay visible
+update products set status = 'published', publish_at = created_at;
+create index products_status_idx on products(status);
+create index products_publish_at_idx on products(publish_at) where status = 'scheduled';
+create index products_unpublish_at_idx on products(unpublish_at) where status = 'published';
+
+-- audit log of status changes, the employee is null for changes of the scheduler
+create table product_status_changes (
+    id serial primary key,
+    product_id integer not null references products(id) on delete cascade,
+    -- null for the initial status of a new product
+    from_status product_status,
+    to_status product_status not null,
+    publish_at timestamp,
+    unpublish_at timestamp,
+    employee_id integer references employees(id) on delete set null,
+    comment text not null default '',
+    created_at timestamp not null default now()
+);
+create index product_status_changes_product_id_idx on product_status_changes(product_id);
+
+-- migrate:down
+
+drop table if exists product_status_changes;
+alter table products drop column if exists unpublish_at;
+alter table products drop column if exists publish_at;
+alter table products drop column if exists status;
+drop type if exists product_status;
@@ -0,0 +1,36 @@
+-- migrate:up
+
+-- regular rows are the history of the variant price, sale rows override it while they are effective
+create type variant_price_kind as enum('regular', 'sale');
+
+create table variant_prices (
+    id serial primary key,
+    product_variant_id integer not null references product_variants(id) on delete cascade,
+    kind variant_price_kind not null,
+    price decimal(10, 2) not null,
+    -- strike-through price of sales, the regular price is shown when null
+    compare_at_price decimal(10, 2),
+    effective_from timestamp not null default now(),
+    -- null for open ranges, the open regular row is the current variant price. A regular price
+    -- replaced in the transaction that set it has an empty range
+    effective_to timestamp,
+    -- cancelled sales stay in the history
+    cancelled_at timestamp,
+    employee_id integer references employees(id) on delete set null,
+    comment text not null default '',
+    created_at timestamp not null default now()
+);
+alter table variant_prices add constraint check_variant_price_nonnegative check (price >= 0);
+alter table variant_prices add constraint check_variant_compare_at_price check (compare_at_price is null or compare_at_price > price);
+alter table variant_prices add constraint check_variant_price_period check (effective_to is null or effective_to >= effective_from);
+create index variant_prices_product_variant_id_idx on variant_prices(product_variant_id, kind, effective_from);
+create index variant_prices_sale_idx on variant_prices(product_variant_id, effective_from) where kind = 'sale' and cancelled_at is null;
+
+-- current prices of the existing variants start the history
+insert into variant_prices (product_variant_id, kind, price, comment)
+select id, 'regular', price, 'Initial price' from product_variants;
+
+-- migrate:down
+
+drop table if exists variant_prices;
+drop type if exists variant_price_kind;
@@ -0,0 +1,57 @@
+-- migrate:up
+
+-- the base columns of products, categories, attributes and attribute options hold the texts in DEFAULT_LANGUAGE,
+-- translation tables hold the texts in the other supported languages. Empty translated
+-- descriptions fall back like missing translations
+
+-- the 'simple' configuration does not stem words, so it works for every language
+alter table products add column search_vector tsvector
+    generated always as (to_tsvector('simple'::regconfig, coalesce(name, '') || ' ' || coalesce(description, ''))) stored;
+create index products_search_vector_idx on products using gin(search_vector);
+
+create table product_translations (
+    product_id integer not null references products(id) on delete cascade,
+    language varchar(8) not null,
+    name varchar(255) not null,
+    description text not null default '',
+    search_vector tsvector
+        generated always as (to_tsvector('simple'::regconfig, name || ' ' || description)) stored,
+    updated_at timestamp not null default now(),
+    primary key (product_id, language)
+);
+create index product_translations_search_vector_idx on product_translations using gin(search_vector);
+
+create table category_translations (
+    category_id integer not null references categories(id) on delete cascade,
+    language varchar(8) not null,
+    name varchar(255) not null,
+    description text not null default '',
+    updated_at timestamp not null default now(),
+    primary key (category_id, language)
+);
+
+create table attribute_translations (
+    attribute_id integer not null references attributes(id) on delete cascade,
+    language varchar(8) not null,
+    name varchar(255) not null,
+    updated_at timestamp not null default now(),
+    primary key (attribute_id, language)
+);
+
+-- translated values of option attributes, e.g. of sizes and colors
+create table attribute_option_translations (
+    option_id integer not null references attribute_options(id) on delete cascade,
+    language varchar(8) not null,
+    name varchar(255) not null,
+    updated_at timestamp not null default now(),
+    primary key (option_id, language)
+);
+
+-- migrate:down
+
+drop table if exists attribute_option_translations;
+drop table if exists attribute_translations;
+drop table if exists category_translations;
+drop table if exists product_translations;
+drop index if exists products_search_vector_idx;
+alter table products drop column if exists search_vector;
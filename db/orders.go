@@ -2,11 +2,40 @@ package db
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"netshop/main/tools/money"
 	"netshop/main/tools/sqb"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	OrderStatusPending    = "pending"
+	OrderStatusProcessing = "processing"
+	OrderStatusShipped    = "shipped"
+	OrderStatusDelivered  = "delivered"
+	OrderStatusCancelled  = "cancelled"
+)
+
+// Allowed order status changes. Pending orders leave the pending status only through processing,
+// which confirms the stock reservations, or cancellation, which releases them
+var orderStatusTransitions = map[string][]string{
+	OrderStatusPending:    {OrderStatusProcessing, OrderStatusCancelled},
+	OrderStatusProcessing: {OrderStatusShipped, OrderStatusDelivered, OrderStatusCancelled},
+	OrderStatusShipped:    {OrderStatusDelivered},
+	OrderStatusDelivered:  {},
+	OrderStatusCancelled:  {},
+}
+
+var (
+	ErrInvalidStatusTransition = errors.New("invalid order status transition")
+	ErrInvalidDeliveryUpdate   = errors.New("invalid delivery update")
 )
 
 type OrderItemEntity struct {
@@ -14,8 +43,17 @@ type OrderItemEntity struct {
 	OrderId          int64                 `json:"order_id"`
 	ProductVariantId int64                 `json:"product_variant_id"`
 	ProductVariant   *ProductVariantEntity `json:"product_variant"`
-	Price            float64               `json:"price"`
-	Quantity         uint32                `json:"quantity"`
+	// Product of the variant, loaded only with the order details
+	ProductId   int64       `json:"product_id,omitempty"`
+	ProductName string      `json:"product_name,omitempty"`
+	Price       money.Money `json:"price"`
+	Quantity    uint32      `json:"quantity"`
+
+	// Line total after discounts and its tax
+	TaxBase      money.Money `json:"tax_base"`
+	TaxRate      json.Number `json:"tax_rate"`
+	TaxInclusive bool        `json:"tax_inclusive"`
+	TaxAmount    money.Money `json:"tax_amount"`
 }
 
 type OrderEntity struct {
@@ -32,17 +70,61 @@ type OrderEntity struct {
 	CreatedAt       time.Time          `json:"created_at"`
 	UpdatedAt       time.Time          `json:"updated_at"`
 	Items           []*OrderItemEntity `json:"items"`
+
+	// Currency of the order prices and its rate to the default currency at the order time
+	Currency     string      `json:"currency"`
+	ExchangeRate json.Number `json:"exchange_rate"`
+
+	// Sum of the item prices before discounts
+	Subtotal      money.Money `json:"subtotal"`
+	DiscountTotal money.Money `json:"discount_total"`
+	// Sum of inclusive and exclusive taxes, only exclusive taxes are added to the total
+	TaxTotal   money.Money       `json:"tax_total"`
+	TaxSummary []OrderTaxSummary `json:"tax_summary"`
+	// Chosen shipping method and its cost, the cost is added to the total
+	ShippingMethodId *int64                   `json:"shipping_method_id"`
+	ShippingTotal    money.Money              `json:"shipping_total"`
+	Total            money.Money              `json:"total"`
+	Adjustments      []*OrderAdjustmentEntity `json:"adjustments"`
 }
 
-type orderItemCreateUpdate struct {
-	Price            float64
-	ProductVariantId int64
-	Quantity         int
+type OrderItemCreateUpdate struct {
+	ProductVariantId int64 `json:"product_variant_id"`
+	Quantity         int   `json:"quantity"`
 }
 
 type OrderGetAllOptions struct {
+	Id         *int64
 	CustomerId *int64
 	Status     *string
+
+	OrderDateFrom  *time.Time
+	OrderDateTo    *time.Time
+	StatusDateFrom *time.Time
+	StatusDateTo   *time.Time
+	// Case-insensitive delivery country
+	Country *string
+	// Order total range in the default currency, totals of other currencies are converted by the order exchange rate
+	MinTotal *money.Money
+	MaxTotal *money.Money
+	// Matches the order id, customer name or email
+	Search *string
+
+	// Zero limit returns all orders
+	Limit  int64
+	Offset int64
+	// One of id, order_date, status_date, total. If empty, orders are sorted by id
+	OrderColumn string
+	// If OrderAsc is false, descending order is used
+	OrderAsc bool
+}
+
+// OrderDeliveryUpdate changes the delivery of an order that is not shipped yet
+type OrderDeliveryUpdate struct {
+	Address string `json:"address"`
+	Zipcode string `json:"zipcode"`
+	City    string `json:"city"`
+	Country string `json:"country"`
 }
 
 type OrderCreateUpdateOptions struct {
@@ -51,16 +133,27 @@ type OrderCreateUpdateOptions struct {
 	Customer   *CustomerCreateUpdate
 	Status     string
 	Delivery   struct{ Address, Zipcode, City, Country string }
-	Items      []*orderItemCreateUpdate
+	Items      []*OrderItemCreateUpdate
+
+	// Optional promotion code applied to the order
+	PromotionCode string
+	// Currency of the order prices. If empty, the default currency is used
+	Currency string
+	// Optional shipping method, its cost is calculated by the shipping rates
+	ShippingMethodId *int64
 }
 
 type OrderEntityStore struct {
 	db *DatabaseConnection
+
+	// Calculates taxes of new orders, the "tax_rules" table is used by default
+	TaxCalculator TaxCalculator
 }
 
 func NewOrderEntity(database *DatabaseConnection) *OrderEntityStore {
 	return &OrderEntityStore{
-		db: database,
+		db:            database,
+		TaxCalculator: &TableTaxCalculator{},
 	}
 }
 
@@ -88,24 +181,88 @@ func (c *OrderEntityStore) GetAll(options *OrderGetAllOptions) ([]OrderEntity, e
 			"orders.status_date",
 			"orders.created_at",
 			"orders.updated_at",
-			"order_items.id",
-			"order_items.order_id",
-			"order_items.product_variant_id",
-			"order_items.price",
-			"order_items.quantity",
+			"orders.currency",
+			"orders.exchange_rate::text",
+			"orders.subtotal",
+			"orders.discount_total",
+			"orders.tax_total",
+			"orders.shipping_method_id",
+			"orders.shipping_total",
+			"orders.total",
 		).
-		From("orders").
-		LeftJoin("order_items", "order_items.order_id = orders.id").
-		LeftJoin("product_variants", "product_variants.id = order_items.product_variant_id").
-		InnerJoin("customers", "customers.id = orders.customer_id").
-		OrderBy("orders.id", "desc")
+		From("orders")
 
+	if options.Id != nil {
+		builder.AndWhere("orders.id = $id")
+		builder.SetParameter("id", options.Id)
+	}
 	if options.CustomerId != nil {
 		builder.AndWhere("orders.customer_id = $customerId")
 		builder.SetParameter("customerId", options.CustomerId)
 	}
+	if options.Status != nil {
+		builder.AndWhere("orders.status = $status")
+		builder.SetParameter("status", options.Status)
+	}
+	if options.OrderDateFrom != nil {
+		builder.AndWhere("orders.order_date >= $orderDateFrom")
+		builder.SetParameter("orderDateFrom", options.OrderDateFrom)
+	}
+	if options.OrderDateTo != nil {
+		builder.AndWhere("orders.order_date <= $orderDateTo")
+		builder.SetParameter("orderDateTo", options.OrderDateTo)
+	}
+	if options.StatusDateFrom != nil {
+		builder.AndWhere("orders.status_date >= $statusDateFrom")
+		builder.SetParameter("statusDateFrom", options.StatusDateFrom)
+	}
+	if options.StatusDateTo != nil {
+		builder.AndWhere("orders.status_date <= $statusDateTo")
+		builder.SetParameter("statusDateTo", options.StatusDateTo)
+	}
+	if options.Country != nil {
+		builder.AndWhere("lower(orders.delivery_country) = lower($country)")
+		builder.SetParameter("country", options.Country)
+	}
+	if options.MinTotal != nil {
+		builder.AndWhere("orders.total / orders.exchange_rate >= $minTotal")
+		builder.SetParameter("minTotal", options.MinTotal)
+	}
+	if options.MaxTotal != nil {
+		builder.AndWhere("orders.total / orders.exchange_rate <= $maxTotal")
+		builder.SetParameter("maxTotal", options.MaxTotal)
+	}
+	if options.Search != nil && strings.TrimSpace(*options.Search) != "" {
+		builder.LeftJoin("customers", "customers.id = orders.customer_id")
+		builder.LeftJoin("person", "person.id = customers.person_id")
+		builder.AndWhere(`(orders.id::text = $search
+			or concat_ws(' ', person.first_name, person.last_name) ilike '%' || $search || '%'
+			or person.email ilike '%' || $search || '%')`)
+		builder.SetParameter("search", strings.TrimSpace(*options.Search))
+	}
+
+	orderColumn := "id"
+	for _, column := range []string{"id", "order_date", "status_date", "total"} {
+		if options.OrderColumn == column {
+			orderColumn = column
+		}
+	}
+	orderDirection := "desc"
+	if options.OrderAsc {
+		orderDirection = "asc"
+	}
+	if orderColumn != "id" {
+		// Orders with the same value keep a stable order between pages
+		orderDirection += ", orders.id " + orderDirection
+	}
+	builder.OrderBy("orders."+orderColumn, orderDirection).
+		Limit(options.Limit).
+		Offset(options.Offset)
 
-	query, args := builder.Build()
+	query, args, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
 
 	rows, err := c.db.Connection.Query(c.db.Context, query, args...)
 	if err != nil {
@@ -116,6 +273,8 @@ func (c *OrderEntityStore) GetAll(options *OrderGetAllOptions) ([]OrderEntity, e
 	result := make([]OrderEntity, 0)
 	for rows.Next() {
 		var order OrderEntity
+		var exchangeRate string
+		var subtotal, discountTotal, taxTotal, shippingTotal, total pgtype.Numeric
 		err := rows.Scan(
 			&order.Id,
 			&order.OrderDate,
@@ -128,17 +287,305 @@ func (c *OrderEntityStore) GetAll(options *OrderGetAllOptions) ([]OrderEntity, e
 			&order.StatusDate,
 			&order.CreatedAt,
 			&order.UpdatedAt,
+			&order.Currency,
+			&exchangeRate,
+			&subtotal,
+			&discountTotal,
+			&taxTotal,
+			&order.ShippingMethodId,
+			&shippingTotal,
+			&total,
 		)
 		if err != nil {
 			return nil, err
 		}
 
+		order.ExchangeRate = json.Number(exchangeRate)
+		if order.Subtotal, err = money.FromNumeric(subtotal, order.Currency); err != nil {
+			return nil, err
+		}
+		if order.DiscountTotal, err = money.FromNumeric(discountTotal, order.Currency); err != nil {
+			return nil, err
+		}
+		if order.TaxTotal, err = money.FromNumeric(taxTotal, order.Currency); err != nil {
+			return nil, err
+		}
+		if order.ShippingTotal, err = money.FromNumeric(shippingTotal, order.Currency); err != nil {
+			return nil, err
+		}
+		if order.Total, err = money.FromNumeric(total, order.Currency); err != nil {
+			return nil, err
+		}
+
 		result = append(result, order)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadOrderDetails(result); err != nil {
+		return nil, err
+	}
 
 	return result, nil
 }
 
+func (c *OrderEntityStore) GetById(id int64) (*OrderEntity, error) {
+	orders, err := c.GetAll(&OrderGetAllOptions{Id: &id})
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, fmt.Errorf("order with id '%d' not found", id)
+	}
+	return &orders[0], nil
+}
+
+// Gets the order with its customer and the current details of the ordered variants
+func (c *OrderEntityStore) GetDetails(id int64) (*OrderEntity, error) {
+	order, err := c.GetById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	order.Customer, err = NewCustomerEntityStore(c.db).GetById(order.CustomerId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get customer: %w", err)
+	}
+
+	variantIds := make([]int64, 0, len(order.Items))
+	for _, item := range order.Items {
+		variantIds = append(variantIds, item.ProductVariantId)
+	}
+	rows, err := c.db.Connection.Query(c.db.Context, `
+		select "product_variants".id, "product_variants".sku, "product_variants".barcode, "products".id, "products".name,
+			"product_variants".price, "product_variants".stock - `+reservedStockSQL+`, "product_variants".weight
+		from "product_variants"
+		join "products" on "products".id = "product_variants".product_id
+		where "product_variants".id = any($1)`, variantIds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order variants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		variant := &ProductVariantEntity{}
+		var productId int64
+		var productName string
+		err := rows.Scan(
+			&variant.Id,
+			&variant.Sku,
+			&variant.Barcode,
+			&productId,
+			&productName,
+			&variant.Price,
+			&variant.Stock,
+			&variant.Weight,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range order.Items {
+			if item.ProductVariantId == variant.Id {
+				item.ProductVariant = variant
+				item.ProductId = productId
+				item.ProductName = productName
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	attributes, err := getVariantAttributes(c.db.Context, c.db.Connection, variantIds, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range order.Items {
+		if item.ProductVariant != nil {
+			item.ProductVariant.Attributes = attributes[item.ProductVariantId]
+		}
+	}
+	return order, nil
+}
+
+// Changes the delivery of a pending or processing order. The country cannot be changed
+// and a new zipcode must not change the order taxes, because the totals are already charged
+func (c *OrderEntityStore) UpdateDelivery(ctx context.Context, id int64, delivery *OrderDeliveryUpdate) error {
+	order, err := c.GetById(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	err = tx.QueryRow(ctx, `select status from "orders" where id = $1 for update`, id).Scan(&status)
+	if err != nil {
+		return fmt.Errorf("failed to get order: %w", err)
+	}
+	if status != OrderStatusPending && status != OrderStatusProcessing {
+		return fmt.Errorf("%w: delivery of %s order cannot be changed", ErrInvalidDeliveryUpdate, status)
+	}
+	if !strings.EqualFold(delivery.Country, order.DeliveryCountry) {
+		return fmt.Errorf("%w: delivery country cannot be changed", ErrInvalidDeliveryUpdate)
+	}
+
+	if delivery.Zipcode != order.DeliveryZipcode {
+		changed, err := c.txTaxesChanged(ctx, tx, order, TaxAddress{Country: order.DeliveryCountry, Zipcode: delivery.Zipcode})
+		if err != nil {
+			return err
+		}
+		if changed {
+			return fmt.Errorf("%w: the new zipcode changes the order taxes", ErrInvalidDeliveryUpdate)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		update "orders"
+		set delivery_address = $2, delivery_zipcode = $3, delivery_city = $4, updated_at = now()
+		where id = $1`, id, delivery.Address, delivery.Zipcode, delivery.City)
+	if err != nil {
+		return fmt.Errorf("failed to update order delivery: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Checks whether the order items would be taxed differently at the given address
+func (c *OrderEntityStore) txTaxesChanged(ctx context.Context, tx pgx.Tx, order *OrderEntity, address TaxAddress) (bool, error) {
+	lines, err := NewPromotionEntityStore(c.db).txGetDiscountLines(ctx, tx, order.Id, order.Currency)
+	if err != nil {
+		return false, err
+	}
+
+	items := make(map[int64]*OrderItemEntity, len(order.Items))
+	for _, item := range order.Items {
+		items[item.Id] = item
+	}
+	taxLines := make([]TaxLine, 0, len(lines))
+	for _, line := range lines {
+		taxLines = append(taxLines, TaxLine{
+			OrderItemId: line.OrderItemId,
+			ProductId:   line.ProductId,
+			CategoryIds: line.CategoryIds,
+			Amount:      items[line.OrderItemId].TaxBase,
+		})
+	}
+
+	taxes, err := c.TaxCalculator.Calculate(ctx, tx, address, taxLines)
+	if err != nil {
+		return false, err
+	}
+	for _, tax := range taxes {
+		item := items[tax.OrderItemId]
+		if item == nil || tax.Inclusive != item.TaxInclusive || tax.Amount.Amount != item.TaxAmount.Amount {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Loads items and adjustments of the given orders
+func (c *OrderEntityStore) loadOrderDetails(orders []OrderEntity) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ordersMap := make(map[int64]*OrderEntity, len(orders))
+	orderIds := make([]int64, 0, len(orders))
+	for i := range orders {
+		ordersMap[orders[i].Id] = &orders[i]
+		orderIds = append(orderIds, orders[i].Id)
+	}
+
+	rows, err := c.db.Connection.Query(c.db.Context, `
+		select id, order_id, product_variant_id, price, quantity, tax_base, tax_rate::text, tax_inclusive, tax_amount
+		from "order_items"
+		where order_id = any($1)
+		order by id`, orderIds)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &OrderItemEntity{}
+		var price, taxBase, taxAmount pgtype.Numeric
+		var taxRate string
+		err := rows.Scan(
+			&item.Id,
+			&item.OrderId,
+			&item.ProductVariantId,
+			&price,
+			&item.Quantity,
+			&taxBase,
+			&taxRate,
+			&item.TaxInclusive,
+			&taxAmount,
+		)
+		if err != nil {
+			return err
+		}
+		order := ordersMap[item.OrderId]
+		if item.Price, err = money.FromNumeric(price, order.Currency); err != nil {
+			return err
+		}
+		if item.TaxBase, err = money.FromNumeric(taxBase, order.Currency); err != nil {
+			return err
+		}
+		if item.TaxAmount, err = money.FromNumeric(taxAmount, order.Currency); err != nil {
+			return err
+		}
+		item.TaxRate = json.Number(taxRate)
+		order.Items = append(order.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range orders {
+		orders[i].TaxSummary = summarizeOrderTaxes(&orders[i])
+	}
+
+	rows, err = c.db.Connection.Query(c.db.Context, `
+		select id, order_id, order_item_id, promotion_id, type, description, amount
+		from "order_adjustments"
+		where order_id = any($1)
+		order by id`, orderIds)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		adjustment := &OrderAdjustmentEntity{}
+		var amount pgtype.Numeric
+		err := rows.Scan(
+			&adjustment.Id,
+			&adjustment.OrderId,
+			&adjustment.OrderItemId,
+			&adjustment.PromotionId,
+			&adjustment.Type,
+			&adjustment.Description,
+			&amount,
+		)
+		if err != nil {
+			return err
+		}
+		order := ordersMap[adjustment.OrderId]
+		if adjustment.Amount, err = money.FromNumeric(amount, order.Currency); err != nil {
+			return err
+		}
+		order.Adjustments = append(order.Adjustments, adjustment)
+	}
+
+	return rows.Err()
+}
+
 // Creates a new order in the database
 // This methods can create a new customer by given customer object if it does not exist
 func (c *OrderEntityStore) Create(ctx context.Context, options *OrderCreateUpdateOptions) (result *OrderEntity, err error) {
@@ -154,8 +601,19 @@ func (c *OrderEntityStore) Create(ctx context.Context, options *OrderCreateUpdat
 		return result, fmt.Errorf("failed to get customer: %w", err)
 	}
 
+	variantIds := make([]int64, 0, len(options.Items))
+	for _, item := range options.Items {
+		variantIds = append(variantIds, item.ProductVariantId)
+	}
+	prices, err := newPriceList(ctx, tx, options.Currency, variantIds)
+	if err != nil {
+		return result, err
+	}
+
 	result = &OrderEntity{
 		CustomerId:      customer.Id,
+		Currency:        prices.Currency,
+		ExchangeRate:    json.Number(prices.Rate.FloatString(8)),
 		Customer:        customer,
 		Status:          options.Status,
 		DeliveryAddress: options.Delivery.Address,
@@ -164,8 +622,9 @@ func (c *OrderEntityStore) Create(ctx context.Context, options *OrderCreateUpdat
 		DeliveryCountry: options.Delivery.Country,
 	}
 
-	if options.OrderDate == nil {
-		result.OrderDate = time.Now()
+	result.OrderDate = time.Now()
+	if options.OrderDate != nil {
+		result.OrderDate = *options.OrderDate
 	}
 
 	err = tx.QueryRow(c.db.Context, `
@@ -177,8 +636,10 @@ func (c *OrderEntityStore) Create(ctx context.Context, options *OrderCreateUpdat
 			delivery_city, 
 			delivery_country, 
 			status_date, 
-			order_date) 
-		values ($1, $2, $3, $4, $5, $6, $7, $8) 
+			order_date,
+			currency,
+			exchange_rate) 
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10::text::decimal) 
 		returning id, created_at, updated_at`,
 		options.CustomerId,
 		options.Status,
@@ -187,7 +648,9 @@ func (c *OrderEntityStore) Create(ctx context.Context, options *OrderCreateUpdat
 		options.Delivery.City,
 		options.Delivery.Country,
 		time.Now(),
-		options.OrderDate,
+		result.OrderDate,
+		result.Currency,
+		result.ExchangeRate.String(),
 	).Scan(&result.Id, &result.CreatedAt, &result.UpdatedAt)
 
 	if err != nil {
@@ -195,31 +658,278 @@ func (c *OrderEntityStore) Create(ctx context.Context, options *OrderCreateUpdat
 	}
 
 	for _, item := range options.Items {
-		itemResult, err := c.createOrderItem(tx, result.Id, item)
+		itemResult, err := c.createOrderItem(ctx, tx, result.Id, item, prices)
 		if err != nil {
 			return result, fmt.Errorf("failed to create order item: %w", err)
 		}
 		result.Items = append(result.Items, itemResult)
 	}
 
+	if err := c.txUpdateTotals(ctx, tx, result, options.PromotionCode, options.ShippingMethodId); err != nil {
+		return result, err
+	}
+
 	return result, tx.Commit(ctx)
 }
 
-func (c *OrderEntityStore) createOrderItem(tx pgx.Tx, orderId int64, item *orderItemCreateUpdate) (*OrderItemEntity, error) {
-	var result OrderItemEntity
-	err := tx.QueryRow(c.db.Context, `
-		with updated_stock as (
-		 	update "product_variants"
-			set stock = stock - $1
-			where id = $2
-			returning *
-		)
+// Calculates the order subtotal, applies the promotion code (if any), taxes and shipping and stores the totals
+func (c *OrderEntityStore) txUpdateTotals(ctx context.Context, tx pgx.Tx, order *OrderEntity, promotionCode string, shippingMethodId *int64) error {
+	subtotal := money.New(0, order.Currency)
+	for _, item := range order.Items {
+		subtotal = subtotal.Add(item.Price.Mul(int64(item.Quantity)))
+	}
+
+	discount := money.New(0, order.Currency)
+	if promotionCode != "" {
+		promotionStore := NewPromotionEntityStore(c.db)
+		applied, err := promotionStore.txApply(ctx, tx, order, promotionCode)
+		if err != nil {
+			return err
+		}
+		discount = applied
+	}
+
+	exclusiveTax, err := c.txApplyTaxes(ctx, tx, order)
+	if err != nil {
+		return err
+	}
+
+	order.ShippingTotal = money.New(0, order.Currency)
+	if shippingMethodId != nil {
+		if order.ShippingTotal, err = c.txGetShippingCost(ctx, tx, order, *shippingMethodId, subtotal.Sub(discount)); err != nil {
+			return err
+		}
+		order.ShippingMethodId = shippingMethodId
+	}
+
+	order.Subtotal = subtotal
+	order.DiscountTotal = discount
+	order.Total = subtotal.Sub(discount).Add(exclusiveTax).Add(order.ShippingTotal)
+
+	_, err = tx.Exec(ctx, `
+		update "orders"
+		set subtotal = $2, discount_total = $3, tax_total = $4, shipping_method_id = $5, shipping_total = $6, total = $7
+		where id = $1`,
+		order.Id, order.Subtotal, order.DiscountTotal, order.TaxTotal, order.ShippingMethodId, order.ShippingTotal, order.Total)
+	if err != nil {
+		return fmt.Errorf("failed to update order totals: %w", err)
+	}
+	return nil
+}
+
+// Returns the cost of the shipping method for the order items, value is the order value after discounts
+func (c *OrderEntityStore) txGetShippingCost(ctx context.Context, tx pgx.Tx, order *OrderEntity, shippingMethodId int64, value money.Money) (money.Money, error) {
+	var weight int64
+	err := tx.QueryRow(ctx, `
+		select coalesce(sum("product_variants".weight * "order_items".quantity), 0)
+		from "order_items"
+		join "product_variants" on "product_variants".id = "order_items".product_variant_id
+		where "order_items".order_id = $1`, order.Id).Scan(&weight)
+	if err != nil {
+		return money.Money{}, fmt.Errorf("failed to get order weight: %w", err)
+	}
+
+	rate, err := money.ParseRate(order.ExchangeRate.String())
+	if err != nil {
+		return money.Money{}, err
+	}
+	quotes, err := quoteShipping(ctx, tx, order.DeliveryCountry, weight, value, &priceList{Currency: order.Currency, Rate: rate})
+	if err != nil {
+		return money.Money{}, err
+	}
+	for _, quote := range quotes {
+		if quote.ShippingMethodId == shippingMethodId {
+			return quote.Price, nil
+		}
+	}
+	return money.Money{}, ErrShippingMethodUnavailable
+}
+
+// Calculates taxes of the order lines after discounts and stores them on the order items.
+// Returns the sum of exclusive taxes that has to be added to the order total
+func (c *OrderEntityStore) txApplyTaxes(ctx context.Context, tx pgx.Tx, order *OrderEntity) (money.Money, error) {
+	promotionStore := NewPromotionEntityStore(c.db)
+	lines, err := promotionStore.txGetDiscountLines(ctx, tx, order.Id, order.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	taxLines := make([]TaxLine, 0, len(lines))
+	for _, line := range discountedLines(lines, order.Adjustments) {
+		taxLines = append(taxLines, TaxLine{
+			OrderItemId: line.OrderItemId,
+			ProductId:   line.ProductId,
+			CategoryIds: line.CategoryIds,
+			Amount:      money.New(line.Amount, order.Currency),
+		})
+	}
+
+	taxes, err := c.TaxCalculator.Calculate(ctx, tx, TaxAddress{Country: order.DeliveryCountry, Zipcode: order.DeliveryZipcode}, taxLines)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	items := make(map[int64]*OrderItemEntity, len(order.Items))
+	for _, item := range order.Items {
+		items[item.Id] = item
+	}
+	bases := make(map[int64]money.Money, len(taxLines))
+	for _, line := range taxLines {
+		bases[line.OrderItemId] = line.Amount
+	}
+
+	order.TaxTotal = money.New(0, order.Currency)
+	exclusiveTax := money.New(0, order.Currency)
+	for _, tax := range taxes {
+		item, exists := items[tax.OrderItemId]
+		if !exists {
+			return money.Money{}, fmt.Errorf("tax calculated for unknown order item '%d'", tax.OrderItemId)
+		}
+		item.TaxBase = bases[tax.OrderItemId]
+		item.TaxRate = tax.Rate
+		item.TaxInclusive = tax.Inclusive
+		item.TaxAmount = tax.Amount
+
+		_, err := tx.Exec(ctx, `
+			update "order_items" set tax_base = $2, tax_rate = $3::text::decimal, tax_inclusive = $4, tax_amount = $5
+			where id = $1`, item.Id, item.TaxBase, item.TaxRate.String(), item.TaxInclusive, item.TaxAmount)
+		if err != nil {
+			return money.Money{}, fmt.Errorf("failed to update order item tax: %w", err)
+		}
+
+		order.TaxTotal = order.TaxTotal.Add(tax.Amount)
+		if !tax.Inclusive {
+			exclusiveTax = exclusiveTax.Add(tax.Amount)
+		}
+	}
+	order.TaxSummary = summarizeOrderTaxes(order)
+
+	return exclusiveTax, nil
+}
+
+// Returns the order lines with their discounts subtracted. Order-level discounts
+// are split between the lines proportionally, the last line gets the rounding remainder
+func discountedLines(lines []discountLine, adjustments []*OrderAdjustmentEntity) []discountLine {
+	result := make([]discountLine, len(lines))
+	copy(result, lines)
+
+	total := int64(0)
+	for _, line := range lines {
+		total += line.Amount
+	}
+
+	orderAdjustment := int64(0)
+	for _, adjustment := range adjustments {
+		if adjustment.OrderItemId == nil {
+			orderAdjustment += adjustment.Amount.Amount
+			continue
+		}
+		for i := range result {
+			if result[i].OrderItemId == *adjustment.OrderItemId {
+				result[i].Amount += adjustment.Amount.Amount
+			}
+		}
+	}
+
+	if orderAdjustment != 0 && total != 0 {
+		allocated := int64(0)
+		for i := range result {
+			amount := orderAdjustment - allocated
+			if i < len(result)-1 {
+				amount = orderAdjustment * lines[i].Amount / total
+			}
+			allocated += amount
+			result[i].Amount += amount
+		}
+	}
+	return result
+}
+
+// Updates the order status along the allowed transitions. Moving a pending order to processing
+// confirms its stock reservations, cancellation releases the reservations or returns the already sold stock.
+// The employee id is recorded in the stock ledger, it is nil for automatic status changes
+func (c *OrderEntityStore) UpdateStatus(ctx context.Context, id int64, status string, employeeId *int64) error {
+	tx, err := c.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var currentStatus string
+	err = tx.QueryRow(ctx, `select status from "orders" where id = $1 for update`, id).Scan(&currentStatus)
+	if err != nil {
+		return fmt.Errorf("failed to get order: %w", err)
+	}
+
+	// Repeated changes, e.g. a retried payment webhook, keep the order as it is
+	if currentStatus == status {
+		return nil
+	}
+	if !slices.Contains(orderStatusTransitions[currentStatus], status) {
+		return fmt.Errorf("%w: %s order cannot become %s", ErrInvalidStatusTransition, currentStatus, status)
+	}
+
+	reservationStore := NewInventoryReservationStore(c.db)
+	switch {
+	case currentStatus == OrderStatusPending && status == OrderStatusProcessing:
+		err = reservationStore.txConfirm(ctx, tx, id, employeeId)
+	case currentStatus == OrderStatusPending && status == OrderStatusCancelled:
+		err = reservationStore.txRelease(ctx, tx, id)
+	case currentStatus == OrderStatusProcessing && status == OrderStatusCancelled:
+		err = reservationStore.txCancelConfirmed(ctx, tx, id, employeeId)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		update "orders"
+		set status = $2, status_date = now(), updated_at = now()
+		where id = $1`, id, status)
+	if err != nil {
+		return fmt.Errorf("failed to update order status: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Creates an order item priced in the order currency and reserves the variant stock for it.
+// A sale effective at the order time sets the item price.
+// The stock itself is decremented only when the order moves to processing
+func (c *OrderEntityStore) createOrderItem(ctx context.Context, tx pgx.Tx, orderId int64, item *OrderItemCreateUpdate, prices *priceList) (*OrderItemEntity, error) {
+	reservationStore := NewInventoryReservationStore(c.db)
+	if err := reservationStore.txReserve(ctx, tx, orderId, item.ProductVariantId, item.Quantity); err != nil {
+		return nil, err
+	}
+
+	var defaultPrice money.Money
+	var published bool
+	err := tx.QueryRow(ctx, `
+		select "product_variants".price, `+publishedProductSQL+`
+		from "product_variants"
+		join "products" on "products".id = "product_variants".product_id
+		where "product_variants".id = $1`, item.ProductVariantId).Scan(&defaultPrice, &published)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant price: %w", err)
+	}
+	if !published {
+		return nil, fmt.Errorf("%w: variant %d", ErrProductNotPublished, item.ProductVariantId)
+	}
+
+	result := OrderItemEntity{
+		OrderId:          orderId,
+		ProductVariantId: item.ProductVariantId,
+		Price:            prices.variantPrice(item.ProductVariantId, defaultPrice),
+		Quantity:         uint32(item.Quantity),
+	}
+	err = tx.QueryRow(ctx, `
 		insert into "order_items" (order_id, product_variant_id, price, quantity)
-		values ($3, $2, (select price from updated_stock), $1)
+		values ($1, $2, $3, $4)
 		returning id`,
-		item.Quantity,
-		item.ProductVariantId,
 		orderId,
+		item.ProductVariantId,
+		result.Price,
+		item.Quantity,
 	).Scan(&result.Id)
 
 	if err != nil {
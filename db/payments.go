@@ -0,0 +1,390 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"netshop/main/tools/money"
+	"netshop/main/tools/payment"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrOrderNotPayable      = errors.New("order cannot be paid")
+	ErrIdempotencyKeyReused = errors.New("idempotency key is already used for another order")
+	ErrPaymentNotFound      = errors.New("payment not found")
+	ErrRefundExceedsPayment = errors.New("refund exceeds the captured payments")
+	ErrProviderUnavailable  = errors.New("payment provider is unavailable, retry with the same idempotency key")
+)
+
+// PaymentEntity is a single payment attempt of an order
+type PaymentEntity struct {
+	Id                int64       `json:"id"`
+	OrderId           int64       `json:"order_id"`
+	Provider          string      `json:"provider"`
+	ProviderPaymentId *string     `json:"provider_payment_id"`
+	IdempotencyKey    string      `json:"idempotency_key"`
+	Status            string      `json:"status"`
+	Amount            money.Money `json:"amount"`
+	RefundedAmount    money.Money `json:"refunded_amount"`
+	Message           string      `json:"message"`
+	CreatedAt         time.Time   `json:"created_at"`
+	UpdatedAt         time.Time   `json:"updated_at"`
+}
+
+type PaymentCreate struct {
+	OrderId        int64  `json:"-"`
+	CustomerId     int64  `json:"-"`
+	IdempotencyKey string `json:"-"`
+	// Payment method token issued by the client side SDK of the provider
+	Token string `json:"token"`
+}
+
+type PaymentStore struct {
+	db       *DatabaseConnection
+	Provider payment.PaymentProvider
+}
+
+func NewPaymentStore(database *DatabaseConnection, provider payment.PaymentProvider) *PaymentStore {
+	return &PaymentStore{
+		db:       database,
+		Provider: provider,
+	}
+}
+
+// Gets payments of the order, the customer id limits them to orders of the customer
+func (s *PaymentStore) GetByOrderId(orderId int64, customerId *int64) ([]PaymentEntity, error) {
+	rows, err := s.db.Connection.Query(s.db.Context, `
+		select `+paymentColumnsSQL+`
+		from "payments"
+		where order_id = $1
+			and ($2::integer is null or order_id in (select id from "orders" where customer_id = $2))
+		order by id`, orderId, customerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := make([]PaymentEntity, 0)
+	for rows.Next() {
+		entity, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *entity)
+	}
+	return payments, rows.Err()
+}
+
+// Pays the pending order of the customer: authorizes and captures the order total.
+// Repeated requests with the same idempotency key return the existing payment.
+// Declined payments are returned without error, the customer can retry with a new key.
+// Payments that did not reach the provider stay pending until they are retried with the same key
+func (s *PaymentStore) Pay(ctx context.Context, opts *PaymentCreate) (*PaymentEntity, error) {
+	if opts.IdempotencyKey == "" {
+		return nil, errors.New("idempotency key is required")
+	}
+
+	entity, created, err := s.create(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	// The provider deduplicates authorizations by the key, so an unanswered one is safe to repeat
+	if !created && (entity.Status != payment.StatusPending || entity.ProviderPaymentId != nil) {
+		return entity, nil
+	}
+
+	result, err := s.Provider.Authorize(ctx, &payment.AuthorizeRequest{
+		IdempotencyKey: opts.IdempotencyKey,
+		Amount:         entity.Amount,
+		Token:          opts.Token,
+		Description:    fmt.Sprintf("Order #%d", opts.OrderId),
+	})
+	if err != nil {
+		// Only the result status tells a decline, the provider may still have processed the request
+		entity.Message = err.Error()
+		if err := s.update(ctx, entity); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrProviderUnavailable, err.Error())
+	}
+	if err := s.applyResult(ctx, entity, result); err != nil {
+		return nil, err
+	}
+
+	return entity, s.completeAuthorized(ctx, entity)
+}
+
+// Creates a pending payment of the order total. The order row is locked, so concurrent requests
+// cannot start two payments of the same order. Returns false with the existing payment when
+// the idempotency key is already used for the order
+func (s *PaymentStore) create(ctx context.Context, opts *PaymentCreate) (*PaymentEntity, bool, error) {
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var status, currency string
+	var customerId int64
+	var total pgtype.Numeric
+	err = tx.QueryRow(ctx, `
+		select status, customer_id, currency, total from "orders" where id = $1 for update`, opts.OrderId,
+	).Scan(&status, &customerId, &currency, &total)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && customerId != opts.CustomerId) {
+		return nil, false, fmt.Errorf("order with id '%d' not found", opts.OrderId)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get order: %w", err)
+	}
+
+	// Checked after the lock, so a concurrent request with the same key gets the payment it created
+	if existing, err := s.getByIdempotencyKey(ctx, tx, opts.IdempotencyKey); err == nil {
+		if existing.OrderId != opts.OrderId {
+			return nil, false, ErrIdempotencyKeyReused
+		}
+		return existing, false, nil
+	} else if !errors.Is(err, ErrPaymentNotFound) {
+		return nil, false, err
+	}
+
+	if status != OrderStatusPending {
+		return nil, false, fmt.Errorf("%w: order is %s", ErrOrderNotPayable, status)
+	}
+	amount, err := money.FromNumeric(total, currency)
+	if err != nil {
+		return nil, false, err
+	}
+	if amount.Amount <= 0 {
+		return nil, false, fmt.Errorf("%w: order total is %s", ErrOrderNotPayable, amount)
+	}
+
+	var inProgress bool
+	err = tx.QueryRow(ctx, `
+		select exists(
+			select 1 from "payments"
+			where order_id = $1 and status in ('pending', 'authorized', 'captured')
+		)`, opts.OrderId).Scan(&inProgress)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to check payments: %w", err)
+	}
+	if inProgress {
+		return nil, false, fmt.Errorf("%w: order is already paid or has a payment in progress", ErrOrderNotPayable)
+	}
+
+	entity, err := scanPayment(tx.QueryRow(ctx, `
+		insert into "payments" (order_id, provider, idempotency_key, amount, currency)
+		values ($1, $2, $3, $4, $5)
+		returning `+paymentColumnsSQL,
+		opts.OrderId, s.Provider.Name(), opts.IdempotencyKey, amount, amount.Currency))
+
+	// Payments of the same order are serialized by the lock, so the key is used by another order
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "payments_idempotency_key_key" {
+		return nil, false, ErrIdempotencyKeyReused
+	}
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, false, fmt.Errorf("%w: order is already paid or has a payment in progress", ErrOrderNotPayable)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create payment: %w", err)
+	}
+
+	return entity, true, tx.Commit(ctx)
+}
+
+// Applies a verified webhook event of the provider. Repeated events are ignored
+func (s *PaymentStore) HandleWebhook(ctx context.Context, event *payment.WebhookEvent) error {
+	entity, err := scanPayment(s.db.Connection.QueryRow(ctx, `
+		select `+paymentColumnsSQL+`
+		from "payments"
+		where provider = $1 and provider_payment_id = $2`, s.Provider.Name(), event.ProviderPaymentId))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrPaymentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	if entity.Status == event.Status || entity.Status == payment.StatusDeclined || entity.Status == payment.StatusRefunded {
+		return nil
+	}
+
+	err = s.applyResult(ctx, entity, &payment.Result{
+		ProviderPaymentId: event.ProviderPaymentId,
+		Status:            event.Status,
+		Message:           event.Message,
+	})
+	if err != nil {
+		return err
+	}
+	return s.completeAuthorized(ctx, entity)
+}
+
+// Refunds all captured payments of the order, used when the order is cancelled
+func (s *PaymentStore) RefundOrder(ctx context.Context, orderId int64) error {
+	payments, err := s.GetByOrderId(orderId, nil)
+	if err != nil {
+		return err
+	}
+
+	for i := range payments {
+		if payments[i].Status == payment.StatusCaptured {
+			if err := s.refund(ctx, &payments[i], payments[i].Amount.Sub(payments[i].RefundedAmount)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Refunds a part of the captured payments of the order, e.g. for returned items
+func (s *PaymentStore) RefundAmount(ctx context.Context, orderId int64, amount money.Money) error {
+	payments, err := s.GetByOrderId(orderId, nil)
+	if err != nil {
+		return err
+	}
+
+	available := money.New(0, amount.Currency)
+	for _, entity := range payments {
+		if entity.Status == payment.StatusCaptured {
+			available = available.Add(entity.Amount.Sub(entity.RefundedAmount))
+		}
+	}
+	if available.Amount < amount.Amount {
+		return fmt.Errorf("%w: %s left to refund", ErrRefundExceedsPayment, available.String())
+	}
+
+	remaining := amount
+	for i := range payments {
+		if remaining.Amount <= 0 {
+			break
+		}
+		if payments[i].Status != payment.StatusCaptured {
+			continue
+		}
+		part := payments[i].Amount.Sub(payments[i].RefundedAmount)
+		if part.Amount > remaining.Amount {
+			part = remaining
+		}
+		if err := s.refund(ctx, &payments[i], part); err != nil {
+			return err
+		}
+		remaining = remaining.Sub(part)
+	}
+	return nil
+}
+
+// Captures authorized payments and moves the paid order to processing
+func (s *PaymentStore) completeAuthorized(ctx context.Context, entity *PaymentEntity) error {
+	if entity.Status == payment.StatusAuthorized {
+		result, err := s.Provider.Capture(ctx, *entity.ProviderPaymentId, entity.Amount)
+		if err != nil {
+			return fmt.Errorf("failed to capture payment: %w", err)
+		}
+		if err := s.applyResult(ctx, entity, result); err != nil {
+			return err
+		}
+	}
+	if entity.Status != payment.StatusCaptured {
+		return nil
+	}
+
+	orderStore := NewOrderEntity(s.db)
+	err := orderStore.UpdateStatus(ctx, entity.OrderId, OrderStatusProcessing, nil)
+	if errors.Is(err, ErrReservationExpired) || errors.Is(err, ErrInvalidStatusTransition) {
+		// The stock is not held anymore or the order is cancelled, so the money is returned
+		log.Printf("Order %d cannot be fulfilled after the payment, refunding payment %d: %s", entity.OrderId, entity.Id, err.Error())
+		if err := s.refund(ctx, entity, entity.Amount.Sub(entity.RefundedAmount)); err != nil {
+			return err
+		}
+		err = orderStore.UpdateStatus(ctx, entity.OrderId, OrderStatusCancelled, nil)
+		if errors.Is(err, ErrInvalidStatusTransition) {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *PaymentStore) refund(ctx context.Context, entity *PaymentEntity, amount money.Money) error {
+	result, err := s.Provider.Refund(ctx, *entity.ProviderPaymentId, amount)
+	if err != nil {
+		return fmt.Errorf("failed to refund payment %d: %w", entity.Id, err)
+	}
+	entity.RefundedAmount = entity.RefundedAmount.Add(amount)
+	return s.applyResult(ctx, entity, result)
+}
+
+func (s *PaymentStore) applyResult(ctx context.Context, entity *PaymentEntity, result *payment.Result) error {
+	entity.ProviderPaymentId = &result.ProviderPaymentId
+	entity.Status = result.Status
+	if result.Message != "" {
+		entity.Message = result.Message
+	}
+	return s.update(ctx, entity)
+}
+
+func (s *PaymentStore) update(ctx context.Context, entity *PaymentEntity) error {
+	err := s.db.Connection.QueryRow(ctx, `
+		update "payments"
+		set provider_payment_id = $2, status = $3, refunded_amount = $4, message = $5, updated_at = now()
+		where id = $1
+		returning updated_at`,
+		entity.Id, entity.ProviderPaymentId, entity.Status, entity.RefundedAmount, entity.Message,
+	).Scan(&entity.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update payment: %w", err)
+	}
+	return nil
+}
+
+func (s *PaymentStore) getByIdempotencyKey(ctx context.Context, q querier, key string) (*PaymentEntity, error) {
+	entity, err := scanPayment(q.QueryRow(ctx, `
+		select `+paymentColumnsSQL+` from "payments" where idempotency_key = $1`, key))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPaymentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+	return entity, nil
+}
+
+const paymentColumnsSQL = `id, order_id, provider, provider_payment_id, idempotency_key, status,
+	amount, refunded_amount, currency, message, created_at, updated_at`
+
+func scanPayment(row pgx.Row) (*PaymentEntity, error) {
+	var entity PaymentEntity
+	var amount, refundedAmount pgtype.Numeric
+	var currency string
+	err := row.Scan(
+		&entity.Id,
+		&entity.OrderId,
+		&entity.Provider,
+		&entity.ProviderPaymentId,
+		&entity.IdempotencyKey,
+		&entity.Status,
+		&amount,
+		&refundedAmount,
+		&currency,
+		&entity.Message,
+		&entity.CreatedAt,
+		&entity.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if entity.Amount, err = money.FromNumeric(amount, currency); err != nil {
+		return nil, err
+	}
+	if entity.RefundedAmount, err = money.FromNumeric(refundedAmount, currency); err != nil {
+		return nil, err
+	}
+	return &entity, nil
+}
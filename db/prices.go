@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"netshop/main/tools/money"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Common part of the connection pool and transactions used by helpers that work with both
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Prices of the variants in a single currency: active sale prices, explicit variant prices
+// and the default currency price converted by the exchange rate, in this order
+type priceList struct {
+	Currency string
+	Rate     *big.Rat
+	prices   map[int64]money.Money
+	// Active sales of the variants in the default currency
+	sales map[int64]VariantPriceEntity
+}
+
+// Loads the exchange rate, the active sales and explicit prices of the given variants.
+// Empty currency means the default currency
+func newPriceList(ctx context.Context, q querier, currency string, variantIds []int64) (*priceList, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		currency = money.DefaultCurrency()
+	}
+
+	rate, err := getExchangeRate(ctx, q, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &priceList{Currency: currency, Rate: rate, prices: make(map[int64]money.Money), sales: make(map[int64]VariantPriceEntity)}
+	if len(variantIds) == 0 {
+		return list, nil
+	}
+
+	// The latest started sale wins when sales overlap
+	rows, err := q.Query(ctx, `
+		select distinct on (product_variant_id) `+variantPriceColumnsSQL+`
+		from "variant_prices"
+		where product_variant_id = any($1) and `+activeSalePriceSQL+`
+		order by product_variant_id, effective_from desc, id desc`, variantIds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sale prices: %w", err)
+	}
+	sales, err := scanVariantPrices(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, sale := range sales {
+		list.sales[sale.ProductVariantId] = sale
+	}
+	if currency == money.DefaultCurrency() {
+		return list, nil
+	}
+
+	rows, err = q.Query(ctx, `
+		select product_variant_id, price
+		from "product_variant_prices"
+		where currency = $1 and product_variant_id = any($2)`, currency, variantIds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant prices: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variantId int64
+		price := money.New(0, currency)
+		if err := rows.Scan(&variantId, &price); err != nil {
+			return nil, err
+		}
+		list.prices[variantId] = price
+	}
+	return list, rows.Err()
+}
+
+// Converts the default currency amount to the currency of the price list
+func (l *priceList) convert(amount money.Money) money.Money {
+	if amount.Currency == l.Currency {
+		return amount
+	}
+	return amount.Convert(l.Currency, l.Rate)
+}
+
+// Returns the converted sale price, the explicit variant price or the converted default currency price
+func (l *priceList) variantPrice(variantId int64, defaultPrice money.Money) money.Money {
+	if sale, exists := l.sales[variantId]; exists {
+		return l.convert(sale.Price)
+	}
+	return l.regularPrice(variantId, defaultPrice)
+}
+
+// Returns the strike-through price of the variant on sale, nil when the variant is not on sale
+func (l *priceList) compareAtPrice(variantId int64, defaultPrice money.Money) *money.Money {
+	sale, exists := l.sales[variantId]
+	if !exists {
+		return nil
+	}
+	price := l.regularPrice(variantId, defaultPrice)
+	if sale.CompareAtPrice != nil {
+		price = l.convert(*sale.CompareAtPrice)
+	}
+	return &price
+}
+
+// Returns the variant price ignoring the sales
+func (l *priceList) regularPrice(variantId int64, defaultPrice money.Money) money.Money {
+	if price, exists := l.prices[variantId]; exists {
+		return price
+	}
+	return l.convert(defaultPrice)
+}
+
+// Sets the explicit price of the variant in the currency of the price
+func (p *ProductEntityStore) SetVariantPrice(ctx context.Context, productId, variantId int64, price money.Money) error {
+	if price.Currency == money.DefaultCurrency() {
+		return fmt.Errorf("price in the default currency is the variant price itself")
+	}
+	if price.Amount < 0 {
+		return fmt.Errorf("price must not be negative")
+	}
+
+	tag, err := p.db.Connection.Exec(ctx, `
+		insert into "product_variant_prices" (product_variant_id, currency, price)
+		select id, $3, $4 from "product_variants" where id = $2 and product_id = $1
+		on conflict (product_variant_id, currency) do update set price = excluded.price`,
+		productId, variantId, price.Currency, price)
+	if err != nil {
+		return fmt.Errorf("failed to set variant price: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("product variant with id '%d' not found", variantId)
+	}
+	return nil
+}
+
+// Removes the explicit price, so the converted default price is used again
+func (p *ProductEntityStore) DeleteVariantPrice(ctx context.Context, productId, variantId int64, currency string) error {
+	_, err := p.db.Connection.Exec(ctx, `
+		delete from "product_variant_prices"
+		where product_variant_id = $2 and currency = $3
+			and exists(select 1 from "product_variants" where id = $2 and product_id = $1)`,
+		productId, variantId, strings.ToUpper(currency))
+	if err != nil {
+		return fmt.Errorf("failed to delete variant price: %w", err)
+	}
+	return nil
+}
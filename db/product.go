@@ -5,38 +5,63 @@ import (
 	"errors"
 	"fmt"
 	"netshop/main/config"
+	"netshop/main/tools/money"
 	"path"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+var ErrDuplicateVariant = errors.New("product already has a variant with the same attributes")
+
 type ProductVariantEntity struct {
 	Id        int64       `json:"id"`
-	Size      SizeEntity  `json:"size"`
-	Color     ColorEntity `json:"color"`
-	Price     float64     `json:"price"`
+	Sku       string      `json:"sku"`
+	Barcode   *string     `json:"barcode,omitempty"`
+	Price     money.Money `json:"price"`
 	Stock     int32       `json:"stock"`
+	Weight    int32       `json:"weight"`
 	ImageUrls []string    `json:"image_urls"`
+	// Strike-through price of the variant on sale
+	CompareAtPrice *money.Money `json:"compare_at_price,omitempty"`
+	// Values of the generic attributes, e.g. material or capacity
+	Attributes []VariantAttributeValue `json:"attributes,omitempty"`
 }
 
 type ProductEntity struct {
-	Name        string                  `json:"name"`
-	Description string                  `json:"description"`
-	Id          int64                   `json:"id"`
-	BasePrice   float64                 `json:"base_price"`
-	Category    CategoryEntity          `json:"category"`
-	CreatedAt   time.Time               `json:"created_at"`
+	Name        string         `json:"name"`
+	Slug        string         `json:"slug"`
+	Description string         `json:"description"`
+	Id          int64          `json:"id"`
+	BasePrice   money.Money    `json:"base_price"`
+	Category    CategoryEntity `json:"category"`
+	CreatedAt   time.Time      `json:"created_at"`
+	// Average rating and number of approved reviews, the average is nil without reviews
+	RatingAverage *float64 `json:"rating_average"`
+	RatingCount   int32    `json:"rating_count"`
+	// draft, scheduled, published or archived
+	Status      string                  `json:"status"`
+	PublishAt   *time.Time              `json:"publish_at,omitempty"`
+	UnpublishAt *time.Time              `json:"unpublish_at,omitempty"`
 	Variants    []*ProductVariantEntity `json:"variants"`
 }
 
 type ProductGetEntitiesQueryOpts struct {
-	CategoryIds []int64  `json:"category_ids,omitempty"`
-	SizeIds     []int64  `json:"size_ids,omitempty"`
-	ColorIds    []int64  `json:"color_ids,omitempty"`
-	MinPrice    *float64 `json:"min_price,omitempty"`
-	MaxPrice    *float64 `json:"max_price,omitempty"`
+	CategoryIds []int64      `json:"category_ids,omitempty"`
+	MinPrice    *money.Money `json:"min_price,omitempty"`
+	MaxPrice    *money.Money `json:"max_price,omitempty"`
+	// Attribute values by attribute code. A variant matches when it has one of the values
+	// of every attribute
+	Attributes map[string][]string `json:"attributes,omitempty"`
+	// Minimum average rating of approved reviews
+	MinRating *float64 `json:"min_rating,omitempty"`
+	// Product statuses, only published products are returned when empty
+	Statuses []string `json:"statuses,omitempty"`
+	// Full-text search query matched against the names and descriptions in the base language
+	// and the languages of the fallback chain
+	Search string `json:"search,omitempty"`
 }
 
 type ProductGetEntitiesOptions struct {
@@ -49,27 +74,70 @@ type ProductGetEntitiesOptions struct {
 	// Offset is the number of products to skip. If 0, no offset is applied
 	Offset int64
 
-	// If OrderColumn is empty, default "id" is used
+	// If OrderColumn is empty, default "id" is used. Products without reviews are the last
+	// when ordered by rating
 	OrderColumn string
 	// If OrderDesc is false, default descending order is used
 	OrderAsc bool
+
+	// Currency of the returned prices. If empty, the default currency is used.
+	// Price filters are always in the default currency and apply to the sale prices of variants on sale
+	Currency string
+
+	// Fallback chain of the languages of the returned texts. If empty, the base texts are returned
+	Languages []string
 }
 
 type ProductVariantCreateUpdate struct {
 	FileIds []int64 `json:"file_ids"`
-	SizeId  int64   `json:"size_id"`
-	ColorId int64   `json:"color_id"`
-	Price   float64 `json:"price"`
-	Stock   int32   `json:"stock"`
+	// Generated when empty
+	Sku string `json:"sku"`
+	// Optional GTIN-8, GTIN-12, GTIN-13 or GTIN-14 barcode
+	Barcode string      `json:"barcode"`
+	Price   money.Money `json:"price"`
+	Stock   int32       `json:"stock"`
+	// Weight in grams, used for shipping rates
+	Weight int32 `json:"weight"`
+	// Attribute values by attribute code, e.g. size and color. Variants of a product differ by attribute values
+	Attributes map[string]any `json:"attributes,omitempty"`
 }
 
 type ProductCreateUpdate struct {
-	Name        string                       `json:"name"`
+	Name string `json:"name"`
+	// Generated from the name when empty
+	Slug        string                       `json:"slug"`
 	Description string                       `json:"description"`
 	CategoryId  int64                        `json:"category_id"`
 	EmployeeId  int64                        `json:"employee_id"`
-	BasePrice   float64                      `json:"base_price"`
+	BasePrice   money.Money                  `json:"base_price"`
 	Variants    []ProductVariantCreateUpdate `json:"variants"`
+	// New products are drafts when empty
+	Status      string     `json:"status"`
+	PublishAt   *time.Time `json:"publish_at"`
+	UnpublishAt *time.Time `json:"unpublish_at"`
+}
+
+// productPatch holds the changed fields of a product, nil fields keep their values
+type productPatch struct {
+	Name        *string
+	Description *string
+	CategoryId  *int64
+	BasePrice   *money.Money
+}
+
+// variantPatch holds the changed fields of a product variant, nil fields keep their values
+type variantPatch struct {
+	Sku *string
+	// An empty barcode removes the barcode
+	Barcode *string
+	Price   *money.Money
+	Stock   *int32
+	Weight  *int32
+	// Nil keeps the images, an empty slice removes them
+	FileIds []int64
+	// Reason of the stock ledger record when the stock changes and the price history
+	// record when the price changes
+	Reason string
 }
 
 type ProductEntityStore struct {
@@ -82,13 +150,35 @@ func NewProductEntityStore(database *DatabaseConnection) *ProductEntityStore {
 	}
 }
 
-func (p *ProductEntityStore) GetById(id int64) (ProductEntity, error) {
-	row := p.db.Connection.QueryRow(p.db.Context, `SELECT "id", "name", "description", "base_price" FROM "products" WHERE id = $1`, id)
+// Returns the product with the base price in the given currency (empty means the default currency).
+// Products that are not published are returned only for previews of employees
+func (p *ProductEntityStore) GetById(id int64, currency string, languages []string, preview bool) (ProductEntity, error) {
+	row := p.db.Connection.QueryRow(p.db.Context, `
+		SELECT "id", "name", "slug", "description", "base_price", "rating_average"::float8, "rating_count",
+			"status"::text, "publish_at", "unpublish_at"
+		FROM "products"
+		WHERE id = $1 AND ($2 OR `+publishedProductSQL+`)`, id, preview)
 	var product ProductEntity
-	err := row.Scan(&product.Id, &product.Name, &product.Description, &product.BasePrice)
+	err := row.Scan(&product.Id, &product.Name, &product.Slug, &product.Description, &product.BasePrice, &product.RatingAverage, &product.RatingCount,
+		&product.Status, &product.PublishAt, &product.UnpublishAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ProductEntity{}, ErrProductNotFound
+	}
+	if err != nil {
+		return ProductEntity{}, err
+	}
+
+	prices, err := newPriceList(p.db.Context, p.db.Connection, currency, nil)
+	if err != nil {
+		return ProductEntity{}, err
+	}
+	texts, err := newTranslationList(p.db.Context, p.db.Connection, TranslationProduct, []int64{product.Id}, languages)
 	if err != nil {
 		return ProductEntity{}, err
 	}
+	product.Name = texts.name(product.Id, product.Name)
+	product.Description = texts.description(product.Id, product.Description)
+	product.BasePrice = prices.convert(product.BasePrice)
 	return product, nil
 }
 
@@ -97,29 +187,33 @@ func (p *ProductEntityStore) GetEntities(opts *ProductGetEntitiesOptions) ([]Pro
 	query.WriteString(`SELECT
 		"products"."id",
 		"products"."name",
+		"products"."slug",
 		"products"."description",
 		"products"."base_price",
 		"products"."created_at",
+		"products"."rating_average"::float8,
+		"products"."rating_count",
+		"products"."status"::text,
+		"products"."publish_at",
+		"products"."unpublish_at",
 		"products"."category_id",
 		"categories"."name",
 		"product_variants"."id",
-		"product_variants"."size_id",
-		"product_variants"."color_id",
+		"product_variants"."sku",
+		"product_variants"."barcode",
 		"product_variants"."price",
-		"product_variants"."stock",
-		"sizes"."name",
-		"colors"."name",
+		"product_variants"."stock" - ` + reservedStockSQL + `,
+		"product_variants"."weight",
 		"files"."path" as "image_path"
 	FROM "products"
 	JOIN "categories" on "products"."category_id" = "categories"."id"
 	JOIN "product_variants" on "products"."id" = "product_variants"."product_id"
-	JOIN "sizes" on "product_variants"."size_id" = "sizes"."id"
-	JOIN "colors" on "product_variants"."color_id" = "colors"."id"
 	JOIN "product_variant_images" on "product_variant_images"."product_variant_id" = "product_variants"."id"
 	JOIN "files" on "files"."id" = "product_variant_images"."file_id"
 	`)
 
 	whereConditions := []string{}
+	args := []any{}
 	convertToSqlSeq := func(ids []int64) string {
 		return strings.Trim(strings.Join(strings.Fields(fmt.Sprint(ids)), ","), "[]")
 	}
@@ -127,30 +221,50 @@ func (p *ProductEntityStore) GetEntities(opts *ProductGetEntitiesOptions) ([]Pro
 		whereConditions = append(whereConditions, condition)
 	}
 
+	statuses := []string{ProductStatusPublished}
+	if opts != nil && opts.Query != nil && len(opts.Query.Statuses) > 0 {
+		statuses = opts.Query.Statuses
+	}
+	args = append(args, statuses)
+	addWhere(fmt.Sprintf(`"products"."status"::text = any($%d)`, len(args)))
+
 	if opts != nil {
 		if opts.Query != nil {
 			if len(opts.Query.CategoryIds) > 0 {
-				addWhere(fmt.Sprintf(`"products"."category_id" in (%s)`, convertToSqlSeq(opts.Query.CategoryIds)))
-			}
-			if len(opts.Query.SizeIds) > 0 {
-				addWhere(fmt.Sprintf(`"product_variants"."size_id" in (%s)`, convertToSqlSeq(opts.Query.SizeIds)))
-			}
-			if len(opts.Query.ColorIds) > 0 {
-				addWhere(fmt.Sprintf(`"product_variants"."color_id" in (%s)`, convertToSqlSeq(opts.Query.ColorIds)))
+				// Products of subcategories belong to the parent categories too
+				addWhere(fmt.Sprintf(`"products"."category_id" in (%s)`, categorySubtreeSQL(convertToSqlSeq(opts.Query.CategoryIds))))
 			}
 			if opts.Query.MinPrice != nil {
-				addWhere(fmt.Sprintf(`"product_variants"."price" >= %f`, *opts.Query.MinPrice))
+				args = append(args, *opts.Query.MinPrice)
+				addWhere(fmt.Sprintf(`%s >= $%d`, effectiveVariantPriceSQL, len(args)))
 			}
 			if opts.Query.MaxPrice != nil {
-				addWhere(fmt.Sprintf(`"product_variants"."price" <= %f`, *opts.Query.MaxPrice))
+				args = append(args, *opts.Query.MaxPrice)
+				addWhere(fmt.Sprintf(`%s <= $%d`, effectiveVariantPriceSQL, len(args)))
+			}
+			if search := strings.TrimSpace(opts.Query.Search); search != "" {
+				args = append(args, search, opts.Languages)
+				addWhere(productSearchSQL(len(args)-1, len(args)))
+			}
+			if opts.Query.MinRating != nil {
+				args = append(args, *opts.Query.MinRating)
+				addWhere(fmt.Sprintf(`"products"."rating_average" >= $%d`, len(args)))
+			}
+			for code, values := range opts.Query.Attributes {
+				lowerValues := make([]string, 0, len(values))
+				for _, value := range values {
+					lowerValues = append(lowerValues, strings.ToLower(value))
+				}
+				args = append(args, strings.ToLower(code), lowerValues)
+				addWhere(fmt.Sprintf(`exists(select 1 from "product_variant_attributes"
+					join "attributes" on "attributes"."id" = "product_variant_attributes"."attribute_id"
+					left join "attribute_options" on "attribute_options"."id" = "product_variant_attributes"."option_id"
+					where "product_variant_attributes"."product_variant_id" = "product_variants"."id"
+						and "attributes"."code" = $%d
+						and lower(coalesce("attribute_options"."value", "product_variant_attributes"."value_text",
+							"product_variant_attributes"."value_number"::text, "product_variant_attributes"."value_boolean"::text)) = any($%d))`,
+					len(args)-1, len(args)))
 			}
-		}
-
-		if opts.Limit > 0 {
-			query.WriteString(fmt.Sprintf(" limit %d", opts.Limit))
-		}
-		if opts.Offset > 0 {
-			query.WriteString(fmt.Sprintf(" offset %d", opts.Offset))
 		}
 	}
 
@@ -167,7 +281,7 @@ func (p *ProductEntityStore) GetEntities(opts *ProductGetEntitiesOptions) ([]Pro
 	}
 
 	if opts.OrderColumn != "" {
-		orderColumns := []string{"id", "name", "base_price", "created_at"}
+		orderColumns := []string{"id", "name", "base_price", "created_at", "rating_average", "rating_count"}
 		for _, column := range orderColumns {
 			if opts.OrderColumn == column {
 				orderColumn = column
@@ -176,30 +290,52 @@ func (p *ProductEntityStore) GetEntities(opts *ProductGetEntitiesOptions) ([]Pro
 		}
 	}
 
-	query.WriteString(fmt.Sprintf(` order by "products"."%s" %s`, orderColumn, orderDirection))
+	orderExpression := fmt.Sprintf(`"products"."%s"`, orderColumn)
+	if orderColumn == "name" && len(opts.Languages) > 0 {
+		args = append(args, opts.Languages)
+		orderExpression = fmt.Sprintf(`coalesce((
+			select "product_translations"."name" from "product_translations"
+			where "product_translations"."product_id" = "products"."id" and "product_translations"."language" = any($%[1]d)
+			order by array_position($%[1]d::text[], "product_translations"."language"::text)
+			limit 1
+		), "products"."name")`, len(args))
+	}
+	query.WriteString(fmt.Sprintf(` order by %s %s nulls last, "products"."id" desc`, orderExpression, orderDirection))
+	if opts.Limit > 0 {
+		query.WriteString(fmt.Sprintf(" limit %d", opts.Limit))
+	}
+	if opts.Offset > 0 {
+		query.WriteString(fmt.Sprintf(" offset %d", opts.Offset))
+	}
 
-	rows, err := p.db.Connection.Query(p.db.Context, query.String())
+	rows, err := p.db.Connection.Query(p.db.Context, query.String(), args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	productsMap := make(map[int64]*ProductEntity)
+	// Product ids in the query order
+	productIds := make([]int64, 0)
 	productsVariantMap := make(map[int64]map[int64]*ProductVariantEntity)
 
 	for rows.Next() {
-		var productId, variantId, sizeId, colorId int64
-		var productName, productDescription, categoryName, sizeName, colorName string
-		var basePrice, variantPrice float64
+		var productId, variantId int64
+		var productName, productSlug, productDescription, categoryName, sku string
+		var barcode *string
+		var basePrice, variantPrice money.Money
 		var categoryId int64
-		var stock int32
+		var stock, weight int32
 		var imagePath string
 		var createdAt time.Time
+		var ratingAverage *float64
+		var ratingCount int32
+		var status string
+		var publishAt, unpublishAt *time.Time
 
 		err := rows.Scan(
-			&productId, &productName, &productDescription, &basePrice, &createdAt, &categoryId, &categoryName,
-			&variantId, &sizeId, &colorId, &variantPrice, &stock,
-			&sizeName, &colorName, &imagePath,
+			&productId, &productName, &productSlug, &productDescription, &basePrice, &createdAt, &ratingAverage, &ratingCount, &status, &publishAt, &unpublishAt, &categoryId, &categoryName,
+			&variantId, &sku, &barcode, &variantPrice, &stock, &weight, &imagePath,
 		)
 		if err != nil {
 			return nil, err
@@ -208,14 +344,21 @@ func (p *ProductEntityStore) GetEntities(opts *ProductGetEntitiesOptions) ([]Pro
 		product, exists := productsMap[productId]
 		if !exists {
 			product = &ProductEntity{
-				Id:          productId,
-				Name:        productName,
-				Description: productDescription,
-				BasePrice:   basePrice,
-				CreatedAt:   createdAt,
-				Category:    CategoryEntity{Id: categoryId, Name: categoryName},
+				Id:            productId,
+				Name:          productName,
+				Slug:          productSlug,
+				Description:   productDescription,
+				BasePrice:     basePrice,
+				CreatedAt:     createdAt,
+				RatingAverage: ratingAverage,
+				RatingCount:   ratingCount,
+				Status:        status,
+				PublishAt:     publishAt,
+				UnpublishAt:   unpublishAt,
+				Category:      CategoryEntity{Id: categoryId, Name: categoryName},
 			}
 			productsMap[productId] = product
+			productIds = append(productIds, productId)
 			productsVariantMap[productId] = make(map[int64]*ProductVariantEntity)
 		}
 
@@ -223,10 +366,11 @@ func (p *ProductEntityStore) GetEntities(opts *ProductGetEntitiesOptions) ([]Pro
 		if !exists {
 			variant = &ProductVariantEntity{
 				Id:        variantId,
-				Size:      SizeEntity{Id: sizeId, Name: sizeName},
-				Color:     ColorEntity{Id: colorId, Name: colorName},
+				Sku:       sku,
+				Barcode:   barcode,
 				Price:     variantPrice,
 				Stock:     stock,
+				Weight:    weight,
 				ImageUrls: []string{},
 			}
 			product.Variants = append(product.Variants, variant)
@@ -235,8 +379,51 @@ func (p *ProductEntityStore) GetEntities(opts *ProductGetEntitiesOptions) ([]Pro
 		variant.ImageUrls = append(variant.ImageUrls, getImageURLFromPath(imagePath))
 	}
 
+	currency := ""
+	var languages []string
+	if opts != nil {
+		currency = opts.Currency
+		languages = opts.Languages
+	}
+	variantIds := make([]int64, 0)
+	variants := make([]*ProductVariantEntity, 0)
+	categoryIds := make([]int64, 0)
+	for _, productId := range productIds {
+		categoryIds = append(categoryIds, productsMap[productId].Category.Id)
+		variants = append(variants, productsMap[productId].Variants...)
+	}
+	for _, variant := range variants {
+		variantIds = append(variantIds, variant.Id)
+	}
+	prices, err := newPriceList(p.db.Context, p.db.Connection, currency, variantIds)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := getVariantAttributes(p.db.Context, p.db.Connection, variantIds, languages)
+	if err != nil {
+		return nil, err
+	}
+	productTexts, err := newTranslationList(p.db.Context, p.db.Connection, TranslationProduct, productIds, languages)
+	if err != nil {
+		return nil, err
+	}
+	categoryTexts, err := newTranslationList(p.db.Context, p.db.Connection, TranslationCategory, categoryIds, languages)
+	if err != nil {
+		return nil, err
+	}
+
 	products := make([]ProductEntity, 0, len(productsMap))
-	for _, product := range productsMap {
+	for _, productId := range productIds {
+		product := productsMap[productId]
+		product.Name = productTexts.name(product.Id, product.Name)
+		product.Description = productTexts.description(product.Id, product.Description)
+		product.Category.Name = categoryTexts.name(product.Category.Id, product.Category.Name)
+		product.BasePrice = prices.convert(product.BasePrice)
+		for _, variant := range product.Variants {
+			variant.CompareAtPrice = prices.compareAtPrice(variant.Id, variant.Price)
+			variant.Price = prices.variantPrice(variant.Id, variant.Price)
+			variant.Attributes = attributes[variant.Id]
+		}
 		products = append(products, *product)
 	}
 
@@ -259,7 +446,7 @@ func (p *ProductEntityStore) Create(ctx context.Context, opts *ProductCreateUpda
 		return err
 	}
 
-	if err := p.createProductVariants(ctx, tx, productId, opts.Variants); err != nil {
+	if err := p.createProductVariants(ctx, tx, productId, opts.EmployeeId, opts.Variants); err != nil {
 		return err
 	}
 
@@ -271,21 +458,22 @@ func (p *ProductEntityStore) Update(ctx context.Context, opts *ProductCreateUpda
 	return errors.New("not implemented")
 }
 
-func (p *ProductEntityStore) GetVariants(productId int64) ([]ProductVariantEntity, error) {
+// Returns the product variants with prices in the given currency (empty means the default currency)
+// and attribute names and values in the languages of the fallback chain.
+// Variants of products that are not published are returned only for previews of employees
+func (p *ProductEntityStore) GetVariants(productId int64, currency string, languages []string, preview bool) ([]ProductVariantEntity, error) {
 	query := `SELECT 
-			"product_variants"."id" as "variant_id", 
-			"size_id", 
-			"color_id", 
+			"product_variants"."id" as "variant_id",
+			"sku",
+			"barcode",
 			"price", 
-			"stock",
-			"sizes"."name",
-			"colors"."name"
+			"stock" - ` + reservedStockSQL + `,
+			"weight"
 		FROM "product_variants"
-			LEFT JOIN "sizes" on "product_variants"."size_id" = "sizes"."id"
-			LEFT JOIN "colors" on "product_variants"."color_id" = "colors"."id"
  		WHERE "product_id" = $1
+			AND ($2 OR EXISTS(SELECT 1 FROM "products" WHERE "products"."id" = $1 AND ` + publishedProductSQL + `))
 	`
-	rows, err := p.db.Connection.Query(p.db.Context, query, productId)
+	rows, err := p.db.Connection.Query(p.db.Context, query, productId, preview)
 	if err != nil {
 		return nil, err
 	}
@@ -294,16 +482,37 @@ func (p *ProductEntityStore) GetVariants(productId int64) ([]ProductVariantEntit
 	var variants []ProductVariantEntity = make([]ProductVariantEntity, 0)
 	for rows.Next() {
 		var variant ProductVariantEntity
-		err := rows.Scan(&variant.Id, &variant.Size.Id, &variant.Color.Id, &variant.Price, &variant.Stock, &variant.Size.Name, &variant.Color.Name)
+		err := rows.Scan(&variant.Id, &variant.Sku, &variant.Barcode, &variant.Price, &variant.Stock, &variant.Weight)
 		if err != nil {
 			return nil, err
 		}
 		variants = append(variants, variant)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	variantIds := make([]int64, 0, len(variants))
+	for _, variant := range variants {
+		variantIds = append(variantIds, variant.Id)
+	}
+	prices, err := newPriceList(p.db.Context, p.db.Connection, currency, variantIds)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := getVariantAttributes(p.db.Context, p.db.Connection, variantIds, languages)
+	if err != nil {
+		return nil, err
+	}
+	for i := range variants {
+		variants[i].CompareAtPrice = prices.compareAtPrice(variants[i].Id, variants[i].Price)
+		variants[i].Price = prices.variantPrice(variants[i].Id, variants[i].Price)
+		variants[i].Attributes = attributes[variants[i].Id]
+	}
 	return variants, nil
 }
 
-func (p *ProductEntityStore) AddProductVariant(ctx context.Context, productId int64, opts *ProductVariantCreateUpdate) error {
+func (p *ProductEntityStore) AddProductVariant(ctx context.Context, productId int64, employeeId int64, opts *ProductVariantCreateUpdate) error {
 	tx, err := p.db.Connection.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -314,7 +523,7 @@ func (p *ProductEntityStore) AddProductVariant(ctx context.Context, productId in
 		return err
 	}
 
-	if _, err := p.addProductVariant(ctx, tx, productId, opts); err != nil {
+	if _, err := p.addProductVariant(ctx, tx, productId, employeeId, opts); err != nil {
 		return err
 	}
 
@@ -322,39 +531,105 @@ func (p *ProductEntityStore) AddProductVariant(ctx context.Context, productId in
 }
 
 func (p *ProductEntityStore) createBaseProduct(ctx context.Context, tx pgx.Tx, opts *ProductCreateUpdate) (int64, error) {
+	if !isDefaultCurrency(opts.BasePrice) {
+		return 0, fmt.Errorf("%w: base price must be in the default currency '%s'", ErrUnsupportedCurrency, money.DefaultCurrency())
+	}
+	slug, err := txProductSlug(ctx, tx, opts.Slug, opts.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	status := &ProductStatusUpdate{
+		Status:      opts.Status,
+		PublishAt:   opts.PublishAt,
+		UnpublishAt: opts.UnpublishAt,
+		Comment:     "Created",
+		EmployeeId:  employeeRef(opts.EmployeeId),
+	}
+	if status.Status == "" {
+		status.Status = ProductStatusDraft
+	}
+	if err := resolveProductSchedule(status, "", nil, time.Now()); err != nil {
+		return 0, err
+	}
+
 	var productId int64
-	err := tx.QueryRow(ctx,
-		`INSERT INTO "products" ("name", "description", "base_price", "category_id", "employee_id") 
-			VALUES ($1, $2, $3, $4, $5) 
+	err = tx.QueryRow(ctx,
+		`INSERT INTO "products" ("name", "slug", "description", "base_price", "category_id", "employee_id", "status", "publish_at", "unpublish_at") 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) 
 			RETURNING "id"`,
-		opts.Name, opts.Description, opts.BasePrice, opts.CategoryId, opts.EmployeeId,
+		opts.Name, slug, opts.Description, opts.BasePrice, opts.CategoryId, employeeRef(opts.EmployeeId),
+		status.Status, status.PublishAt, status.UnpublishAt,
 	).Scan(&productId)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create product: %w", err)
 	}
+	if _, err := txRecordProductStatus(ctx, tx, productId, nil, status); err != nil {
+		return 0, err
+	}
 	return productId, nil
 }
 
-func (p *ProductEntityStore) createProductVariants(ctx context.Context, tx pgx.Tx, productId int64, variants []ProductVariantCreateUpdate) error {
+func (p *ProductEntityStore) createProductVariants(ctx context.Context, tx pgx.Tx, productId int64, employeeId int64, variants []ProductVariantCreateUpdate) error {
 	for _, variant := range variants {
-		if _, err := p.addProductVariant(ctx, tx, productId, &variant); err != nil {
+		if _, err := p.addProductVariant(ctx, tx, productId, employeeId, &variant); err != nil {
 			return fmt.Errorf("failed to create product variant: %w", err)
 		}
 	}
 	return nil
 }
 
-func (p *ProductEntityStore) addProductVariant(ctx context.Context, tx pgx.Tx, productId int64, opts *ProductVariantCreateUpdate) (int64, error) {
+func (p *ProductEntityStore) addProductVariant(ctx context.Context, tx pgx.Tx, productId int64, employeeId int64, opts *ProductVariantCreateUpdate) (int64, error) {
+	if !isDefaultCurrency(opts.Price) {
+		return 0, fmt.Errorf("%w: variant price must be in the default currency '%s'", ErrUnsupportedCurrency, money.DefaultCurrency())
+	}
+	sku, err := normalizeSku(opts.Sku)
+	if err != nil {
+		return 0, err
+	}
+	barcode := strings.TrimSpace(opts.Barcode)
+	if barcode != "" {
+		if err := checkGTIN(barcode); err != nil {
+			return 0, err
+		}
+	}
+	attributes, err := resolveVariantAttributes(ctx, tx, productId, opts.Attributes)
+	if err != nil {
+		return 0, err
+	}
+
 	var productVariantId int64
-	err := tx.QueryRow(ctx,
-		`INSERT INTO "product_variants" ("product_id", "size_id", "color_id", "price", "stock")
-			VALUES ($1, $2, $3, $4, $5)
+	err = tx.QueryRow(ctx,
+		`INSERT INTO "product_variants" ("product_id", "sku", "barcode", "price", "stock", "weight", "dimension_key")
+			VALUES ($1, $2, nullif($3, ''), $4, $5, $6, $7)
 		RETURNING "id"`,
-		productId, opts.SizeId, opts.ColorId, opts.Price, opts.Stock,
+		productId, sku, barcode, opts.Price, opts.Stock, opts.Weight, attributeDimensionKey(attributes),
 	).Scan(&productVariantId)
 
 	if err != nil {
-		return 0, fmt.Errorf("failed to add product variant: %w", err)
+		return 0, variantWriteError(err)
+	}
+
+	if err := txInsertVariantAttributes(ctx, tx, productVariantId, attributes); err != nil {
+		return 0, err
+	}
+
+	if err := txRecordRegularPrice(ctx, tx, productVariantId, opts.Price, employeeId, "Initial price"); err != nil {
+		return 0, err
+	}
+
+	if opts.Stock != 0 {
+		stockMovementStore := NewStockMovementStore(p.db)
+		err := stockMovementStore.txRecord(ctx, tx, &StockMovementEntity{
+			ProductVariantId: productVariantId,
+			Type:             StockMovementRestock,
+			Quantity:         opts.Stock,
+			EmployeeId:       employeeRef(employeeId),
+			Reason:           "Initial stock",
+		})
+		if err != nil {
+			return 0, err
+		}
 	}
 
 	for _, fileId := range opts.FileIds {
@@ -367,6 +642,91 @@ func (p *ProductEntityStore) addProductVariant(ctx context.Context, tx pgx.Tx, p
 	return productVariantId, nil
 }
 
+// Updates the changed fields of the product, nil fields keep their values
+func (p *ProductEntityStore) txPatchProduct(ctx context.Context, tx pgx.Tx, productId int64, patch *productPatch) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE "products" SET
+			"name" = coalesce($2, "name"),
+			"description" = coalesce($3, "description"),
+			"category_id" = coalesce($4, "category_id"),
+			"base_price" = coalesce($5, "base_price"),
+			"updated_at" = now()
+		WHERE "id" = $1`,
+		productId, patch.Name, patch.Description, patch.CategoryId, patch.BasePrice,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update product: %w", err)
+	}
+	return nil
+}
+
+// Updates the changed fields of the variant. A stock change is recorded in the stock ledger
+// as an adjustment and cannot make the stock lower than the reserved quantity. A price change
+// is recorded in the price history
+func (p *ProductEntityStore) txPatchVariant(ctx context.Context, tx pgx.Tx, variantId int64, employeeId int64, patch *variantPatch) error {
+	var stock, reserved int64
+	var price money.Money
+	err := tx.QueryRow(ctx, `
+		SELECT "product_variants"."stock", `+reservedStockSQL+`, "product_variants"."price"
+		FROM "product_variants"
+		WHERE "product_variants"."id" = $1
+		FOR UPDATE`, variantId).Scan(&stock, &reserved, &price)
+	if err != nil {
+		return fmt.Errorf("failed to get product variant: %w", err)
+	}
+
+	if patch.Price != nil && patch.Price.Amount != price.Amount {
+		if err := txRecordRegularPrice(ctx, tx, variantId, *patch.Price, employeeId, patch.Reason); err != nil {
+			return err
+		}
+	}
+
+	if patch.Stock != nil && int64(*patch.Stock) != stock {
+		if int64(*patch.Stock) < reserved {
+			return fmt.Errorf("%w: stock cannot become lower than the reserved quantity (%d)", ErrInvalidStockMovement, reserved)
+		}
+		stockMovementStore := NewStockMovementStore(p.db)
+		err := stockMovementStore.txRecord(ctx, tx, &StockMovementEntity{
+			ProductVariantId: variantId,
+			Type:             StockMovementAdjustment,
+			Quantity:         *patch.Stock - int32(stock),
+			EmployeeId:       employeeRef(employeeId),
+			Reason:           patch.Reason,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE "product_variants" SET
+			"price" = coalesce($2, "price"),
+			"stock" = coalesce($3, "stock"),
+			"weight" = coalesce($4, "weight"),
+			"sku" = coalesce($5, "sku"),
+			"barcode" = CASE WHEN $6::text IS NULL THEN "barcode" ELSE nullif($6, '') END
+		WHERE "id" = $1`,
+		variantId, patch.Price, patch.Stock, patch.Weight, patch.Sku, patch.Barcode,
+	)
+	if err != nil {
+		return variantWriteError(err)
+	}
+
+	if patch.FileIds == nil {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM "product_variant_images" WHERE "product_variant_id" = $1`, variantId); err != nil {
+		return fmt.Errorf("failed to remove product variant images: %w", err)
+	}
+	for _, fileId := range patch.FileIds {
+		_, err := tx.Exec(ctx, `INSERT INTO "product_variant_images" ("product_variant_id", "file_id") VALUES ($1, $2)`, variantId, fileId)
+		if err != nil {
+			return fmt.Errorf("failed to add product variant image: %w", err)
+		}
+	}
+	return nil
+}
+
 func (p *ProductEntityStore) checkCategoryExists(ctx context.Context, tx pgx.Tx, categoryId int64) error {
 	var exists bool
 	err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM "categories" WHERE "id" = $1)`, categoryId).Scan(&exists)
@@ -391,6 +751,25 @@ func (p *ProductEntityStore) checkProductExists(ctx context.Context, tx pgx.Tx,
 	return nil
 }
 
+func variantWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		if pgErr.ConstraintName == "product_variants_sku_key" || pgErr.ConstraintName == "product_variants_barcode_key" {
+			return ErrIdentifierExists
+		}
+		return ErrDuplicateVariant
+	}
+	return fmt.Errorf("failed to save product variant: %w", err)
+}
+
 func getImageURLFromPath(imagePath string) string {
 	return path.Join(config.AppConfig.ServerURL, imagePath)
 }
+
+// Employee id 0 means the change is not made by an employee, e.g. by a CLI command
+func employeeRef(employeeId int64) *int64 {
+	if employeeId == 0 {
+		return nil
+	}
+	return &employeeId
+}
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrProductNotFound   = errors.New("product not found")
+	ErrVariantNotFound   = errors.New("product variant not found")
+	ErrIdentifierExists  = errors.New("sku, barcode or slug is already used")
+	ErrInvalidIdentifier = errors.New("invalid product identifier")
+)
+
+var skuRegexp = regexp.MustCompile(`^[A-Z0-9][A-Z0-9._-]{0,63}$`)
+
+// ProductVariantLookup is a variant found by SKU or barcode with its product
+type ProductVariantLookup struct {
+	ProductId   int64                `json:"product_id"`
+	ProductName string               `json:"product_name"`
+	ProductSlug string               `json:"product_slug"`
+	Variant     ProductVariantEntity `json:"variant"`
+}
+
+type VariantIdentifiersUpdate struct {
+	// Nil keeps the SKU
+	Sku *string `json:"sku"`
+	// Nil keeps the barcode, an empty string removes it
+	Barcode *string `json:"barcode"`
+}
+
+// Returns the product id and the current slug of the product. Previous slugs of a product
+// return its current slug, so callers can redirect to it
+func (p *ProductEntityStore) ResolveSlug(ctx context.Context, slug string) (int64, string, error) {
+	var productId int64
+	var currentSlug string
+	err := p.db.Connection.QueryRow(ctx, `
+		select "id", "slug" from "products" where "slug" = $1
+		union all
+		select "products"."id", "products"."slug" from "product_slug_history"
+		join "products" on "products"."id" = "product_slug_history"."product_id"
+		where "product_slug_history"."slug" = $1
+		limit 1`, slug).Scan(&productId, &currentSlug)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", ErrProductNotFound
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to get product by slug: %w", err)
+	}
+	return productId, currentSlug, nil
+}
+
+// Changes the product slug. The previous slug is kept in the slug history and cannot be used by other products
+func (p *ProductEntityStore) SetSlug(ctx context.Context, productId int64, slug string) (string, error) {
+	slug = slugify(slug)
+	if slug == "" {
+		return "", fmt.Errorf("%w: slug must contain latin letters or digits", ErrInvalidIdentifier)
+	}
+
+	tx, err := p.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var currentSlug string
+	err = tx.QueryRow(ctx, `SELECT "slug" FROM "products" WHERE "id" = $1 FOR UPDATE`, productId).Scan(&currentSlug)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrProductNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get product: %w", err)
+	}
+	if currentSlug == slug {
+		return slug, nil
+	}
+
+	var ownerId *int64
+	err = tx.QueryRow(ctx, `SELECT "product_id" FROM "product_slug_history" WHERE "slug" = $1`, slug).Scan(&ownerId)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("failed to check slug history: %w", err)
+	}
+	if ownerId != nil && *ownerId != productId {
+		return "", ErrIdentifierExists
+	}
+	// A previous slug of the same product becomes current again
+	if _, err := tx.Exec(ctx, `DELETE FROM "product_slug_history" WHERE "slug" = $1`, slug); err != nil {
+		return "", fmt.Errorf("failed to update slug history: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO "product_slug_history" ("slug", "product_id") VALUES ($1, $2)`, currentSlug, productId); err != nil {
+		return "", fmt.Errorf("failed to update slug history: %w", err)
+	}
+	_, err = tx.Exec(ctx, `UPDATE "products" SET "slug" = $2, "updated_at" = now() WHERE "id" = $1`, productId, slug)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return "", ErrIdentifierExists
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to update product slug: %w", err)
+	}
+
+	return slug, tx.Commit(ctx)
+}
+
+// Returns the variant with the SKU, SKUs are case insensitive. Variants of products that are
+// not published are returned only for previews of employees
+func (p *ProductEntityStore) GetVariantBySku(ctx context.Context, sku string, currency string, languages []string, preview bool) (*ProductVariantLookup, error) {
+	return p.getVariantLookup(ctx, `"product_variants"."sku" = $1`, strings.ToUpper(strings.TrimSpace(sku)), currency, languages, preview)
+}
+
+func (p *ProductEntityStore) GetVariantByBarcode(ctx context.Context, barcode string, currency string, languages []string, preview bool) (*ProductVariantLookup, error) {
+	return p.getVariantLookup(ctx, `"product_variants"."barcode" = $1`, strings.TrimSpace(barcode), currency, languages, preview)
+}
+
+func (p *ProductEntityStore) getVariantLookup(ctx context.Context, where string, value string, currency string, languages []string, preview bool) (*ProductVariantLookup, error) {
+	lookup := &ProductVariantLookup{}
+	err := p.db.Connection.QueryRow(ctx, `
+		SELECT "products"."id", "products"."name", "products"."slug"
+		FROM "product_variants"
+		JOIN "products" on "products"."id" = "product_variants"."product_id"
+		WHERE `+where+` AND ($2 OR `+publishedProductSQL+`)`, value, preview).Scan(&lookup.ProductId, &lookup.ProductName, &lookup.ProductSlug)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrVariantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product variant: %w", err)
+	}
+
+	texts, err := newTranslationList(ctx, p.db.Connection, TranslationProduct, []int64{lookup.ProductId}, languages)
+	if err != nil {
+		return nil, err
+	}
+	lookup.ProductName = texts.name(lookup.ProductId, lookup.ProductName)
+
+	variants, err := p.GetVariants(lookup.ProductId, currency, languages, preview)
+	if err != nil {
+		return nil, err
+	}
+	for _, variant := range variants {
+		if strings.EqualFold(variant.Sku, value) || (variant.Barcode != nil && *variant.Barcode == value) {
+			lookup.Variant = variant
+			return lookup, nil
+		}
+	}
+	return nil, ErrVariantNotFound
+}
+
+// Changes the SKU or the barcode of the product variant
+func (p *ProductEntityStore) SetVariantIdentifiers(ctx context.Context, productId int64, variantId int64, update *VariantIdentifiersUpdate) error {
+	patch := &variantPatch{}
+	if update.Sku != nil {
+		sku, err := normalizeSku(*update.Sku)
+		if err != nil {
+			return err
+		}
+		patch.Sku = &sku
+	}
+	if update.Barcode != nil {
+		barcode := strings.TrimSpace(*update.Barcode)
+		if barcode != "" {
+			if err := checkGTIN(barcode); err != nil {
+				return err
+			}
+		}
+		patch.Barcode = &barcode
+	}
+
+	tx, err := p.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM "product_variants" WHERE "id" = $1 AND "product_id" = $2)`, variantId, productId).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check product variant: %w", err)
+	}
+	if !exists {
+		return ErrVariantNotFound
+	}
+	if err := p.txPatchVariant(ctx, tx, variantId, 0, patch); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Returns a free slug for a new product. Taken slugs generated from the name get a numeric suffix,
+// explicit slugs must be free
+func txProductSlug(ctx context.Context, tx pgx.Tx, slug string, name string) (string, error) {
+	generated := slug == ""
+	if generated {
+		slug = name
+	}
+	slug = slugify(slug)
+	if slug == "" {
+		if !generated {
+			return "", fmt.Errorf("%w: slug must contain latin letters or digits", ErrInvalidIdentifier)
+		}
+		slug = "product"
+	}
+
+	for suffix := 1; ; suffix++ {
+		candidate := slug
+		if suffix > 1 {
+			candidate = slug + "-" + strconv.Itoa(suffix)
+		}
+		var taken bool
+		err := tx.QueryRow(ctx, `
+			SELECT EXISTS(SELECT 1 FROM "products" WHERE "slug" = $1)
+				OR EXISTS(SELECT 1 FROM "product_slug_history" WHERE "slug" = $1)`, candidate).Scan(&taken)
+		if err != nil {
+			return "", fmt.Errorf("failed to check product slug: %w", err)
+		}
+		if !taken {
+			return candidate, nil
+		}
+		if !generated {
+			return "", ErrIdentifierExists
+		}
+	}
+}
+
+// Returns the uppercase SKU or a new random SKU when the SKU is empty
+func normalizeSku(sku string) (string, error) {
+	sku = strings.ToUpper(strings.TrimSpace(sku))
+	if sku == "" {
+		suffix := make([]byte, 5)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", fmt.Errorf("failed to generate sku: %w", err)
+		}
+		return "NS-" + strings.ToUpper(hex.EncodeToString(suffix)), nil
+	}
+	if !skuRegexp.MatchString(sku) {
+		return "", fmt.Errorf("%w: sku '%s' must contain up to 64 latin letters, digits, dots, dashes and underscores", ErrInvalidIdentifier, sku)
+	}
+	return sku, nil
+}
+
+// Validates the length and the check digit of a GTIN-8, GTIN-12 (UPC), GTIN-13 (EAN) or GTIN-14 barcode
+func checkGTIN(barcode string) error {
+	switch len(barcode) {
+	case 8, 12, 13, 14:
+	default:
+		return fmt.Errorf("%w: barcode '%s' must have 8, 12, 13 or 14 digits", ErrInvalidIdentifier, barcode)
+	}
+
+	sum := 0
+	for i := len(barcode) - 1; i >= 0; i-- {
+		digit := int(barcode[i] - '0')
+		if digit < 0 || digit > 9 {
+			return fmt.Errorf("%w: barcode '%s' must contain only digits", ErrInvalidIdentifier, barcode)
+		}
+		// Digits are weighted 3 and 1 from the right, the check digit itself has weight 1
+		if (len(barcode)-1-i)%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	if sum%10 != 0 {
+		return fmt.Errorf("%w: barcode '%s' has an invalid check digit", ErrInvalidIdentifier, barcode)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	ProductStatusDraft     = "draft"
+	ProductStatusScheduled = "scheduled"
+	ProductStatusPublished = "published"
+	ProductStatusArchived  = "archived"
+)
+
+// SQL condition of the "products" row visible to customers
+const publishedProductSQL = `"products"."status" = 'published'`
+
+var (
+	ErrInvalidProductStatus = errors.New("invalid product status")
+	ErrProductNotPublished  = errors.New("product is not available")
+)
+
+type ProductStatusUpdate struct {
+	// draft, scheduled, published or archived
+	Status string `json:"status"`
+	// Required for scheduled products, ignored for other statuses
+	PublishAt *time.Time `json:"publish_at"`
+	// Optional time of archiving a scheduled or published product
+	UnpublishAt *time.Time `json:"unpublish_at"`
+	Comment     string     `json:"comment"`
+	// Nil for changes of the scheduler
+	EmployeeId *int64 `json:"-"`
+}
+
+// ProductStatusChangeEntity is a record of the product status audit log
+type ProductStatusChangeEntity struct {
+	Id        int64 `json:"id"`
+	ProductId int64 `json:"product_id"`
+	// Nil for the initial status of a new product
+	FromStatus  *string    `json:"from_status"`
+	ToStatus    string     `json:"to_status"`
+	PublishAt   *time.Time `json:"publish_at"`
+	UnpublishAt *time.Time `json:"unpublish_at"`
+	// Nil for changes of the scheduler
+	EmployeeId *int64    `json:"employee_id"`
+	Comment    string    `json:"comment"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const productStatusChangeColumnsSQL = `id, product_id, from_status::text, to_status::text, publish_at, unpublish_at, employee_id, comment, created_at`
+
+// Changes the status of the product and records the change in the audit log
+func (p *ProductEntityStore) SetStatus(ctx context.Context, productId int64, update *ProductStatusUpdate) (*ProductStatusChangeEntity, error) {
+	tx, err := p.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var currentStatus string
+	var currentPublishAt *time.Time
+	err = tx.QueryRow(ctx, `select status::text, publish_at from "products" where id = $1 for update`, productId).
+		Scan(&currentStatus, &currentPublishAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+
+	if err := resolveProductSchedule(update, currentStatus, currentPublishAt, time.Now()); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		update "products" set status = $2, publish_at = $3, unpublish_at = $4, updated_at = now()
+		where id = $1`, productId, update.Status, update.PublishAt, update.UnpublishAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update product status: %w", err)
+	}
+
+	change, err := txRecordProductStatus(ctx, tx, productId, &currentStatus, update)
+	if err != nil {
+		return nil, err
+	}
+	return change, tx.Commit(ctx)
+}
+
+// Returns the status changes of the product, the latest first
+func (p *ProductEntityStore) GetStatusHistory(ctx context.Context, productId int64) ([]ProductStatusChangeEntity, error) {
+	var exists bool
+	err := p.db.Connection.QueryRow(ctx, `select exists(select 1 from "products" where id = $1)`, productId).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check product: %w", err)
+	}
+	if !exists {
+		return nil, ErrProductNotFound
+	}
+
+	rows, err := p.db.Connection.Query(ctx, `
+		select `+productStatusChangeColumnsSQL+`
+		from "product_status_changes"
+		where product_id = $1
+		order by created_at desc, id desc`, productId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product status changes: %w", err)
+	}
+	return scanProductStatusChanges(rows)
+}
+
+// Publishes scheduled products whose publish time has come and archives published products
+// whose unpublish time has passed. Returns the recorded status changes
+func (p *ProductEntityStore) ApplySchedule(ctx context.Context) ([]ProductStatusChangeEntity, error) {
+	tx, err := p.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	changes := make([]ProductStatusChangeEntity, 0)
+	// A product scheduled with a past unpublish time is published and archived in the same run
+	transitions := []struct{ from, to, timeColumn, comment string }{
+		{ProductStatusScheduled, ProductStatusPublished, "publish_at", "Published by schedule"},
+		{ProductStatusPublished, ProductStatusArchived, "unpublish_at", "Archived by schedule"},
+	}
+	for _, transition := range transitions {
+		rows, err := tx.Query(ctx, `
+			with changed as (
+				update "products" set status = $2, updated_at = now()
+				where status = $1 and `+transition.timeColumn+` <= now()
+				returning id, publish_at, unpublish_at
+			)
+			insert into "product_status_changes" (product_id, from_status, to_status, publish_at, unpublish_at, comment)
+			select id, $1, $2, publish_at, unpublish_at, $3 from changed
+			returning `+productStatusChangeColumnsSQL, transition.from, transition.to, transition.comment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply product schedule: %w", err)
+		}
+		applied, err := scanProductStatusChanges(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, applied...)
+	}
+
+	return changes, tx.Commit(ctx)
+}
+
+// Validates the new status and sets the publish and unpublish times it keeps
+func resolveProductSchedule(update *ProductStatusUpdate, currentStatus string, currentPublishAt *time.Time, now time.Time) error {
+	switch update.Status {
+	case ProductStatusDraft:
+		update.PublishAt = nil
+		update.UnpublishAt = nil
+	case ProductStatusScheduled:
+		if update.PublishAt == nil || !update.PublishAt.After(now) {
+			return fmt.Errorf("%w: scheduled products require a future publish time", ErrInvalidProductStatus)
+		}
+	case ProductStatusPublished:
+		// Republishing keeps the original publish time
+		if currentStatus == ProductStatusPublished && currentPublishAt != nil {
+			update.PublishAt = currentPublishAt
+		} else {
+			update.PublishAt = &now
+		}
+	case ProductStatusArchived:
+		update.PublishAt = currentPublishAt
+		update.UnpublishAt = nil
+	default:
+		return fmt.Errorf("%w: unsupported status '%s'", ErrInvalidProductStatus, update.Status)
+	}
+
+	if update.UnpublishAt != nil {
+		if !update.UnpublishAt.After(now) {
+			return fmt.Errorf("%w: unpublish time must be in the future", ErrInvalidProductStatus)
+		}
+		if update.PublishAt != nil && !update.UnpublishAt.After(*update.PublishAt) {
+			return fmt.Errorf("%w: unpublish time must be after the publish time", ErrInvalidProductStatus)
+		}
+	}
+	return nil
+}
+
+func txRecordProductStatus(ctx context.Context, tx pgx.Tx, productId int64, fromStatus *string, update *ProductStatusUpdate) (*ProductStatusChangeEntity, error) {
+	rows, err := tx.Query(ctx, `
+		insert into "product_status_changes" (product_id, from_status, to_status, publish_at, unpublish_at, employee_id, comment)
+		values ($1, $2, $3, $4, $5, $6, $7)
+		returning `+productStatusChangeColumnsSQL,
+		productId, fromStatus, update.Status, update.PublishAt, update.UnpublishAt, update.EmployeeId, update.Comment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record product status change: %w", err)
+	}
+	changes, err := scanProductStatusChanges(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &changes[0], nil
+}
+
+func scanProductStatusChanges(rows pgx.Rows) ([]ProductStatusChangeEntity, error) {
+	defer rows.Close()
+
+	changes := make([]ProductStatusChangeEntity, 0)
+	for rows.Next() {
+		var change ProductStatusChangeEntity
+		err := rows.Scan(
+			&change.Id,
+			&change.ProductId,
+			&change.FromStatus,
+			&change.ToStatus,
+			&change.PublishAt,
+			&change.UnpublishAt,
+			&change.EmployeeId,
+			&change.Comment,
+			&change.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
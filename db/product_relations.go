@@ -0,0 +1,298 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"netshop/main/tools/money"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	ProductRelationRelated   = "related"
+	ProductRelationAccessory = "accessory"
+	ProductRelationUpsell    = "upsell"
+	ProductRelationCrossSell = "cross_sell"
+)
+
+const (
+	// Curated by employees
+	RelatedSourceManual = "manual"
+	// Bought together in the same orders
+	RelatedSourceCoPurchase = "co_purchase"
+)
+
+var (
+	ErrRelationNotFound = errors.New("product relation not found")
+	ErrRelationExists   = errors.New("product relation already exists")
+	ErrInvalidRelation  = errors.New("invalid product relation")
+)
+
+// RelatedProductEntity is a short summary of a related or frequently bought together product
+type RelatedProductEntity struct {
+	ProductId int64  `json:"product_id"`
+	Name      string `json:"name"`
+	Slug      string `json:"slug"`
+	// Price of the cheapest variant in stock, the base price when no variant is in stock
+	Price         money.Money `json:"price"`
+	Stock         int32       `json:"stock"`
+	ImageUrl      string      `json:"image_url,omitempty"`
+	RatingAverage *float64    `json:"rating_average"`
+	RatingCount   int32       `json:"rating_count"`
+	// manual or co_purchase
+	Source string `json:"source"`
+	// Relation type of manual relations
+	Type string `json:"type,omitempty"`
+	// Number of sold orders with both products of co_purchase relations
+	Orders int64 `json:"orders,omitempty"`
+}
+
+type ProductRelationCreate struct {
+	RelatedProductId int64  `json:"related_product_id"`
+	Type             string `json:"type"`
+	SortOrder        int32  `json:"sort_order"`
+	EmployeeId       int64  `json:"-"`
+}
+
+type RelatedProductsOptions struct {
+	// Only manual relations of the type, empty for all types
+	Type string
+	// Maximum number of frequently bought together products
+	Limit int64
+	// Currency of the returned prices. If empty, the default currency is used
+	Currency string
+	// Fallback chain of the languages of the product names. If empty, the base names are returned
+	Languages []string
+}
+
+type ProductRelationStore struct {
+	db *DatabaseConnection
+}
+
+// Summary columns of the "products" row with the cheapest variant in stock as "cheapest"
+const relatedProductSelectSQL = `select
+		"products".id,
+		"products".name,
+		"products".slug,
+		"cheapest".id,
+		coalesce("cheapest".price, "products".base_price),
+		greatest(coalesce((
+			select sum("product_variants".stock - ` + reservedStockSQL + `)
+			from "product_variants" where "product_variants".product_id = "products".id
+		), 0), 0),
+		coalesce((
+			select "files".path
+			from "product_variant_images"
+			join "files" on "files".id = "product_variant_images".file_id
+			join "product_variants" on "product_variants".id = "product_variant_images".product_variant_id
+			where "product_variants".product_id = "products".id
+			order by "product_variants".id, "files".id
+			limit 1
+		), ''),
+		"products".rating_average::float8,
+		"products".rating_count`
+
+// Lateral join of the cheapest variant in stock of the "products" row
+const cheapestInStockSQL = `lateral (
+		select "product_variants".id, "product_variants".price
+		from "product_variants"
+		where "product_variants".product_id = "products".id
+			and "product_variants".stock - ` + reservedStockSQL + ` > 0
+		order by "product_variants".price, "product_variants".id
+		limit 1
+	) "cheapest"`
+
+func NewProductRelationStore(database *DatabaseConnection) *ProductRelationStore {
+	return &ProductRelationStore{
+		db: database,
+	}
+}
+
+// Returns the manual relations of the product followed by the products most often bought
+// together with it. Frequently bought together products without stock are skipped
+func (s *ProductRelationStore) GetRelated(ctx context.Context, productId int64, opts *RelatedProductsOptions) ([]RelatedProductEntity, error) {
+	if opts.Type != "" && !isProductRelationType(opts.Type) {
+		return nil, fmt.Errorf("%w: unsupported type '%s'", ErrInvalidRelation, opts.Type)
+	}
+	limit := "all"
+	if opts.Limit > 0 {
+		limit = fmt.Sprint(opts.Limit)
+	}
+
+	var exists bool
+	err := s.db.Connection.QueryRow(ctx, `select exists(select 1 from "products" where id = $1 and `+publishedProductSQL+`)`, productId).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check product: %w", err)
+	}
+	if !exists {
+		return nil, ErrProductNotFound
+	}
+
+	rows, err := s.db.Connection.Query(ctx, relatedProductSelectSQL+`,
+			'`+RelatedSourceManual+`',
+			"product_relations".type::text,
+			0::bigint
+		from "product_relations"
+		join "products" on "products".id = "product_relations".related_product_id
+		left join `+cheapestInStockSQL+` on true
+		where "product_relations".product_id = $1
+			and ($2 = '' or "product_relations".type::text = $2)
+			and `+publishedProductSQL+`
+		order by "product_relations".type, "product_relations".sort_order, "products".id`, productId, opts.Type)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product relations: %w", err)
+	}
+	variantIds, products, err := scanRelatedProducts(rows, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Type == "" {
+		rows, err = s.db.Connection.Query(ctx, relatedProductSelectSQL+`,
+				'`+RelatedSourceCoPurchase+`',
+				'',
+				"product_co_purchases".orders
+			from "product_co_purchases"
+			join "products" on "products".id = "product_co_purchases".related_product_id
+			join `+cheapestInStockSQL+` on true
+			where "product_co_purchases".product_id = $1
+				and `+publishedProductSQL+`
+				and not exists(
+					select 1 from "product_relations"
+					where "product_relations".product_id = $1
+						and "product_relations".related_product_id = "product_co_purchases".related_product_id
+				)
+			order by "product_co_purchases".orders desc, "products".id
+			limit `+limit, productId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get frequently bought together products: %w", err)
+		}
+		variantIds, products, err = scanRelatedProducts(rows, variantIds, products)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	prices, err := newPriceList(ctx, s.db.Connection, opts.Currency, variantIds)
+	if err != nil {
+		return nil, err
+	}
+	productIds := make([]int64, 0, len(products))
+	for _, product := range products {
+		productIds = append(productIds, product.ProductId)
+	}
+	texts, err := newTranslationList(ctx, s.db.Connection, TranslationProduct, productIds, opts.Languages)
+	if err != nil {
+		return nil, err
+	}
+	for i := range products {
+		products[i].Name = texts.name(products[i].ProductId, products[i].Name)
+		if variantIds[i] != 0 {
+			products[i].Price = prices.variantPrice(variantIds[i], products[i].Price)
+		} else {
+			products[i].Price = prices.convert(products[i].Price)
+		}
+	}
+	return products, nil
+}
+
+func (s *ProductRelationStore) Create(ctx context.Context, productId int64, opts *ProductRelationCreate) error {
+	if !isProductRelationType(opts.Type) {
+		return fmt.Errorf("%w: unsupported type '%s'", ErrInvalidRelation, opts.Type)
+	}
+	if opts.RelatedProductId == productId {
+		return fmt.Errorf("%w: a product cannot be related to itself", ErrInvalidRelation)
+	}
+
+	_, err := s.db.Connection.Exec(ctx, `
+		insert into "product_relations" (product_id, related_product_id, type, sort_order, employee_id)
+		values ($1, $2, $3, $4, $5)`,
+		productId, opts.RelatedProductId, opts.Type, opts.SortOrder, employeeRef(opts.EmployeeId))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			switch pgErr.Code {
+			case "23505":
+				return ErrRelationExists
+			case "23503":
+				return ErrProductNotFound
+			}
+		}
+		return fmt.Errorf("failed to create product relation: %w", err)
+	}
+	return nil
+}
+
+func (s *ProductRelationStore) Delete(ctx context.Context, productId, relatedProductId int64, relationType string) error {
+	tag, err := s.db.Connection.Exec(ctx, `
+		delete from "product_relations"
+		where product_id = $1 and related_product_id = $2 and type::text = $3`,
+		productId, relatedProductId, relationType)
+	if err != nil {
+		return fmt.Errorf("failed to delete product relation: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRelationNotFound
+	}
+	return nil
+}
+
+// Recalculates the frequently bought together statistics from the sold orders
+func (s *ProductRelationStore) RefreshCoPurchases(ctx context.Context) error {
+	_, err := s.db.Connection.Exec(ctx, `refresh materialized view concurrently "product_co_purchases"`)
+	if err != nil {
+		return fmt.Errorf("failed to refresh product co-purchases: %w", err)
+	}
+	return nil
+}
+
+func isProductRelationType(relationType string) bool {
+	switch relationType {
+	case ProductRelationRelated, ProductRelationAccessory, ProductRelationUpsell, ProductRelationCrossSell:
+		return true
+	}
+	return false
+}
+
+// Appends the scanned products and the ids of their cheapest variants in stock (0 for none)
+func scanRelatedProducts(rows pgx.Rows, variantIds []int64, products []RelatedProductEntity) ([]int64, []RelatedProductEntity, error) {
+	defer rows.Close()
+
+	if products == nil {
+		products = make([]RelatedProductEntity, 0)
+	}
+	for rows.Next() {
+		var product RelatedProductEntity
+		var variantId *int64
+		var imagePath string
+		err := rows.Scan(
+			&product.ProductId,
+			&product.Name,
+			&product.Slug,
+			&variantId,
+			&product.Price,
+			&product.Stock,
+			&imagePath,
+			&product.RatingAverage,
+			&product.RatingCount,
+			&product.Source,
+			&product.Type,
+			&product.Orders,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		if imagePath != "" {
+			product.ImageUrl = getImageURLFromPath(imagePath)
+		}
+		if variantId != nil {
+			variantIds = append(variantIds, *variantId)
+		} else {
+			variantIds = append(variantIds, 0)
+		}
+		products = append(products, product)
+	}
+	return variantIds, products, rows.Err()
+}
@@ -0,0 +1,491 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"netshop/main/tools/money"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	PromotionDiscountPercentage = "percentage"
+	PromotionDiscountFixed      = "fixed"
+
+	OrderAdjustmentPromotion = "promotion"
+)
+
+var (
+	ErrPromotionNotFound      = errors.New("promotion code not found")
+	ErrPromotionNotApplicable = errors.New("promotion code cannot be applied")
+	ErrPromotionCodeExists    = errors.New("promotion code already exists")
+	ErrInvalidPromotion       = errors.New("invalid promotion")
+)
+
+type PromotionEntity struct {
+	Id                    int64        `json:"id"`
+	Code                  string       `json:"code"`
+	Name                  string       `json:"name"`
+	DiscountType          string       `json:"discount_type"`
+	DiscountPercent       *int32       `json:"discount_percent"`
+	DiscountAmount        *money.Money `json:"discount_amount"`
+	MinOrderValue         *money.Money `json:"min_order_value"`
+	UsageLimit            *int32       `json:"usage_limit"`
+	UsageLimitPerCustomer *int32       `json:"usage_limit_per_customer"`
+	StartsAt              *time.Time   `json:"starts_at"`
+	EndsAt                *time.Time   `json:"ends_at"`
+	IsActive              bool         `json:"is_active"`
+	CategoryIds           []int64      `json:"category_ids"`
+	ProductIds            []int64      `json:"product_ids"`
+	UsageCount            int64        `json:"usage_count"`
+	CreatedAt             time.Time    `json:"created_at"`
+}
+
+type PromotionCreateUpdate struct {
+	Code                  string       `json:"code"`
+	Name                  string       `json:"name"`
+	DiscountType          string       `json:"discount_type"`
+	DiscountPercent       *int32       `json:"discount_percent"`
+	DiscountAmount        *money.Money `json:"discount_amount"`
+	MinOrderValue         *money.Money `json:"min_order_value"`
+	UsageLimit            *int32       `json:"usage_limit"`
+	UsageLimitPerCustomer *int32       `json:"usage_limit_per_customer"`
+	StartsAt              *time.Time   `json:"starts_at"`
+	EndsAt                *time.Time   `json:"ends_at"`
+	CategoryIds           []int64      `json:"category_ids"`
+	ProductIds            []int64      `json:"product_ids"`
+	EmployeeId            int64        `json:"-"`
+}
+
+// OrderAdjustmentEntity is a change of the order total, e.g. a promotion discount.
+// Adjustments without OrderItemId belong to the whole order
+type OrderAdjustmentEntity struct {
+	Id          int64       `json:"id"`
+	OrderId     int64       `json:"order_id"`
+	OrderItemId *int64      `json:"order_item_id"`
+	PromotionId *int64      `json:"promotion_id"`
+	Type        string      `json:"type"`
+	Description string      `json:"description"`
+	Amount      money.Money `json:"amount"`
+}
+
+// A single order line used for discount calculation. Amounts are in minor units of the currency
+type discountLine struct {
+	OrderItemId int64
+	ProductId   int64
+	// The product category followed by its ancestors up to the root category
+	CategoryIds []int64
+	Amount      int64
+}
+
+// Discount of a single order line or the whole order (OrderItemId is nil). Amount is in minor units
+type discountAllocation struct {
+	OrderItemId *int64
+	Amount      int64
+}
+
+type PromotionEntityStore struct {
+	db *DatabaseConnection
+}
+
+func NewPromotionEntityStore(database *DatabaseConnection) *PromotionEntityStore {
+	return &PromotionEntityStore{
+		db: database,
+	}
+}
+
+func (s *PromotionEntityStore) GetEntities() ([]PromotionEntity, error) {
+	rows, err := s.db.Connection.Query(s.db.Context, `
+		select `+promotionColumnsSQL+`
+		from "promotions"
+		order by "promotions".id desc`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	promotions := make([]PromotionEntity, 0)
+	for rows.Next() {
+		promotion, err := scanPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, *promotion)
+	}
+	return promotions, nil
+}
+
+func (s *PromotionEntityStore) Create(ctx context.Context, opts *PromotionCreateUpdate) (*PromotionEntity, error) {
+	opts.Code = strings.ToUpper(strings.TrimSpace(opts.Code))
+	if err := validatePromotion(opts); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		insert into "promotions" (
+			code,
+			name,
+			discount_type,
+			discount_percent,
+			discount_amount,
+			min_order_value,
+			usage_limit,
+			usage_limit_per_customer,
+			starts_at,
+			ends_at,
+			employee_id)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		returning id`,
+		opts.Code,
+		opts.Name,
+		opts.DiscountType,
+		opts.DiscountPercent,
+		opts.DiscountAmount,
+		opts.MinOrderValue,
+		opts.UsageLimit,
+		opts.UsageLimitPerCustomer,
+		opts.StartsAt,
+		opts.EndsAt,
+		opts.EmployeeId,
+	).Scan(&id)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrPromotionCodeExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create promotion: %w", err)
+	}
+
+	for _, categoryId := range opts.CategoryIds {
+		if _, err := tx.Exec(ctx, `insert into "promotion_categories" (promotion_id, category_id) values ($1, $2)`, id, categoryId); err != nil {
+			return nil, fmt.Errorf("failed to add promotion category '%d': %w", categoryId, err)
+		}
+	}
+	for _, productId := range opts.ProductIds {
+		if _, err := tx.Exec(ctx, `insert into "promotion_products" (promotion_id, product_id) values ($1, $2)`, id, productId); err != nil {
+			return nil, fmt.Errorf("failed to add promotion product '%d': %w", productId, err)
+		}
+	}
+
+	promotion, err := s.txGetByCode(ctx, tx, opts.Code, false)
+	if err != nil {
+		return nil, err
+	}
+	return promotion, tx.Commit(ctx)
+}
+
+// Deactivates the promotion. Promotions are never deleted to keep the order adjustments reproducible
+func (s *PromotionEntityStore) Deactivate(ctx context.Context, id int64) error {
+	tag, err := s.db.Connection.Exec(ctx, `update "promotions" set is_active = false, updated_at = now() where id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate promotion: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("promotion with id '%d' not found", id)
+	}
+	return nil
+}
+
+// Applies the promotion code to the order: validates the promotion rules, stores order and line
+// adjustments and the redemption. Returns the total discount
+func (s *PromotionEntityStore) txApply(ctx context.Context, tx pgx.Tx, order *OrderEntity, code string) (money.Money, error) {
+	// The promotion row is locked to count its usages without races
+	promotion, err := s.txGetByCode(ctx, tx, strings.ToUpper(strings.TrimSpace(code)), true)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	now := time.Now()
+	if !promotion.IsActive ||
+		(promotion.StartsAt != nil && now.Before(*promotion.StartsAt)) ||
+		(promotion.EndsAt != nil && now.After(*promotion.EndsAt)) {
+		return money.Money{}, fmt.Errorf("%w: promotion is not active", ErrPromotionNotApplicable)
+	}
+
+	if promotion.UsageLimit != nil && promotion.UsageCount >= int64(*promotion.UsageLimit) {
+		return money.Money{}, fmt.Errorf("%w: usage limit is reached", ErrPromotionNotApplicable)
+	}
+	if promotion.UsageLimitPerCustomer != nil {
+		var customerUsages int64
+		err := tx.QueryRow(ctx, `
+			select count(*) from `+countedRedemptionsSQL+`
+			where "promotion_redemptions".promotion_id = $1 and "promotion_redemptions".customer_id = $2`, promotion.Id, order.CustomerId).Scan(&customerUsages)
+		if err != nil {
+			return money.Money{}, fmt.Errorf("failed to count promotion usages: %w", err)
+		}
+		if customerUsages >= int64(*promotion.UsageLimitPerCustomer) {
+			return money.Money{}, fmt.Errorf("%w: usage limit per customer is reached", ErrPromotionNotApplicable)
+		}
+	}
+
+	lines, err := s.txGetDiscountLines(ctx, tx, order.Id, order.Currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	// Promotion amounts are in the default currency and are converted by the order exchange rate
+	rate, err := money.ParseRate(order.ExchangeRate.String())
+	if err != nil {
+		return money.Money{}, err
+	}
+	prices := &priceList{Currency: order.Currency, Rate: rate}
+	currency := prices.Currency
+
+	subtotal := int64(0)
+	for _, line := range lines {
+		subtotal += line.Amount
+	}
+	if promotion.MinOrderValue != nil {
+		minOrderValue := prices.convert(*promotion.MinOrderValue)
+		if subtotal < minOrderValue.Amount {
+			return money.Money{}, fmt.Errorf("%w: minimum order value is %s", ErrPromotionNotApplicable, minOrderValue)
+		}
+	}
+
+	allocations := calculatePromotionDiscount(promotion, lines, prices)
+	if len(allocations) == 0 {
+		return money.Money{}, fmt.Errorf("%w: no eligible products in the order", ErrPromotionNotApplicable)
+	}
+
+	discount := int64(0)
+	description := fmt.Sprintf("Promotion %s", promotion.Code)
+	for _, allocation := range allocations {
+		adjustment := &OrderAdjustmentEntity{
+			OrderId:     order.Id,
+			OrderItemId: allocation.OrderItemId,
+			PromotionId: &promotion.Id,
+			Type:        OrderAdjustmentPromotion,
+			Description: description,
+			Amount:      money.New(-allocation.Amount, currency),
+		}
+		err := tx.QueryRow(ctx, `
+			insert into "order_adjustments" (order_id, order_item_id, promotion_id, type, description, amount)
+			values ($1, $2, $3, $4, $5, $6)
+			returning id`,
+			adjustment.OrderId,
+			adjustment.OrderItemId,
+			adjustment.PromotionId,
+			adjustment.Type,
+			adjustment.Description,
+			adjustment.Amount,
+		).Scan(&adjustment.Id)
+		if err != nil {
+			return money.Money{}, fmt.Errorf("failed to create order adjustment: %w", err)
+		}
+
+		order.Adjustments = append(order.Adjustments, adjustment)
+		discount += allocation.Amount
+	}
+
+	_, err = tx.Exec(ctx, `
+		insert into "promotion_redemptions" (promotion_id, order_id, customer_id)
+		values ($1, $2, $3)`, promotion.Id, order.Id, order.CustomerId)
+	if err != nil {
+		return money.Money{}, fmt.Errorf("failed to redeem promotion: %w", err)
+	}
+
+	return money.New(discount, currency), nil
+}
+
+func (s *PromotionEntityStore) txGetByCode(ctx context.Context, tx pgx.Tx, code string, lock bool) (*PromotionEntity, error) {
+	query := `select ` + promotionColumnsSQL + ` from "promotions" where code = $1`
+	if lock {
+		query += ` for update`
+	}
+
+	promotion, err := scanPromotion(tx.QueryRow(ctx, query, code))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPromotionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get promotion: %w", err)
+	}
+	return promotion, nil
+}
+
+func (s *PromotionEntityStore) txGetDiscountLines(ctx context.Context, tx pgx.Tx, orderId int64, currency string) ([]discountLine, error) {
+	rows, err := tx.Query(ctx, `
+		select
+			"order_items".id,
+			"products".id,
+			`+categoryAncestorsSQL(`"products".category_id`)+`,
+			"order_items".price,
+			"order_items".quantity
+		from "order_items"
+		join "product_variants" on "product_variants".id = "order_items".product_variant_id
+		join "products" on "products".id = "product_variants".product_id
+		where "order_items".order_id = $1
+		order by "order_items".id`, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order items: %w", err)
+	}
+	defer rows.Close()
+
+	lines := make([]discountLine, 0)
+	for rows.Next() {
+		var line discountLine
+		price := money.New(0, currency)
+		var quantity int64
+		if err := rows.Scan(&line.OrderItemId, &line.ProductId, &line.CategoryIds, &price, &quantity); err != nil {
+			return nil, err
+		}
+		line.Amount = price.Mul(quantity).Amount
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// Calculates the discount of the promotion for the given order lines.
+// Promotions without categories and products discount the whole order, otherwise the discount
+// is applied to the eligible lines only (products of subcategories are eligible for their parent categories) and a fixed discount is split between them proportionally
+func calculatePromotionDiscount(promotion *PromotionEntity, lines []discountLine, prices *priceList) []discountAllocation {
+	isScoped := len(promotion.CategoryIds) > 0 || len(promotion.ProductIds) > 0
+
+	eligible := make([]discountLine, 0, len(lines))
+	eligibleTotal := int64(0)
+	for _, line := range lines {
+		if !isScoped || slices.ContainsFunc(line.CategoryIds, func(id int64) bool { return containsId(promotion.CategoryIds, id) }) ||
+			containsId(promotion.ProductIds, line.ProductId) {
+			eligible = append(eligible, line)
+			eligibleTotal += line.Amount
+		}
+	}
+	if eligibleTotal == 0 {
+		return nil
+	}
+
+	discount := int64(0)
+	switch promotion.DiscountType {
+	case PromotionDiscountPercentage:
+		// Rounded half up to the minor unit
+		discount = (eligibleTotal*int64(*promotion.DiscountPercent) + 50) / 100
+	case PromotionDiscountFixed:
+		discount = min(prices.convert(*promotion.DiscountAmount).Amount, eligibleTotal)
+	}
+	if discount <= 0 {
+		return nil
+	}
+
+	if !isScoped {
+		return []discountAllocation{{OrderItemId: nil, Amount: discount}}
+	}
+
+	// Split the discount proportionally to the line amounts, the last line gets the rounding remainder
+	allocations := make([]discountAllocation, 0, len(eligible))
+	allocated := int64(0)
+	for i, line := range eligible {
+		amount := discount - allocated
+		if i < len(eligible)-1 {
+			amount = discount * line.Amount / eligibleTotal
+		}
+		allocated += amount
+
+		orderItemId := line.OrderItemId
+		allocations = append(allocations, discountAllocation{OrderItemId: &orderItemId, Amount: amount})
+	}
+	return allocations
+}
+
+func validatePromotion(opts *PromotionCreateUpdate) error {
+	if opts.Code == "" {
+		return fmt.Errorf("%w: code is required", ErrInvalidPromotion)
+	}
+	switch opts.DiscountType {
+	case PromotionDiscountPercentage:
+		if opts.DiscountPercent == nil || *opts.DiscountPercent <= 0 || *opts.DiscountPercent > 100 {
+			return fmt.Errorf("%w: discount percent must be in range [1, 100]", ErrInvalidPromotion)
+		}
+		if opts.DiscountAmount != nil {
+			return fmt.Errorf("%w: percentage promotions have no discount amount", ErrInvalidPromotion)
+		}
+	case PromotionDiscountFixed:
+		if opts.DiscountAmount == nil || opts.DiscountAmount.Amount <= 0 {
+			return fmt.Errorf("%w: discount amount must be positive", ErrInvalidPromotion)
+		}
+		if !isDefaultCurrency(*opts.DiscountAmount) {
+			return fmt.Errorf("%w: discount amount must be in the default currency", ErrInvalidPromotion)
+		}
+		if opts.DiscountPercent != nil {
+			return fmt.Errorf("%w: fixed promotions have no discount percent", ErrInvalidPromotion)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported discount type '%s'", ErrInvalidPromotion, opts.DiscountType)
+	}
+	if opts.MinOrderValue != nil && !isDefaultCurrency(*opts.MinOrderValue) {
+		return fmt.Errorf("%w: minimum order value must be in the default currency", ErrInvalidPromotion)
+	}
+	if opts.StartsAt != nil && opts.EndsAt != nil && opts.EndsAt.Before(*opts.StartsAt) {
+		return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidPromotion)
+	}
+	return nil
+}
+
+// Redemptions that count towards the usage limits. Cancelled orders release their redemptions
+const countedRedemptionsSQL = `"promotion_redemptions"
+	join "orders" on "orders".id = "promotion_redemptions".order_id and "orders".status <> '` + OrderStatusCancelled + `'`
+
+const promotionColumnsSQL = `
+	"promotions".id,
+	"promotions".code,
+	"promotions".name,
+	"promotions".discount_type,
+	"promotions".discount_percent,
+	"promotions".discount_amount,
+	"promotions".min_order_value,
+	"promotions".usage_limit,
+	"promotions".usage_limit_per_customer,
+	"promotions".starts_at,
+	"promotions".ends_at,
+	"promotions".is_active,
+	array(select category_id from "promotion_categories" where promotion_id = "promotions".id order by category_id),
+	array(select product_id from "promotion_products" where promotion_id = "promotions".id order by product_id),
+	(select count(*) from ` + countedRedemptionsSQL + ` where "promotion_redemptions".promotion_id = "promotions".id),
+	"promotions".created_at`
+
+func scanPromotion(row pgx.Row) (*PromotionEntity, error) {
+	var promotion PromotionEntity
+	err := row.Scan(
+		&promotion.Id,
+		&promotion.Code,
+		&promotion.Name,
+		&promotion.DiscountType,
+		&promotion.DiscountPercent,
+		&promotion.DiscountAmount,
+		&promotion.MinOrderValue,
+		&promotion.UsageLimit,
+		&promotion.UsageLimitPerCustomer,
+		&promotion.StartsAt,
+		&promotion.EndsAt,
+		&promotion.IsActive,
+		&promotion.CategoryIds,
+		&promotion.ProductIds,
+		&promotion.UsageCount,
+		&promotion.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &promotion, nil
+}
+
+func containsId(ids []int64, id int64) bool {
+	for _, value := range ids {
+		if value == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,415 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"netshop/main/tools/money"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	ReportPeriodDay   = "day"
+	ReportPeriodWeek  = "week"
+	ReportPeriodMonth = "month"
+)
+
+var ErrInvalidReport = errors.New("invalid report parameters")
+
+// Orders that count as sales
+const soldOrdersSQL = `"orders".status in ('processing', 'shipped', 'delivered')`
+
+// Line sales after discounts in the default currency
+const lineRevenueSQL = `"order_items".tax_base / "orders".exchange_rate`
+
+// ReportTable is a report that can be exported as CSV
+type ReportTable interface {
+	Header() []string
+	Records() [][]string
+}
+
+type ReportOptions struct {
+	// Inclusive range of the order dates, nil values are not limited
+	From *time.Time
+	To   *time.Time
+	// One of day, week, month
+	Period string
+	// Sorting and grouping dimension of the report
+	By string
+	// Zero limit returns all rows
+	Limit int64
+}
+
+type RevenueRow struct {
+	Period            time.Time   `json:"period"`
+	Orders            int64       `json:"orders"`
+	Revenue           money.Money `json:"revenue"`
+	Discounts         money.Money `json:"discounts"`
+	Taxes             money.Money `json:"taxes"`
+	Shipping          money.Money `json:"shipping"`
+	AverageOrderValue money.Money `json:"average_order_value"`
+}
+
+type RevenueReport []RevenueRow
+
+type TopProductRow struct {
+	ProductId   int64  `json:"product_id"`
+	ProductName string `json:"product_name"`
+	// Variant fields are empty when the report is grouped by products
+	ProductVariantId *int64 `json:"product_variant_id,omitempty"`
+	// Attribute values of the variant, e.g. "Color: Black, Size: M"
+	Attributes string      `json:"attributes,omitempty"`
+	Units      int64       `json:"units"`
+	Revenue    money.Money `json:"revenue"`
+}
+
+type TopProductsReport []TopProductRow
+
+type SalesGroupRow struct {
+	Id      *int64      `json:"id"`
+	Name    string      `json:"name"`
+	Orders  int64       `json:"orders"`
+	Units   int64       `json:"units"`
+	Revenue money.Money `json:"revenue"`
+}
+
+type SalesGroupReport []SalesGroupRow
+
+type CustomersRow struct {
+	Period time.Time `json:"period"`
+	// Customers whose first sold order is in the period
+	NewCustomers       int64 `json:"new_customers"`
+	ReturningCustomers int64 `json:"returning_customers"`
+}
+
+type CustomersReport []CustomersRow
+
+type ReportStore struct {
+	db *DatabaseConnection
+}
+
+func NewReportStore(database *DatabaseConnection) *ReportStore {
+	return &ReportStore{
+		db: database,
+	}
+}
+
+// Revenue, order count and average order value per period. The report is built from
+// the "daily_sales" view, so it includes orders up to the last refresh
+func (s *ReportStore) GetRevenue(ctx context.Context, opts *ReportOptions) (RevenueReport, error) {
+	if err := validateReportPeriod(opts.Period); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Connection.Query(ctx, `
+		select date_trunc($1, day)::date, sum(orders)::bigint, sum(revenue), sum(discounts), sum(taxes), sum(shipping)
+		from "daily_sales"
+		where ($2::date is null or day >= $2) and ($3::date is null or day <= $3)
+		group by 1
+		order by 1`, opts.Period, opts.From, opts.To)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revenue: %w", err)
+	}
+	defer rows.Close()
+
+	report := make(RevenueReport, 0)
+	for rows.Next() {
+		var row RevenueRow
+		var revenue, discounts, taxes, shipping pgtype.Numeric
+		if err := rows.Scan(&row.Period, &row.Orders, &revenue, &discounts, &taxes, &shipping); err != nil {
+			return nil, err
+		}
+
+		amounts, err := reportAmounts(revenue, discounts, taxes, shipping)
+		if err != nil {
+			return nil, err
+		}
+		row.Revenue, row.Discounts, row.Taxes, row.Shipping = amounts[0], amounts[1], amounts[2], amounts[3]
+		row.AverageOrderValue = money.New(0, money.DefaultCurrency())
+		if row.Orders > 0 {
+			row.AverageOrderValue = money.New(row.Revenue.Amount/row.Orders, row.Revenue.Currency)
+		}
+		report = append(report, row)
+	}
+
+	return report, rows.Err()
+}
+
+// Best selling products or variants (by "variant") sorted by units or revenue (opts.By)
+func (s *ReportStore) GetTopProducts(ctx context.Context, opts *ReportOptions, byVariant bool) (TopProductsReport, error) {
+	orderColumn := "units"
+	switch opts.By {
+	case "", "units":
+	case "revenue":
+		orderColumn = "revenue"
+	default:
+		return nil, fmt.Errorf("%w: unsupported sorting '%s'", ErrInvalidReport, opts.By)
+	}
+
+	variantColumns := `null::integer, ''`
+	groupBy := `"products".id`
+	if byVariant {
+		variantColumns = `"product_variants".id, coalesce(` + variantAttributesTextSQL + `, '')`
+		groupBy = `"products".id, "product_variants".id`
+	}
+
+	rows, err := s.db.Connection.Query(ctx, `
+		select "products".id, "products".name, `+variantColumns+`,
+			sum("order_items".quantity)::bigint as units, sum(`+lineRevenueSQL+`) as revenue
+		from "order_items"
+		join "orders" on "orders".id = "order_items".order_id
+		join "product_variants" on "product_variants".id = "order_items".product_variant_id
+		join "products" on "products".id = "product_variants".product_id
+		where `+soldOrdersSQL+` and `+reportDatesSQL+`
+		group by `+groupBy+`
+		order by `+orderColumn+` desc, "products".id
+		`+reportLimitSQL(opts.Limit), opts.From, opts.To)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top products: %w", err)
+	}
+	defer rows.Close()
+
+	report := make(TopProductsReport, 0)
+	for rows.Next() {
+		var row TopProductRow
+		var revenue pgtype.Numeric
+		err := rows.Scan(&row.ProductId, &row.ProductName, &row.ProductVariantId, &row.Attributes, &row.Units, &revenue)
+		if err != nil {
+			return nil, err
+		}
+		if row.Revenue, err = money.FromNumeric(revenue, money.DefaultCurrency()); err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+
+	return report, rows.Err()
+}
+
+// Sales grouped by category or by the options of an option attribute (opts.By is the attribute code, e.g. size or color).
+// Variants without the attribute are in the group without id
+func (s *ReportStore) GetSalesBy(ctx context.Context, opts *ReportOptions) (SalesGroupReport, error) {
+	idColumn, nameColumn := `"categories".id`, `"categories".name`
+	join := `left join "categories" on "categories".id = "products".category_id`
+	args := []any{opts.From, opts.To}
+	if opts.By != "category" {
+		var attributeId int64
+		err := s.db.Connection.QueryRow(ctx, `select id from "attributes" where code = $1 and type = 'option'`, opts.By).Scan(&attributeId)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: unsupported grouping '%s'", ErrInvalidReport, opts.By)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get grouping attribute: %w", err)
+		}
+		idColumn, nameColumn = `"attribute_options".id`, `"attribute_options".value`
+		join = `left join "product_variant_attributes" on "product_variant_attributes".product_variant_id = "product_variants".id
+			and "product_variant_attributes".attribute_id = $3
+		left join "attribute_options" on "attribute_options".id = "product_variant_attributes".option_id`
+		args = append(args, attributeId)
+	}
+
+	rows, err := s.db.Connection.Query(ctx, `
+		select `+idColumn+`, coalesce(`+nameColumn+`, ''), count(distinct "orders".id),
+			sum("order_items".quantity)::bigint, sum(`+lineRevenueSQL+`) as revenue
+		from "order_items"
+		join "orders" on "orders".id = "order_items".order_id
+		join "product_variants" on "product_variants".id = "order_items".product_variant_id
+		join "products" on "products".id = "product_variants".product_id
+		`+join+`
+		where `+soldOrdersSQL+` and `+reportDatesSQL+`
+		group by 1, 2
+		order by revenue desc
+		`+reportLimitSQL(opts.Limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sales: %w", err)
+	}
+	defer rows.Close()
+
+	report := make(SalesGroupReport, 0)
+	for rows.Next() {
+		var row SalesGroupRow
+		var revenue pgtype.Numeric
+		if err := rows.Scan(&row.Id, &row.Name, &row.Orders, &row.Units, &revenue); err != nil {
+			return nil, err
+		}
+		if row.Revenue, err = money.FromNumeric(revenue, money.DefaultCurrency()); err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+
+	return report, rows.Err()
+}
+
+// New and returning customers per period
+func (s *ReportStore) GetCustomers(ctx context.Context, opts *ReportOptions) (CustomersReport, error) {
+	if err := validateReportPeriod(opts.Period); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Connection.Query(ctx, `
+		with first_orders as (
+			select customer_id, min(order_date) as first_order_date
+			from "orders"
+			where `+soldOrdersSQL+`
+			group by customer_id
+		)
+		select date_trunc($3, "orders".order_date)::date,
+			count(distinct "orders".customer_id) filter (
+				where date_trunc($3, first_orders.first_order_date) = date_trunc($3, "orders".order_date)),
+			count(distinct "orders".customer_id) filter (
+				where date_trunc($3, first_orders.first_order_date) < date_trunc($3, "orders".order_date))
+		from "orders"
+		join first_orders on first_orders.customer_id = "orders".customer_id
+		where `+soldOrdersSQL+` and `+reportDatesSQL+`
+		group by 1
+		order by 1`, opts.From, opts.To, opts.Period)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get customers: %w", err)
+	}
+	defer rows.Close()
+
+	report := make(CustomersReport, 0)
+	for rows.Next() {
+		var row CustomersRow
+		if err := rows.Scan(&row.Period, &row.NewCustomers, &row.ReturningCustomers); err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+
+	return report, rows.Err()
+}
+
+// Refreshes materialized views of the reports without blocking reads
+func (s *ReportStore) RefreshViews(ctx context.Context) error {
+	_, err := s.db.Connection.Exec(ctx, `refresh materialized view concurrently "daily_sales"`)
+	if err != nil {
+		return fmt.Errorf("failed to refresh daily sales: %w", err)
+	}
+	return nil
+}
+
+func (r RevenueReport) Header() []string {
+	return []string{"period", "orders", "revenue", "discounts", "taxes", "shipping", "average_order_value", "currency"}
+}
+
+func (r RevenueReport) Records() [][]string {
+	records := make([][]string, 0, len(r))
+	for _, row := range r {
+		records = append(records, []string{
+			row.Period.Format(time.DateOnly),
+			strconv.FormatInt(row.Orders, 10),
+			row.Revenue.Decimal(),
+			row.Discounts.Decimal(),
+			row.Taxes.Decimal(),
+			row.Shipping.Decimal(),
+			row.AverageOrderValue.Decimal(),
+			row.Revenue.Currency,
+		})
+	}
+	return records
+}
+
+func (r TopProductsReport) Header() []string {
+	return []string{"product_id", "product_name", "product_variant_id", "attributes", "units", "revenue", "currency"}
+}
+
+func (r TopProductsReport) Records() [][]string {
+	records := make([][]string, 0, len(r))
+	for _, row := range r {
+		variantId := ""
+		if row.ProductVariantId != nil {
+			variantId = strconv.FormatInt(*row.ProductVariantId, 10)
+		}
+		records = append(records, []string{
+			strconv.FormatInt(row.ProductId, 10),
+			row.ProductName,
+			variantId,
+			row.Attributes,
+			strconv.FormatInt(row.Units, 10),
+			row.Revenue.Decimal(),
+			row.Revenue.Currency,
+		})
+	}
+	return records
+}
+
+func (r SalesGroupReport) Header() []string {
+	return []string{"id", "name", "orders", "units", "revenue", "currency"}
+}
+
+func (r SalesGroupReport) Records() [][]string {
+	records := make([][]string, 0, len(r))
+	for _, row := range r {
+		id := ""
+		if row.Id != nil {
+			id = strconv.FormatInt(*row.Id, 10)
+		}
+		records = append(records, []string{
+			id,
+			row.Name,
+			strconv.FormatInt(row.Orders, 10),
+			strconv.FormatInt(row.Units, 10),
+			row.Revenue.Decimal(),
+			row.Revenue.Currency,
+		})
+	}
+	return records
+}
+
+func (r CustomersReport) Header() []string {
+	return []string{"period", "new_customers", "returning_customers"}
+}
+
+func (r CustomersReport) Records() [][]string {
+	records := make([][]string, 0, len(r))
+	for _, row := range r {
+		records = append(records, []string{
+			row.Period.Format(time.DateOnly),
+			strconv.FormatInt(row.NewCustomers, 10),
+			strconv.FormatInt(row.ReturningCustomers, 10),
+		})
+	}
+	return records
+}
+
+// Limits the order date by the first two query parameters
+const reportDatesSQL = `($1::date is null or "orders".order_date >= $1::date)
+	and ($2::date is null or "orders".order_date < $2::date + 1)`
+
+func reportLimitSQL(limit int64) string {
+	if limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("limit %d", limit)
+}
+
+func validateReportPeriod(period string) error {
+	switch period {
+	case ReportPeriodDay, ReportPeriodWeek, ReportPeriodMonth:
+		return nil
+	}
+	return fmt.Errorf("%w: unsupported period '%s'", ErrInvalidReport, period)
+}
+
+// Converts numeric sums of the default currency, NULL sums of empty groups are zero
+func reportAmounts(values ...pgtype.Numeric) ([]money.Money, error) {
+	amounts := make([]money.Money, 0, len(values))
+	for _, value := range values {
+		if !value.Valid {
+			amounts = append(amounts, money.New(0, money.DefaultCurrency()))
+			continue
+		}
+		amount, err := money.FromNumeric(value, money.DefaultCurrency())
+		if err != nil {
+			return nil, err
+		}
+		amounts = append(amounts, amount)
+	}
+	return amounts, nil
+}
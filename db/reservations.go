@@ -0,0 +1,237 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"netshop/main/config"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	ReservationStatusActive    = "active"
+	ReservationStatusConfirmed = "confirmed"
+	ReservationStatusReleased  = "released"
+)
+
+var (
+	ErrInsufficientStock  = errors.New("insufficient stock")
+	ErrReservationExpired = errors.New("order stock reservations have expired")
+)
+
+// SQL expression of the quantity held by active reservations of the "product_variants" row.
+// Available stock of a variant is "product_variants"."stock" minus this value
+const reservedStockSQL = `coalesce((
+		select sum("inventory_reservations"."quantity")
+		from "inventory_reservations"
+		where "inventory_reservations"."product_variant_id" = "product_variants"."id"
+			and "inventory_reservations"."status" = 'active'
+			and "inventory_reservations"."expires_at" > now()
+	), 0)`
+
+// InventoryReservationEntity represents stock held for a pending order
+type InventoryReservationEntity struct {
+	Id               int64     `json:"id"`
+	OrderId          int64     `json:"order_id"`
+	ProductVariantId int64     `json:"product_variant_id"`
+	Quantity         int32     `json:"quantity"`
+	Status           string    `json:"status"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type InventoryReservationStore struct {
+	db *DatabaseConnection
+}
+
+func NewInventoryReservationStore(database *DatabaseConnection) *InventoryReservationStore {
+	return &InventoryReservationStore{
+		db: database,
+	}
+}
+
+func (s *InventoryReservationStore) GetByOrderId(orderId int64) ([]InventoryReservationEntity, error) {
+	rows, err := s.db.Connection.Query(s.db.Context, `
+		select id, order_id, product_variant_id, quantity, status, expires_at, created_at
+		from "inventory_reservations"
+		where order_id = $1
+		order by id`, orderId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reservations := make([]InventoryReservationEntity, 0)
+	for rows.Next() {
+		var reservation InventoryReservationEntity
+		err := rows.Scan(
+			&reservation.Id,
+			&reservation.OrderId,
+			&reservation.ProductVariantId,
+			&reservation.Quantity,
+			&reservation.Status,
+			&reservation.ExpiresAt,
+			&reservation.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, reservation)
+	}
+
+	return reservations, nil
+}
+
+// Releases all active reservations that are past their expiration time and cancels pending orders
+// that have no active reservations left. Orders with a payment in progress are left to the payment,
+// which cancels and refunds them when their reservations are expired.
+// Returns the number of released reservations and cancelled orders
+func (s *InventoryReservationStore) ReleaseExpired(ctx context.Context) (int64, int64, error) {
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	released, err := tx.Exec(ctx, `
+		update "inventory_reservations"
+		set status = 'released', updated_at = now()
+		where status = 'active' and expires_at <= now()`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to release expired reservations: %w", err)
+	}
+
+	cancelled, err := tx.Exec(ctx, `
+		update "orders"
+		set status = 'cancelled', status_date = now(), updated_at = now()
+		where status = 'pending'
+			and exists(select 1 from "inventory_reservations" where order_id = "orders".id and status = 'released')
+			and not exists(select 1 from "inventory_reservations" where order_id = "orders".id and status <> 'released')
+			and not exists(
+				select 1 from "payments"
+				where order_id = "orders".id and status in ('pending', 'authorized', 'captured')
+			)`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to cancel orders with expired reservations: %w", err)
+	}
+
+	return released.RowsAffected(), cancelled.RowsAffected(), tx.Commit(ctx)
+}
+
+// Holds the given quantity of the variant for the order.
+// The variant row is locked until the transaction ends, so concurrent orders cannot oversell it
+func (s *InventoryReservationStore) txReserve(ctx context.Context, tx pgx.Tx, orderId, variantId int64, quantity int) error {
+	ttl, err := time.ParseDuration(config.AppConfig.ReservationTTL)
+	if err != nil {
+		return fmt.Errorf("invalid reservation TTL: %w", err)
+	}
+
+	var available int64
+	err = tx.QueryRow(ctx, `
+		select "product_variants"."stock" - `+reservedStockSQL+`
+		from "product_variants"
+		where "product_variants"."id" = $1
+		for update`, variantId).Scan(&available)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("product variant with id '%d' not found", variantId)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check available stock: %w", err)
+	}
+	if available < int64(quantity) {
+		return fmt.Errorf("%w for product variant '%d': requested %d, available %d", ErrInsufficientStock, variantId, quantity, available)
+	}
+
+	_, err = tx.Exec(ctx, `
+		insert into "inventory_reservations" (order_id, product_variant_id, quantity, expires_at)
+		values ($1, $2, $3, $4)`,
+		orderId, variantId, quantity, time.Now().Add(ttl),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reserve stock: %w", err)
+	}
+	return nil
+}
+
+// Converts active reservations of the order into an actual stock decrement
+// and records the sales in the stock ledger
+func (s *InventoryReservationStore) txConfirm(ctx context.Context, tx pgx.Tx, orderId int64, employeeId *int64) error {
+	var expired bool
+	err := tx.QueryRow(ctx, `
+		select exists(
+			select 1 from "inventory_reservations"
+			where order_id = $1 and (status = 'released' or (status = 'active' and expires_at <= now()))
+		)`, orderId).Scan(&expired)
+	if err != nil {
+		return fmt.Errorf("failed to check reservations: %w", err)
+	}
+	if expired {
+		return ErrReservationExpired
+	}
+
+	_, err = tx.Exec(ctx, `
+		with confirmed as (
+			update "inventory_reservations"
+			set status = 'confirmed', updated_at = now()
+			where order_id = $1 and status = 'active'
+			returning order_id, product_variant_id, quantity
+		), movements as (
+			insert into "stock_movements" (product_variant_id, type, quantity, employee_id, order_id, reason)
+			select product_variant_id, 'sale'::stock_movement_type, -quantity, $2::integer, order_id, 'Order confirmed'
+			from confirmed
+		)
+		update "product_variants"
+		set stock = stock - totals.quantity
+		from (
+			select product_variant_id, sum(quantity) as quantity
+			from confirmed
+			group by product_variant_id
+		) as totals
+		where "product_variants".id = totals.product_variant_id`, orderId, employeeId)
+	if err != nil {
+		return fmt.Errorf("failed to confirm reservations: %w", err)
+	}
+	return nil
+}
+
+// Releases active reservations of the order without touching the stock
+func (s *InventoryReservationStore) txRelease(ctx context.Context, tx pgx.Tx, orderId int64) error {
+	_, err := tx.Exec(ctx, `
+		update "inventory_reservations"
+		set status = 'released', updated_at = now()
+		where order_id = $1 and status = 'active'`, orderId)
+	if err != nil {
+		return fmt.Errorf("failed to release reservations: %w", err)
+	}
+	return nil
+}
+
+// Returns the stock of confirmed reservations back to the variants
+// and records the cancellation in the stock ledger
+func (s *InventoryReservationStore) txCancelConfirmed(ctx context.Context, tx pgx.Tx, orderId int64, employeeId *int64) error {
+	_, err := tx.Exec(ctx, `
+		with cancelled as (
+			update "inventory_reservations"
+			set status = 'released', updated_at = now()
+			where order_id = $1 and status = 'confirmed'
+			returning order_id, product_variant_id, quantity
+		), movements as (
+			insert into "stock_movements" (product_variant_id, type, quantity, employee_id, order_id, reason)
+			select product_variant_id, 'cancellation'::stock_movement_type, quantity, $2::integer, order_id, 'Order cancelled'
+			from cancelled
+		)
+		update "product_variants"
+		set stock = stock + totals.quantity
+		from (
+			select product_variant_id, sum(quantity) as quantity
+			from cancelled
+			group by product_variant_id
+		) as totals
+		where "product_variants".id = totals.product_variant_id`, orderId, employeeId)
+	if err != nil {
+		return fmt.Errorf("failed to cancel reservations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,558 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"netshop/main/tools/money"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+	ReturnStatusReceived  = "received"
+	ReturnStatusInspected = "inspected"
+	ReturnStatusRefunding = "refunding"
+	ReturnStatusRefunded  = "refunded"
+)
+
+var (
+	ErrReturnNotFound          = errors.New("return not found")
+	ErrInvalidReturn           = errors.New("invalid return")
+	ErrInvalidReturnTransition = errors.New("invalid return status transition")
+)
+
+// Status changes made directly by employees, inspection and refund have their own methods
+var returnTransitions = map[string][]string{
+	ReturnStatusRequested: {ReturnStatusApproved, ReturnStatusRejected},
+	ReturnStatusApproved:  {ReturnStatusReceived},
+}
+
+type ReturnItemEntity struct {
+	Id               int64       `json:"id"`
+	ReturnId         int64       `json:"return_id"`
+	OrderItemId      int64       `json:"order_item_id"`
+	ProductVariantId int64       `json:"product_variant_id"`
+	Quantity         int32       `json:"quantity"`
+	Reason           string      `json:"reason"`
+	RefundAmount     money.Money `json:"refund_amount"`
+	// Quantity put back to the stock, nil until the return is inspected
+	RestockedQuantity *int32 `json:"restocked_quantity"`
+}
+
+// ReturnEntity is a return request (RMA) of delivered order items
+type ReturnEntity struct {
+	Id           int64               `json:"id"`
+	OrderId      int64               `json:"order_id"`
+	CustomerId   int64               `json:"customer_id"`
+	Status       string              `json:"status"`
+	Comment      string              `json:"comment"`
+	RefundAmount money.Money         `json:"refund_amount"`
+	EmployeeId   *int64              `json:"employee_id"`
+	CreatedAt    time.Time           `json:"created_at"`
+	UpdatedAt    time.Time           `json:"updated_at"`
+	Items        []*ReturnItemEntity `json:"items"`
+}
+
+type ReturnItemCreate struct {
+	OrderItemId int64  `json:"order_item_id"`
+	Quantity    int32  `json:"quantity"`
+	Reason      string `json:"reason"`
+}
+
+type ReturnCreate struct {
+	OrderId    int64               `json:"-"`
+	CustomerId int64               `json:"-"`
+	Comment    string              `json:"comment"`
+	Items      []*ReturnItemCreate `json:"items"`
+}
+
+type ReturnItemInspection struct {
+	ReturnItemId      int64 `json:"return_item_id"`
+	RestockedQuantity int32 `json:"restocked_quantity"`
+}
+
+type ReturnInspection struct {
+	ReturnId   int64 `json:"-"`
+	EmployeeId int64 `json:"-"`
+	// Items missing in the list are not restocked
+	Items []*ReturnItemInspection `json:"items"`
+}
+
+type ReturnGetAllOptions struct {
+	CustomerId *int64
+	Status     *string
+}
+
+type ReturnStore struct {
+	db       *DatabaseConnection
+	Payments *PaymentStore
+}
+
+func NewReturnStore(database *DatabaseConnection, payments *PaymentStore) *ReturnStore {
+	return &ReturnStore{
+		db:       database,
+		Payments: payments,
+	}
+}
+
+func (s *ReturnStore) GetAll(opts *ReturnGetAllOptions) ([]ReturnEntity, error) {
+	rows, err := s.db.Connection.Query(s.db.Context, `
+		select id, order_id, customer_id, status, comment, refund_amount, currency, employee_id, created_at, updated_at
+		from "returns"
+		where ($1::integer is null or customer_id = $1)
+			and ($2::text is null or status::text = $2)
+		order by id desc`, opts.CustomerId, opts.Status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	returns := make([]ReturnEntity, 0)
+	for rows.Next() {
+		entity, err := scanReturn(rows)
+		if err != nil {
+			return nil, err
+		}
+		returns = append(returns, *entity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return returns, s.loadItems(returns)
+}
+
+func (s *ReturnStore) GetById(id int64) (*ReturnEntity, error) {
+	entity, err := scanReturn(s.db.Connection.QueryRow(s.db.Context, `
+		select id, order_id, customer_id, status, comment, refund_amount, currency, employee_id, created_at, updated_at
+		from "returns"
+		where id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrReturnNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get return: %w", err)
+	}
+
+	returns := []ReturnEntity{*entity}
+	if err := s.loadItems(returns); err != nil {
+		return nil, err
+	}
+	return &returns[0], nil
+}
+
+// Creates a return request for items of a delivered order of the customer.
+// The refund of every item is its share of the paid line total including exclusive taxes
+func (s *ReturnStore) Create(ctx context.Context, opts *ReturnCreate) (*ReturnEntity, error) {
+	if len(opts.Items) == 0 {
+		return nil, fmt.Errorf("%w: items are required", ErrInvalidReturn)
+	}
+	seen := make(map[int64]bool, len(opts.Items))
+	for _, item := range opts.Items {
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: item quantity must be positive", ErrInvalidReturn)
+		}
+		if seen[item.OrderItemId] {
+			return nil, fmt.Errorf("%w: order item %d is listed twice", ErrInvalidReturn, item.OrderItemId)
+		}
+		seen[item.OrderItemId] = true
+	}
+
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the order serializes return requests of the same order
+	var status, currency string
+	var customerId int64
+	err = tx.QueryRow(ctx, `
+		select status, customer_id, currency from "orders" where id = $1 for update`, opts.OrderId,
+	).Scan(&status, &customerId, &currency)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && customerId != opts.CustomerId) {
+		return nil, fmt.Errorf("order with id '%d' not found", opts.OrderId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	if status != OrderStatusDelivered {
+		return nil, fmt.Errorf("%w: only delivered orders can be returned, order is %s", ErrInvalidReturn, status)
+	}
+
+	entity := &ReturnEntity{
+		OrderId:      opts.OrderId,
+		CustomerId:   opts.CustomerId,
+		Status:       ReturnStatusRequested,
+		Comment:      opts.Comment,
+		RefundAmount: money.New(0, currency),
+		Items:        make([]*ReturnItemEntity, 0, len(opts.Items)),
+	}
+	for _, item := range opts.Items {
+		returnItem, err := s.txPrepareItem(ctx, tx, opts.OrderId, currency, item)
+		if err != nil {
+			return nil, err
+		}
+		entity.RefundAmount = entity.RefundAmount.Add(returnItem.RefundAmount)
+		entity.Items = append(entity.Items, returnItem)
+	}
+
+	err = tx.QueryRow(ctx, `
+		insert into "returns" (order_id, customer_id, comment, refund_amount, currency)
+		values ($1, $2, $3, $4, $5)
+		returning id, created_at, updated_at`,
+		entity.OrderId, entity.CustomerId, entity.Comment, entity.RefundAmount, currency,
+	).Scan(&entity.Id, &entity.CreatedAt, &entity.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create return: %w", err)
+	}
+
+	for _, item := range entity.Items {
+		item.ReturnId = entity.Id
+		err = tx.QueryRow(ctx, `
+			insert into "return_items" (return_id, order_item_id, quantity, reason, refund_amount)
+			values ($1, $2, $3, $4, $5)
+			returning id`,
+			item.ReturnId, item.OrderItemId, item.Quantity, item.Reason, item.RefundAmount,
+		).Scan(&item.Id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create return item: %w", err)
+		}
+	}
+
+	return entity, tx.Commit(ctx)
+}
+
+// Approves, rejects or marks the return as received by the warehouse
+func (s *ReturnStore) UpdateStatus(ctx context.Context, id int64, status string, employeeId int64) error {
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	currentStatus, _, err := s.txLock(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	allowed := false
+	for _, next := range returnTransitions[currentStatus] {
+		allowed = allowed || next == status
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s return cannot be %s", ErrInvalidReturnTransition, currentStatus, status)
+	}
+
+	if err := s.txSetStatus(ctx, tx, id, status, employeeId); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Records the inspection of a received return: restocks the sellable quantities
+// into the inventory and records them in the stock ledger
+func (s *ReturnStore) Inspect(ctx context.Context, opts *ReturnInspection) (*ReturnEntity, error) {
+	entity, err := s.GetById(opts.ReturnId)
+	if err != nil {
+		return nil, err
+	}
+
+	restocked := make(map[int64]int32, len(opts.Items))
+	for _, item := range opts.Items {
+		restocked[item.ReturnItemId] = item.RestockedQuantity
+	}
+	for _, item := range entity.Items {
+		quantity := restocked[item.Id]
+		if quantity < 0 || quantity > item.Quantity {
+			return nil, fmt.Errorf("%w: restocked quantity of item %d must be between 0 and %d", ErrInvalidReturn, item.Id, item.Quantity)
+		}
+		if quantity > 0 && item.ProductVariantId == 0 {
+			return nil, fmt.Errorf("%w: product of item %d does not exist anymore", ErrInvalidReturn, item.Id)
+		}
+		item.RestockedQuantity = &quantity
+		delete(restocked, item.Id)
+	}
+	for id := range restocked {
+		return nil, fmt.Errorf("%w: item %d does not belong to the return", ErrInvalidReturn, id)
+	}
+
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	currentStatus, _, err := s.txLock(ctx, tx, entity.Id)
+	if err != nil {
+		return nil, err
+	}
+	if currentStatus != ReturnStatusReceived {
+		return nil, fmt.Errorf("%w: only received returns can be inspected, return is %s", ErrInvalidReturnTransition, currentStatus)
+	}
+
+	movementStore := NewStockMovementStore(s.db)
+	for _, item := range entity.Items {
+		_, err := tx.Exec(ctx, `update "return_items" set restocked_quantity = $2 where id = $1`, item.Id, *item.RestockedQuantity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update return item: %w", err)
+		}
+		if *item.RestockedQuantity == 0 {
+			continue
+		}
+
+		err = movementStore.txRecord(ctx, tx, &StockMovementEntity{
+			ProductVariantId: item.ProductVariantId,
+			Type:             StockMovementReturn,
+			Quantity:         *item.RestockedQuantity,
+			EmployeeId:       &opts.EmployeeId,
+			OrderId:          &entity.OrderId,
+			Reason:           fmt.Sprintf("Return #%d", entity.Id),
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(ctx, `update "product_variants" set stock = stock + $2 where id = $1`, item.ProductVariantId, *item.RestockedQuantity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update stock: %w", err)
+		}
+	}
+
+	if err := s.txSetStatus(ctx, tx, entity.Id, ReturnStatusInspected, opts.EmployeeId); err != nil {
+		return nil, err
+	}
+	entity.Status = ReturnStatusInspected
+	entity.EmployeeId = &opts.EmployeeId
+
+	return entity, tx.Commit(ctx)
+}
+
+// Refunds the inspected return through the payment gateway. The return stays locked
+// during the refund, so concurrent requests cannot refund it twice
+func (s *ReturnStore) Refund(ctx context.Context, id int64, employeeId int64) error {
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	currentStatus, orderId, err := s.txLock(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if currentStatus != ReturnStatusInspected {
+		return fmt.Errorf("%w: only inspected returns can be refunded, return is %s", ErrInvalidReturnTransition, currentStatus)
+	}
+
+	var refundAmount pgtype.Numeric
+	var currency string
+	err = tx.QueryRow(ctx, `select refund_amount, currency from "returns" where id = $1`, id).Scan(&refundAmount, &currency)
+	if err != nil {
+		return fmt.Errorf("failed to get return: %w", err)
+	}
+	amount, err := money.FromNumeric(refundAmount, currency)
+	if err != nil {
+		return err
+	}
+
+	// The status is committed before the provider is called, so a concurrent refund of the return
+	// is rejected and money is never returned for a return whose status could not be saved
+	if err := s.txSetStatus(ctx, tx, id, ReturnStatusRefunding, employeeId); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if !amount.IsZero() {
+		if err := s.Payments.RefundAmount(ctx, orderId, amount); err != nil {
+			// Provider errors mean the refund was not made, so the return can be refunded again
+			if err := s.setStatusFrom(ctx, id, ReturnStatusRefunding, ReturnStatusInspected, employeeId); err != nil {
+				log.Printf("Failed to reset status of return %d after a failed refund: %s", id, err.Error())
+			}
+			return err
+		}
+	}
+	return s.setStatusFrom(ctx, id, ReturnStatusRefunding, ReturnStatusRefunded, employeeId)
+}
+
+// Calculates the refund of the returned quantity of the order item. Returning the rest
+// of the item refunds the rest of its line total, so partial returns add up exactly
+func (s *ReturnStore) txPrepareItem(ctx context.Context, tx pgx.Tx, orderId int64, currency string, item *ReturnItemCreate) (*ReturnItemEntity, error) {
+	var variantId *int64
+	var quantity, returnedQuantity int32
+	var taxBase, taxAmount, returnedAmount pgtype.Numeric
+	var taxInclusive bool
+	err := tx.QueryRow(ctx, `
+		select "order_items".product_variant_id, "order_items".quantity, "order_items".tax_base,
+			"order_items".tax_inclusive, "order_items".tax_amount,
+			coalesce(sum("return_items".quantity), 0)::integer, coalesce(sum("return_items".refund_amount), 0)
+		from "order_items"
+		left join "return_items" on "return_items".order_item_id = "order_items".id
+			and "return_items".return_id in (select id from "returns" where status <> 'rejected')
+		where "order_items".id = $1 and "order_items".order_id = $2
+		group by "order_items".id`, item.OrderItemId, orderId,
+	).Scan(&variantId, &quantity, &taxBase, &taxInclusive, &taxAmount, &returnedQuantity, &returnedAmount)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: order item %d not found in the order", ErrInvalidReturn, item.OrderItemId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order item: %w", err)
+	}
+	if variantId == nil {
+		return nil, fmt.Errorf("%w: product of order item %d does not exist anymore", ErrInvalidReturn, item.OrderItemId)
+	}
+	if item.Quantity > quantity-returnedQuantity {
+		return nil, fmt.Errorf("%w: only %d of order item %d can be returned", ErrInvalidReturn, quantity-returnedQuantity, item.OrderItemId)
+	}
+
+	amounts := make([]money.Money, 0, 3)
+	for _, value := range []pgtype.Numeric{taxBase, taxAmount, returnedAmount} {
+		amount, err := money.FromNumeric(value, currency)
+		if err != nil {
+			return nil, err
+		}
+		amounts = append(amounts, amount)
+	}
+	lineTotal := amounts[0]
+	if !taxInclusive {
+		lineTotal = lineTotal.Add(amounts[1])
+	}
+
+	refund := lineTotal.MulRat(big.NewRat(int64(item.Quantity), int64(quantity)))
+	if item.Quantity == quantity-returnedQuantity {
+		refund = lineTotal.Sub(amounts[2])
+	}
+
+	return &ReturnItemEntity{
+		OrderItemId:      item.OrderItemId,
+		ProductVariantId: *variantId,
+		Quantity:         item.Quantity,
+		Reason:           item.Reason,
+		RefundAmount:     refund,
+	}, nil
+}
+
+// Locks the return row for the status change and returns its status and order id
+func (s *ReturnStore) txLock(ctx context.Context, tx pgx.Tx, id int64) (string, int64, error) {
+	var status string
+	var orderId int64
+	err := tx.QueryRow(ctx, `select status, order_id from "returns" where id = $1 for update`, id).Scan(&status, &orderId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", 0, ErrReturnNotFound
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get return: %w", err)
+	}
+	return status, orderId, nil
+}
+
+func (s *ReturnStore) txSetStatus(ctx context.Context, tx pgx.Tx, id int64, status string, employeeId int64) error {
+	_, err := tx.Exec(ctx, `
+		update "returns"
+		set status = $2, employee_id = $3, updated_at = now()
+		where id = $1`, id, status, employeeId)
+	if err != nil {
+		return fmt.Errorf("failed to update return status: %w", err)
+	}
+	return nil
+}
+
+func (s *ReturnStore) setStatusFrom(ctx context.Context, id int64, from string, to string, employeeId int64) error {
+	_, err := s.db.Connection.Exec(ctx, `
+		update "returns"
+		set status = $3, employee_id = $4, updated_at = now()
+		where id = $1 and status = $2`, id, from, to, employeeId)
+	if err != nil {
+		return fmt.Errorf("failed to update return status: %w", err)
+	}
+	return nil
+}
+
+// Loads items of the given returns
+func (s *ReturnStore) loadItems(returns []ReturnEntity) error {
+	if len(returns) == 0 {
+		return nil
+	}
+
+	returnsMap := make(map[int64]*ReturnEntity, len(returns))
+	returnIds := make([]int64, 0, len(returns))
+	for i := range returns {
+		returns[i].Items = make([]*ReturnItemEntity, 0)
+		returnsMap[returns[i].Id] = &returns[i]
+		returnIds = append(returnIds, returns[i].Id)
+	}
+
+	rows, err := s.db.Connection.Query(s.db.Context, `
+		select "return_items".id, "return_items".return_id, "return_items".order_item_id,
+			coalesce("order_items".product_variant_id, 0), "return_items".quantity, "return_items".reason,
+			"return_items".refund_amount, "return_items".restocked_quantity
+		from "return_items"
+		join "order_items" on "order_items".id = "return_items".order_item_id
+		where "return_items".return_id = any($1)
+		order by "return_items".id`, returnIds)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &ReturnItemEntity{}
+		var refundAmount pgtype.Numeric
+		err := rows.Scan(
+			&item.Id,
+			&item.ReturnId,
+			&item.OrderItemId,
+			&item.ProductVariantId,
+			&item.Quantity,
+			&item.Reason,
+			&refundAmount,
+			&item.RestockedQuantity,
+		)
+		if err != nil {
+			return err
+		}
+
+		entity := returnsMap[item.ReturnId]
+		if item.RefundAmount, err = money.FromNumeric(refundAmount, entity.RefundAmount.Currency); err != nil {
+			return err
+		}
+		entity.Items = append(entity.Items, item)
+	}
+
+	return rows.Err()
+}
+
+func scanReturn(row pgx.Row) (*ReturnEntity, error) {
+	var entity ReturnEntity
+	var refundAmount pgtype.Numeric
+	var currency string
+	err := row.Scan(
+		&entity.Id,
+		&entity.OrderId,
+		&entity.CustomerId,
+		&entity.Status,
+		&entity.Comment,
+		&refundAmount,
+		&currency,
+		&entity.EmployeeId,
+		&entity.CreatedAt,
+		&entity.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if entity.RefundAmount, err = money.FromNumeric(refundAmount, currency); err != nil {
+		return nil, err
+	}
+	return &entity, nil
+}
@@ -0,0 +1,429 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusApproved = "approved"
+	ReviewStatusRejected = "rejected"
+)
+
+const maxReviewImages = 5
+
+var (
+	ErrReviewNotFound   = errors.New("review not found")
+	ErrReviewExists     = errors.New("customer already reviewed the product")
+	ErrReviewNotAllowed = errors.New("only customers with a delivered order of the product can review it")
+	ErrInvalidReview    = errors.New("invalid review")
+	ErrOwnReviewVote    = errors.New("customers cannot vote for their own reviews")
+)
+
+type ReviewEntity struct {
+	Id         int64 `json:"id"`
+	ProductId  int64 `json:"product_id"`
+	CustomerId int64 `json:"customer_id"`
+	// First name and last name initial of the customer
+	AuthorName string `json:"author_name"`
+	// Delivered order of the product, nil when the order was deleted
+	OrderId        *int64    `json:"order_id,omitempty"`
+	Rating         int16     `json:"rating"`
+	Title          string    `json:"title"`
+	Body           string    `json:"body"`
+	Status         string    `json:"status"`
+	ModerationNote string    `json:"moderation_note,omitempty"`
+	HelpfulCount   int64     `json:"helpful_count"`
+	ImageUrls      []string  `json:"image_urls"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type ReviewCreateUpdate struct {
+	ProductId  int64  `json:"-"`
+	CustomerId int64  `json:"-"`
+	Rating     int16  `json:"rating"`
+	Title      string `json:"title"`
+	Body       string `json:"body"`
+	// Photos uploaded with /file/upload
+	FileIds []int64 `json:"file_ids"`
+}
+
+type ReviewModeration struct {
+	Status     string `json:"status"`
+	Note       string `json:"note"`
+	EmployeeId int64  `json:"-"`
+}
+
+type ReviewGetAllOptions struct {
+	ProductId  *int64
+	CustomerId *int64
+	Status     *string
+	// newest, helpful, rating_desc or rating_asc
+	Sort   string
+	Limit  int64
+	Offset int64
+}
+
+type ReviewStore struct {
+	db *DatabaseConnection
+}
+
+const reviewSelectSQL = `select "reviews".id, "reviews".product_id, "reviews".customer_id,
+		trim(coalesce("person".first_name, '') || ' ' || left(coalesce("person".last_name, ''), 1)),
+		"reviews".order_id, "reviews".rating, "reviews".title, "reviews".body, "reviews".status,
+		"reviews".moderation_note, (select count(*) from "review_votes" where "review_votes".review_id = "reviews".id),
+		"reviews".created_at, "reviews".updated_at
+	from "reviews"
+	join "customers" on "customers".id = "reviews".customer_id
+	left join "person" on "person".id = "customers".person_id`
+
+var reviewSortSQL = map[string]string{
+	"":            `"reviews".created_at desc`,
+	"newest":      `"reviews".created_at desc`,
+	"helpful":     `11 desc, "reviews".created_at desc`,
+	"rating_desc": `"reviews".rating desc, "reviews".created_at desc`,
+	"rating_asc":  `"reviews".rating asc, "reviews".created_at desc`,
+}
+
+func NewReviewStore(database *DatabaseConnection) *ReviewStore {
+	return &ReviewStore{
+		db: database,
+	}
+}
+
+func (s *ReviewStore) GetAll(ctx context.Context, opts *ReviewGetAllOptions) ([]ReviewEntity, error) {
+	orderBy, exists := reviewSortSQL[opts.Sort]
+	if !exists {
+		return nil, fmt.Errorf("%w: unsupported sort '%s'", ErrInvalidReview, opts.Sort)
+	}
+	limit := "all"
+	if opts.Limit > 0 {
+		limit = fmt.Sprint(opts.Limit)
+	}
+
+	rows, err := s.db.Connection.Query(ctx, reviewSelectSQL+`
+		where ($1::integer is null or "reviews".product_id = $1)
+			and ($2::integer is null or "reviews".customer_id = $2)
+			and ($3::text is null or "reviews".status::text = $3)
+		order by `+orderBy+`
+		limit `+limit+` offset $4`, opts.ProductId, opts.CustomerId, opts.Status, opts.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reviews: %w", err)
+	}
+	defer rows.Close()
+
+	reviews := make([]ReviewEntity, 0)
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, *review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reviews, s.loadImages(ctx, reviews)
+}
+
+func (s *ReviewStore) GetById(ctx context.Context, id int64) (*ReviewEntity, error) {
+	review, err := scanReview(s.db.Connection.QueryRow(ctx, reviewSelectSQL+` where "reviews".id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrReviewNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get review: %w", err)
+	}
+
+	reviews := []ReviewEntity{*review}
+	if err := s.loadImages(ctx, reviews); err != nil {
+		return nil, err
+	}
+	return &reviews[0], nil
+}
+
+// Creates a pending review of a customer with a delivered order of the product
+func (s *ReviewStore) Create(ctx context.Context, opts *ReviewCreateUpdate) (*ReviewEntity, error) {
+	if err := normalizeReview(opts); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var orderId int64
+	err = tx.QueryRow(ctx, `
+		select "orders".id from "orders"
+		join "order_items" on "order_items".order_id = "orders".id
+		join "product_variants" on "product_variants".id = "order_items".product_variant_id
+		where "orders".customer_id = $1 and "orders".status = $2 and "product_variants".product_id = $3
+		order by "orders".id desc
+		limit 1`, opts.CustomerId, OrderStatusDelivered, opts.ProductId).Scan(&orderId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrReviewNotAllowed
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check delivered orders: %w", err)
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		insert into "reviews" (product_id, customer_id, order_id, rating, title, body)
+		values ($1, $2, $3, $4, $5, $6)
+		returning id`, opts.ProductId, opts.CustomerId, orderId, opts.Rating, opts.Title, opts.Body,
+	).Scan(&id)
+	if err != nil {
+		return nil, reviewWriteError(err)
+	}
+	if err := txSetReviewImages(ctx, tx, id, opts.FileIds); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return s.GetById(ctx, id)
+}
+
+// Updates a review of the customer. Edited reviews are moderated again
+func (s *ReviewStore) Update(ctx context.Context, id int64, opts *ReviewCreateUpdate) (*ReviewEntity, error) {
+	if err := normalizeReview(opts); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var productId int64
+	err = tx.QueryRow(ctx, `
+		update "reviews" set rating = $3, title = $4, body = $5, status = $6, moderation_note = '', updated_at = now()
+		where id = $1 and customer_id = $2
+		returning product_id`, id, opts.CustomerId, opts.Rating, opts.Title, opts.Body, ReviewStatusPending,
+	).Scan(&productId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrReviewNotFound
+	}
+	if err != nil {
+		return nil, reviewWriteError(err)
+	}
+	if err := txSetReviewImages(ctx, tx, id, opts.FileIds); err != nil {
+		return nil, err
+	}
+	if err := txRefreshProductRating(ctx, tx, productId); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return s.GetById(ctx, id)
+}
+
+// Approves or rejects a review and updates the rating of the product
+func (s *ReviewStore) Moderate(ctx context.Context, id int64, moderation *ReviewModeration) (*ReviewEntity, error) {
+	switch moderation.Status {
+	case ReviewStatusPending, ReviewStatusApproved, ReviewStatusRejected:
+	default:
+		return nil, fmt.Errorf("%w: unsupported status '%s'", ErrInvalidReview, moderation.Status)
+	}
+
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var productId int64
+	err = tx.QueryRow(ctx, `
+		update "reviews" set status = $2, moderation_note = $3, employee_id = $4, updated_at = now()
+		where id = $1
+		returning product_id`, id, moderation.Status, strings.TrimSpace(moderation.Note), employeeRef(moderation.EmployeeId),
+	).Scan(&productId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrReviewNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to moderate review: %w", err)
+	}
+	if err := txRefreshProductRating(ctx, tx, productId); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return s.GetById(ctx, id)
+}
+
+// Deletes the review, customer id nil allows deleting reviews of any customer
+func (s *ReviewStore) Delete(ctx context.Context, id int64, customerId *int64) error {
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var productId int64
+	err = tx.QueryRow(ctx, `
+		delete from "reviews"
+		where id = $1 and ($2::integer is null or customer_id = $2)
+		returning product_id`, id, customerId).Scan(&productId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrReviewNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete review: %w", err)
+	}
+	if err := txRefreshProductRating(ctx, tx, productId); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Marks an approved review as helpful for the customer, repeated votes are ignored
+func (s *ReviewStore) Vote(ctx context.Context, id int64, customerId int64) error {
+	var authorId int64
+	err := s.db.Connection.QueryRow(ctx, `select customer_id from "reviews" where id = $1 and status = $2`, id, ReviewStatusApproved).Scan(&authorId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrReviewNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get review: %w", err)
+	}
+	if authorId == customerId {
+		return ErrOwnReviewVote
+	}
+
+	_, err = s.db.Connection.Exec(ctx, `
+		insert into "review_votes" (review_id, customer_id) values ($1, $2)
+		on conflict do nothing`, id, customerId)
+	if err != nil {
+		return fmt.Errorf("failed to vote for review: %w", err)
+	}
+	return nil
+}
+
+func (s *ReviewStore) Unvote(ctx context.Context, id int64, customerId int64) error {
+	_, err := s.db.Connection.Exec(ctx, `delete from "review_votes" where review_id = $1 and customer_id = $2`, id, customerId)
+	if err != nil {
+		return fmt.Errorf("failed to remove review vote: %w", err)
+	}
+	return nil
+}
+
+func (s *ReviewStore) loadImages(ctx context.Context, reviews []ReviewEntity) error {
+	ids := make([]int64, 0, len(reviews))
+	indexes := make(map[int64]int, len(reviews))
+	for i := range reviews {
+		reviews[i].ImageUrls = make([]string, 0)
+		ids = append(ids, reviews[i].Id)
+		indexes[reviews[i].Id] = i
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := s.db.Connection.Query(ctx, `
+		select "review_images".review_id, "files".path
+		from "review_images"
+		join "files" on "files".id = "review_images".file_id
+		where "review_images".review_id = any($1)
+		order by "review_images".sort_order`, ids)
+	if err != nil {
+		return fmt.Errorf("failed to get review images: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reviewId int64
+		var imagePath string
+		if err := rows.Scan(&reviewId, &imagePath); err != nil {
+			return err
+		}
+		review := &reviews[indexes[reviewId]]
+		review.ImageUrls = append(review.ImageUrls, getImageURLFromPath(imagePath))
+	}
+	return rows.Err()
+}
+
+func txSetReviewImages(ctx context.Context, tx pgx.Tx, reviewId int64, fileIds []int64) error {
+	if _, err := tx.Exec(ctx, `delete from "review_images" where review_id = $1`, reviewId); err != nil {
+		return fmt.Errorf("failed to remove review images: %w", err)
+	}
+	for i, fileId := range fileIds {
+		_, err := tx.Exec(ctx, `insert into "review_images" (review_id, file_id, sort_order) values ($1, $2, $3)`, reviewId, fileId, i)
+		if err != nil {
+			return reviewWriteError(err)
+		}
+	}
+	return nil
+}
+
+// Recalculates the average rating and the number of approved reviews of the product
+func txRefreshProductRating(ctx context.Context, tx pgx.Tx, productId int64) error {
+	_, err := tx.Exec(ctx, `
+		update "products" set
+			rating_average = (select round(avg(rating), 2) from "reviews" where product_id = $1 and status = $2),
+			rating_count = (select count(*) from "reviews" where product_id = $1 and status = $2)
+		where id = $1`, productId, ReviewStatusApproved)
+	if err != nil {
+		return fmt.Errorf("failed to update product rating: %w", err)
+	}
+	return nil
+}
+
+func normalizeReview(opts *ReviewCreateUpdate) error {
+	opts.Title = strings.TrimSpace(opts.Title)
+	opts.Body = strings.TrimSpace(opts.Body)
+	if opts.Rating < 1 || opts.Rating > 5 {
+		return fmt.Errorf("%w: rating must be from 1 to 5", ErrInvalidReview)
+	}
+	if len(opts.Title) > 255 {
+		return fmt.Errorf("%w: title must be at most 255 characters", ErrInvalidReview)
+	}
+	if len(opts.FileIds) > maxReviewImages {
+		return fmt.Errorf("%w: at most %d photos are allowed", ErrInvalidReview, maxReviewImages)
+	}
+	return nil
+}
+
+func reviewWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			if pgErr.ConstraintName == "review_images_pkey" {
+				return fmt.Errorf("%w: photo is listed twice", ErrInvalidReview)
+			}
+			return ErrReviewExists
+		case "23503":
+			return fmt.Errorf("%w: photo or product not found", ErrInvalidReview)
+		}
+	}
+	return fmt.Errorf("failed to save review: %w", err)
+}
+
+func scanReview(row pgx.Row) (*ReviewEntity, error) {
+	review := &ReviewEntity{}
+	err := row.Scan(
+		&review.Id, &review.ProductId, &review.CustomerId, &review.AuthorName, &review.OrderId,
+		&review.Rating, &review.Title, &review.Body, &review.Status, &review.ModerationNote,
+		&review.HelpfulCount, &review.CreatedAt, &review.UpdatedAt,
+	)
+	return review, err
+}
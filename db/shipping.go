@@ -0,0 +1,325 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"netshop/main/tools/money"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrShippingMethodUnavailable = errors.New("shipping method is not available for the order")
+	ErrInvalidShippingRate       = errors.New("invalid shipping rate")
+)
+
+// ShippingMethodEntity is a delivery option of a carrier, e.g. "Nova Poshta - Courier".
+// Amounts are in the default currency
+type ShippingMethodEntity struct {
+	Id                    int64                `json:"id"`
+	Carrier               string               `json:"carrier"`
+	Name                  string               `json:"name"`
+	FreeShippingThreshold *money.Money         `json:"free_shipping_threshold"`
+	IsActive              bool                 `json:"is_active"`
+	Rates                 []ShippingRateEntity `json:"rates"`
+	CreatedAt             time.Time            `json:"created_at"`
+}
+
+// ShippingRateEntity is a price of the shipping method for a country, weight and order value range
+type ShippingRateEntity struct {
+	Id               int64       `json:"id"`
+	ShippingMethodId int64       `json:"shipping_method_id"`
+	Country          string      `json:"country"`
+	MaxWeight        *int32      `json:"max_weight"`
+	MinOrderValue    money.Money `json:"min_order_value"`
+	Price            money.Money `json:"price"`
+}
+
+type ShippingMethodCreate struct {
+	Carrier               string       `json:"carrier"`
+	Name                  string       `json:"name"`
+	FreeShippingThreshold *money.Money `json:"free_shipping_threshold"`
+}
+
+type ShippingRateCreate struct {
+	ShippingMethodId int64       `json:"-"`
+	Country          string      `json:"country"`
+	MaxWeight        *int32      `json:"max_weight"`
+	MinOrderValue    money.Money `json:"min_order_value"`
+	Price            money.Money `json:"price"`
+}
+
+type ShippingQuoteRequest struct {
+	Country  string                   `json:"country"`
+	Items    []*OrderItemCreateUpdate `json:"items"`
+	Currency string                   `json:"currency"`
+}
+
+// ShippingQuote is the cost of an available shipping method in the requested currency
+type ShippingQuote struct {
+	ShippingMethodId int64       `json:"shipping_method_id"`
+	Carrier          string      `json:"carrier"`
+	Name             string      `json:"name"`
+	Price            money.Money `json:"price"`
+	IsFree           bool        `json:"is_free"`
+}
+
+type ShippingStore struct {
+	db *DatabaseConnection
+}
+
+func NewShippingStore(database *DatabaseConnection) *ShippingStore {
+	return &ShippingStore{
+		db: database,
+	}
+}
+
+// Returns shipping methods with their rates. Inactive methods are returned only if activeOnly is false
+func (s *ShippingStore) GetMethods(activeOnly bool) ([]ShippingMethodEntity, error) {
+	rows, err := s.db.Connection.Query(s.db.Context, `
+		select id, carrier, name, free_shipping_threshold, is_active, created_at
+		from "shipping_methods"
+		where is_active or not $1
+		order by id`, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	methods := make([]ShippingMethodEntity, 0)
+	methodIds := make([]int64, 0)
+	for rows.Next() {
+		method := ShippingMethodEntity{Rates: []ShippingRateEntity{}}
+		err := rows.Scan(
+			&method.Id,
+			&method.Carrier,
+			&method.Name,
+			&method.FreeShippingThreshold,
+			&method.IsActive,
+			&method.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, method)
+		methodIds = append(methodIds, method.Id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Connection.Query(s.db.Context, `
+		select id, shipping_method_id, country, max_weight, min_order_value, price
+		from "shipping_rates"
+		where shipping_method_id = any($1)
+		order by shipping_method_id, country, max_weight nulls last, min_order_value`, methodIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rate ShippingRateEntity
+		err := rows.Scan(
+			&rate.Id,
+			&rate.ShippingMethodId,
+			&rate.Country,
+			&rate.MaxWeight,
+			&rate.MinOrderValue,
+			&rate.Price,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for i := range methods {
+			if methods[i].Id == rate.ShippingMethodId {
+				methods[i].Rates = append(methods[i].Rates, rate)
+			}
+		}
+	}
+
+	return methods, rows.Err()
+}
+
+func (s *ShippingStore) CreateMethod(ctx context.Context, opts *ShippingMethodCreate) (*ShippingMethodEntity, error) {
+	if strings.TrimSpace(opts.Carrier) == "" || strings.TrimSpace(opts.Name) == "" {
+		return nil, errors.New("carrier and name are required")
+	}
+	if opts.FreeShippingThreshold != nil && opts.FreeShippingThreshold.Currency != money.DefaultCurrency() {
+		return nil, fmt.Errorf("free shipping threshold must be in %s", money.DefaultCurrency())
+	}
+
+	method := &ShippingMethodEntity{
+		Carrier:               strings.TrimSpace(opts.Carrier),
+		Name:                  strings.TrimSpace(opts.Name),
+		FreeShippingThreshold: opts.FreeShippingThreshold,
+		IsActive:              true,
+		Rates:                 []ShippingRateEntity{},
+	}
+	err := s.db.Connection.QueryRow(ctx, `
+		insert into "shipping_methods" (carrier, name, free_shipping_threshold)
+		values ($1, $2, $3)
+		returning id, created_at`,
+		method.Carrier, method.Name, method.FreeShippingThreshold,
+	).Scan(&method.Id, &method.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shipping method: %w", err)
+	}
+	return method, nil
+}
+
+// Deactivates the shipping method. Methods are never deleted because orders reference them
+func (s *ShippingStore) Deactivate(ctx context.Context, id int64) error {
+	tag, err := s.db.Connection.Exec(ctx, `update "shipping_methods" set is_active = false where id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate shipping method: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("shipping method with id '%d' not found", id)
+	}
+	return nil
+}
+
+func (s *ShippingStore) AddRate(ctx context.Context, opts *ShippingRateCreate) (*ShippingRateEntity, error) {
+	if opts.Price.Amount < 0 || opts.MinOrderValue.Amount < 0 {
+		return nil, fmt.Errorf("%w: price and minimum order value must not be negative", ErrInvalidShippingRate)
+	}
+	opts.MinOrderValue = money.New(opts.MinOrderValue.Amount, opts.MinOrderValue.Currency)
+	if opts.Price.Currency != money.DefaultCurrency() || opts.MinOrderValue.Currency != money.DefaultCurrency() {
+		return nil, fmt.Errorf("%w: amounts must be in %s", ErrInvalidShippingRate, money.DefaultCurrency())
+	}
+	if opts.MaxWeight != nil && *opts.MaxWeight < 0 {
+		return nil, fmt.Errorf("%w: max weight must not be negative", ErrInvalidShippingRate)
+	}
+
+	rate := &ShippingRateEntity{
+		ShippingMethodId: opts.ShippingMethodId,
+		Country:          strings.TrimSpace(opts.Country),
+		MaxWeight:        opts.MaxWeight,
+		MinOrderValue:    opts.MinOrderValue,
+		Price:            opts.Price,
+	}
+	err := s.db.Connection.QueryRow(ctx, `
+		insert into "shipping_rates" (shipping_method_id, country, max_weight, min_order_value, price)
+		select id, $2, $3, $4, $5 from "shipping_methods" where id = $1
+		returning id`,
+		rate.ShippingMethodId, rate.Country, rate.MaxWeight, rate.MinOrderValue, rate.Price,
+	).Scan(&rate.Id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("shipping method with id '%d' not found", opts.ShippingMethodId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to add shipping rate: %w", err)
+	}
+	return rate, nil
+}
+
+func (s *ShippingStore) DeleteRate(ctx context.Context, id int64) error {
+	tag, err := s.db.Connection.Exec(ctx, `delete from "shipping_rates" where id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete shipping rate: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("shipping rate with id '%d' not found", id)
+	}
+	return nil
+}
+
+// Returns the cost of every shipping method available for the given items and country
+func (s *ShippingStore) Quote(ctx context.Context, opts *ShippingQuoteRequest) ([]ShippingQuote, error) {
+	variantIds := make([]int64, 0, len(opts.Items))
+	quantities := make(map[int64]int64, len(opts.Items))
+	for _, item := range opts.Items {
+		variantIds = append(variantIds, item.ProductVariantId)
+		quantities[item.ProductVariantId] += int64(item.Quantity)
+	}
+
+	prices, err := newPriceList(ctx, s.db.Connection, opts.Currency, variantIds)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Connection.Query(ctx, `
+		select id, price, weight from "product_variants" where id = any($1)`, variantIds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product variants: %w", err)
+	}
+	defer rows.Close()
+
+	weight := int64(0)
+	value := money.New(0, prices.Currency)
+	found := 0
+	for rows.Next() {
+		var variantId, variantWeight int64
+		var price money.Money
+		if err := rows.Scan(&variantId, &price, &variantWeight); err != nil {
+			return nil, err
+		}
+		weight += variantWeight * quantities[variantId]
+		value = value.Add(prices.variantPrice(variantId, price).Mul(quantities[variantId]))
+		found++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if found != len(quantities) {
+		return nil, errors.New("some product variants are not found")
+	}
+
+	return quoteShipping(ctx, s.db.Connection, opts.Country, weight, value, prices)
+}
+
+// Returns quotes of the active shipping methods for the parcel. Rates and thresholds are
+// in the default currency, the value is converted to compare with them and prices are converted back
+func quoteShipping(ctx context.Context, q querier, country string, weight int64, value money.Money, prices *priceList) ([]ShippingQuote, error) {
+	defaultValue := value
+	if prices.Currency != money.DefaultCurrency() {
+		defaultValue = value.Convert(money.DefaultCurrency(), new(big.Rat).Inv(prices.Rate))
+	}
+
+	rows, err := q.Query(ctx, `
+		select distinct on ("shipping_methods".id)
+			"shipping_methods".id,
+			"shipping_methods".carrier,
+			"shipping_methods".name,
+			"shipping_methods".free_shipping_threshold,
+			"shipping_rates".price
+		from "shipping_methods"
+		join "shipping_rates" on "shipping_rates".shipping_method_id = "shipping_methods".id
+		where "shipping_methods".is_active
+			and ("shipping_rates".country = '' or lower("shipping_rates".country) = lower($1))
+			and ("shipping_rates".max_weight is null or "shipping_rates".max_weight >= $2)
+			and "shipping_rates".min_order_value <= $3
+		order by
+			"shipping_methods".id,
+			"shipping_rates".country = '',
+			"shipping_rates".max_weight nulls last,
+			"shipping_rates".min_order_value desc`,
+		strings.TrimSpace(country), weight, defaultValue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shipping rates: %w", err)
+	}
+	defer rows.Close()
+
+	quotes := make([]ShippingQuote, 0)
+	for rows.Next() {
+		var quote ShippingQuote
+		var threshold *money.Money
+		var price money.Money
+		if err := rows.Scan(&quote.ShippingMethodId, &quote.Carrier, &quote.Name, &threshold, &price); err != nil {
+			return nil, err
+		}
+
+		quote.IsFree = threshold != nil && defaultValue.Amount >= threshold.Amount
+		if quote.IsFree {
+			price = money.New(0, price.Currency)
+		}
+		quote.Price = prices.convert(price)
+		quotes = append(quotes, quote)
+	}
+	return quotes, rows.Err()
+}
@@ -0,0 +1,362 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrAlreadySubscribed = errors.New("already subscribed to the product variant")
+	ErrVariantInStock    = errors.New("product variant is in stock")
+)
+
+// Available stock and effective reorder threshold of every variant. The variant threshold has
+// priority over the thresholds of the category and then its parent categories, $1 is the default threshold
+var variantThresholdsSQL = `variant_thresholds as (
+		select
+			"product_variants"."id",
+			"product_variants"."stock" - ` + reservedStockSQL + ` as "available",
+			coalesce("product_variants"."reorder_threshold", (
+				select "categories"."reorder_threshold"
+				from unnest(` + categoryAncestorsSQL(`"products"."category_id"`) + `) with ordinality as "chain"("id", "depth")
+				join "categories" on "categories"."id" = "chain"."id"
+				where "categories"."reorder_threshold" is not null
+				order by "chain"."depth"
+				limit 1
+			), $1) as "threshold"
+		from "product_variants"
+		join "products" on "products"."id" = "product_variants"."product_id"
+	)`
+
+// LowStockAlertEntity is raised when the available stock of a variant drops to its reorder threshold
+type LowStockAlertEntity struct {
+	Id               int64      `json:"id"`
+	ProductVariantId int64      `json:"product_variant_id"`
+	ProductId        int64      `json:"product_id"`
+	ProductName      string     `json:"product_name"`
+	Stock            int32      `json:"stock"`
+	Threshold        int32      `json:"threshold"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ResolvedAt       *time.Time `json:"resolved_at"`
+	NotifiedAt       *time.Time `json:"notified_at"`
+	// Channels that already delivered the notification when some others failed
+	NotifiedChannels []string `json:"-"`
+}
+
+// BackInStockEntity is a pending "notify me" subscription whose variant is available again
+type BackInStockEntity struct {
+	SubscriptionId   int64  `json:"subscription_id"`
+	ProductVariantId int64  `json:"product_variant_id"`
+	ProductId        int64  `json:"product_id"`
+	ProductName      string `json:"product_name"`
+	CustomerEmail    string `json:"customer_email"`
+	Stock            int32  `json:"stock"`
+	// Channels that already delivered the notification when some others failed
+	NotifiedChannels []string `json:"-"`
+}
+
+type StockAlertStore struct {
+	db *DatabaseConnection
+}
+
+func NewStockAlertStore(database *DatabaseConnection) *StockAlertStore {
+	return &StockAlertStore{
+		db: database,
+	}
+}
+
+// Returns alerts that are not resolved yet
+func (s *StockAlertStore) GetOpenAlerts() ([]LowStockAlertEntity, error) {
+	rows, err := s.db.Connection.Query(s.db.Context, `
+		select
+			"low_stock_alerts".id,
+			"low_stock_alerts".product_variant_id,
+			"products".id,
+			"products".name,
+			"low_stock_alerts".stock,
+			"low_stock_alerts".threshold,
+			"low_stock_alerts".created_at,
+			"low_stock_alerts".resolved_at,
+			"low_stock_alerts".notified_at,
+			"low_stock_alerts".notified_channels
+		from "low_stock_alerts"
+		join "product_variants" on "product_variants".id = "low_stock_alerts".product_variant_id
+		join "products" on "products".id = "product_variants".product_id
+		where "low_stock_alerts".resolved_at is null
+		order by "low_stock_alerts".id desc`)
+	if err != nil {
+		return nil, err
+	}
+	return scanLowStockAlerts(rows)
+}
+
+// Returns open alerts whose notification is not delivered through all channels yet, oldest first
+func (s *StockAlertStore) GetUnnotifiedAlerts(ctx context.Context) ([]LowStockAlertEntity, error) {
+	rows, err := s.db.Connection.Query(ctx, `
+		select
+			"low_stock_alerts".id,
+			"low_stock_alerts".product_variant_id,
+			"products".id,
+			"products".name,
+			"low_stock_alerts".stock,
+			"low_stock_alerts".threshold,
+			"low_stock_alerts".created_at,
+			"low_stock_alerts".resolved_at,
+			"low_stock_alerts".notified_at,
+			"low_stock_alerts".notified_channels
+		from "low_stock_alerts"
+		join "product_variants" on "product_variants".id = "low_stock_alerts".product_variant_id
+		join "products" on "products".id = "product_variants".product_id
+		where "low_stock_alerts".resolved_at is null and "low_stock_alerts".notified_at is null
+		order by "low_stock_alerts".id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanLowStockAlerts(rows)
+}
+
+// Saves the channels that delivered the notification of the alert, so the next attempt
+// sends it only through the failed channels
+func (s *StockAlertStore) SetAlertChannels(ctx context.Context, alertId int64, channels []string) error {
+	_, err := s.db.Connection.Exec(ctx, `update "low_stock_alerts" set notified_channels = $2 where id = $1`, alertId, channels)
+	if err != nil {
+		return fmt.Errorf("failed to save notified channels: %w", err)
+	}
+	return nil
+}
+
+func (s *StockAlertStore) MarkAlertNotified(ctx context.Context, alertId int64, channels []string) error {
+	_, err := s.db.Connection.Exec(ctx, `
+		update "low_stock_alerts" set notified_at = now(), notified_channels = $2
+		where id = $1`, alertId, channels)
+	if err != nil {
+		return fmt.Errorf("failed to mark alert as notified: %w", err)
+	}
+	return nil
+}
+
+// Resolves alerts of variants that are above their threshold again and opens alerts for
+// variants that dropped to their threshold. Returns only the newly opened alerts, their
+// notifications are sent from GetUnnotifiedAlerts
+func (s *StockAlertStore) RefreshAlerts(ctx context.Context, defaultThreshold int32) ([]LowStockAlertEntity, error) {
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		with `+variantThresholdsSQL+`
+		update "low_stock_alerts"
+		set resolved_at = now()
+		from variant_thresholds
+		where "low_stock_alerts".product_variant_id = variant_thresholds.id
+			and "low_stock_alerts".resolved_at is null
+			and variant_thresholds.available > variant_thresholds.threshold`, defaultThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve low stock alerts: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		with `+variantThresholdsSQL+`, inserted as (
+			insert into "low_stock_alerts" (product_variant_id, stock, threshold)
+			select id, available, threshold
+			from variant_thresholds
+			where available <= threshold and not exists(
+				select 1 from "low_stock_alerts"
+				where "low_stock_alerts".product_variant_id = variant_thresholds.id
+					and "low_stock_alerts".resolved_at is null
+			)
+			returning *
+		)
+		select
+			inserted.id,
+			inserted.product_variant_id,
+			"products".id,
+			"products".name,
+			inserted.stock,
+			inserted.threshold,
+			inserted.created_at,
+			inserted.resolved_at,
+			inserted.notified_at,
+			inserted.notified_channels
+		from inserted
+		join "product_variants" on "product_variants".id = inserted.product_variant_id
+		join "products" on "products".id = "product_variants".product_id
+		order by inserted.id`, defaultThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open low stock alerts: %w", err)
+	}
+	alerts, err := scanLowStockAlerts(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return alerts, tx.Commit(ctx)
+}
+
+// Sets the reorder threshold of the variant. Nil threshold falls back to the category threshold
+func (s *StockAlertStore) SetVariantThreshold(ctx context.Context, productId, variantId int64, threshold *int32) error {
+	tag, err := s.db.Connection.Exec(ctx, `
+		update "product_variants" set reorder_threshold = $3
+		where id = $2 and product_id = $1`, productId, variantId, threshold)
+	if err != nil {
+		return fmt.Errorf("failed to set reorder threshold: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("product variant with id '%d' not found", variantId)
+	}
+	return nil
+}
+
+// Sets the reorder threshold of all variants in the category. Nil threshold falls back to the default threshold
+func (s *StockAlertStore) SetCategoryThreshold(ctx context.Context, categoryId int64, threshold *int32) error {
+	tag, err := s.db.Connection.Exec(ctx, `update "categories" set reorder_threshold = $2 where id = $1`, categoryId, threshold)
+	if err != nil {
+		return fmt.Errorf("failed to set reorder threshold: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("category with id '%d' not found", categoryId)
+	}
+	return nil
+}
+
+// Subscribes the customer to the "back in stock" notification of the variant. Only out of stock
+// variants accept subscriptions, so the notification is sent when the stock goes from 0 above 0
+func (s *StockAlertStore) Subscribe(ctx context.Context, customerId, productId, variantId int64) error {
+	var available int32
+	err := s.db.Connection.QueryRow(ctx, `
+		with "variant" as (
+			select id, "product_variants"."stock" - `+reservedStockSQL+` as "available"
+			from "product_variants"
+			where id = $3 and product_id = $2
+		), inserted as (
+			insert into "restock_subscriptions" (customer_id, product_variant_id)
+			select $1, id from "variant" where "available" <= 0
+		)
+		select "available" from "variant"`,
+		customerId, productId, variantId).Scan(&available)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrAlreadySubscribed
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("product variant with id '%d' not found", variantId)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+	if available > 0 {
+		return ErrVariantInStock
+	}
+	return nil
+}
+
+func (s *StockAlertStore) Unsubscribe(ctx context.Context, customerId, variantId int64) error {
+	_, err := s.db.Connection.Exec(ctx, `
+		delete from "restock_subscriptions"
+		where customer_id = $1 and product_variant_id = $2 and notified_at is null`,
+		customerId, variantId)
+	if err != nil {
+		return fmt.Errorf("failed to unsubscribe: %w", err)
+	}
+	return nil
+}
+
+// Returns pending subscriptions whose variants have available stock
+func (s *StockAlertStore) GetBackInStock(ctx context.Context) ([]BackInStockEntity, error) {
+	rows, err := s.db.Connection.Query(ctx, `
+		select
+			"restock_subscriptions".id,
+			"product_variants".id,
+			"products".id,
+			"products".name,
+			"person".email,
+			"product_variants"."stock" - `+reservedStockSQL+` as "available",
+			"restock_subscriptions".notified_channels
+		from "restock_subscriptions"
+		join "product_variants" on "product_variants".id = "restock_subscriptions".product_variant_id
+		join "products" on "products".id = "product_variants".product_id
+		join "customers" on "customers".id = "restock_subscriptions".customer_id
+		join "person" on "person".id = "customers".person_id
+		where "restock_subscriptions".notified_at is null
+			and "product_variants"."stock" - `+reservedStockSQL+` > 0
+		order by "restock_subscriptions".id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]BackInStockEntity, 0)
+	for rows.Next() {
+		var item BackInStockEntity
+		err := rows.Scan(
+			&item.SubscriptionId,
+			&item.ProductVariantId,
+			&item.ProductId,
+			&item.ProductName,
+			&item.CustomerEmail,
+			&item.Stock,
+			&item.NotifiedChannels,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
+
+// Saves the channels that delivered the notification of the subscription, so the next attempt
+// sends it only through the failed channels
+func (s *StockAlertStore) SetSubscriptionChannels(ctx context.Context, subscriptionId int64, channels []string) error {
+	_, err := s.db.Connection.Exec(ctx, `update "restock_subscriptions" set notified_channels = $2 where id = $1`, subscriptionId, channels)
+	if err != nil {
+		return fmt.Errorf("failed to save notified channels: %w", err)
+	}
+	return nil
+}
+
+func (s *StockAlertStore) MarkSubscriptionNotified(ctx context.Context, subscriptionId int64, channels []string) error {
+	_, err := s.db.Connection.Exec(ctx, `
+		update "restock_subscriptions" set notified_at = now(), notified_channels = $2
+		where id = $1`, subscriptionId, channels)
+	if err != nil {
+		return fmt.Errorf("failed to mark subscription as notified: %w", err)
+	}
+	return nil
+}
+
+func scanLowStockAlerts(rows pgx.Rows) ([]LowStockAlertEntity, error) {
+	defer rows.Close()
+
+	alerts := make([]LowStockAlertEntity, 0)
+	for rows.Next() {
+		var alert LowStockAlertEntity
+		err := rows.Scan(
+			&alert.Id,
+			&alert.ProductVariantId,
+			&alert.ProductId,
+			&alert.ProductName,
+			&alert.Stock,
+			&alert.Threshold,
+			&alert.CreatedAt,
+			&alert.ResolvedAt,
+			&alert.NotifiedAt,
+			&alert.NotifiedChannels,
+		)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+
+	return alerts, rows.Err()
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	StockMovementSale         = "sale"
+	StockMovementCancellation = "cancellation"
+	StockMovementAdjustment   = "adjustment"
+	StockMovementRestock      = "restock"
+	StockMovementReturn       = "return"
+)
+
+var ErrInvalidStockMovement = errors.New("invalid stock movement")
+
+// StockMovementEntity is a single record of the append-only stock ledger
+type StockMovementEntity struct {
+	Id               int64     `json:"id"`
+	ProductVariantId int64     `json:"product_variant_id"`
+	Type             string    `json:"type"`
+	Quantity         int32     `json:"quantity"`
+	EmployeeId       *int64    `json:"employee_id"`
+	OrderId          *int64    `json:"order_id"`
+	Reason           string    `json:"reason"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type StockAdjustmentCreate struct {
+	ProductId        int64  `json:"-"`
+	ProductVariantId int64  `json:"-"`
+	EmployeeId       int64  `json:"-"`
+	Type             string `json:"type"`
+	Quantity         int32  `json:"quantity"`
+	Reason           string `json:"reason"`
+}
+
+// StockDiscrepancy is a variant whose stock does not match the sum of its ledger records
+type StockDiscrepancy struct {
+	ProductVariantId int64 `json:"product_variant_id"`
+	Stock            int64 `json:"stock"`
+	LedgerStock      int64 `json:"ledger_stock"`
+}
+
+type StockMovementStore struct {
+	db *DatabaseConnection
+}
+
+func NewStockMovementStore(database *DatabaseConnection) *StockMovementStore {
+	return &StockMovementStore{
+		db: database,
+	}
+}
+
+func (s *StockMovementStore) GetByVariantId(variantId int64) ([]StockMovementEntity, error) {
+	rows, err := s.db.Connection.Query(s.db.Context, `
+		select id, product_variant_id, type, quantity, employee_id, order_id, reason, created_at
+		from "stock_movements"
+		where product_variant_id = $1
+		order by id desc`, variantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movements := make([]StockMovementEntity, 0)
+	for rows.Next() {
+		var movement StockMovementEntity
+		err := rows.Scan(
+			&movement.Id,
+			&movement.ProductVariantId,
+			&movement.Type,
+			&movement.Quantity,
+			&movement.EmployeeId,
+			&movement.OrderId,
+			&movement.Reason,
+			&movement.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movements = append(movements, movement)
+	}
+
+	return movements, nil
+}
+
+// Changes the variant stock by the given quantity and records the change in the ledger.
+// Only manual movement types (adjustment, restock, return) are accepted
+func (s *StockMovementStore) Adjust(ctx context.Context, opts *StockAdjustmentCreate) (*StockMovementEntity, error) {
+	switch opts.Type {
+	case StockMovementAdjustment:
+		if opts.Reason == "" {
+			return nil, fmt.Errorf("%w: reason is required for adjustments", ErrInvalidStockMovement)
+		}
+	case StockMovementRestock, StockMovementReturn:
+		if opts.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: %s quantity must be positive", ErrInvalidStockMovement, opts.Type)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported type '%s'", ErrInvalidStockMovement, opts.Type)
+	}
+	if opts.Quantity == 0 {
+		return nil, fmt.Errorf("%w: quantity must not be zero", ErrInvalidStockMovement)
+	}
+
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var stock, reserved int64
+	err = tx.QueryRow(ctx, `
+		select "product_variants"."stock", `+reservedStockSQL+`
+		from "product_variants"
+		where "product_variants"."id" = $1 and "product_variants"."product_id" = $2
+		for update`, opts.ProductVariantId, opts.ProductId).Scan(&stock, &reserved)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("product variant with id '%d' not found", opts.ProductVariantId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product variant: %w", err)
+	}
+	if stock+int64(opts.Quantity) < reserved {
+		return nil, fmt.Errorf("%w: stock cannot become lower than the reserved quantity (%d)", ErrInvalidStockMovement, reserved)
+	}
+
+	movement := &StockMovementEntity{
+		ProductVariantId: opts.ProductVariantId,
+		Type:             opts.Type,
+		Quantity:         opts.Quantity,
+		EmployeeId:       &opts.EmployeeId,
+		Reason:           opts.Reason,
+	}
+	if err := s.txRecord(ctx, tx, movement); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `update "product_variants" set stock = stock + $2 where id = $1`, opts.ProductVariantId, opts.Quantity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update stock: %w", err)
+	}
+
+	return movement, tx.Commit(ctx)
+}
+
+// Returns all variants whose stock differs from the sum of their ledger records
+func (s *StockMovementStore) Reconcile(ctx context.Context) ([]StockDiscrepancy, error) {
+	rows, err := s.db.Connection.Query(ctx, `
+		select "product_variants".id, "product_variants".stock, coalesce(sum("stock_movements".quantity), 0)
+		from "product_variants"
+		left join "stock_movements" on "stock_movements".product_variant_id = "product_variants".id
+		group by "product_variants".id
+		having "product_variants".stock <> coalesce(sum("stock_movements".quantity), 0)
+		order by "product_variants".id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	discrepancies := make([]StockDiscrepancy, 0)
+	for rows.Next() {
+		var discrepancy StockDiscrepancy
+		if err := rows.Scan(&discrepancy.ProductVariantId, &discrepancy.Stock, &discrepancy.LedgerStock); err != nil {
+			return nil, err
+		}
+		discrepancies = append(discrepancies, discrepancy)
+	}
+
+	return discrepancies, nil
+}
+
+// Inserts a ledger record. The caller is responsible for changing the variant stock in the same transaction
+func (s *StockMovementStore) txRecord(ctx context.Context, tx pgx.Tx, movement *StockMovementEntity) error {
+	err := tx.QueryRow(ctx, `
+		insert into "stock_movements" (product_variant_id, type, quantity, employee_id, order_id, reason)
+		values ($1, $2, $3, $4, $5, $6)
+		returning id, created_at`,
+		movement.ProductVariantId,
+		movement.Type,
+		movement.Quantity,
+		movement.EmployeeId,
+		movement.OrderId,
+		movement.Reason,
+	).Scan(&movement.Id, &movement.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record stock movement: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"netshop/main/tools/money"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrInvalidTaxRule = errors.New("invalid tax rule")
+	ErrTaxRuleExists  = errors.New("tax rule with the same country, zipcode prefix and category already exists")
+)
+
+// TaxRuleEntity is a tax rate of a country or its region (zipcode prefix), optionally limited to a category
+type TaxRuleEntity struct {
+	Id            int64       `json:"id"`
+	Name          string      `json:"name"`
+	Country       string      `json:"country"`
+	ZipcodePrefix string      `json:"zipcode_prefix"`
+	CategoryId    *int64      `json:"category_id"`
+	Rate          json.Number `json:"rate"`
+	IsInclusive   bool        `json:"is_inclusive"`
+	CreatedAt     time.Time   `json:"created_at"`
+}
+
+type TaxRuleCreate struct {
+	Name          string      `json:"name"`
+	Country       string      `json:"country"`
+	ZipcodePrefix string      `json:"zipcode_prefix"`
+	CategoryId    *int64      `json:"category_id"`
+	Rate          json.Number `json:"rate"`
+	IsInclusive   bool        `json:"is_inclusive"`
+}
+
+// TaxAddress is the part of the delivery address that defines the tax jurisdiction
+type TaxAddress struct {
+	Country string
+	Zipcode string
+}
+
+// TaxLine is an order line for tax calculation, Amount is the line total after discounts
+type TaxLine struct {
+	OrderItemId int64
+	ProductId   int64
+	// The product category followed by its ancestors up to the root category
+	CategoryIds []int64
+	Amount      money.Money
+}
+
+// LineTax is the calculated tax of a single order line
+type LineTax struct {
+	OrderItemId int64
+	Rate        json.Number
+	Inclusive   bool
+	Amount      money.Money
+}
+
+// OrderTaxSummary is the total tax of the order lines with the same rate
+type OrderTaxSummary struct {
+	Rate      json.Number `json:"rate"`
+	Inclusive bool        `json:"inclusive"`
+	Base      money.Money `json:"base"`
+	Amount    money.Money `json:"amount"`
+}
+
+// TaxCalculator calculates taxes of the order lines during the order creation.
+// It runs inside the order transaction
+type TaxCalculator interface {
+	Calculate(ctx context.Context, tx pgx.Tx, address TaxAddress, lines []TaxLine) ([]LineTax, error)
+}
+
+// TableTaxCalculator is the default TaxCalculator that uses rules of the "tax_rules" table.
+// Lines without a matching rule are not taxed
+type TableTaxCalculator struct{}
+
+func (c *TableTaxCalculator) Calculate(ctx context.Context, tx pgx.Tx, address TaxAddress, lines []TaxLine) ([]LineTax, error) {
+	rows, err := tx.Query(ctx, `
+		select `+taxRuleColumnsSQL+`
+		from "tax_rules"
+		where lower(country) = lower($1) and left($2, length(zipcode_prefix)) = zipcode_prefix
+		order by category_id is null, length(zipcode_prefix) desc`,
+		strings.TrimSpace(address.Country), strings.TrimSpace(address.Zipcode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tax rules: %w", err)
+	}
+	rules, err := scanTaxRules(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	taxes := make([]LineTax, 0, len(lines))
+	for _, line := range lines {
+		tax := LineTax{OrderItemId: line.OrderItemId, Rate: "0", Inclusive: true, Amount: money.New(0, line.Amount.Currency)}
+
+		// The rule of the nearest category wins, rules without a category apply when no category rule
+		// matches. Rules of the same category are ordered by the zipcode prefix length
+		var match *TaxRuleEntity
+		matchDepth := 0
+		for i, rule := range rules {
+			depth := len(line.CategoryIds)
+			if rule.CategoryId != nil {
+				if depth = slices.Index(line.CategoryIds, *rule.CategoryId); depth < 0 {
+					continue
+				}
+			}
+			if match == nil || depth < matchDepth {
+				match, matchDepth = &rules[i], depth
+			}
+		}
+
+		if match != nil {
+			rate, ok := new(big.Rat).SetString(match.Rate.String())
+			if !ok {
+				return nil, fmt.Errorf("%w: rate '%s' of rule '%d'", ErrInvalidTaxRule, match.Rate, match.Id)
+			}
+			tax.Rate = match.Rate
+			tax.Inclusive = match.IsInclusive
+			tax.Amount = line.Amount.MulRat(taxRatio(rate, match.IsInclusive))
+		}
+		taxes = append(taxes, tax)
+	}
+	return taxes, nil
+}
+
+// Returns the share of the line amount that is tax. Inclusive prices already contain the tax,
+// so the tax is rate / (100 + rate) of the price, exclusive taxes are rate / 100 of the price
+func taxRatio(rate *big.Rat, inclusive bool) *big.Rat {
+	divisor := big.NewRat(100, 1)
+	if inclusive {
+		divisor.Add(divisor, rate)
+	}
+	return new(big.Rat).Quo(rate, divisor)
+}
+
+// Groups the order item taxes by rate
+func summarizeOrderTaxes(order *OrderEntity) []OrderTaxSummary {
+	summary := make([]OrderTaxSummary, 0)
+	for _, item := range order.Items {
+		if item.TaxAmount.IsZero() {
+			continue
+		}
+
+		index := -1
+		for i := range summary {
+			if summary[i].Rate == item.TaxRate && summary[i].Inclusive == item.TaxInclusive {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			summary = append(summary, OrderTaxSummary{
+				Rate:      item.TaxRate,
+				Inclusive: item.TaxInclusive,
+				Base:      money.New(0, order.Currency),
+				Amount:    money.New(0, order.Currency),
+			})
+			index = len(summary) - 1
+		}
+		summary[index].Base = summary[index].Base.Add(item.TaxBase)
+		summary[index].Amount = summary[index].Amount.Add(item.TaxAmount)
+	}
+	return summary
+}
+
+type TaxRuleStore struct {
+	db *DatabaseConnection
+}
+
+func NewTaxRuleStore(database *DatabaseConnection) *TaxRuleStore {
+	return &TaxRuleStore{
+		db: database,
+	}
+}
+
+func (s *TaxRuleStore) GetAll() ([]TaxRuleEntity, error) {
+	rows, err := s.db.Connection.Query(s.db.Context, `
+		select `+taxRuleColumnsSQL+`
+		from "tax_rules"
+		order by country, zipcode_prefix, category_id nulls first`)
+	if err != nil {
+		return nil, err
+	}
+	return scanTaxRules(rows)
+}
+
+func (s *TaxRuleStore) Create(ctx context.Context, opts *TaxRuleCreate) (*TaxRuleEntity, error) {
+	opts.Country = strings.TrimSpace(opts.Country)
+	opts.ZipcodePrefix = strings.TrimSpace(opts.ZipcodePrefix)
+	if opts.Country == "" {
+		return nil, fmt.Errorf("%w: country is required", ErrInvalidTaxRule)
+	}
+	rate, ok := new(big.Rat).SetString(opts.Rate.String())
+	if !ok || rate.Sign() < 0 || rate.Cmp(big.NewRat(100, 1)) > 0 {
+		return nil, fmt.Errorf("%w: rate must be a percent in range [0, 100]", ErrInvalidTaxRule)
+	}
+
+	rows, err := s.db.Connection.Query(ctx, `
+		insert into "tax_rules" (name, country, zipcode_prefix, category_id, rate, is_inclusive)
+		values ($1, $2, $3, $4, $5::text::decimal, $6)
+		returning `+taxRuleColumnsSQL,
+		opts.Name, opts.Country, opts.ZipcodePrefix, opts.CategoryId, opts.Rate.String(), opts.IsInclusive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tax rule: %w", err)
+	}
+	rules, err := scanTaxRules(rows)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrTaxRuleExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tax rule: %w", err)
+	}
+	return &rules[0], nil
+}
+
+func (s *TaxRuleStore) Delete(ctx context.Context, id int64) error {
+	tag, err := s.db.Connection.Exec(ctx, `delete from "tax_rules" where id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete tax rule: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("tax rule with id '%d' not found", id)
+	}
+	return nil
+}
+
+const taxRuleColumnsSQL = `id, name, country, zipcode_prefix, category_id, rate::text, is_inclusive, created_at`
+
+func scanTaxRules(rows pgx.Rows) ([]TaxRuleEntity, error) {
+	defer rows.Close()
+
+	rules := make([]TaxRuleEntity, 0)
+	for rows.Next() {
+		var rule TaxRuleEntity
+		var rate string
+		err := rows.Scan(
+			&rule.Id,
+			&rule.Name,
+			&rule.Country,
+			&rule.ZipcodePrefix,
+			&rule.CategoryId,
+			&rate,
+			&rule.IsInclusive,
+			&rule.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rule.Rate = json.Number(rate)
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
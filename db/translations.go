@@ -0,0 +1,357 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"netshop/main/tools/i18n"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	TranslationProduct   = "product"
+	TranslationCategory  = "category"
+	TranslationAttribute = "attribute"
+	// Values of option attributes, e.g. sizes and colors
+	TranslationAttributeOption = "attribute_option"
+)
+
+var (
+	ErrTranslationNotFound = errors.New("translation not found")
+	ErrInvalidTranslation  = errors.New("invalid translation")
+)
+
+// Tables of the translations of an entity type
+type translationTable struct {
+	table       string
+	keyColumn   string
+	sourceTable string
+	// Column of the base name in the source table
+	nameColumn string
+	// Products and categories have translated descriptions
+	hasDescription bool
+	notFound       error
+}
+
+var translationTables = map[string]translationTable{
+	TranslationProduct:         {"product_translations", "product_id", "products", "name", true, ErrProductNotFound},
+	TranslationCategory:        {"category_translations", "category_id", "categories", "name", true, ErrCategoryNotFound},
+	TranslationAttribute:       {"attribute_translations", "attribute_id", "attributes", "name", false, ErrAttributeNotFound},
+	TranslationAttributeOption: {"attribute_option_translations", "option_id", "attribute_options", "value", false, ErrAttributeNotFound},
+}
+
+// TranslationEntity is the text of a product, category, attribute or attribute option in a language other than the default one
+type TranslationEntity struct {
+	EntityType string `json:"entity_type"`
+	EntityId   int64  `json:"entity_id"`
+	Language   string `json:"language"`
+	Name       string `json:"name"`
+	// Only products and categories have descriptions
+	Description string    `json:"description,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type TranslationUpdate struct {
+	Name string `json:"name"`
+	// Ignored for attributes and options, empty falls back to the next language of the chain
+	Description string `json:"description"`
+}
+
+// MissingTranslationEntity is an entity without a translation to some of the supported languages
+type MissingTranslationEntity struct {
+	EntityType string `json:"entity_type"`
+	EntityId   int64  `json:"entity_id"`
+	// Base name in the default language
+	Name string `json:"name"`
+	// Languages without a translation or with an empty description while the base description is set
+	Languages []string `json:"languages"`
+}
+
+type MissingTranslationsOptions struct {
+	// product, category, attribute or attribute_option
+	EntityType string
+	// Only entities missing the language, all translation languages when empty
+	Language string
+	Limit    int64
+	Offset   int64
+}
+
+type TranslationStore struct {
+	db *DatabaseConnection
+}
+
+func NewTranslationStore(database *DatabaseConnection) *TranslationStore {
+	return &TranslationStore{
+		db: database,
+	}
+}
+
+// Returns the translations of the entity ordered by language
+func (s *TranslationStore) GetAll(ctx context.Context, entityType string, entityId int64) ([]TranslationEntity, error) {
+	table, err := getTranslationTable(entityType)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err = s.db.Connection.QueryRow(ctx, `select exists(select 1 from "`+table.sourceTable+`" where id = $1)`, entityId).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check translated entity: %w", err)
+	}
+	if !exists {
+		return nil, table.notFound
+	}
+
+	rows, err := s.db.Connection.Query(ctx, `
+		select `+table.keyColumn+`, language, name, `+table.descriptionSQL()+`, updated_at
+		from "`+table.table+`"
+		where `+table.keyColumn+` = $1
+		order by language`, entityId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get translations: %w", err)
+	}
+	defer rows.Close()
+
+	translations := make([]TranslationEntity, 0)
+	for rows.Next() {
+		translation := TranslationEntity{EntityType: entityType}
+		err := rows.Scan(&translation.EntityId, &translation.Language, &translation.Name, &translation.Description, &translation.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		translations = append(translations, translation)
+	}
+	return translations, rows.Err()
+}
+
+// Inserts or replaces the translation of the entity to the language
+func (s *TranslationStore) Set(ctx context.Context, entityType string, entityId int64, language string, update *TranslationUpdate) (*TranslationEntity, error) {
+	table, err := getTranslationTable(entityType)
+	if err != nil {
+		return nil, err
+	}
+	language, err = checkTranslationLanguage(language)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(update.Name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidTranslation)
+	}
+	description := ""
+	if table.hasDescription {
+		description = strings.TrimSpace(update.Description)
+	}
+
+	columns, values, assignments := `name`, `$3`, `name = excluded.name`
+	args := []any{entityId, language, name}
+	if table.hasDescription {
+		columns += `, description`
+		values += `, $4`
+		assignments += `, description = excluded.description`
+		args = append(args, description)
+	}
+
+	translation := TranslationEntity{EntityType: entityType, EntityId: entityId, Language: language, Name: name, Description: description}
+	err = s.db.Connection.QueryRow(ctx, `
+		insert into "`+table.table+`" (`+table.keyColumn+`, language, `+columns+`)
+		values ($1, $2, `+values+`)
+		on conflict (`+table.keyColumn+`, language) do update set `+assignments+`, updated_at = now()
+		returning updated_at`, args...).Scan(&translation.UpdatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			switch pgErr.Code {
+			case "23503":
+				return nil, table.notFound
+			case "22001":
+				return nil, fmt.Errorf("%w: name is too long", ErrInvalidTranslation)
+			}
+		}
+		return nil, fmt.Errorf("failed to save translation: %w", err)
+	}
+	return &translation, nil
+}
+
+func (s *TranslationStore) Delete(ctx context.Context, entityType string, entityId int64, language string) error {
+	table, err := getTranslationTable(entityType)
+	if err != nil {
+		return err
+	}
+
+	tag, err := s.db.Connection.Exec(ctx, `
+		delete from "`+table.table+`"
+		where `+table.keyColumn+` = $1 and language = $2`, entityId, i18n.Normalize(language))
+	if err != nil {
+		return fmt.Errorf("failed to delete translation: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTranslationNotFound
+	}
+	return nil
+}
+
+// Returns the entities of the type that miss translations to the supported languages, ordered by id
+func (s *TranslationStore) GetMissing(ctx context.Context, opts *MissingTranslationsOptions) ([]MissingTranslationEntity, error) {
+	table, err := getTranslationTable(opts.EntityType)
+	if err != nil {
+		return nil, err
+	}
+	languages := i18n.TranslationLanguages()
+	if opts.Language != "" {
+		language, err := checkTranslationLanguage(opts.Language)
+		if err != nil {
+			return nil, err
+		}
+		languages = []string{language}
+	}
+	limit := "all"
+	if opts.Limit > 0 {
+		limit = fmt.Sprint(opts.Limit)
+	}
+
+	// Empty translated descriptions count as missing only when there is a base description
+	emptyDescription := `false`
+	if table.hasDescription {
+		emptyDescription = `"translations".description = '' and coalesce("source".description, '') <> ''`
+	}
+	rows, err := s.db.Connection.Query(ctx, `
+		select "missing".id, "missing".name, "missing".languages
+		from (
+			select "source".id, "source".`+table.nameColumn+` as name, array(
+				select "language" from unnest($1::text[]) with ordinality as "languages"("language", position)
+				where not exists(
+					select 1 from "`+table.table+`" "translations"
+					where "translations".`+table.keyColumn+` = "source".id
+						and "translations".language = "languages"."language"
+						and not (`+emptyDescription+`)
+				)
+				order by position
+			) as languages
+			from "`+table.sourceTable+`" "source"
+		) "missing"
+		where cardinality("missing".languages) > 0
+		order by "missing".id
+		limit `+limit+` offset $2`, languages, opts.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get missing translations: %w", err)
+	}
+	defer rows.Close()
+
+	missing := make([]MissingTranslationEntity, 0)
+	for rows.Next() {
+		entity := MissingTranslationEntity{EntityType: opts.EntityType}
+		if err := rows.Scan(&entity.EntityId, &entity.Name, &entity.Languages); err != nil {
+			return nil, err
+		}
+		missing = append(missing, entity)
+	}
+	return missing, rows.Err()
+}
+
+// Translated texts of entities of one type resolved by a fallback chain of languages.
+// Texts without a translation in any language of the chain are the base texts
+type translationList struct {
+	names        map[int64]string
+	descriptions map[int64]string
+}
+
+// Loads the translations of the entities to the languages of the fallback chain.
+// An empty chain means the default language, so nothing is loaded
+func newTranslationList(ctx context.Context, q querier, entityType string, ids []int64, languages []string) (*translationList, error) {
+	list := &translationList{names: make(map[int64]string), descriptions: make(map[int64]string)}
+	if len(ids) == 0 || len(languages) == 0 {
+		return list, nil
+	}
+	table, err := getTranslationTable(entityType)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(ctx, `
+		select `+table.keyColumn+`, name, `+table.descriptionSQL()+`
+		from "`+table.table+`"
+		where `+table.keyColumn+` = any($1) and language = any($2)
+		order by array_position($2::text[], language::text)`, ids, languages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get translations: %w", err)
+	}
+	defer rows.Close()
+
+	// Every text falls back separately, so a translation with an empty description
+	// takes the description of the next language
+	for rows.Next() {
+		var id int64
+		var name, description string
+		if err := rows.Scan(&id, &name, &description); err != nil {
+			return nil, err
+		}
+		if _, exists := list.names[id]; !exists && name != "" {
+			list.names[id] = name
+		}
+		if _, exists := list.descriptions[id]; !exists && description != "" {
+			list.descriptions[id] = description
+		}
+	}
+	return list, rows.Err()
+}
+
+// Returns the translated name or the base name
+func (l *translationList) name(id int64, base string) string {
+	if name, exists := l.names[id]; exists {
+		return name
+	}
+	return base
+}
+
+// Returns the translated description or the base description
+func (l *translationList) description(id int64, base string) string {
+	if description, exists := l.descriptions[id]; exists {
+		return description
+	}
+	return base
+}
+
+func (t translationTable) descriptionSQL() string {
+	if t.hasDescription {
+		return `description`
+	}
+	return `''`
+}
+
+func getTranslationTable(entityType string) (translationTable, error) {
+	table, exists := translationTables[entityType]
+	if !exists {
+		return translationTable{}, fmt.Errorf("%w: unsupported entity type '%s'", ErrInvalidTranslation, entityType)
+	}
+	return table, nil
+}
+
+// Returns the normalized language if translations to it are allowed. Texts in the default language
+// are the base texts of the entities
+func checkTranslationLanguage(language string) (string, error) {
+	language = i18n.Normalize(language)
+	if language == i18n.DefaultLanguage() {
+		return "", fmt.Errorf("%w: texts in the default language '%s' are edited on the entity itself", ErrInvalidTranslation, language)
+	}
+	if !i18n.IsSupported(language) {
+		return "", fmt.Errorf("%w: unsupported language '%s'", ErrInvalidTranslation, language)
+	}
+	return language, nil
+}
+
+// SQL condition of the "products" row matching the full-text search query in the base texts
+// or the translations to the languages of the fallback chain. The query and the languages
+// are the parameters with the given numbers
+func productSearchSQL(queryParam, languagesParam int) string {
+	return fmt.Sprintf(`("products"."search_vector" @@ websearch_to_tsquery('simple', $%[1]d)
+		or exists(
+			select 1 from "product_translations"
+			where "product_translations".product_id = "products"."id"
+				and "product_translations".language = any($%[2]d)
+				and "product_translations".search_vector @@ websearch_to_tsquery('simple', $%[1]d)
+		))`, queryParam, languagesParam)
+}
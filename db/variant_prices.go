@@ -0,0 +1,238 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"netshop/main/tools/money"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// History of the variant price, the open regular row is the current price
+	VariantPriceRegular = "regular"
+	// Scheduled price that overrides the regular price while it is effective
+	VariantPriceSale = "sale"
+)
+
+var (
+	ErrVariantPriceNotFound = errors.New("variant price not found")
+	ErrInvalidSalePrice     = errors.New("invalid sale price")
+	ErrSalePriceOverlap     = errors.New("sale price overlaps another sale of the variant")
+)
+
+// SQL condition of the "variant_prices" sale row effective right now
+const activeSalePriceSQL = `"variant_prices".kind = 'sale'
+	and "variant_prices".cancelled_at is null
+	and "variant_prices".effective_from <= now()
+	and ("variant_prices".effective_to is null or "variant_prices".effective_to > now())`
+
+// Default currency price of the "product_variants" row: the latest active sale price
+// or the regular price
+const effectiveVariantPriceSQL = `coalesce((
+		select "variant_prices".price from "variant_prices"
+		where "variant_prices".product_variant_id = "product_variants"."id" and ` + activeSalePriceSQL + `
+		order by "variant_prices".effective_from desc, "variant_prices".id desc
+		limit 1
+	), "product_variants"."price")`
+
+// VariantPriceEntity is a record of the variant price history or a scheduled sale
+type VariantPriceEntity struct {
+	Id               int64  `json:"id"`
+	ProductVariantId int64  `json:"product_variant_id"`
+	Kind             string `json:"kind"`
+	// Prices are in the default currency
+	Price money.Money `json:"price"`
+	// Strike-through price of sales, nil shows the regular price
+	CompareAtPrice *money.Money `json:"compare_at_price"`
+	EffectiveFrom  time.Time    `json:"effective_from"`
+	// Nil for open ranges
+	EffectiveTo *time.Time `json:"effective_to"`
+	CancelledAt *time.Time `json:"cancelled_at,omitempty"`
+	// Nil for changes that are not made by an employee
+	EmployeeId *int64    `json:"employee_id"`
+	Comment    string    `json:"comment"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type SalePriceCreate struct {
+	Price          money.Money  `json:"price"`
+	CompareAtPrice *money.Money `json:"compare_at_price"`
+	// The sale starts right away when empty
+	EffectiveFrom *time.Time `json:"effective_from"`
+	// The sale lasts until it is cancelled when empty
+	EffectiveTo *time.Time `json:"effective_to"`
+	Comment     string     `json:"comment"`
+	EmployeeId  int64      `json:"-"`
+}
+
+const variantPriceColumnsSQL = `id, product_variant_id, kind::text, price, compare_at_price, effective_from, effective_to, cancelled_at, employee_id, comment, created_at`
+
+// Returns the regular price history and the sales of the variant, the latest first
+func (p *ProductEntityStore) GetPriceHistory(ctx context.Context, productId, variantId int64) ([]VariantPriceEntity, error) {
+	if err := checkVariantOfProduct(ctx, p.db.Connection, productId, variantId); err != nil {
+		return nil, err
+	}
+
+	rows, err := p.db.Connection.Query(ctx, `
+		select `+variantPriceColumnsSQL+`
+		from "variant_prices"
+		where product_variant_id = $1
+		order by effective_from desc, id desc`, variantId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant prices: %w", err)
+	}
+	return scanVariantPrices(rows)
+}
+
+// Schedules a sale price of the variant. Sales of a variant cannot overlap
+func (p *ProductEntityStore) ScheduleSalePrice(ctx context.Context, productId, variantId int64, opts *SalePriceCreate) (*VariantPriceEntity, error) {
+	now := time.Now()
+	effectiveFrom := now
+	if opts.EffectiveFrom != nil && opts.EffectiveFrom.After(now) {
+		effectiveFrom = *opts.EffectiveFrom
+	}
+	if !isDefaultCurrency(opts.Price) || (opts.CompareAtPrice != nil && !isDefaultCurrency(*opts.CompareAtPrice)) {
+		return nil, fmt.Errorf("%w: sale prices must be in the default currency", ErrInvalidSalePrice)
+	}
+	if opts.Price.Amount < 0 {
+		return nil, fmt.Errorf("%w: price must not be negative", ErrInvalidSalePrice)
+	}
+	if opts.EffectiveTo != nil && !opts.EffectiveTo.After(effectiveFrom) {
+		return nil, fmt.Errorf("%w: sale must end after it starts", ErrInvalidSalePrice)
+	}
+	if opts.CompareAtPrice != nil && opts.CompareAtPrice.Amount <= opts.Price.Amount {
+		return nil, fmt.Errorf("%w: compare-at price must be higher than the sale price", ErrInvalidSalePrice)
+	}
+
+	tx, err := p.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// The variant row lock serializes the overlap checks of concurrent sales
+	var regularPrice money.Money
+	err = tx.QueryRow(ctx, `
+		select price from "product_variants"
+		where id = $1 and product_id = $2
+		for update`, variantId, productId).Scan(&regularPrice)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrVariantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product variant: %w", err)
+	}
+	if opts.CompareAtPrice == nil && opts.Price.Amount >= regularPrice.Amount {
+		return nil, fmt.Errorf("%w: sale price must be lower than the regular price", ErrInvalidSalePrice)
+	}
+
+	var overlaps bool
+	err = tx.QueryRow(ctx, `
+		select exists(
+			select 1 from "variant_prices"
+			where product_variant_id = $1 and kind = 'sale' and cancelled_at is null
+				and effective_from < coalesce($3, 'infinity'::timestamp)
+				and coalesce(effective_to, 'infinity'::timestamp) > $2
+		)`, variantId, effectiveFrom, opts.EffectiveTo).Scan(&overlaps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check sale prices: %w", err)
+	}
+	if overlaps {
+		return nil, ErrSalePriceOverlap
+	}
+
+	rows, err := tx.Query(ctx, `
+		insert into "variant_prices" (product_variant_id, kind, price, compare_at_price, effective_from, effective_to, employee_id, comment)
+		values ($1, 'sale', $2, $3, $4, $5, $6, $7)
+		returning `+variantPriceColumnsSQL,
+		variantId, opts.Price, opts.CompareAtPrice, effectiveFrom, opts.EffectiveTo, employeeRef(opts.EmployeeId), opts.Comment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sale price: %w", err)
+	}
+	prices, err := scanVariantPrices(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &prices[0], tx.Commit(ctx)
+}
+
+// Cancels a sale of the variant that has not ended yet. Cancelled sales stay in the history
+func (p *ProductEntityStore) CancelSalePrice(ctx context.Context, productId, variantId, priceId int64) error {
+	tag, err := p.db.Connection.Exec(ctx, `
+		update "variant_prices" set cancelled_at = now()
+		where id = $3 and product_variant_id = $2 and kind = 'sale' and cancelled_at is null
+			and (effective_to is null or effective_to > now())
+			and exists(select 1 from "product_variants" where id = $2 and product_id = $1)`,
+		productId, variantId, priceId)
+	if err != nil {
+		return fmt.Errorf("failed to cancel sale price: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrVariantPriceNotFound
+	}
+	return nil
+}
+
+// Closes the current regular price of the variant and records the new one
+func txRecordRegularPrice(ctx context.Context, tx pgx.Tx, variantId int64, price money.Money, employeeId int64, comment string) error {
+	_, err := tx.Exec(ctx, `
+		update "variant_prices" set effective_to = now()
+		where product_variant_id = $1 and kind = 'regular' and effective_to is null`, variantId)
+	if err != nil {
+		return fmt.Errorf("failed to close variant price: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		insert into "variant_prices" (product_variant_id, kind, price, employee_id, comment)
+		values ($1, 'regular', $2, $3, $4)`, variantId, price, employeeRef(employeeId), comment)
+	if err != nil {
+		return fmt.Errorf("failed to record variant price: %w", err)
+	}
+	return nil
+}
+
+func isDefaultCurrency(price money.Money) bool {
+	return price.Currency == "" || price.Currency == money.DefaultCurrency()
+}
+
+func checkVariantOfProduct(ctx context.Context, q querier, productId, variantId int64) error {
+	var exists bool
+	err := q.QueryRow(ctx, `select exists(select 1 from "product_variants" where id = $1 and product_id = $2)`, variantId, productId).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check product variant: %w", err)
+	}
+	if !exists {
+		return ErrVariantNotFound
+	}
+	return nil
+}
+
+func scanVariantPrices(rows pgx.Rows) ([]VariantPriceEntity, error) {
+	defer rows.Close()
+
+	prices := make([]VariantPriceEntity, 0)
+	for rows.Next() {
+		var price VariantPriceEntity
+		err := rows.Scan(
+			&price.Id,
+			&price.ProductVariantId,
+			&price.Kind,
+			&price.Price,
+			&price.CompareAtPrice,
+			&price.EffectiveFrom,
+			&price.EffectiveTo,
+			&price.CancelledAt,
+			&price.EmployeeId,
+			&price.Comment,
+			&price.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		prices = append(prices, price)
+	}
+	return prices, rows.Err()
+}
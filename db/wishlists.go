@@ -0,0 +1,527 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"netshop/main/tools/money"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const maxWishlistsPerCustomer = 20
+
+var (
+	ErrWishlistNotFound     = errors.New("wishlist not found")
+	ErrWishlistExists       = errors.New("customer already has a wishlist with the same name")
+	ErrWishlistItemNotFound = errors.New("wishlist item not found")
+	ErrWishlistItemExists   = errors.New("wishlist already contains the product")
+	ErrInvalidWishlist      = errors.New("invalid wishlist")
+)
+
+// WishlistItemEntity is a saved product or product variant with its live price and stock
+type WishlistItemEntity struct {
+	Id          int64  `json:"id"`
+	ProductId   int64  `json:"product_id"`
+	ProductName string `json:"product_name"`
+	ProductSlug string `json:"product_slug"`
+	// Nil when the whole product is saved
+	ProductVariantId *int64 `json:"product_variant_id,omitempty"`
+	Sku              string `json:"sku,omitempty"`
+	// Attribute values of the variant, e.g. "Color: Black, Size: M"
+	Attributes string `json:"attributes,omitempty"`
+	// Variant price, or the lowest variant price when the whole product is saved
+	Price money.Money `json:"price"`
+	// Available stock of the variant, or of all variants when the whole product is saved
+	Stock           int32     `json:"stock"`
+	ImageUrl        string    `json:"image_url,omitempty"`
+	NotifyPriceDrop bool      `json:"notify_price_drop"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type WishlistEntity struct {
+	Id int64 `json:"id"`
+	// Owner of the wishlist, not exposed through shared links
+	CustomerId int64  `json:"-"`
+	Name       string `json:"name"`
+	// Token of the public link, nil when the wishlist is not shared
+	ShareToken *string              `json:"share_token,omitempty"`
+	Items      []WishlistItemEntity `json:"items"`
+	CreatedAt  time.Time            `json:"created_at"`
+	UpdatedAt  time.Time            `json:"updated_at"`
+}
+
+type WishlistCreateUpdate struct {
+	CustomerId int64  `json:"-"`
+	Name       string `json:"name"`
+}
+
+type WishlistItemCreate struct {
+	ProductId int64 `json:"product_id"`
+	// Optional variant of the product, nil saves the whole product
+	ProductVariantId *int64 `json:"product_variant_id"`
+	// Notify about price drops of the variant, true when omitted
+	NotifyPriceDrop *bool `json:"notify_price_drop"`
+}
+
+// WishlistPriceDropEntity is a price drop of a wishlisted variant for one customer
+type WishlistPriceDropEntity struct {
+	CustomerId       int64       `json:"customer_id"`
+	CustomerEmail    string      `json:"customer_email"`
+	ProductId        int64       `json:"product_id"`
+	ProductName      string      `json:"product_name"`
+	ProductVariantId int64       `json:"product_variant_id"`
+	OldPrice         money.Money `json:"old_price"`
+	Price            money.Money `json:"price"`
+	// Wishlist items of the customer with the variant
+	ItemIds []int64 `json:"item_ids"`
+}
+
+type WishlistStore struct {
+	db *DatabaseConnection
+}
+
+const wishlistItemSelectSQL = `select
+		"wishlist_items".id,
+		"wishlist_items".wishlist_id,
+		"products".id,
+		"products".name,
+		"products".slug,
+		"wishlist_items".product_variant_id,
+		coalesce("product_variants".sku, ''),
+		coalesce(` + variantAttributesTextSQL + `, ''),
+		coalesce("product_variants".price, (
+			select min("variants".price) from "product_variants" "variants" where "variants".product_id = "products".id
+		), "products".base_price),
+		coalesce("product_variants".stock - ` + reservedStockSQL + `, (
+			select sum("product_variants".stock - ` + reservedStockSQL + `)
+			from "product_variants" where "product_variants".product_id = "products".id
+		), 0),
+		coalesce((
+			select "files".path
+			from "product_variant_images"
+			join "files" on "files".id = "product_variant_images".file_id
+			join "product_variants" "variants" on "variants".id = "product_variant_images".product_variant_id
+			where "variants".product_id = "products".id
+				and ("wishlist_items".product_variant_id is null or "variants".id = "wishlist_items".product_variant_id)
+			order by "variants".id, "files".id
+			limit 1
+		), ''),
+		"wishlist_items".notify_price_drop,
+		"wishlist_items".created_at
+	from "wishlist_items"
+	join "products" on "products".id = "wishlist_items".product_id
+	left join "product_variants" on "product_variants".id = "wishlist_items".product_variant_id`
+
+func NewWishlistStore(database *DatabaseConnection) *WishlistStore {
+	return &WishlistStore{
+		db: database,
+	}
+}
+
+// Returns the wishlists of the customer with prices in the given currency (empty means the default currency)
+func (s *WishlistStore) GetAll(ctx context.Context, customerId int64, currency string) ([]WishlistEntity, error) {
+	rows, err := s.db.Connection.Query(ctx, `
+		select id, customer_id, name, share_token, created_at, updated_at
+		from "wishlists"
+		where customer_id = $1
+		order by created_at, id`, customerId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wishlists: %w", err)
+	}
+	defer rows.Close()
+
+	wishlists := make([]WishlistEntity, 0)
+	for rows.Next() {
+		wishlist, err := scanWishlist(rows)
+		if err != nil {
+			return nil, err
+		}
+		wishlists = append(wishlists, wishlist)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := s.loadItems(ctx, wishlists, currency); err != nil {
+		return nil, err
+	}
+	return wishlists, nil
+}
+
+// Returns the wishlist of the customer
+func (s *WishlistStore) GetById(ctx context.Context, id, customerId int64, currency string) (*WishlistEntity, error) {
+	row := s.db.Connection.QueryRow(ctx, `
+		select id, customer_id, name, share_token, created_at, updated_at
+		from "wishlists"
+		where id = $1 and customer_id = $2`, id, customerId)
+	return s.getOne(ctx, row, currency)
+}
+
+// Returns the wishlist shared with the token
+func (s *WishlistStore) GetShared(ctx context.Context, token string, currency string) (*WishlistEntity, error) {
+	row := s.db.Connection.QueryRow(ctx, `
+		select id, customer_id, name, share_token, created_at, updated_at
+		from "wishlists"
+		where share_token = $1`, token)
+	return s.getOne(ctx, row, currency)
+}
+
+func (s *WishlistStore) Create(ctx context.Context, opts *WishlistCreateUpdate) (*WishlistEntity, error) {
+	if err := normalizeWishlist(opts); err != nil {
+		return nil, err
+	}
+
+	var count int
+	err := s.db.Connection.QueryRow(ctx, `select count(*) from "wishlists" where customer_id = $1`, opts.CustomerId).Scan(&count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count wishlists: %w", err)
+	}
+	if count >= maxWishlistsPerCustomer {
+		return nil, fmt.Errorf("%w: a customer can have at most %d wishlists", ErrInvalidWishlist, maxWishlistsPerCustomer)
+	}
+
+	row := s.db.Connection.QueryRow(ctx, `
+		insert into "wishlists" (customer_id, name) values ($1, $2)
+		returning id, customer_id, name, share_token, created_at, updated_at`, opts.CustomerId, opts.Name)
+	wishlist, err := scanWishlist(row)
+	if err != nil {
+		return nil, wishlistWriteError(err)
+	}
+	wishlist.Items = make([]WishlistItemEntity, 0)
+	return &wishlist, nil
+}
+
+// Renames the wishlist of the customer
+func (s *WishlistStore) Update(ctx context.Context, id int64, opts *WishlistCreateUpdate) error {
+	if err := normalizeWishlist(opts); err != nil {
+		return err
+	}
+
+	tag, err := s.db.Connection.Exec(ctx, `
+		update "wishlists" set name = $3, updated_at = now()
+		where id = $1 and customer_id = $2`, id, opts.CustomerId, opts.Name)
+	if err != nil {
+		return wishlistWriteError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrWishlistNotFound
+	}
+	return nil
+}
+
+func (s *WishlistStore) Delete(ctx context.Context, id, customerId int64) error {
+	tag, err := s.db.Connection.Exec(ctx, `delete from "wishlists" where id = $1 and customer_id = $2`, id, customerId)
+	if err != nil {
+		return fmt.Errorf("failed to delete wishlist: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrWishlistNotFound
+	}
+	return nil
+}
+
+// Creates a share token of the wishlist, an already shared wishlist keeps its token
+func (s *WishlistStore) Share(ctx context.Context, id, customerId int64) (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate share token: %w", err)
+	}
+
+	var shareToken string
+	err := s.db.Connection.QueryRow(ctx, `
+		update "wishlists" set share_token = coalesce(share_token, $3), updated_at = now()
+		where id = $1 and customer_id = $2
+		returning share_token`, id, customerId, hex.EncodeToString(token)).Scan(&shareToken)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrWishlistNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to share wishlist: %w", err)
+	}
+	return shareToken, nil
+}
+
+// Removes the share token, so the public link stops working
+func (s *WishlistStore) Unshare(ctx context.Context, id, customerId int64) error {
+	tag, err := s.db.Connection.Exec(ctx, `
+		update "wishlists" set share_token = null, updated_at = now()
+		where id = $1 and customer_id = $2`, id, customerId)
+	if err != nil {
+		return fmt.Errorf("failed to unshare wishlist: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrWishlistNotFound
+	}
+	return nil
+}
+
+// Adds the product or its variant to the wishlist of the customer
+func (s *WishlistStore) AddItem(ctx context.Context, wishlistId, customerId int64, opts *WishlistItemCreate) (int64, error) {
+	notifyPriceDrop := true
+	if opts.NotifyPriceDrop != nil {
+		notifyPriceDrop = *opts.NotifyPriceDrop
+	}
+
+	tx, err := s.db.Connection.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `update "wishlists" set updated_at = now() where id = $1 and customer_id = $2`, wishlistId, customerId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update wishlist: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, ErrWishlistNotFound
+	}
+
+	// The current price is the reference of the price drop notifications
+	var itemId int64
+	if opts.ProductVariantId == nil {
+		err = tx.QueryRow(ctx, `
+			insert into "wishlist_items" (wishlist_id, product_id, notify_price_drop)
+			select $1, id, $3 from "products" where id = $2 and `+publishedProductSQL+`
+			returning id`, wishlistId, opts.ProductId, notifyPriceDrop).Scan(&itemId)
+	} else {
+		err = tx.QueryRow(ctx, `
+			insert into "wishlist_items" (wishlist_id, product_id, product_variant_id, notified_price, notify_price_drop)
+			select $1, "product_variants".product_id, "product_variants".id, `+effectiveVariantPriceSQL+`, $4
+			from "product_variants"
+			join "products" on "products".id = "product_variants".product_id
+			where "product_variants".id = $3 and "product_variants".product_id = $2 and `+publishedProductSQL+`
+			returning id`, wishlistId, opts.ProductId, *opts.ProductVariantId, notifyPriceDrop).Scan(&itemId)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		if opts.ProductVariantId != nil {
+			return 0, ErrVariantNotFound
+		}
+		return 0, ErrProductNotFound
+	}
+	if err != nil {
+		return 0, wishlistWriteError(err)
+	}
+
+	return itemId, tx.Commit(ctx)
+}
+
+// Removes the item from the wishlist of the customer
+func (s *WishlistStore) RemoveItem(ctx context.Context, wishlistId, itemId, customerId int64) error {
+	tag, err := s.db.Connection.Exec(ctx, `
+		delete from "wishlist_items"
+		using "wishlists"
+		where "wishlist_items".id = $2 and "wishlist_items".wishlist_id = $1
+			and "wishlists".id = "wishlist_items".wishlist_id and "wishlists".customer_id = $3`,
+		wishlistId, itemId, customerId)
+	if err != nil {
+		return fmt.Errorf("failed to remove wishlist item: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrWishlistItemNotFound
+	}
+	return nil
+}
+
+// Raises the reference price of items whose variants got more expensive and returns
+// the variants that got cheaper than the last notified price, grouped by customer
+func (s *WishlistStore) RefreshPriceDrops(ctx context.Context) ([]WishlistPriceDropEntity, error) {
+	_, err := s.db.Connection.Exec(ctx, `
+		update "wishlist_items" set notified_price = "current".price
+		from "product_variants"
+		join lateral (select `+effectiveVariantPriceSQL+` as price) "current" on true
+		where "product_variants".id = "wishlist_items".product_variant_id
+			and ("wishlist_items".notified_price is null or "current".price > "wishlist_items".notified_price)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update wishlist prices: %w", err)
+	}
+
+	rows, err := s.db.Connection.Query(ctx, `
+		select
+			"wishlists".customer_id,
+			"person".email,
+			"products".id,
+			"products".name,
+			"product_variants".id,
+			max("wishlist_items".notified_price),
+			"current".price,
+			array_agg("wishlist_items".id order by "wishlist_items".id)
+		from "wishlist_items"
+		join "wishlists" on "wishlists".id = "wishlist_items".wishlist_id
+		join "customers" on "customers".id = "wishlists".customer_id
+		join "person" on "person".id = "customers".person_id
+		join "product_variants" on "product_variants".id = "wishlist_items".product_variant_id
+		join "products" on "products".id = "product_variants".product_id
+		join lateral (select `+effectiveVariantPriceSQL+` as price) "current" on true
+		where "wishlist_items".notify_price_drop
+			and "current".price < "wishlist_items".notified_price
+		group by "wishlists".customer_id, "person".email, "products".id, "products".name, "product_variants".id, "current".price
+		order by "products".id, "product_variants".id, "wishlists".customer_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wishlist price drops: %w", err)
+	}
+	defer rows.Close()
+
+	drops := make([]WishlistPriceDropEntity, 0)
+	for rows.Next() {
+		var drop WishlistPriceDropEntity
+		err := rows.Scan(
+			&drop.CustomerId,
+			&drop.CustomerEmail,
+			&drop.ProductId,
+			&drop.ProductName,
+			&drop.ProductVariantId,
+			&drop.OldPrice,
+			&drop.Price,
+			&drop.ItemIds,
+		)
+		if err != nil {
+			return nil, err
+		}
+		drops = append(drops, drop)
+	}
+	return drops, rows.Err()
+}
+
+// Stores the notified price as the reference of the next price drop
+func (s *WishlistStore) MarkPriceDropNotified(ctx context.Context, drop *WishlistPriceDropEntity) error {
+	_, err := s.db.Connection.Exec(ctx, `update "wishlist_items" set notified_price = $2 where id = any($1)`, drop.ItemIds, drop.Price)
+	if err != nil {
+		return fmt.Errorf("failed to mark price drop as notified: %w", err)
+	}
+	return nil
+}
+
+func (s *WishlistStore) getOne(ctx context.Context, row pgx.Row, currency string) (*WishlistEntity, error) {
+	wishlist, err := scanWishlist(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrWishlistNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wishlist: %w", err)
+	}
+
+	wishlists := []WishlistEntity{wishlist}
+	if err := s.loadItems(ctx, wishlists, currency); err != nil {
+		return nil, err
+	}
+	return &wishlists[0], nil
+}
+
+// Loads the items of the wishlists with live prices in the currency
+func (s *WishlistStore) loadItems(ctx context.Context, wishlists []WishlistEntity, currency string) error {
+	if len(wishlists) == 0 {
+		return nil
+	}
+	wishlistIds := make([]int64, 0, len(wishlists))
+	indexes := make(map[int64]int, len(wishlists))
+	for i := range wishlists {
+		wishlistIds = append(wishlistIds, wishlists[i].Id)
+		indexes[wishlists[i].Id] = i
+		wishlists[i].Items = make([]WishlistItemEntity, 0)
+	}
+
+	rows, err := s.db.Connection.Query(ctx, wishlistItemSelectSQL+`
+		where "wishlist_items".wishlist_id = any($1)
+		order by "wishlist_items".created_at desc, "wishlist_items".id desc`, wishlistIds)
+	if err != nil {
+		return fmt.Errorf("failed to get wishlist items: %w", err)
+	}
+	defer rows.Close()
+
+	itemWishlistIds := make([]int64, 0)
+	variantIds := make([]int64, 0)
+	items := make([]WishlistItemEntity, 0)
+	for rows.Next() {
+		var item WishlistItemEntity
+		var wishlistId int64
+		var imagePath string
+		err := rows.Scan(
+			&item.Id,
+			&wishlistId,
+			&item.ProductId,
+			&item.ProductName,
+			&item.ProductSlug,
+			&item.ProductVariantId,
+			&item.Sku,
+			&item.Attributes,
+			&item.Price,
+			&item.Stock,
+			&imagePath,
+			&item.NotifyPriceDrop,
+			&item.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if imagePath != "" {
+			item.ImageUrl = getImageURLFromPath(imagePath)
+		}
+		if item.ProductVariantId != nil {
+			variantIds = append(variantIds, *item.ProductVariantId)
+		}
+		itemWishlistIds = append(itemWishlistIds, wishlistId)
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	prices, err := newPriceList(ctx, s.db.Connection, currency, variantIds)
+	if err != nil {
+		return err
+	}
+	for i, item := range items {
+		if item.ProductVariantId != nil {
+			item.Price = prices.variantPrice(*item.ProductVariantId, item.Price)
+		} else {
+			item.Price = prices.convert(item.Price)
+		}
+		wishlist := &wishlists[indexes[itemWishlistIds[i]]]
+		wishlist.Items = append(wishlist.Items, item)
+	}
+	return nil
+}
+
+func normalizeWishlist(opts *WishlistCreateUpdate) error {
+	opts.Name = strings.TrimSpace(opts.Name)
+	if opts.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidWishlist)
+	}
+	if len(opts.Name) > 64 {
+		return fmt.Errorf("%w: name must be at most 64 characters", ErrInvalidWishlist)
+	}
+	return nil
+}
+
+func wishlistWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "wishlist_items_product_idx", "wishlist_items_variant_idx":
+			return ErrWishlistItemExists
+		default:
+			return ErrWishlistExists
+		}
+	}
+	return fmt.Errorf("failed to save wishlist: %w", err)
+}
+
+func scanWishlist(row pgx.Row) (WishlistEntity, error) {
+	var wishlist WishlistEntity
+	err := row.Scan(
+		&wishlist.Id,
+		&wishlist.CustomerId,
+		&wishlist.Name,
+		&wishlist.ShareToken,
+		&wishlist.CreatedAt,
+		&wishlist.UpdatedAt,
+	)
+	return wishlist, err
+}
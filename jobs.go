@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"netshop/main/config"
+	"netshop/main/db"
+	"netshop/main/tools/notify"
+	"netshop/main/tools/scheduler"
+)
+
+// Starts all periodic background jobs of the application
+func startBackgroundJobs(ctx context.Context, database *db.DatabaseConnection) {
+	releaseInterval, err := time.ParseDuration(config.AppConfig.ReservationReleaseInterval)
+	if err != nil {
+		log.Fatalf("Invalid reservation release interval '%s'", config.AppConfig.ReservationReleaseInterval)
+	}
+
+	reservationStore := db.NewInventoryReservationStore(database)
+	scheduler.Every(ctx, "Release expired reservations", releaseInterval, func(ctx context.Context) error {
+		released, cancelled, err := reservationStore.ReleaseExpired(ctx)
+		if err != nil {
+			return err
+		}
+		if released > 0 {
+			log.Printf("Released %d expired stock reservations", released)
+		}
+		if cancelled > 0 {
+			log.Printf("Cancelled %d pending orders with expired reservations", cancelled)
+		}
+		return nil
+	})
+
+	stockNotificationsInterval, err := time.ParseDuration(config.AppConfig.StockNotificationsInterval)
+	if err != nil {
+		log.Fatalf("Invalid stock notifications interval '%s'", config.AppConfig.StockNotificationsInterval)
+	}
+	lowStockThreshold, err := strconv.ParseInt(config.AppConfig.LowStockThreshold, 10, 32)
+	if err != nil {
+		log.Fatalf("Invalid low stock threshold '%s'", config.AppConfig.LowStockThreshold)
+	}
+
+	notifier := newNotifier()
+	stockAlertStore := db.NewStockAlertStore(database)
+	employeeStore := db.NewEmployeeEntityStore(database)
+
+	scheduler.Every(ctx, "Low stock alerts", stockNotificationsInterval, func(ctx context.Context) error {
+		if _, err := stockAlertStore.RefreshAlerts(ctx, int32(lowStockThreshold)); err != nil {
+			return err
+		}
+
+		// Alerts stay unnotified until every channel delivers them, so failed notifications are retried
+		alerts, err := stockAlertStore.GetUnnotifiedAlerts(ctx)
+		if err != nil || len(alerts) == 0 {
+			return err
+		}
+		recipients, err := employeeStore.GetEmails()
+		if err != nil {
+			return err
+		}
+		for _, alert := range alerts {
+			channels, err := notifier.NotifySkipping(ctx, &notify.Notification{
+				Event:      "stock.low",
+				Recipients: recipients,
+				Subject:    fmt.Sprintf("Low stock: %s", alert.ProductName),
+				Message:    fmt.Sprintf("Variant %d of '%s' has %d items left (threshold %d)", alert.ProductVariantId, alert.ProductName, alert.Stock, alert.Threshold),
+				Data:       alert,
+			}, alert.NotifiedChannels)
+			if err != nil {
+				log.Printf("Failed to send low stock notification for variant %d: %s", alert.ProductVariantId, err.Error())
+				if err := stockAlertStore.SetAlertChannels(ctx, alert.Id, channels); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := stockAlertStore.MarkAlertNotified(ctx, alert.Id, channels); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	scheduler.Every(ctx, "Back in stock notifications", stockNotificationsInterval, func(ctx context.Context) error {
+		items, err := stockAlertStore.GetBackInStock(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			// Channels that delivered the notification before are skipped, only the failed ones are retried
+			channels, err := notifier.NotifySkipping(ctx, &notify.Notification{
+				Event:      "stock.back_in_stock",
+				Recipients: []string{item.CustomerEmail},
+				Subject:    fmt.Sprintf("%s is back in stock", item.ProductName),
+				Message:    fmt.Sprintf("Good news! '%s' you were waiting for is available again", item.ProductName),
+				Data:       item,
+			}, item.NotifiedChannels)
+			if err != nil {
+				log.Printf("Failed to send back in stock notification %d: %s", item.SubscriptionId, err.Error())
+				if err := stockAlertStore.SetSubscriptionChannels(ctx, item.SubscriptionId, channels); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := stockAlertStore.MarkSubscriptionNotified(ctx, item.SubscriptionId, channels); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	priceDropInterval, err := time.ParseDuration(config.AppConfig.PriceDropNotificationsInterval)
+	if err != nil {
+		log.Fatalf("Invalid price drop notifications interval '%s'", config.AppConfig.PriceDropNotificationsInterval)
+	}
+
+	wishlistStore := db.NewWishlistStore(database)
+	scheduler.Every(ctx, "Wishlist price drop notifications", priceDropInterval, func(ctx context.Context) error {
+		drops, err := wishlistStore.RefreshPriceDrops(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, drop := range drops {
+			err := notifier.Notify(ctx, &notify.Notification{
+				Event:      "wishlist.price_drop",
+				Recipients: []string{drop.CustomerEmail},
+				Subject:    fmt.Sprintf("%s is now cheaper", drop.ProductName),
+				Message:    fmt.Sprintf("The price of '%s' from your wishlist dropped from %s to %s", drop.ProductName, drop.OldPrice, drop.Price),
+				Data:       drop,
+			})
+			if err != nil {
+				log.Printf("Failed to send price drop notification of variant %d to customer %d: %s", drop.ProductVariantId, drop.CustomerId, err.Error())
+				continue
+			}
+			if err := wishlistStore.MarkPriceDropNotified(ctx, &drop); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	reportsRefreshInterval, err := time.ParseDuration(config.AppConfig.ReportsRefreshInterval)
+	if err != nil {
+		log.Fatalf("Invalid reports refresh interval '%s'", config.AppConfig.ReportsRefreshInterval)
+	}
+
+	reportStore := db.NewReportStore(database)
+	scheduler.Every(ctx, "Refresh sales reports", reportsRefreshInterval, reportStore.RefreshViews)
+
+	recommendationsRefreshInterval, err := time.ParseDuration(config.AppConfig.RecommendationsRefreshInterval)
+	if err != nil {
+		log.Fatalf("Invalid recommendations refresh interval '%s'", config.AppConfig.RecommendationsRefreshInterval)
+	}
+
+	productRelationStore := db.NewProductRelationStore(database)
+	scheduler.Every(ctx, "Refresh frequently bought together", recommendationsRefreshInterval, productRelationStore.RefreshCoPurchases)
+
+	publishingInterval, err := time.ParseDuration(config.AppConfig.PublishingInterval)
+	if err != nil {
+		log.Fatalf("Invalid publishing interval '%s'", config.AppConfig.PublishingInterval)
+	}
+
+	productStore := db.NewProductEntityStore(database)
+	scheduler.Every(ctx, "Publish scheduled products", publishingInterval, func(ctx context.Context) error {
+		changes, err := productStore.ApplySchedule(ctx)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			log.Printf("Product %d is %s by schedule", change.ProductId, change.ToStatus)
+		}
+		return nil
+	})
+}
+
+// Creates a notifier from the channels listed in the NOTIFIERS config
+func newNotifier() notify.MultiNotifier {
+	notifiers := notify.MultiNotifier{}
+	for _, name := range strings.Split(config.AppConfig.Notifiers, ",") {
+		name = strings.TrimSpace(name)
+		var notifier notify.Notifier
+		switch name {
+		case "":
+			continue
+		case "log":
+			notifier = &notify.LogNotifier{}
+		case "email":
+			notifier = &notify.EmailNotifier{
+				Mailer: &notify.SMTPMailer{
+					Host:     config.AppConfig.SmtpHost,
+					Port:     config.AppConfig.SmtpPort,
+					Username: config.AppConfig.SmtpUsername,
+					Password: config.AppConfig.SmtpPassword,
+					From:     config.AppConfig.SmtpFrom,
+				},
+			}
+		case "webhook":
+			if config.AppConfig.NotifyWebhookURL == "" {
+				log.Fatalln("NOTIFY_WEBHOOK_URL is required for the webhook notifier")
+			}
+			notifier = notify.NewWebhookNotifier(config.AppConfig.NotifyWebhookURL)
+		default:
+			log.Fatalf("Unknown notifier '%s'", name)
+		}
+		notifiers = append(notifiers, notify.Channel{Name: name, Notifier: notifier})
+	}
+	return notifiers
+}
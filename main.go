@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"netshop/main/api"
 	"netshop/main/config"
 	"netshop/main/db"
+	"netshop/main/tools/payment"
 )
 
 func main() {
@@ -29,8 +31,21 @@ func main() {
 		log.Fatalf("Failed database ping by url '%s'", config.AppConfig.DatabaseURL)
 	}
 
+	if len(os.Args) > 1 {
+		runCommand(database, os.Args[1], os.Args[2:])
+		return
+	}
+
+	startBackgroundJobs(context.Background(), database)
+
+	paymentProvider, err := newPaymentProvider()
+	if err != nil {
+		log.Fatalf("Cannot create payment provider: %s", err.Error())
+	}
+
 	router := api.InitAndCreateRouter(&api.InitEndpointsOptions{
 		DatabaseConnection: database,
+		PaymentProvider:    paymentProvider,
 	})
 
 	httpServerStr := fmt.Sprintf("%s:%s", config.AppConfig.ServerHost, config.AppConfig.ServerPort)
@@ -48,3 +63,18 @@ func main() {
 		log.Fatalf("Cannot run server on %s. Error: %s", httpServerStr, err.Error())
 	}
 }
+
+// Creates the payment gateway selected by the PAYMENT_PROVIDER config
+func newPaymentProvider() (payment.PaymentProvider, error) {
+	switch config.AppConfig.PaymentProvider {
+	case "mock":
+		delay, err := time.ParseDuration(config.AppConfig.MockPaymentWebhookDelay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MOCK_PAYMENT_WEBHOOK_DELAY: %w", err)
+		}
+		webhookURL := config.AppConfig.ServerURL + "/api/v1/payments/webhook/mock"
+		return payment.NewMockProvider(webhookURL, config.AppConfig.PaymentWebhookSecret, delay), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider '%s'", config.AppConfig.PaymentProvider)
+	}
+}
@@ -0,0 +1,101 @@
+// i18n package negotiates the language of the catalog texts. Texts in the default language are
+// the base texts of the catalog, texts in other supported languages are their translations.
+package i18n
+
+import (
+	"netshop/main/config"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Returns the language of the base catalog texts (DEFAULT_LANGUAGE config)
+func DefaultLanguage() string {
+	return Normalize(config.AppConfig.DefaultLanguage)
+}
+
+// Returns the supported languages starting with the default language (SUPPORTED_LANGUAGES config)
+func SupportedLanguages() []string {
+	languages := []string{DefaultLanguage()}
+	for _, language := range strings.Split(config.AppConfig.SupportedLanguages, ",") {
+		language = Normalize(language)
+		if language != "" && !slices.Contains(languages, language) {
+			languages = append(languages, language)
+		}
+	}
+	return languages
+}
+
+// Returns the supported languages other than the default language, the languages of translations
+func TranslationLanguages() []string {
+	return SupportedLanguages()[1:]
+}
+
+func IsSupported(language string) bool {
+	return slices.Contains(SupportedLanguages(), Normalize(language))
+}
+
+// Converts a language tag to its lowercase primary language, e.g. "en-US" to "en"
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	return tag
+}
+
+// Returns the language tags of the Accept-Language header value ordered by their quality.
+// Tags with zero quality and the "*" wildcard are skipped
+func ParseAcceptLanguage(header string) []string {
+	type weightedTag struct {
+		tag     string
+		quality float64
+	}
+
+	tags := make([]weightedTag, 0)
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality <= 0 {
+			continue
+		}
+		tags = append(tags, weightedTag{tag: tag, quality: quality})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].quality > tags[j].quality })
+
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		result = append(result, tag.tag)
+	}
+	return result
+}
+
+// Returns the translation languages to try in order for the preferred language tags.
+// Unsupported languages are skipped and the chain ends before the default language,
+// because the base texts are the last fallback of every chain
+func FallbackChain(preferred []string) []string {
+	defaultLanguage := DefaultLanguage()
+	chain := make([]string, 0)
+	for _, tag := range preferred {
+		language := Normalize(tag)
+		if language == defaultLanguage {
+			break
+		}
+		if IsSupported(language) && !slices.Contains(chain, language) {
+			chain = append(chain, language)
+		}
+	}
+	return chain
+}
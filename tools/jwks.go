@@ -0,0 +1,243 @@
+package tools
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"netshop/main/config"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnsupportedSigningMethod = errors.New("unsupported JWT signing method")
+	ErrUnsupportedKeyType       = errors.New("unsupported key type, only RSA and Ed25519 keys are allowed")
+	ErrInvalidPEM               = errors.New("the file does not contain a PEM encoded key")
+)
+
+// JSONWebKey is a public key in the RFC 7517 format
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA public key parameters
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// OKP (Ed25519) public key parameters
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet is a set of public keys returned by the /.well-known/jwks.json endpoint
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+type jwtVerificationKey struct {
+	method jwt.SigningMethod
+	key    interface{}
+}
+
+type jwtKeySet struct {
+	method     jwt.SigningMethod
+	signingKey interface{}
+	signingKid string
+
+	// Keys that are accepted for token verification by their kid
+	verificationKeys map[string]jwtVerificationKey
+}
+
+var (
+	loadedKeySet    *jwtKeySet
+	loadedKeySetErr error
+	keySetOnce      sync.Once
+)
+
+// Returns the key set built from the application config. Keys are loaded once on the first call
+func getJWTKeySet() (*jwtKeySet, error) {
+	keySetOnce.Do(func() {
+		loadedKeySet, loadedKeySetErr = loadJWTKeySet()
+	})
+	return loadedKeySet, loadedKeySetErr
+}
+
+func loadJWTKeySet() (*jwtKeySet, error) {
+	keySet := &jwtKeySet{
+		signingKid:       config.AppConfig.JwtKeyId,
+		verificationKeys: map[string]jwtVerificationKey{},
+	}
+
+	switch config.AppConfig.JwtSigningMethod {
+	case jwt.SigningMethodHS256.Alg():
+		// Symmetric keys cannot be published, so they are never part of the JWKS
+		keySet.method = jwt.SigningMethodHS256
+		keySet.signingKey = []byte(config.AppConfig.JwtSecret)
+		keySet.verificationKeys[keySet.signingKid] = jwtVerificationKey{keySet.method, keySet.signingKey}
+		return keySet, nil
+	case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg():
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSigningMethod, config.AppConfig.JwtSigningMethod)
+	}
+
+	if keySet.signingKid == "" {
+		return nil, errors.New("JWT_KEY_ID is required for asymmetric signing methods")
+	}
+
+	privateKey, err := readPrivateKeyFile(config.AppConfig.JwtPrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT private key: %w", err)
+	}
+	publicKey, method, err := publicKeyAndMethod(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if method.Alg() != config.AppConfig.JwtSigningMethod {
+		return nil, fmt.Errorf("JWT private key does not match the signing method %s", config.AppConfig.JwtSigningMethod)
+	}
+
+	keySet.method = method
+	keySet.signingKey = privateKey
+	keySet.verificationKeys[keySet.signingKid] = jwtVerificationKey{method, publicKey}
+
+	for _, pair := range strings.Split(config.AppConfig.JwtVerificationKeys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kid, keyPath, found := strings.Cut(pair, "=")
+		if !found || kid == "" || keyPath == "" {
+			return nil, fmt.Errorf("invalid JWT verification key '%s', expected format is kid=path", pair)
+		}
+		// A duplicate kid would silently replace the signing key or another verification key
+		if _, exists := keySet.verificationKeys[kid]; exists {
+			return nil, fmt.Errorf("duplicate JWT key id '%s' in JWT_VERIFICATION_KEYS", kid)
+		}
+
+		publicKey, err := readPublicKeyFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT verification key '%s': %w", kid, err)
+		}
+		_, method, err := publicKeyAndMethod(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		keySet.verificationKeys[kid] = jwtVerificationKey{method, publicKey}
+	}
+
+	return keySet, nil
+}
+
+// Reads a PKCS#8 or PKCS#1 PEM encoded private key
+func readPrivateKeyFile(filePath string) (crypto.PrivateKey, error) {
+	block, err := readPEMFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: unexpected block type '%s'", ErrInvalidPEM, block.Type)
+	}
+}
+
+// Reads a PEM encoded public key. Private keys are accepted as well and converted to their public part
+func readPublicKeyFile(filePath string) (crypto.PublicKey, error) {
+	block, err := readPEMFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		privateKey, err := readPrivateKeyFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, _, err := publicKeyAndMethod(privateKey)
+		return publicKey, err
+	}
+}
+
+func readPEMFile(filePath string) (*pem.Block, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+	return block, nil
+}
+
+// Returns the public key and the signing method that matches the given (private or public) key
+func publicKeyAndMethod(key interface{}) (crypto.PublicKey, jwt.SigningMethod, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey, jwt.SigningMethodRS256, nil
+	case *rsa.PublicKey:
+		return k, jwt.SigningMethodRS256, nil
+	case ed25519.PrivateKey:
+		return k.Public(), jwt.SigningMethodEdDSA, nil
+	case ed25519.PublicKey:
+		return k, jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, nil, ErrUnsupportedKeyType
+	}
+}
+
+// Returns all public verification keys in the JWKS format.
+// The key set is empty when tokens are signed with a symmetric (HS256) secret
+func GetJSONWebKeySet() (*JSONWebKeySet, error) {
+	keySet, err := getJWTKeySet()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	for kid, verificationKey := range keySet.verificationKeys {
+		jwk := JSONWebKey{
+			Kid: kid,
+			Use: "sig",
+			Alg: verificationKey.method.Alg(),
+		}
+
+		switch k := verificationKey.key.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+
+		result.Keys = append(result.Keys, jwk)
+	}
+
+	return result, nil
+}
@@ -1,12 +1,18 @@
 package tools
 
 import (
+	"errors"
 	"netshop/main/config"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var (
+	ErrInvalidTokenClaims = errors.New("token has missing or invalid claims")
+	ErrUnknownTokenKey    = errors.New("token is signed by an unknown key")
+)
+
 type UserTokenClaims struct {
 	Id       int64  `json:"id"`
 	Type     string `json:"type"`
@@ -20,20 +26,72 @@ func NewJWTToken(claims jwt.MapClaims) (string, error) {
 		return "", err
 	}
 
-	token := jwt.New(jwt.SigningMethodHS256)
-	token.Claims = claims
+	keySet, err := getJWTKeySet()
+	if err != nil {
+		return "", err
+	}
+
 	claims["exp"] = time.Now().Add(expire).Unix()
 	claims["iat"] = time.Now().Unix()
+	if config.AppConfig.JwtIssuer != "" {
+		claims["iss"] = config.AppConfig.JwtIssuer
+	}
+	if config.AppConfig.JwtAudience != "" {
+		claims["aud"] = config.AppConfig.JwtAudience
+	}
+
+	token := jwt.NewWithClaims(keySet.method, claims)
+	if keySet.signingKid != "" {
+		token.Header["kid"] = keySet.signingKid
+	}
 
-	return token.SignedString([]byte(config.AppConfig.JwtSecret))
+	return token.SignedString(keySet.signingKey)
 }
 
 // Parses a JWT token and returns the claims (values) of the token
 func ParseJWTToken(tokenString string) (*UserTokenClaims, error) {
-	secret := config.AppConfig.JwtSecret
+	keySet, err := getJWTKeySet()
+	if err != nil {
+		return nil, err
+	}
+
+	validMethods := []string{}
+	for _, verificationKey := range keySet.verificationKeys {
+		validMethods = append(validMethods, verificationKey.method.Alg())
+	}
+
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods(validMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if config.AppConfig.JwtIssuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(config.AppConfig.JwtIssuer))
+	}
+	if config.AppConfig.JwtAudience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(config.AppConfig.JwtAudience))
+	}
+
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+		// Tokens without kid header are verified by the active signing key
+		kid := keySet.signingKid
+		if value, exists := token.Header["kid"]; exists {
+			if kid, exists = value.(string); !exists {
+				return nil, ErrUnknownTokenKey
+			}
+		}
+
+		verificationKey, exists := keySet.verificationKeys[kid]
+		if !exists {
+			return nil, ErrUnknownTokenKey
+		}
+
+		// Prevents algorithm confusion: the key is only valid for its own signing method
+		if token.Method.Alg() != verificationKey.method.Alg() {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return verificationKey.key, nil
+	}, parserOptions...)
 	if err != nil {
 		return nil, err
 	}
@@ -41,10 +99,21 @@ func ParseJWTToken(tokenString string) (*UserTokenClaims, error) {
 		return nil, jwt.ErrInvalidKey
 	}
 
-	claims := token.Claims.(jwt.MapClaims)
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidTokenClaims
+	}
+
+	id, idOk := claims["id"].(float64)
+	userType, typeOk := claims["type"].(string)
+	username, usernameOk := claims["username"].(string)
+	if !idOk || !typeOk || !usernameOk {
+		return nil, ErrInvalidTokenClaims
+	}
+
 	return &UserTokenClaims{
-		Id:       int64(claims["id"].(float64)),
-		Type:     claims["type"].(string),
-		Username: claims["username"].(string),
+		Id:       int64(id),
+		Type:     userType,
+		Username: username,
 	}, nil
 }
@@ -0,0 +1,306 @@
+// money package represents monetary amounts as integer minor units (cents) with an ISO 4217 currency,
+// so that prices, totals and discounts never lose precision on float rounding.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"netshop/main/config"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	ErrInvalidAmount    = errors.New("invalid money amount")
+	ErrCurrencyMismatch = errors.New("money currencies do not match")
+	ErrInvalidRate      = errors.New("invalid exchange rate")
+)
+
+// Number of minor units digits of the currencies that do not use cents
+var currencyExponents = map[string]int32{
+	"JPY": 0,
+	"KRW": 0,
+	"VND": 0,
+	"BHD": 3,
+	"KWD": 3,
+	"OMR": 3,
+	"TND": 3,
+}
+
+type Money struct {
+	// Amount in minor units of the currency, e.g. cents
+	Amount   int64
+	Currency string
+}
+
+// Returns the currency used when it is not specified explicitly (DEFAULT_CURRENCY config)
+func DefaultCurrency() string {
+	return strings.ToUpper(strings.TrimSpace(config.AppConfig.DefaultCurrency))
+}
+
+// Returns the number of digits after the decimal point of the currency
+func Exponent(currency string) int32 {
+	if exponent, exists := currencyExponents[currency]; exists {
+		return exponent
+	}
+	return 2
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: normalizeCurrency(currency)}
+}
+
+// Parses a decimal string like "10.50" into money of the given currency.
+// Values with more fractional digits than the currency allows are rejected
+func Parse(value string, currency string) (Money, error) {
+	currency = normalizeCurrency(currency)
+	exponent := Exponent(currency)
+
+	value = strings.TrimSpace(value)
+	if !isDecimalString(value) {
+		return Money{}, fmt.Errorf("%w: '%s'", ErrInvalidAmount, value)
+	}
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return Money{}, fmt.Errorf("%w: '%s'", ErrInvalidAmount, value)
+	}
+
+	rat.Mul(rat, new(big.Rat).SetInt(pow10(exponent)))
+	if !rat.IsInt() || !rat.Num().IsInt64() {
+		return Money{}, fmt.Errorf("%w: '%s' has too many fractional digits for %s", ErrInvalidAmount, value, currency)
+	}
+	return Money{Amount: rat.Num().Int64(), Currency: currency}, nil
+}
+
+// Converts a float value to money rounding it to the nearest minor unit
+func FromFloat(value float64, currency string) Money {
+	currency = normalizeCurrency(currency)
+	return Money{
+		Amount:   int64(math.Round(value * math.Pow10(int(Exponent(currency))))),
+		Currency: currency,
+	}
+}
+
+// Parses a positive decimal exchange rate like "0.0245"
+func ParseRate(value string) (*big.Rat, error) {
+	value = strings.TrimSpace(value)
+	if !isDecimalString(value) {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidRate, value)
+	}
+	rate, ok := new(big.Rat).SetString(value)
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidRate, value)
+	}
+	return rate, nil
+}
+
+// Converts the amount to another currency, rate is the amount of the target currency for one unit
+// of the source currency. The result is rounded to the nearest minor unit of the target currency
+func (m Money) Convert(currency string, rate *big.Rat) Money {
+	currency = normalizeCurrency(currency)
+	value := new(big.Rat).SetFrac(big.NewInt(m.Amount), pow10(Exponent(m.currency())))
+	value.Mul(value, rate)
+	value.Mul(value, new(big.Rat).SetInt(pow10(Exponent(currency))))
+	return Money{Amount: roundRat(value).Int64(), Currency: currency}
+}
+
+// Adds two amounts of the same currency. Mixing currencies is a programming error and panics
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.resultCurrency(other)}
+}
+
+// Subtracts two amounts of the same currency. Mixing currencies is a programming error and panics
+func (m Money) Sub(other Money) Money {
+	return Money{Amount: m.Amount - other.Amount, Currency: m.resultCurrency(other)}
+}
+
+func (m Money) Mul(quantity int64) Money {
+	return Money{Amount: m.Amount * quantity, Currency: m.currency()}
+}
+
+// Multiplies the amount by the ratio, e.g. a tax rate, rounding to the nearest minor unit
+func (m Money) MulRat(ratio *big.Rat) Money {
+	value := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), ratio)
+	return Money{Amount: roundRat(value).Int64(), Currency: m.currency()}
+}
+
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.currency()}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Returns the amount as a decimal string without currency, e.g. "10.50"
+func (m Money) Decimal() string {
+	exponent := Exponent(m.currency())
+	if exponent == 0 {
+		return strconv.FormatInt(m.Amount, 10)
+	}
+
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	divisor := pow10(exponent).Int64()
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/divisor, exponent, amount%divisor)
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%s %s", m.Decimal(), m.currency())
+}
+
+type moneyJSON struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Money is encoded as {"amount": 1050, "currency": "UAH"} where amount is in minor units
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Amount, Currency: m.currency()})
+}
+
+// Accepts the {"amount", "currency"} object, or a decimal number/string in the default currency
+func (m *Money) UnmarshalJSON(data []byte) error {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		value := moneyJSON{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		*m = New(value.Amount, value.Currency)
+		return nil
+	}
+
+	parsed, err := Parse(strings.Trim(trimmed, `"`), DefaultCurrency())
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Parses a decimal string in the default currency, used for query params
+func (m *Money) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text), DefaultCurrency())
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Implements pgtype.NumericScanner, so numeric columns can be scanned directly into Money.
+// The currency is kept if it is set before scanning, otherwise the default one is used
+func (m *Money) ScanNumeric(value pgtype.Numeric) error {
+	parsed, err := FromNumeric(value, m.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Converts a numeric column value to money of the given currency. Use it when the currency
+// is stored in another column of the same row
+func FromNumeric(value pgtype.Numeric, currency string) (Money, error) {
+	if !value.Valid || value.NaN || value.InfinityModifier != pgtype.Finite {
+		return Money{}, fmt.Errorf("%w: cannot scan NULL, NaN or infinity", ErrInvalidAmount)
+	}
+
+	currency = normalizeCurrency(currency)
+
+	shift := value.Exp + Exponent(currency)
+	amount := new(big.Int).Set(value.Int)
+	if shift >= 0 {
+		amount.Mul(amount, pow10(shift))
+	} else {
+		// Round half away from zero when the column has more digits than the currency
+		rat := new(big.Rat).SetFrac(amount, pow10(-shift))
+		amount = roundRat(rat)
+	}
+	if !amount.IsInt64() {
+		return Money{}, fmt.Errorf("%w: value is out of range", ErrInvalidAmount)
+	}
+
+	return Money{Amount: amount.Int64(), Currency: currency}, nil
+}
+
+// Implements pgtype.NumericValuer, so Money can be used as a numeric query parameter
+func (m Money) NumericValue() (pgtype.Numeric, error) {
+	return pgtype.Numeric{
+		Int:   big.NewInt(m.Amount),
+		Exp:   -Exponent(m.currency()),
+		Valid: true,
+	}, nil
+}
+
+// Returns the same amount in another currency, used after scanning numeric columns
+func (m Money) WithCurrency(currency string) Money {
+	return Money{Amount: m.Amount, Currency: normalizeCurrency(currency)}
+}
+
+func (m Money) currency() string {
+	if m.Currency == "" {
+		return DefaultCurrency()
+	}
+	return m.Currency
+}
+
+// Returns the currency of the operation result. Zero values without currency take the other currency
+func (m Money) resultCurrency(other Money) string {
+	if m.Currency == "" {
+		return other.currency()
+	}
+	if other.Currency != "" && m.Currency != other.Currency {
+		panic(fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency))
+	}
+	return m.Currency
+}
+
+func normalizeCurrency(currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return DefaultCurrency()
+	}
+	return currency
+}
+
+// Checks that the value is a plain decimal number: optional sign, digits and an optional fraction
+func isDecimalString(value string) bool {
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+	integer, fraction, _ := strings.Cut(value, ".")
+	if integer == "" && fraction == "" {
+		return false
+	}
+	for _, c := range integer + fraction {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func pow10(exponent int32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
+
+func roundRat(rat *big.Rat) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(rat.Num(), rat.Denom(), new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(rat.Denom()) >= 0 {
+		if rat.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	return quotient
+}
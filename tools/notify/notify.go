@@ -0,0 +1,119 @@
+// notify package delivers application events (low stock, back in stock, etc.) through pluggable channels
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Notification is a single event sent to its recipients
+type Notification struct {
+	// Event is a machine readable event name, e.g. "stock.low"
+	Event string `json:"event"`
+	// Recipients are email addresses of the notified people. Channels that are not personal (log, webhook) ignore it
+	Recipients []string    `json:"recipients"`
+	Subject    string      `json:"subject"`
+	Message    string      `json:"message"`
+	Data       interface{} `json:"data"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, notification *Notification) error
+}
+
+// LogNotifier writes notifications to the application log
+type LogNotifier struct{}
+
+func (n *LogNotifier) Notify(ctx context.Context, notification *Notification) error {
+	log.Printf("Notification [%s] to %v: %s. %s", notification.Event, notification.Recipients, notification.Subject, notification.Message)
+	return nil
+}
+
+// EmailNotifier sends notifications to their recipients by email
+type EmailNotifier struct {
+	Mailer Mailer
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, notification *Notification) error {
+	if len(notification.Recipients) == 0 {
+		return nil
+	}
+	return n.Mailer.Send(ctx, notification.Recipients, notification.Subject, notification.Message)
+}
+
+// WebhookNotifier posts notifications as JSON to the given URL
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, notification *Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Channel is a notifier with the name it is configured by in NOTIFIERS
+type Channel struct {
+	Name     string
+	Notifier Notifier
+}
+
+// MultiNotifier sends every notification through all of its channels
+type MultiNotifier []Channel
+
+func (n MultiNotifier) Notify(ctx context.Context, notification *Notification) error {
+	_, err := n.NotifySkipping(ctx, notification, nil)
+	return err
+}
+
+// Sends the notification through the channels that are not in the delivered list. Returns the
+// names of all channels that delivered it, including the skipped ones, so a failed notification
+// can be retried without sending it twice through the same channel
+func (n MultiNotifier) NotifySkipping(ctx context.Context, notification *Notification, delivered []string) ([]string, error) {
+	result := slices.Clone(delivered)
+	errs := []error{}
+	for _, channel := range n {
+		if slices.Contains(delivered, channel.Name) {
+			continue
+		}
+		if err := channel.Notifier.Notify(ctx, notification); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", channel.Name, err))
+			continue
+		}
+		result = append(result, channel.Name)
+	}
+	return result, errors.Join(errs...)
+}
@@ -0,0 +1,197 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"netshop/main/tools/money"
+	"sync"
+	"time"
+)
+
+const (
+	// Tokens of the mock provider that simulate different outcomes, any other token succeeds
+	MockTokenDecline      = "tok_decline"
+	MockTokenAsync        = "tok_async"
+	MockTokenAsyncDecline = "tok_async_decline"
+
+	mockSignatureHeader = "X-Mock-Signature"
+)
+
+// MockProvider simulates a payment gateway for local testing. Payments are kept in memory,
+// async payments stay pending and are completed by a signed webhook sent to WebhookURL after WebhookDelay
+type MockProvider struct {
+	WebhookURL    string
+	WebhookSecret string
+	WebhookDelay  time.Duration
+	Client        *http.Client
+
+	mu       sync.Mutex
+	payments map[string]*mockPayment
+	keys     map[string]string
+}
+
+type mockPayment struct {
+	status   string
+	amount   money.Money
+	refunded money.Money
+}
+
+type mockWebhookPayload struct {
+	PaymentId string `json:"payment_id"`
+	Status    string `json:"status"`
+	Message   string `json:"message"`
+}
+
+func NewMockProvider(webhookURL, webhookSecret string, webhookDelay time.Duration) *MockProvider {
+	return &MockProvider{
+		WebhookURL:    webhookURL,
+		WebhookSecret: webhookSecret,
+		WebhookDelay:  webhookDelay,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		payments:      make(map[string]*mockPayment),
+		keys:          make(map[string]string),
+	}
+}
+
+func (p *MockProvider) Name() string {
+	return "mock"
+}
+
+func (p *MockProvider) Authorize(ctx context.Context, req *AuthorizeRequest) (*Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if id, exists := p.keys[req.IdempotencyKey]; exists {
+		return &Result{ProviderPaymentId: id, Status: p.payments[id].status}, nil
+	}
+
+	id, err := newMockPaymentId()
+	if err != nil {
+		return nil, err
+	}
+	p.keys[req.IdempotencyKey] = id
+
+	result := &Result{ProviderPaymentId: id, Status: StatusAuthorized}
+	switch req.Token {
+	case MockTokenDecline:
+		result.Status = StatusDeclined
+		result.Message = "Card declined"
+	case MockTokenAsync:
+		result.Status = StatusPending
+		go p.sendWebhook(id, StatusCaptured, "")
+	case MockTokenAsyncDecline:
+		result.Status = StatusPending
+		go p.sendWebhook(id, StatusDeclined, "Card declined by the issuer")
+	}
+
+	p.payments[id] = &mockPayment{status: result.Status, amount: req.Amount}
+	return result, nil
+}
+
+func (p *MockProvider) Capture(ctx context.Context, providerPaymentId string, amount money.Money) (*Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, exists := p.payments[providerPaymentId]
+	if !exists {
+		return nil, fmt.Errorf("mock payment '%s' not found", providerPaymentId)
+	}
+	if payment.status != StatusAuthorized && payment.status != StatusCaptured {
+		return nil, fmt.Errorf("mock payment '%s' is %s and cannot be captured", providerPaymentId, payment.status)
+	}
+
+	payment.status = StatusCaptured
+	return &Result{ProviderPaymentId: providerPaymentId, Status: payment.status}, nil
+}
+
+func (p *MockProvider) Refund(ctx context.Context, providerPaymentId string, amount money.Money) (*Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, exists := p.payments[providerPaymentId]
+	if !exists {
+		return nil, fmt.Errorf("mock payment '%s' not found", providerPaymentId)
+	}
+	if payment.status != StatusCaptured {
+		return nil, fmt.Errorf("mock payment '%s' is %s and cannot be refunded", providerPaymentId, payment.status)
+	}
+	refunded := payment.refunded.Add(amount)
+	if refunded.Amount > payment.amount.Amount {
+		return nil, fmt.Errorf("mock payment '%s' refund exceeds the captured amount", providerPaymentId)
+	}
+
+	// Partially refunded payments stay captured
+	payment.refunded = refunded
+	if refunded.Amount == payment.amount.Amount {
+		payment.status = StatusRefunded
+	}
+	return &Result{ProviderPaymentId: providerPaymentId, Status: payment.status}, nil
+}
+
+func (p *MockProvider) VerifyWebhook(payload []byte, header http.Header) (*WebhookEvent, error) {
+	signature, err := hex.DecodeString(header.Get(mockSignatureHeader))
+	if err != nil || !hmac.Equal(signature, p.sign(payload)) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidWebhook)
+	}
+
+	body := mockWebhookPayload{}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidWebhook, err)
+	}
+	return &WebhookEvent{ProviderPaymentId: body.PaymentId, Status: body.Status, Message: body.Message}, nil
+}
+
+// Completes the pending payment and notifies the shop as a real gateway would do
+func (p *MockProvider) sendWebhook(id string, status string, message string) {
+	time.Sleep(p.WebhookDelay)
+
+	p.mu.Lock()
+	p.payments[id].status = status
+	p.mu.Unlock()
+
+	payload, err := json.Marshal(mockWebhookPayload{PaymentId: id, Status: status, Message: message})
+	if err != nil {
+		log.Printf("Mock payment webhook failed: %s", err.Error())
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("Mock payment webhook failed: %s", err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(mockSignatureHeader, hex.EncodeToString(p.sign(payload)))
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		log.Printf("Mock payment webhook failed: %s", err.Error())
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		log.Printf("Mock payment webhook responded with status %d", res.StatusCode)
+	}
+}
+
+func (p *MockProvider) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(p.WebhookSecret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func newMockPaymentId() (string, error) {
+	bytes := make([]byte, 12)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return "mock_" + hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,56 @@
+// payment package abstracts payment gateways, so orders can be paid through different providers
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"netshop/main/tools/money"
+)
+
+const (
+	StatusPending    = "pending"
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusDeclined   = "declined"
+	StatusRefunded   = "refunded"
+)
+
+var ErrInvalidWebhook = errors.New("invalid payment webhook")
+
+type AuthorizeRequest struct {
+	// Key that makes repeated authorizations of the same payment safe
+	IdempotencyKey string
+	Amount         money.Money
+	// Payment method token issued by the client side SDK of the provider
+	Token       string
+	Description string
+}
+
+// Result is the state of the payment reported by the provider
+type Result struct {
+	ProviderPaymentId string
+	Status            string
+	// Human readable reason, e.g. of a decline
+	Message string
+}
+
+// WebhookEvent is a verified asynchronous change of the payment status
+type WebhookEvent struct {
+	ProviderPaymentId string
+	Status            string
+	Message           string
+}
+
+// PaymentProvider is a payment gateway. Declines are reported by the result status, errors mean
+// that the provider could not process the request
+type PaymentProvider interface {
+	// Name is stored with the payments to route webhooks and refunds to the right provider
+	Name() string
+	Authorize(ctx context.Context, req *AuthorizeRequest) (*Result, error)
+	Capture(ctx context.Context, providerPaymentId string, amount money.Money) (*Result, error)
+	// Refunds the amount or its part, the payment stays captured until it is fully refunded
+	Refund(ctx context.Context, providerPaymentId string, amount money.Money) (*Result, error)
+	// Verifies the webhook signature and parses the event
+	VerifyWebhook(payload []byte, header http.Header) (*WebhookEvent, error)
+}
@@ -0,0 +1,401 @@
+package pdf
+
+import (
+	"bytes"
+	"embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DejaVu Sans covers Latin, Cyrillic and Greek, see fonts/LICENSE
+//
+//go:embed fonts/DejaVuSans.ttf fonts/DejaVuSans-Bold.ttf
+var fontFiles embed.FS
+
+var (
+	regularFont = sync.OnceValue(func() *font { return mustLoadFont("DejaVuSans", "fonts/DejaVuSans.ttf") })
+	boldFont    = sync.OnceValue(func() *font { return mustLoadFont("DejaVuSans-Bold", "fonts/DejaVuSans-Bold.ttf") })
+)
+
+var errInvalidFont = errors.New("invalid TrueType font")
+
+// Tables a TrueType font embedded into a PDF needs, other tables are dropped from subsets
+var subsetTables = []string{"cvt ", "fpgm", "glyf", "head", "hhea", "hmtx", "loca", "maxp", "prep"}
+
+// font is a parsed TrueType font. Metrics are in font units
+type font struct {
+	name       string
+	data       []byte
+	tables     map[string][]byte
+	unitsPerEm int
+	ascent     int
+	descent    int
+	capHeight  int
+	bbox       [4]int
+	advances   []int
+	glyphs     map[rune]uint16
+	// Glyph data offsets, glyph i is glyf[loca[i]:loca[i+1]]
+	loca []int
+}
+
+func mustLoadFont(name, path string) *font {
+	data, err := fontFiles.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	f, err := parseFont(name, data)
+	if err != nil {
+		panic(fmt.Errorf("%s: %w", path, err))
+	}
+	return f
+}
+
+func parseFont(name string, data []byte) (*font, error) {
+	if len(data) < 12 {
+		return nil, errInvalidFont
+	}
+	f := &font{name: name, data: data, tables: make(map[string][]byte)}
+
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		record := 12 + i*16
+		if record+16 > len(data) {
+			return nil, errInvalidFont
+		}
+		offset := int(binary.BigEndian.Uint32(data[record+8:]))
+		length := int(binary.BigEndian.Uint32(data[record+12:]))
+		if offset+length > len(data) {
+			return nil, errInvalidFont
+		}
+		f.tables[string(data[record:record+4])] = data[offset : offset+length]
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "maxp", "loca", "glyf", "cmap"} {
+		if _, exists := f.tables[tag]; !exists {
+			return nil, fmt.Errorf("%w: %s table is missing", errInvalidFont, tag)
+		}
+	}
+
+	head := f.tables["head"]
+	f.unitsPerEm = int(binary.BigEndian.Uint16(head[18:]))
+	for i := range f.bbox {
+		f.bbox[i] = int(int16(binary.BigEndian.Uint16(head[36+i*2:])))
+	}
+	longLoca := binary.BigEndian.Uint16(head[50:]) == 1
+
+	hhea := f.tables["hhea"]
+	f.ascent = int(int16(binary.BigEndian.Uint16(hhea[4:])))
+	f.descent = int(int16(binary.BigEndian.Uint16(hhea[6:])))
+	f.capHeight = f.ascent
+	if os2, exists := f.tables["OS/2"]; exists && len(os2) >= 90 && binary.BigEndian.Uint16(os2) >= 2 {
+		f.capHeight = int(int16(binary.BigEndian.Uint16(os2[88:])))
+	}
+
+	numGlyphs := int(binary.BigEndian.Uint16(f.tables["maxp"][4:]))
+	numMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	hmtx := f.tables["hmtx"]
+	if numMetrics == 0 || numMetrics > numGlyphs || len(hmtx) < numMetrics*4 {
+		return nil, fmt.Errorf("%w: hmtx table is too short", errInvalidFont)
+	}
+	// Glyphs after the last metric have its advance
+	f.advances = make([]int, numGlyphs)
+	for i := range f.advances {
+		f.advances[i] = int(binary.BigEndian.Uint16(hmtx[min(i, numMetrics-1)*4:]))
+	}
+
+	loca := f.tables["loca"]
+	f.loca = make([]int, numGlyphs+1)
+	for i := range f.loca {
+		if longLoca {
+			if len(loca) < (i+1)*4 {
+				return nil, fmt.Errorf("%w: loca table is too short", errInvalidFont)
+			}
+			f.loca[i] = int(binary.BigEndian.Uint32(loca[i*4:]))
+		} else {
+			if len(loca) < (i+1)*2 {
+				return nil, fmt.Errorf("%w: loca table is too short", errInvalidFont)
+			}
+			f.loca[i] = int(binary.BigEndian.Uint16(loca[i*2:])) * 2
+		}
+		if f.loca[i] > len(f.tables["glyf"]) {
+			return nil, fmt.Errorf("%w: glyph is out of the glyf table", errInvalidFont)
+		}
+	}
+
+	glyphs, err := parseCmap(f.tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	f.glyphs = glyphs
+	return f, nil
+}
+
+// Reads the Unicode mapping of the cmap table: the full repertoire subtable (format 12)
+// or the Basic Multilingual Plane subtable (format 4)
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, errInvalidFont
+	}
+	var bmp, full []byte
+	numTables := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < numTables; i++ {
+		record := 4 + i*8
+		if record+8 > len(cmap) {
+			return nil, errInvalidFont
+		}
+		platform := binary.BigEndian.Uint16(cmap[record:])
+		encoding := binary.BigEndian.Uint16(cmap[record+2:])
+		offset := int(binary.BigEndian.Uint32(cmap[record+4:]))
+		if offset+4 > len(cmap) {
+			return nil, errInvalidFont
+		}
+		subtable := cmap[offset:]
+		switch format := binary.BigEndian.Uint16(subtable); {
+		case platform == 3 && encoding == 10 && format == 12:
+			full = subtable
+		case platform == 3 && encoding == 1 && format == 4:
+			bmp = subtable
+		}
+	}
+
+	glyphs := make(map[rune]uint16)
+	switch {
+	case full != nil:
+		if len(full) < 16 {
+			return nil, errInvalidFont
+		}
+		groups := int(binary.BigEndian.Uint32(full[12:]))
+		if len(full) < 16+groups*12 {
+			return nil, errInvalidFont
+		}
+		for i := 0; i < groups; i++ {
+			group := full[16+i*12:]
+			start := binary.BigEndian.Uint32(group)
+			end := binary.BigEndian.Uint32(group[4:])
+			glyph := binary.BigEndian.Uint32(group[8:])
+			for char := start; char <= end && char <= 0x10FFFF; char++ {
+				glyphs[rune(char)] = uint16(glyph + char - start)
+			}
+		}
+	case bmp != nil:
+		if len(bmp) < 14 {
+			return nil, errInvalidFont
+		}
+		segments := int(binary.BigEndian.Uint16(bmp[6:])) / 2
+		ends := 14
+		starts := ends + segments*2 + 2
+		deltas := starts + segments*2
+		rangeOffsets := deltas + segments*2
+		if len(bmp) < rangeOffsets+segments*2 {
+			return nil, errInvalidFont
+		}
+		for i := 0; i < segments; i++ {
+			end := int(binary.BigEndian.Uint16(bmp[ends+i*2:]))
+			start := int(binary.BigEndian.Uint16(bmp[starts+i*2:]))
+			delta := int(binary.BigEndian.Uint16(bmp[deltas+i*2:]))
+			rangeOffset := int(binary.BigEndian.Uint16(bmp[rangeOffsets+i*2:]))
+			for char := start; char <= end && char != 0xFFFF; char++ {
+				glyph := 0
+				if rangeOffset == 0 {
+					glyph = (char + delta) & 0xFFFF
+				} else {
+					// The offset is relative to the position of the range offset itself
+					index := rangeOffsets + i*2 + rangeOffset + (char-start)*2
+					if index+2 > len(bmp) {
+						return nil, errInvalidFont
+					}
+					if glyph = int(binary.BigEndian.Uint16(bmp[index:])); glyph != 0 {
+						glyph = (glyph + delta) & 0xFFFF
+					}
+				}
+				if glyph != 0 {
+					glyphs[rune(char)] = uint16(glyph)
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: no Unicode cmap subtable", errInvalidFont)
+	}
+	return glyphs, nil
+}
+
+// Returns the glyph of the character, characters missing in the font are rendered as '?'
+func (f *font) glyph(char rune) uint16 {
+	if glyph, exists := f.glyphs[char]; exists {
+		return glyph
+	}
+	return f.glyphs['?']
+}
+
+// Converts font units to 1/1000 of the font size
+func (f *font) scale(value int) int {
+	return value * 1000 / f.unitsPerEm
+}
+
+// Returns the width of the text in 1/1000 of the font size
+func (f *font) textWidth(text string) int {
+	width := 0
+	for _, char := range text {
+		width += f.advances[f.glyph(char)]
+	}
+	return f.scale(width)
+}
+
+// Returns the glyph data, empty for glyphs without contours like the space
+func (f *font) glyphData(glyph uint16) []byte {
+	return f.tables["glyf"][f.loca[glyph]:f.loca[glyph+1]]
+}
+
+// Returns the glyphs the composite glyph is made of
+func (f *font) components(glyph uint16) []uint16 {
+	const (
+		argsAreWords     = 0x0001
+		haveScale        = 0x0008
+		moreComponents   = 0x0020
+		haveXYScale      = 0x0040
+		haveTwoByTwo     = 0x0080
+		compositeHeadLen = 10
+	)
+
+	data := f.glyphData(glyph)
+	if len(data) < compositeHeadLen || int16(binary.BigEndian.Uint16(data)) >= 0 {
+		return nil
+	}
+	components := []uint16{}
+	for offset := compositeHeadLen; offset+4 <= len(data); {
+		flags := binary.BigEndian.Uint16(data[offset:])
+		components = append(components, binary.BigEndian.Uint16(data[offset+2:]))
+		offset += 4
+		if flags&argsAreWords != 0 {
+			offset += 4
+		} else {
+			offset += 2
+		}
+		switch {
+		case flags&haveScale != 0:
+			offset += 2
+		case flags&haveXYScale != 0:
+			offset += 4
+		case flags&haveTwoByTwo != 0:
+			offset += 8
+		}
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	return components
+}
+
+// Returns the font with the data of the given glyphs only. Glyph ids are kept, so the text
+// encoded for the full font renders the same, other glyphs become empty
+func (f *font) subset(glyphs map[uint16]rune) []byte {
+	// .notdef is required and composite glyphs need their components
+	keep := map[uint16]bool{0: true}
+	queue := []uint16{0}
+	for glyph := range glyphs {
+		queue = append(queue, glyph)
+	}
+	for len(queue) > 0 {
+		glyph := queue[0]
+		queue = queue[1:]
+		keep[glyph] = true
+		for _, component := range f.components(glyph) {
+			if !keep[component] && int(component) < len(f.advances) {
+				queue = append(queue, component)
+			}
+		}
+	}
+
+	glyf := &bytes.Buffer{}
+	loca := make([]byte, len(f.loca)*4)
+	for glyph := 0; glyph < len(f.loca)-1; glyph++ {
+		binary.BigEndian.PutUint32(loca[glyph*4:], uint32(glyf.Len()))
+		if keep[uint16(glyph)] {
+			glyf.Write(f.glyphData(uint16(glyph)))
+			glyf.Write(make([]byte, (4-glyf.Len()%4)%4))
+		}
+	}
+	binary.BigEndian.PutUint32(loca[(len(f.loca)-1)*4:], uint32(glyf.Len()))
+
+	// The subset always uses the long loca format, the checksum adjustment is set after writing
+	head := bytes.Clone(f.tables["head"])
+	binary.BigEndian.PutUint32(head[8:], 0)
+	binary.BigEndian.PutUint16(head[50:], 1)
+
+	tables := map[string][]byte{"glyf": glyf.Bytes(), "loca": loca, "head": head}
+	tags := []string{}
+	for _, tag := range subsetTables {
+		if _, exists := tables[tag]; !exists {
+			table, exists := f.tables[tag]
+			if !exists {
+				continue
+			}
+			tables[tag] = table
+		}
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= len(tags) {
+		entrySelector++
+	}
+	searchRange := (1 << entrySelector) * 16
+
+	result := &bytes.Buffer{}
+	binary.Write(result, binary.BigEndian, []uint32{0x00010000})
+	binary.Write(result, binary.BigEndian, []uint16{uint16(len(tags)), uint16(searchRange), uint16(entrySelector), uint16(len(tags)*16 - searchRange)})
+	offset := 12 + len(tags)*16
+	headOffset := 0
+	for _, tag := range tags {
+		table := tables[tag]
+		result.WriteString(tag)
+		binary.Write(result, binary.BigEndian, []uint32{checksum(table), uint32(offset), uint32(len(table))})
+		if tag == "head" {
+			headOffset = offset
+		}
+		offset += (len(table) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		result.Write(tables[tag])
+		result.Write(make([]byte, (4-result.Len()%4)%4))
+	}
+
+	data := result.Bytes()
+	binary.BigEndian.PutUint32(data[headOffset+8:], 0xB1B0AFBA-checksum(data))
+	return data
+}
+
+// Returns the name of the subset: a tag of six uppercase letters unique for the glyphs
+// followed by the font name
+func (f *font) subsetName(glyphs map[uint16]rune) string {
+	ids := make([]int, 0, len(glyphs))
+	for glyph := range glyphs {
+		ids = append(ids, int(glyph))
+	}
+	sort.Ints(ids)
+
+	hash := fnv.New32a()
+	fmt.Fprint(hash, f.name, ids)
+	sum := hash.Sum32()
+	var tag strings.Builder
+	for i := 0; i < 6; i++ {
+		tag.WriteByte(byte('A' + sum%26))
+		sum /= 26
+	}
+	return tag.String() + "+" + f.name
+}
+
+func checksum(data []byte) uint32 {
+	sum := uint32(0)
+	for i := 0; i < len(data); i += 4 {
+		word := [4]byte{}
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
@@ -0,0 +1,219 @@
+// pdf package writes simple text documents in the PDF format without external dependencies.
+// Documents embed subsets of the DejaVu Sans fonts, so Latin, Cyrillic and Greek texts are
+// rendered and can be copied from the document, other characters are replaced with '?'
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+type Document struct {
+	pages []*Page
+	// Glyphs used by the text of the regular (F1) and bold (F2) fonts with their characters
+	glyphs [2]map[uint16]rune
+}
+
+// Page content, coordinates are measured in points from the top left corner
+type Page struct {
+	document *Document
+	content  bytes.Buffer
+}
+
+func NewDocument() *Document {
+	return &Document{glyphs: [2]map[uint16]rune{{}, {}}}
+}
+
+func (d *Document) AddPage() *Page {
+	page := &Page{document: d}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// Writes the text with its baseline at the given position
+func (p *Page) Text(x, y, size float64, bold bool, text string) {
+	index := fontIndex(bold)
+	fmt.Fprintf(&p.content, "BT /F%d %.2f Tf %.2f %.2f Td <%s> Tj ET\n", index+1, size, x, PageHeight-y, p.document.encode(index, text))
+}
+
+// Writes the text so that it ends at the given position
+func (p *Page) TextRight(x, y, size float64, bold bool, text string) {
+	p.Text(x-TextWidth(text, size, bold), y, size, bold, text)
+}
+
+func (p *Page) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// Bytes renders the document
+func (d *Document) Bytes() []byte {
+	pages := d.pages
+	if len(pages) == 0 {
+		pages = []*Page{{}}
+	}
+
+	buffer := &bytes.Buffer{}
+	offsets := []int{}
+	writeObject := func(body string) {
+		offsets = append(offsets, buffer.Len())
+		fmt.Fprintf(buffer, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	writeStream := func(dictionary string, data []byte) {
+		writeObject(fmt.Sprintf("<< /Length %d %s>>\nstream\n%s\nendstream", len(data), dictionary, data))
+	}
+
+	buffer.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+
+	// Objects 1-2 are the catalog and the page tree, then every page takes two objects
+	// and every font takes five objects
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 3+i*2)
+	}
+	fontsObject := 3 + len(pages)*2
+	writeObject("<< /Type /Catalog /Pages 2 0 R >>")
+	writeObject(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	for i, page := range pages {
+		writeObject(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, fontsObject, fontsObject+5, 4+i*2,
+		))
+		writeStream("", page.content.Bytes())
+	}
+
+	for index, f := range []*font{regularFont(), boldFont()} {
+		glyphs := d.glyphs[index]
+		if glyphs == nil {
+			glyphs = map[uint16]rune{}
+		}
+		object := fontsObject + index*5
+		name := f.subsetName(glyphs)
+		writeObject(fmt.Sprintf(
+			"<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+			name, object+1, object+4,
+		))
+		writeObject(fmt.Sprintf(
+			"<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /CIDToGIDMap /Identity /DW %d /W [%s] >>",
+			name, object+2, f.scale(f.advances[0]), glyphWidths(f, glyphs),
+		))
+		writeObject(fmt.Sprintf(
+			"<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+			name, f.scale(f.bbox[0]), f.scale(f.bbox[1]), f.scale(f.bbox[2]), f.scale(f.bbox[3]),
+			f.scale(f.ascent), f.scale(f.descent), f.scale(f.capHeight), object+3,
+		))
+		subset := f.subset(glyphs)
+		writeStream(fmt.Sprintf("/Length1 %d /Filter /FlateDecode ", len(subset)), deflate(subset))
+		writeStream("", toUnicodeCMap(glyphs))
+	}
+
+	xrefOffset := buffer.Len()
+	fmt.Fprintf(buffer, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(buffer, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(buffer, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xrefOffset)
+
+	return buffer.Bytes()
+}
+
+// TextWidth returns the width of the text in points
+func TextWidth(text string, size float64, bold bool) float64 {
+	return float64(fontFor(bold).textWidth(text)) * size / 1000
+}
+
+func fontIndex(bold bool) int {
+	if bold {
+		return 1
+	}
+	return 0
+}
+
+func fontFor(bold bool) *font {
+	if bold {
+		return boldFont()
+	}
+	return regularFont()
+}
+
+// Encodes the text to the hex string of its glyph ids and remembers the glyphs for the font subset
+func (d *Document) encode(index int, text string) string {
+	f := fontFor(index == 1)
+	var builder strings.Builder
+	for _, char := range text {
+		glyph := f.glyph(char)
+		if _, exists := d.glyphs[index][glyph]; !exists {
+			d.glyphs[index][glyph] = char
+		}
+		fmt.Fprintf(&builder, "%04X", glyph)
+	}
+	return builder.String()
+}
+
+// Returns the widths of the glyphs in the format of the CIDFont W array
+func glyphWidths(f *font, glyphs map[uint16]rune) string {
+	ids := sortedGlyphs(glyphs)
+	widths := make([]string, 0, len(ids))
+	for _, glyph := range ids {
+		widths = append(widths, fmt.Sprintf("%d [%d]", glyph, f.scale(f.advances[glyph])))
+	}
+	return strings.Join(widths, " ")
+}
+
+// Returns the CMap that maps the glyph ids back to the characters, so the text can be copied
+func toUnicodeCMap(glyphs map[uint16]rune) []byte {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	buffer.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	buffer.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	buffer.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+
+	// A section has at most 100 mappings
+	ids := sortedGlyphs(glyphs)
+	for len(ids) > 0 {
+		section := ids[:min(len(ids), 100)]
+		ids = ids[len(section):]
+		fmt.Fprintf(buffer, "%d beginbfchar\n", len(section))
+		for _, glyph := range section {
+			fmt.Fprintf(buffer, "<%04X> <%s>\n", glyph, utf16Hex(glyphs[glyph]))
+		}
+		buffer.WriteString("endbfchar\n")
+	}
+
+	buffer.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend")
+	return buffer.Bytes()
+}
+
+func sortedGlyphs(glyphs map[uint16]rune) []uint16 {
+	ids := make([]uint16, 0, len(glyphs))
+	for glyph := range glyphs {
+		ids = append(ids, glyph)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Encodes the character to UTF-16BE hex, characters outside of the BMP take a surrogate pair
+func utf16Hex(char rune) string {
+	if char < 0x10000 {
+		return fmt.Sprintf("%04X", char)
+	}
+	char -= 0x10000
+	return fmt.Sprintf("%04X%04X", 0xD800+(char>>10), 0xDC00+(char&0x3FF))
+}
+
+func deflate(data []byte) []byte {
+	buffer := &bytes.Buffer{}
+	writer := zlib.NewWriter(buffer)
+	writer.Write(data)
+	writer.Close()
+	return buffer.Bytes()
+}
@@ -21,11 +21,15 @@ type ErrorDetail struct {
 }
 
 func RespondWithError(w http.ResponseWriter, message string, status int) {
+	RespondWithErrorDetails(w, message, nil, status)
+}
+
+func RespondWithErrorDetails(w http.ResponseWriter, message string, details interface{}, status int) {
 	response := ErrorResponse{
 		Status: status,
 		Error: ErrorDetail{
 			Message: message,
-			Details: nil,
+			Details: details,
 		},
 	}
 	respondWithJSON(w, status, response)
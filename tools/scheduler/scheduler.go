@@ -0,0 +1,32 @@
+// scheduler package runs background jobs periodically in their own goroutines.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Job is a unit of background work. Returned errors are logged and do not stop the schedule
+type Job func(ctx context.Context) error
+
+// Every runs the job with the given interval until the context is cancelled
+func Every(ctx context.Context, name string, interval time.Duration, job Job) {
+	log.Printf("Scheduled background job '%s' every %s", name, interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := job(ctx); err != nil {
+					log.Printf("Background job '%s' failed: %s", name, err.Error())
+				}
+			}
+		}
+	}()
+}
@@ -15,6 +15,9 @@ type SQLQueryBuilder struct {
 	FromTable        string
 	OrderByColumn    string
 	OrderByDirection string
+	// Zero limit or offset is not added to the query
+	LimitValue  int64
+	OffsetValue int64
 
 	// Named parameters in the query. For example, $userId, $a_b_c
 	Parameters map[string]any
@@ -27,7 +30,8 @@ func NewSQLQueryBuilder() *SQLQueryBuilder {
 }
 
 // Build returns the SQL query and the parameters to be used in the query.
-func (sqb *SQLQueryBuilder) Build() (string, []interface{}) {
+// Every named parameter of the query must be set
+func (sqb *SQLQueryBuilder) Build() (string, []interface{}, error) {
 	query := strings.Builder{}
 	if len(sqb.SelectedColumns) == 0 {
 		query.WriteString("SELECT *")
@@ -61,6 +65,13 @@ func (sqb *SQLQueryBuilder) Build() (string, []interface{}) {
 		query.WriteString(sqb.OrderByDirection)
 	}
 
+	if sqb.LimitValue > 0 {
+		query.WriteString(fmt.Sprintf(" LIMIT %d", sqb.LimitValue))
+	}
+	if sqb.OffsetValue > 0 {
+		query.WriteString(fmt.Sprintf(" OFFSET %d", sqb.OffsetValue))
+	}
+
 	// Parsing named parameters in the query and replacing it by their numeric equivalent.
 	// For example:
 	// SELECT * FROM orders WHERE customer_id = $customerId
@@ -69,20 +80,23 @@ func (sqb *SQLQueryBuilder) Build() (string, []interface{}) {
 	params := parseParameters(raw)
 	args := make([]interface{}, 0, len(params))
 	for _, key := range params {
-		if value, ok := sqb.Parameters[key]; ok {
-			args = append(args, value)
+		value, ok := sqb.Parameters[key]
+		if !ok {
+			return "", nil, fmt.Errorf("parameter '$%s' is not set", key)
 		}
+		args = append(args, value)
 	}
 	replaced := replaceParamsToSQLVars(raw, params)
 
-	return replaced, args
+	return replaced, args, nil
 }
 
 func isIdentifier(c rune) bool {
 	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_'
 }
 
-// Parses variables in the query, like $abc and returns an array of the params without '$' symbol
+// Parses variables in the query, like $abc and returns an array of the params without '$' symbol.
+// Numeric placeholders, like $1, are not named parameters and are skipped
 func parseParameters(query string) []string {
 	exists := map[string]struct{}{}
 	args := []string{}
@@ -94,7 +108,10 @@ func parseParameters(query string) []string {
 				i++
 			}
 			arg := query[start:i]
-			if _, ok := exists[arg]; !ok && arg != "" {
+			if arg == "" || unicode.IsDigit(rune(arg[0])) {
+				continue
+			}
+			if _, ok := exists[arg]; !ok {
 				args = append(args, arg)
 				exists[arg] = struct{}{}
 			}
@@ -104,15 +121,33 @@ func parseParameters(query string) []string {
 	return args
 }
 
-// This function replaces all named parameters ($abc, $test) to their numeric equivalent ($1, $2, $3, ...$n)
+// This function replaces all named parameters ($abc, $test) to their numeric equivalent ($1, $2, $3, ...$n).
+// Only whole identifiers are replaced, so $status does not change the beginning of $statusDateFrom
 func replaceParamsToSQLVars(query string, args []string) string {
+	positions := make(map[string]int, len(args))
 	for i, arg := range args {
-		arg := strings.Trim(arg, " ")
-		if arg != "" {
-			query = strings.ReplaceAll(query, "$"+arg, fmt.Sprintf("$%d", i+1))
+		positions[arg] = i + 1
+	}
+
+	result := strings.Builder{}
+	for i := 0; i < len(query); i++ {
+		if query[i] != '$' {
+			result.WriteByte(query[i])
+			continue
+		}
+		start := i + 1
+		end := start
+		for end < len(query) && isIdentifier(rune(query[end])) {
+			end++
 		}
+		if position, ok := positions[query[start:end]]; ok {
+			result.WriteString(fmt.Sprintf("$%d", position))
+		} else {
+			result.WriteString(query[i:end])
+		}
+		i = end - 1
 	}
-	return query
+	return result.String()
 }
 
 func (sqb *SQLQueryBuilder) Select(columns ...string) *SQLQueryBuilder {
@@ -151,6 +186,16 @@ func (sqb *SQLQueryBuilder) OrderBy(column, direction string) *SQLQueryBuilder {
 	return sqb
 }
 
+func (sqb *SQLQueryBuilder) Limit(limit int64) *SQLQueryBuilder {
+	sqb.LimitValue = limit
+	return sqb
+}
+
+func (sqb *SQLQueryBuilder) Offset(offset int64) *SQLQueryBuilder {
+	sqb.OffsetValue = offset
+	return sqb
+}
+
 func (sqb *SQLQueryBuilder) SetParameter(key string, value any) *SQLQueryBuilder {
 	sqb.Parameters[key] = value
 	return sqb